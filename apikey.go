@@ -0,0 +1,71 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
+
+// SecretProvider supplies a secret, like an API key, when a request is sent.  Implementations
+// can fetch secrets from a vault, or reload them from a file, so they can be rotated
+// without rebuilding the Requester.
+type SecretProvider interface {
+	Secret(ctx context.Context) (string, error)
+}
+
+// SecretProviderFunc adapts a function to the SecretProvider interface.
+type SecretProviderFunc func(ctx context.Context) (string, error)
+
+// Secret implements SecretProvider.
+func (f SecretProviderFunc) Secret(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticSecret is a SecretProvider which always returns the same secret.
+type StaticSecret string
+
+// Secret implements SecretProvider.
+func (s StaticSecret) Secret(context.Context) (string, error) {
+	return string(s), nil
+}
+
+// APIKeyHeader is middleware which sets a header to an API key, fetched from key each time
+// a request is sent:
+//
+//	requester.APIKeyHeader("X-Api-Key", requester.StaticSecret(apiKey))
+//
+// The header is redacted by DefaultRedaction if it's X-Api-Key or Authorization.  Otherwise,
+// add its name to the Redaction's Headers.
+func APIKeyHeader(name string, key SecretProvider) Middleware {
+	return apiKey(key, func(req *http.Request, secret string) {
+		req.Header.Set(name, secret)
+	})
+}
+
+// APIKeyQuery is middleware which sets a query parameter to an API key, fetched from key each
+// time a request is sent.
+//
+// API keys in URLs tend to end up in logs.  The param is redacted by DefaultRedaction if it's
+// api_key or access_token.  Otherwise, add its name to the Redaction's QueryParams.
+func APIKeyQuery(param string, key SecretProvider) Middleware {
+	return apiKey(key, func(req *http.Request, secret string) {
+		q := req.URL.Query()
+		q.Set(param, secret)
+		req.URL.RawQuery = q.Encode()
+	})
+}
+
+func apiKey(key SecretProvider, set func(req *http.Request, secret string)) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			secret, err := key.Secret(req.Context())
+			if err != nil {
+				return nil, merry.Prepend(err, "fetching API key")
+			}
+			req = req.Clone(req.Context())
+			set(req, secret)
+			return next.Do(req)
+		})
+	}
+}
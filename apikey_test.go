@@ -0,0 +1,62 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyHeader(t *testing.T) {
+	var req *http.Request
+	doer := DoerFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return MockResponse(200), nil
+	})
+
+	key := "key1"
+	r := MustNew(
+		WithDoer(doer),
+		APIKeyHeader("X-Api-Key", SecretProviderFunc(func(ctx context.Context) (string, error) {
+			return key, nil
+		})),
+	)
+
+	_, err := r.Send()
+	require.NoError(t, err)
+	assert.Equal(t, "key1", req.Header.Get("X-Api-Key"))
+
+	// the key is rotated
+	key = "key2"
+	_, err = r.Send()
+	require.NoError(t, err)
+	assert.Equal(t, "key2", req.Header.Get("X-Api-Key"))
+
+	_, err = r.Send(APIKeyHeader("X-Other-Key", SecretProviderFunc(func(ctx context.Context) (string, error) {
+		return "", errors.New("vault unavailable")
+	})))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "vault unavailable")
+}
+
+func TestAPIKeyQuery(t *testing.T) {
+	var req *http.Request
+	doer := DoerFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return MockResponse(200), nil
+	})
+
+	_, err := Send(
+		WithDoer(doer),
+		Get("http://example.test/?color=red"),
+		APIKeyQuery("api_key", StaticSecret("s3cret")),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", req.URL.Query().Get("api_key"))
+	assert.Equal(t, "red", req.URL.Query().Get("color"))
+
+	assert.Equal(t, "http://example.test/?api_key=REDACTED&color=red", DefaultRedaction.URL(req.URL).String())
+}
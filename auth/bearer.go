@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+// bearerRefreshMargin is how long before a token expires that BearerTokenSource
+// fetches a new one, matching oauth2's margin.
+const bearerRefreshMargin = 10 * time.Second
+
+// BearerTokenSource returns middleware which sets a bearer Authorization header, with tokens
+// obtained from fetch.  fetch returns a token and when it expires.
+//
+// Tokens are cached, and a new token is fetched shortly before the current one expires.  If
+// fetch returns a zero expiry, and the token is a JWT, the expiry is read from the token's exp
+// claim.  Tokens with no known expiry are cached forever.  Fetches are serialized: while one
+// request is fetching a token, concurrent requests wait for it.
+func BearerTokenSource(fetch func(ctx context.Context) (token string, expiry time.Time, err error)) requester.Middleware {
+	s := &bearerSource{fetch: fetch}
+
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			token, err := s.token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			return next.Do(withBearer(req, token))
+		})
+	}
+}
+
+type bearerSource struct {
+	fetch func(ctx context.Context) (string, time.Time, error)
+
+	mu     sync.Mutex
+	tok    string
+	expiry time.Time
+}
+
+func (s *bearerSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tok != "" && (s.expiry.IsZero() || time.Now().Add(bearerRefreshMargin).Before(s.expiry)) {
+		return s.tok, nil
+	}
+
+	tok, expiry, err := s.fetch(ctx)
+	if err != nil {
+		return "", merry.Prepend(err, "fetching bearer token")
+	}
+	if tok == "" {
+		return "", merry.New("fetching bearer token: empty token")
+	}
+	if expiry.IsZero() {
+		expiry = jwtExpiry(tok)
+	}
+	s.tok, s.expiry = tok, expiry
+	return tok, nil
+}
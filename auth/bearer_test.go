@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unsignedJWT returns a JWT with an exp claim.  The signature isn't checked.
+func unsignedJWT(exp time.Time) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none"}`)) + "." +
+		enc([]byte(`{"exp":`+strconv.FormatInt(exp.Unix(), 10)+`}`)) + "." +
+		enc([]byte("sig"))
+}
+
+func TestBearerTokenSource(t *testing.T) {
+	var auth atomic.Value
+	doer := requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		auth.Store(req.Header.Get(requester.HeaderAuthorization))
+		return requester.MockResponse(200), nil
+	})
+
+	var fetches int32
+	var expiry time.Time
+	reqs := requester.MustNew(
+		requester.WithDoer(doer),
+		BearerTokenSource(func(ctx context.Context) (string, time.Time, error) {
+			n := atomic.AddInt32(&fetches, 1)
+			time.Sleep(10 * time.Millisecond)
+			return "token" + strconv.Itoa(int(n)), expiry, nil
+		}),
+	)
+
+	// tokens without an expiry are cached, and fetches are serialized
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reqs.Send()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.Equal(t, "Bearer token1", auth.Load())
+
+	t.Run("expiry", func(t *testing.T) {
+		var fetches int32
+		reqs := requester.MustNew(
+			requester.WithDoer(doer),
+			BearerTokenSource(func(ctx context.Context) (string, time.Time, error) {
+				atomic.AddInt32(&fetches, 1)
+				return "token", time.Now().Add(5 * time.Second), nil
+			}),
+		)
+		for i := 0; i < 2; i++ {
+			_, err := reqs.Send()
+			require.NoError(t, err)
+		}
+		// expires within the refresh margin, so it's refreshed each time
+		assert.Equal(t, int32(2), fetches)
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		var tokens []string
+		reqs := requester.MustNew(
+			requester.WithDoer(doer),
+			BearerTokenSource(func(ctx context.Context) (string, time.Time, error) {
+				tok := unsignedJWT(time.Now().Add(time.Duration(len(tokens)) * time.Hour))
+				tokens = append(tokens, tok)
+				return tok, time.Time{}, nil
+			}),
+		)
+		for i := 0; i < 3; i++ {
+			_, err := reqs.Send()
+			require.NoError(t, err)
+		}
+		// the first token was already expired, the second is still valid
+		require.Len(t, tokens, 2)
+		assert.Equal(t, "Bearer "+tokens[1], auth.Load())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := requester.Send(
+			requester.WithDoer(doer),
+			BearerTokenSource(func(ctx context.Context) (string, time.Time, error) {
+				return "", time.Time{}, errors.New("boom")
+			}),
+		)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "boom")
+	})
+}
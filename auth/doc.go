@@ -0,0 +1,27 @@
+// Package auth contains requester Middleware for authenticating requests
+// with tokens obtained from an authorization server.
+//
+// OIDC() obtains tokens from an OpenID Connect provider, discovered from
+// the provider's issuer URL:
+//
+//	reqs := requester.MustNew(
+//	    requester.URL("https://api.example.com"),
+//	    auth.OIDC(auth.OIDCConfig{
+//	        Issuer:       "https://login.example.com",
+//	        ClientID:     "my-service",
+//	        ClientSecret: secret,
+//	    }),
+//	)
+//
+// OAuth2() authenticates with tokens from any oauth2.TokenSource, and ClientCredentials()
+// with tokens obtained with the OAuth2 client credentials flow:
+//
+//	reqs := requester.MustNew(
+//	    requester.URL("https://api.example.com"),
+//	    auth.ClientCredentials(&clientcredentials.Config{
+//	        ClientID:     "my-service",
+//	        ClientSecret: secret,
+//	        TokenURL:     "https://login.example.com/oauth2/token",
+//	    }, nil),
+//	)
+package auth
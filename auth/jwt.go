@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+// jwk is a single JSON Web Key, as defined in RFC 7517.  Only the
+// members needed for RSA and EC public keys are decoded.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, merry.Prepend(err, "invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, merry.Prepend(err, "invalid RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, merry.Errorf("unsupported EC curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, merry.Prepend(err, "invalid EC x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, merry.Prepend(err, "invalid EC y coordinate")
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, merry.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+// keySet fetches and caches the signing keys published at a JWKS URI.
+// The keys are re-fetched when a token is signed with an unknown key ID,
+// which handles key rotation.
+type keySet struct {
+	uri  string
+	reqs *requester.Requester
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+func (s *keySet) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, merry.Errorf("no key found in JWKS for key ID %q", kid)
+}
+
+func (s *keySet) refresh() error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	_, _, err := s.reqs.Receive(&set, requester.Get(s.uri), requester.ExpectSuccessCode())
+	if err != nil {
+		return merry.Prepend(err, "fetching JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pk, err := k.publicKey()
+		if err != nil {
+			// skip keys we don't understand
+			continue
+		}
+		keys[k.Kid] = pk
+	}
+	s.keys = keys
+	return nil
+}
+
+// idTokenClaims are the claims of an ID token which are validated.
+type idTokenClaims struct {
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience decodes the "aud" claim, which may be a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// clockSkew is the leeway allowed when validating token timestamps.
+const clockSkew = time.Minute
+
+// verifyIDToken verifies the signature of a JWT ID token using keys
+// from the key set, and validates its issuer, audience, and validity period.
+func verifyIDToken(token string, keys *keySet, issuer, clientID string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return merry.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return merry.Prepend(err, "invalid ID token header")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return merry.Prepend(err, "invalid ID token signature")
+	}
+
+	key, err := keys.key(header.Kid)
+	if err != nil {
+		return err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return err
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return merry.Prepend(err, "invalid ID token claims")
+	}
+
+	switch {
+	case claims.Issuer != issuer:
+		return merry.Errorf("ID token issued by %q, expected %q", claims.Issuer, issuer)
+	case !claims.Audience.contains(clientID):
+		return merry.Errorf("ID token audience %v does not contain client ID %q", []string(claims.Audience), clientID)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)):
+		return merry.New("ID token is expired")
+	case claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return merry.New("ID token is not valid yet")
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return merry.Wrap(json.Unmarshal(b, v))
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return merry.Errorf("unsupported ID token algorithm: %s", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return merry.Errorf("unsupported ID token algorithm: %s", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pk, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return merry.Errorf("key type does not match algorithm %s", alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pk, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(pk, hash, digest, sig, nil)
+		}
+		if err != nil {
+			return merry.Prepend(err, "invalid ID token signature")
+		}
+	case "ES":
+		pk, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return merry.Errorf("key type does not match algorithm %s", alg)
+		}
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return merry.New("invalid ID token signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pk, digest, r, s) {
+			return merry.New("invalid ID token signature")
+		}
+	default:
+		return merry.Errorf("unsupported ID token algorithm: %s", alg)
+	}
+	return nil
+}
+
+// jwtExpiry returns the expiry time in a JWT's exp claim, without verifying the token.
+// Returns the zero time if the token isn't a JWT, or has no exp claim.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	var claims struct {
+		Expiry int64 `json:"exp"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expiry, 0)
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gemalto/requester"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// OAuth2 returns middleware which sets the Authorization header of each request, using
+// tokens from the token source.
+//
+// Tokens are cached until shortly before they expire.  When a token needs to be refreshed,
+// one request fetches a new token from the token source, and concurrent requests wait for
+// it, rather than each fetching their own.
+func OAuth2(ts oauth2.TokenSource) requester.Middleware {
+	ts = oauth2.ReuseTokenSource(nil, ts)
+
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			tok, err := ts.Token()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			tok.SetAuthHeader(req)
+			return next.Do(req)
+		})
+	}
+}
+
+// ClientCredentials returns middleware which authenticates requests with tokens obtained with
+// the OAuth2 client credentials flow, for machine-to-machine APIs.  Token requests are sent
+// with httpClient, or http.DefaultClient if it's nil.  See OAuth2.
+func ClientCredentials(cfg *clientcredentials.Config, httpClient *http.Client) requester.Middleware {
+	ctx := context.Background()
+	if httpClient != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+	return OAuth2(cfg.TokenSource(ctx))
+}
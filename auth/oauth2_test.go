@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// countingTokenSource returns a new token on each call, which expires after ttl.
+type countingTokenSource struct {
+	calls int32
+	ttl   time.Duration
+	err   error
+}
+
+func (s *countingTokenSource) Token() (*oauth2.Token, error) {
+	n := atomic.AddInt32(&s.calls, 1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	// slow enough that concurrent requests overlap
+	time.Sleep(10 * time.Millisecond)
+	return &oauth2.Token{
+		AccessToken: "token" + strconv.Itoa(int(n)),
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(s.ttl),
+	}, nil
+}
+
+func TestOAuth2(t *testing.T) {
+	var auths []string
+	var mu sync.Mutex
+	doer := requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		auths = append(auths, req.Header.Get(requester.HeaderAuthorization))
+		return requester.MockResponse(200), nil
+	})
+
+	ts := &countingTokenSource{ttl: time.Hour}
+	reqs := requester.MustNew(requester.WithDoer(doer), OAuth2(ts))
+
+	// concurrent requests share a single token fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := reqs.Send(requester.Get("http://example.test/"))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ts.calls))
+	assert.Equal(t, []string{"Bearer token1", "Bearer token1", "Bearer token1", "Bearer token1", "Bearer token1"}, auths)
+
+	t.Run("refresh", func(t *testing.T) {
+		// tokens expiring within a few seconds are refreshed
+		ts := &countingTokenSource{ttl: time.Second}
+		reqs := requester.MustNew(requester.WithDoer(doer), OAuth2(ts))
+
+		for i := 0; i < 2; i++ {
+			_, err := reqs.Send(requester.Get("http://example.test/"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&ts.calls))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ts := &countingTokenSource{err: errors.New("no token")}
+		reqs := requester.MustNew(requester.WithDoer(doer), OAuth2(ts))
+
+		_, err := reqs.Send(requester.Get("http://example.test/"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no token")
+	})
+}
+
+func TestClientCredentials(t *testing.T) {
+	var tokenCalls int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenCalls, 1)
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "client", user)
+		assert.Equal(t, "secret", pass)
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		writeJSON(w, map[string]interface{}{
+			"access_token": "cc-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Auth", r.Header.Get(requester.HeaderAuthorization))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	reqs := requester.MustNew(
+		requester.URL(srv.URL+"/api"),
+		ClientCredentials(&clientcredentials.Config{
+			ClientID:     "client",
+			ClientSecret: "secret",
+			TokenURL:     srv.URL + "/token",
+		}, srv.Client()),
+	)
+
+	for i := 0; i < 2; i++ {
+		resp, err := reqs.Send()
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "Bearer cc-token", resp.Header.Get("X-Auth"))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tokenCalls))
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// OIDCConfig configures authentication against an OpenID Connect provider.
+type OIDCConfig struct {
+	// Issuer is the URL of the OpenID provider.  Provider metadata is
+	// discovered at <Issuer>/.well-known/openid-configuration.
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// Scopes to request.  The device flow always requests the "openid" scope.
+	Scopes []string
+
+	// DevicePrompt selects the device authorization flow (RFC 8628), rather
+	// than the client credentials flow.  It is called with the user code and
+	// verification URI, which must be shown to the user.  Tokens are requested
+	// once the user has authorized the device.
+	DevicePrompt func(DeviceAuthorization)
+
+	// HTTPClient is used for discovery, JWKS, and token requests.  Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// DeviceAuthorization is the response to a device authorization request.  The user
+// should be directed to VerificationURI, and asked to enter UserCode.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OIDC returns middleware which authenticates requests with access tokens
+// obtained from an OpenID Connect provider.  See OIDCTokenSource.  Waiting for
+// discovery or for the device flow is cancelled if the request's context is.
+func OIDC(cfg OIDCConfig) requester.Middleware {
+	s := &oidcTokenSource{cfg: cfg}
+
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			tok, err := s.token(req.Context())
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			tok.SetAuthHeader(req)
+			return next.Do(req)
+		})
+	}
+}
+
+// OIDCTokenSource returns an oauth2.TokenSource which obtains tokens from an
+// OpenID Connect provider.
+//
+// On first use, the provider's metadata is fetched from its discovery endpoint.
+// Tokens are then requested with the client credentials flow, or with the device
+// flow if OIDCConfig.DevicePrompt is set.  Tokens are cached, and refreshed when
+// they expire.  Only one discovery or device flow is in progress at a time: concurrent
+// calls wait for it to finish.
+//
+// If a token response includes an ID token, the ID token is validated: its
+// signature must be verified by a key from the provider's JWKS (which is cached),
+// it must be issued by the issuer to the client ID, and it must not be expired.
+//
+// If discovery fails, it is retried the next time a token is requested.
+func OIDCTokenSource(cfg OIDCConfig) oauth2.TokenSource {
+	return &oidcTokenSource{cfg: cfg}
+}
+
+// oidcMetadata is the subset of the OpenID provider metadata used
+// by oidcTokenSource.
+type oidcMetadata struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+type oidcTokenSource struct {
+	cfg OIDCConfig
+
+	mu        sync.Mutex
+	meta      *oidcMetadata
+	keys      *keySet
+	src       oauth2.TokenSource
+	validated string
+	// pending is closed when the discovery and device flow in progress finish.
+	// The network requests and polling are done without holding mu.
+	pending chan struct{}
+}
+
+func (s *oidcTokenSource) Token() (*oauth2.Token, error) {
+	return s.token(context.Background())
+}
+
+func (s *oidcTokenSource) token(ctx context.Context) (*oauth2.Token, error) {
+	src, err := s.source(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := src.Token()
+	if err != nil {
+		return nil, merry.Prepend(err, "fetching OIDC token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// only validate each new token once
+	if tok.AccessToken != s.validated {
+		if idToken, _ := tok.Extra("id_token").(string); idToken != "" {
+			if err := verifyIDToken(idToken, s.keys, s.meta.Issuer, s.cfg.ClientID, time.Now()); err != nil {
+				return nil, merry.Prepend(err, "validating ID token")
+			}
+		}
+		s.validated = tok.AccessToken
+	}
+	return tok, nil
+}
+
+func (s *oidcTokenSource) client() *http.Client {
+	if s.cfg.HTTPClient != nil {
+		return s.cfg.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (s *oidcTokenSource) requester() *requester.Requester {
+	return requester.MustNew(requester.WithDoer(s.client()))
+}
+
+// source returns the token source, discovering the provider and performing the device
+// flow first, if they haven't been yet.  If another call is already doing that, it waits
+// for it.
+func (s *oidcTokenSource) source(ctx context.Context) (oauth2.TokenSource, error) {
+	for {
+		s.mu.Lock()
+		if src := s.src; src != nil {
+			s.mu.Unlock()
+			return src, nil
+		}
+		pending := s.pending
+		if pending == nil {
+			break
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-pending:
+			// if it failed, try again
+		case <-ctx.Done():
+			return nil, merry.Prepend(ctx.Err(), "waiting for OIDC token")
+		}
+	}
+
+	pending := make(chan struct{})
+	s.pending = pending
+	meta := s.meta
+	s.mu.Unlock()
+
+	var keys *keySet
+	var src oauth2.TokenSource
+	var err error
+	if meta == nil {
+		meta, keys, err = s.discover(ctx)
+	}
+	if err == nil {
+		src, err = s.newSource(ctx, meta)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keys != nil {
+		s.meta, s.keys = meta, keys
+	}
+	if err == nil {
+		s.src = src
+	}
+	s.pending = nil
+	close(pending)
+	return src, err
+}
+
+func (s *oidcTokenSource) discover(ctx context.Context) (*oidcMetadata, *keySet, error) {
+	var meta oidcMetadata
+	_, _, err := s.requester().ReceiveContext(ctx, &meta,
+		requester.Get(strings.TrimSuffix(s.cfg.Issuer, "/")+"/.well-known/openid-configuration"),
+		requester.ExpectSuccessCode(),
+	)
+	if err != nil {
+		return nil, nil, merry.Prepend(err, "OIDC discovery")
+	}
+
+	if meta.Issuer != s.cfg.Issuer {
+		return nil, nil, merry.Errorf("OIDC discovery: issuer %q does not match %q", meta.Issuer, s.cfg.Issuer)
+	}
+	if meta.TokenEndpoint == "" {
+		return nil, nil, merry.New("OIDC discovery: provider has no token endpoint")
+	}
+
+	return &meta, &keySet{uri: meta.JWKSURI, reqs: s.requester()}, nil
+}
+
+// newSource returns the token source.  ctx only applies to the device flow: the source
+// refreshes tokens with its own context.
+func (s *oidcTokenSource) newSource(ctx context.Context, meta *oidcMetadata) (oauth2.TokenSource, error) {
+	srcCtx := context.WithValue(context.Background(), oauth2.HTTPClient, s.client())
+
+	if s.cfg.DevicePrompt == nil {
+		cc := clientcredentials.Config{
+			ClientID:     s.cfg.ClientID,
+			ClientSecret: s.cfg.ClientSecret,
+			TokenURL:     meta.TokenEndpoint,
+			Scopes:       s.cfg.Scopes,
+		}
+		return cc.TokenSource(srcCtx), nil
+	}
+
+	tok, err := s.deviceToken(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// the oauth2 config takes care of refreshing the token
+	c := oauth2.Config{
+		ClientID:     s.cfg.ClientID,
+		ClientSecret: s.cfg.ClientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: meta.TokenEndpoint},
+		Scopes:       s.scopes(),
+	}
+	return c.TokenSource(srcCtx, tok), nil
+}
+
+func (s *oidcTokenSource) scopes() []string {
+	for _, scope := range s.cfg.Scopes {
+		if scope == "openid" {
+			return s.cfg.Scopes
+		}
+	}
+	return append([]string{"openid"}, s.cfg.Scopes...)
+}
+
+// deviceToken performs the device authorization flow, and polls the token
+// endpoint until the user authorizes the device, or ctx is cancelled.
+func (s *oidcTokenSource) deviceToken(ctx context.Context, meta *oidcMetadata) (*oauth2.Token, error) {
+	if meta.DeviceAuthorizationEndpoint == "" {
+		return nil, merry.New("OIDC provider does not support the device authorization flow")
+	}
+
+	reqs := s.requester()
+
+	var da DeviceAuthorization
+	_, _, err := reqs.ReceiveContext(ctx, &da,
+		requester.Post(meta.DeviceAuthorizationEndpoint),
+		requester.Form(),
+		requester.Body(s.clientParams(url.Values{
+			"scope": {strings.Join(s.scopes(), " ")},
+		})),
+		requester.ExpectSuccessCode(),
+	)
+	if err != nil {
+		return nil, merry.Prepend(err, "device authorization")
+	}
+
+	s.cfg.DevicePrompt(da)
+
+	interval := time.Duration(da.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expires := time.Now().Add(time.Duration(da.ExpiresIn) * time.Second)
+
+	params := s.clientParams(url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {da.DeviceCode},
+	})
+
+	for {
+		var tr tokenResponse
+		_, _, err := reqs.ReceiveContext(ctx, &tr,
+			requester.Post(meta.TokenEndpoint),
+			requester.Form(),
+			requester.Body(params),
+		)
+		if err != nil {
+			return nil, merry.Prepend(err, "device access token request")
+		}
+
+		switch tr.Error {
+		case "":
+			if tr.AccessToken == "" {
+				return nil, merry.New("device access token request: server response missing access_token")
+			}
+			return tr.token(), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, merry.Errorf("device access token request: %s: %s", tr.Error, tr.ErrorDescription)
+		}
+
+		if da.ExpiresIn > 0 && time.Now().Add(interval).After(expires) {
+			return nil, merry.New("device access token request: device code expired")
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, merry.Prepend(ctx.Err(), "device access token request")
+		}
+	}
+}
+
+func (s *oidcTokenSource) clientParams(v url.Values) url.Values {
+	v.Set("client_id", s.cfg.ClientID)
+	if s.cfg.ClientSecret != "" {
+		v.Set("client_secret", s.cfg.ClientSecret)
+	}
+	return v
+}
+
+// tokenResponse is a token endpoint response, as defined in RFC 6749.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (tr *tokenResponse) token() *oauth2.Token {
+	tok := &oauth2.Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+	}
+	if tr.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	if tr.IDToken != "" {
+		tok = tok.WithExtra(map[string]interface{}{"id_token": tr.IDToken})
+	}
+	return tok
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider is a fake OpenID provider.
+type testProvider struct {
+	*httptest.Server
+	key          *rsa.PrivateKey
+	tokenCalls   int32
+	idTokenClaim map[string]interface{}
+	// authPending, if set, keeps device access token requests pending
+	authPending int32
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                        p.URL,
+			"token_endpoint":                p.URL + "/token",
+			"jwks_uri":                      p.URL + "/jwks",
+			"device_authorization_endpoint": p.URL + "/device",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"device_code":      "dc",
+			"user_code":        "ABCD",
+			"verification_uri": p.URL + "/verify",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") == "urn:ietf:params:oauth:grant-type:device_code" && atomic.LoadInt32(&p.authPending) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"authorization_pending"}`))
+			return
+		}
+		n := atomic.AddInt32(&p.tokenCalls, 1)
+		resp := map[string]interface{}{
+			"access_token": "token" + string(rune('0'+n)),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		}
+		if r.FormValue("grant_type") == "urn:ietf:params:oauth:grant-type:device_code" {
+			resp["refresh_token"] = "refresh"
+		}
+		if p.idTokenClaim != nil {
+			resp["id_token"] = p.signJWT(t, "RS256", "k1", p.idTokenClaim)
+		}
+		writeJSON(w, resp)
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testProvider) signJWT(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestOIDC_clientCredentials(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	var auths []string
+	doer := requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		auths = append(auths, req.Header.Get("Authorization"))
+		return requester.MockResponse(200), nil
+	})
+
+	reqs := requester.MustNew(doer, OIDC(OIDCConfig{
+		Issuer:       p.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	}))
+
+	for i := 0; i < 3; i++ {
+		_, _, err := reqs.Receive(nil)
+		require.NoError(t, err)
+	}
+
+	// token is cached
+	assert.Equal(t, []string{"Bearer token1", "Bearer token1", "Bearer token1"}, auths)
+	assert.EqualValues(t, 1, p.tokenCalls)
+}
+
+func TestOIDC_deviceFlow(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	p.idTokenClaim = map[string]interface{}{
+		"iss": p.URL,
+		"aud": "client",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	var prompt DeviceAuthorization
+	ts := OIDCTokenSource(OIDCConfig{
+		Issuer:   p.URL,
+		ClientID: "client",
+		DevicePrompt: func(da DeviceAuthorization) {
+			prompt = da
+		},
+	})
+
+	tok, err := ts.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "ABCD", prompt.UserCode)
+	assert.Equal(t, "token1", tok.AccessToken)
+	assert.Equal(t, "refresh", tok.RefreshToken)
+}
+
+func TestOIDC_deviceFlowCancel(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	atomic.StoreInt32(&p.authPending, 1)
+
+	prompted := make(chan struct{})
+	reqs := requester.MustNew(
+		requester.Get(p.URL+"/resource"),
+		OIDC(OIDCConfig{
+			Issuer:   p.URL,
+			ClientID: "client",
+			DevicePrompt: func(DeviceAuthorization) {
+				close(prompted)
+			},
+		}),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := reqs.SendContext(ctx)
+		errs <- err
+	}()
+	<-prompted
+
+	// other requests wait for the device flow in progress, but can be cancelled
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	start := time.Now()
+	_, err := reqs.SendContext(ctx2)
+	require.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// cancelling the device flow stops polling
+	cancel()
+	select {
+	case err := <-errs:
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.Canceled))
+	case <-time.After(5 * time.Second):
+		t.Fatal("device flow wasn't cancelled")
+	}
+}
+
+func TestOIDC_idTokenValidation(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	tests := []struct {
+		name   string
+		claims func() map[string]interface{}
+		errMsg string
+	}{
+		{
+			name: "valid",
+			claims: func() map[string]interface{} {
+				return map[string]interface{}{"iss": p.URL, "aud": []string{"other", "client"}, "exp": time.Now().Add(time.Hour).Unix()}
+			},
+		},
+		{
+			name: "wrong issuer",
+			claims: func() map[string]interface{} {
+				return map[string]interface{}{"iss": "http://evil.com", "aud": "client", "exp": time.Now().Add(time.Hour).Unix()}
+			},
+			errMsg: "ID token issued by",
+		},
+		{
+			name: "wrong audience",
+			claims: func() map[string]interface{} {
+				return map[string]interface{}{"iss": p.URL, "aud": "other", "exp": time.Now().Add(time.Hour).Unix()}
+			},
+			errMsg: "audience",
+		},
+		{
+			name: "expired",
+			claims: func() map[string]interface{} {
+				return map[string]interface{}{"iss": p.URL, "aud": "client", "exp": time.Now().Add(-time.Hour).Unix()}
+			},
+			errMsg: "expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.idTokenClaim = tt.claims()
+			ts := OIDCTokenSource(OIDCConfig{Issuer: p.URL, ClientID: "client"})
+			_, err := ts.Token()
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestOIDC_discoveryErrors(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+
+	// issuer mismatch
+	ts := OIDCTokenSource(OIDCConfig{Issuer: p.URL + "/", ClientID: "client"})
+	_, err := ts.Token()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match")
+
+	ts = OIDCTokenSource(OIDCConfig{Issuer: p.URL + "/missing", ClientID: "client"})
+	_, err = ts.Token()
+	require.Error(t, err)
+
+	_, _, err = requester.Receive(
+		requester.MockDoer(200),
+		OIDC(OIDCConfig{Issuer: p.URL + "/missing", ClientID: "client"}),
+	)
+	require.Error(t, err)
+}
+
+func TestVerifySignature(t *testing.T) {
+	signed := []byte("header.payload")
+	h := sha256.Sum256(signed)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, h[:])
+	require.NoError(t, err)
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+
+	require.NoError(t, verifySignature("ES256", &ecKey.PublicKey, signed, sig))
+	require.Error(t, verifySignature("ES256", &ecKey.PublicKey, []byte("tampered"), sig))
+	require.Error(t, verifySignature("RS256", &ecKey.PublicKey, signed, sig))
+	require.Error(t, verifySignature("none", &ecKey.PublicKey, signed, sig))
+	require.Error(t, verifySignature("HS256", &ecKey.PublicKey, signed, sig))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pss, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, h[:], nil)
+	require.NoError(t, err)
+	require.NoError(t, verifySignature("PS256", &rsaKey.PublicKey, signed, pss))
+	require.Error(t, verifySignature("RS256", &rsaKey.PublicKey, signed, pss))
+}
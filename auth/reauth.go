@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+// Reauthenticate returns middleware for APIs whose session tokens can expire mid-flight.  When
+// a request gets a 401 response, reauth is called to obtain a new token, and the request is
+// replayed once, with the new token in a bearer Authorization header.  Later requests are
+// sent with the new token too.
+//
+// Until the first 401, requests are sent with whatever credentials they already have.  If
+// concurrent requests get 401s, only one calls reauth: the others wait for it, and replay with
+// its token.  If reauth fails, its error is returned, with the 401 status as its HTTP code
+// (see merry.HTTPCode), and the 401 response is discarded.
+//
+// Requests with bodies can only be replayed if the request's GetBody function is set.
+// Otherwise the 401 response is returned.
+func Reauthenticate(reauth func(ctx context.Context) (token string, err error)) requester.Middleware {
+	s := &reauthState{reauth: reauth}
+
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			token, gen := s.current()
+			if token != "" {
+				req = withBearer(req, token)
+			}
+
+			resp, err := next.Do(req)
+			if err != nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+
+			if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+				return resp, nil
+			}
+
+			// the 401 response is discarded, so release its connection
+			drain(resp.Body)
+
+			token, err = s.refresh(req.Context(), gen)
+			if err != nil {
+				return nil, merry.Prepend(err, "reauthenticating").WithHTTPCode(resp.StatusCode)
+			}
+
+			replay := withBearer(req, token)
+			if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+				if replay.Body, err = req.GetBody(); err != nil {
+					return nil, merry.Prepend(err, "calling req.GetBody")
+				}
+			}
+
+			return next.Do(replay)
+		})
+	}
+}
+
+// reauthState holds the current token.  gen counts refreshes, so a request
+// which got a 401 can tell whether the token has been refreshed since it was sent.
+type reauthState struct {
+	reauth func(ctx context.Context) (string, error)
+
+	mu    sync.Mutex
+	token string
+	gen   int
+}
+
+func (s *reauthState) current() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, s.gen
+}
+
+// refresh obtains a new token, unless the token has already been refreshed
+// since generation gen.
+func (s *reauthState) refresh(ctx context.Context, gen int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gen != gen {
+		return s.token, nil
+	}
+
+	token, err := s.reauth(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.gen++
+	return token, nil
+}
+
+func withBearer(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set(requester.HeaderAuthorization, "Bearer "+token)
+	return req
+}
+
+func drain(r io.ReadCloser) {
+	if r == nil {
+		return
+	}
+	defer r.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(r, 4096))
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReauthenticate(t *testing.T) {
+	var valid atomic.Value
+	valid.Store("Bearer session1")
+
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(requester.HeaderAuthorization) != valid.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer srv.Close()
+
+	var reauths int32
+	reqs := requester.MustNew(
+		requester.URL(srv.URL),
+		requester.WithDoer(srv.Client()),
+		Reauthenticate(func(ctx context.Context) (string, error) {
+			n := atomic.AddInt32(&reauths, 1)
+			return "session" + strconv.Itoa(int(n)), nil
+		}),
+	)
+
+	// first request has no credentials, so it's reauthenticated and replayed, with its body
+	resp, err := reqs.Send(requester.Post("/"), requester.Body("hi"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"hi"}, bodies)
+
+	// later requests use the new token
+	resp, err = reqs.Send()
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reauths))
+
+	// the session expires
+	valid.Store("Bearer session2")
+	resp, err = reqs.Send()
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&reauths))
+
+	// only replayed once
+	valid.Store("Bearer never")
+	resp, err = reqs.Send()
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reauths))
+}
+
+func TestReauthenticate_noReplay(t *testing.T) {
+	var calls int
+	var bodies []*closeRecorder
+	doer := requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		resp := requester.MockResponse(401)
+		body := &closeRecorder{ReadCloser: resp.Body}
+		bodies = append(bodies, body)
+		resp.Body = body
+		return resp, nil
+	})
+
+	reauth := func(ctx context.Context) (string, error) {
+		return "", errors.New("bad credentials")
+	}
+
+	reqs := requester.MustNew(requester.WithDoer(doer), Reauthenticate(reauth))
+
+	// bodies which can't be rewound can't be replayed
+	resp, err := reqs.Send(requester.Body(ioutil.NopCloser(strings.NewReader("hi"))))
+	require.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+
+	_, err = reqs.Send()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad credentials")
+	assert.Equal(t, 401, merry.HTTPCode(err))
+	// the discarded 401 response is closed
+	assert.True(t, bodies[1].closed)
+}
+
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,19 @@
+// Package awssig signs requests with AWS Signature Version 4, so Requester can call AWS
+// and S3-compatible APIs directly:
+//
+//	reqs := requester.MustNew(
+//	    requester.URL("https://sqs.us-east-1.amazonaws.com"),
+//	    &awssig.Signer{
+//	        Credentials: awssig.Credentials{
+//	            AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+//	            SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+//	            SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+//	        },
+//	        Region:  "us-east-1",
+//	        Service: "sqs",
+//	    },
+//	)
+//
+// Signer implements requester.Signer, and is also a requester.Option, which installs
+// itself as the Requester's Signer.
+package awssig
@@ -0,0 +1,235 @@
+package awssig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+const (
+	algorithm       = "AWS4-HMAC-SHA256"
+	timeFormat      = "20060102T150405Z"
+	dateFormat      = "20060102"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// HeaderDate is the header which carries the signing time.
+	HeaderDate = "X-Amz-Date"
+	// HeaderContentSHA256 is the header which carries the hash of the payload.
+	HeaderContentSHA256 = "X-Amz-Content-Sha256"
+	// HeaderSecurityToken is the header which carries the session token of
+	// temporary credentials.
+	HeaderSecurityToken = "X-Amz-Security-Token"
+)
+
+// Credentials are AWS access keys.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only set for temporary credentials, e.g. from STS.
+	SessionToken string
+}
+
+// Signer signs requests with AWS Signature Version 4.
+type Signer struct {
+	Credentials Credentials
+	// Region is the region of the service, e.g. "us-east-1".
+	Region string
+	// Service is the signing name of the service, e.g. "s3" or "execute-api".
+	Service string
+	// UnsignedPayload excludes the body from the signature, so it doesn't have to be
+	// read before the request is sent.  Use it to stream large uploads to S3.  Otherwise,
+	// requests with bodies must have GetBody set, so the body can be hashed.
+	UnsignedPayload bool
+
+	// now returns the signing time.  Defaults to time.Now.
+	now func() time.Time
+}
+
+// Apply implements requester.Option.  It installs the Signer as the Requester's Signer.
+func (s *Signer) Apply(r *requester.Requester) error {
+	r.Signer = s
+	return nil
+}
+
+// Sign implements requester.Signer.  It sets the X-Amz-Date, X-Amz-Security-Token (if
+// there's a session token), and Authorization headers.  For S3, and unsigned payloads, it
+// also sets X-Amz-Content-Sha256.
+//
+// The host, content type, and all X-Amz-* headers are signed.
+func (s *Signer) Sign(req *http.Request) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	t := now().UTC()
+
+	payloadHash, err := s.payloadHash(req)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(HeaderDate, t.Format(timeFormat))
+	if s.UnsignedPayload || s.Service == "s3" {
+		req.Header.Set(HeaderContentSHA256, payloadHash)
+	}
+	if s.Credentials.SessionToken != "" {
+		req.Header.Set(HeaderSecurityToken, s.Credentials.SessionToken)
+	}
+
+	signedHeaders, canonicalHeaders := s.canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalPath(req),
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(dateFormat), s.Region, s.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		algorithm,
+		t.Format(timeFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.Credentials.SecretAccessKey), t.Format(dateFormat))
+	for _, part := range []string{s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set(requester.HeaderAuthorization, algorithm+
+		" Credential="+s.Credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+	return nil
+}
+
+func (s *Signer) payloadHash(req *http.Request) (string, error) {
+	if s.UnsignedPayload {
+		return unsignedPayload, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return hashHex(nil), nil
+	}
+	if req.GetBody == nil {
+		return "", merry.New("can't sign request body: GetBody isn't set.  Use an UnsignedPayload signer to stream bodies")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return "", merry.Prepend(err, "calling req.GetBody")
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", merry.Prepend(err, "reading request body")
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalPath returns the URI-encoded path.  Services other than S3 expect
+// each path segment to be encoded twice.
+func (s *Signer) canonicalPath(req *http.Request) string {
+	path := req.URL.Path
+	if path == "" {
+		return "/"
+	}
+	encoded := uriEncode(path, false)
+	if s.Service != "s3" {
+		encoded = uriEncode(encoded, false)
+	}
+	return encoded
+}
+
+func canonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	pairs := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders returns the signed header names, and the canonical headers block.
+func (s *Signer) canonicalHeaders(req *http.Request) (signed, canonical string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if lk != "content-type" && !strings.HasPrefix(lk, "x-amz-") {
+			continue
+		}
+		values := make([]string, len(vs))
+		for i, v := range vs {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[lk] = strings.Join(values, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(headers[k])
+		b.WriteByte('\n')
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// uriEncode encodes s as SigV4 requires: everything except unreserved characters is
+// percent-encoded, with upper case hex digits.  Slashes are only encoded if encodeSlash is true.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~',
+			c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package awssig
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSigner uses the credentials and time of the AWS Signature Version 4 test suite.
+func testSigner() *Signer {
+	return &Signer{
+		Credentials: Credentials{
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		},
+		Region:  "us-east-1",
+		Service: "service",
+		now: func() time.Time {
+			return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+		},
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	tests := []struct {
+		name, method, url, auth string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			auth:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, nil)
+			require.NoError(t, err)
+
+			require.NoError(t, testSigner().Sign(req))
+			assert.Equal(t, "20150830T123600Z", req.Header.Get(HeaderDate))
+			assert.Equal(t, tc.auth, req.Header.Get(requester.HeaderAuthorization))
+		})
+	}
+}
+
+func TestSigner_body(t *testing.T) {
+	signed := func(s *Signer, body string) *http.Request {
+		req, err := http.NewRequest("POST", "https://example.amazonaws.com/", strings.NewReader(body))
+		require.NoError(t, err)
+		require.NoError(t, s.Sign(req))
+		return req
+	}
+
+	// the body is part of the signature
+	s := testSigner()
+	assert.NotEqual(t, signed(s, "a").Header.Get(requester.HeaderAuthorization), signed(s, "b").Header.Get(requester.HeaderAuthorization))
+	assert.Empty(t, signed(s, "a").Header.Get(HeaderContentSHA256))
+
+	// unless the payload is unsigned
+	s.UnsignedPayload = true
+	assert.Equal(t, signed(s, "a").Header.Get(requester.HeaderAuthorization), signed(s, "b").Header.Get(requester.HeaderAuthorization))
+	assert.Equal(t, "UNSIGNED-PAYLOAD", signed(s, "a").Header.Get(HeaderContentSHA256))
+
+	// S3 always gets the payload hash header
+	s = testSigner()
+	s.Service = "s3"
+	assert.Equal(t, "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb", signed(s, "a").Header.Get(HeaderContentSHA256))
+
+	// bodies which can't be re-read can't be signed
+	req, err := http.NewRequest("POST", "https://example.amazonaws.com/", strings.NewReader("a"))
+	require.NoError(t, err)
+	req.GetBody = nil
+	assert.Error(t, testSigner().Sign(req))
+}
+
+func TestSigner_sessionToken(t *testing.T) {
+	s := testSigner()
+	s.Credentials.SessionToken = "token"
+
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(req))
+
+	assert.Equal(t, "token", req.Header.Get(HeaderSecurityToken))
+	assert.Contains(t, req.Header.Get(requester.HeaderAuthorization), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+func TestSigner_Apply(t *testing.T) {
+	s := testSigner()
+	req, err := requester.Request(
+		requester.Post("https://example.amazonaws.com/a b/c"),
+		requester.JSON(false),
+		requester.Body(map[string]string{"color": "red"}),
+		s,
+	)
+	require.NoError(t, err)
+
+	auth := req.Header.Get(requester.HeaderAuthorization)
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="), auth)
+	assert.Equal(t, "/a%2520b/c", s.canonicalPath(req))
+}
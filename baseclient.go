@@ -0,0 +1,57 @@
+package requester
+
+// BaseClient is a base type for REST API bindings built on Requester.  Embed it in an SDK's
+// client types, and derive a child client per API resource with NewResourceClient:
+//
+//	type WidgetsClient struct {
+//	    *requester.BaseClient
+//	}
+//
+//	func (c *WidgetsClient) Get(ctx context.Context, id string) (*Widget, error) {
+//	    var w Widget
+//	    _, _, err := c.ReceiveContext(ctx, &w, requester.Get(id))
+//	    return &w, err
+//	}
+//
+//	type APIClient struct {
+//	    *requester.BaseClient
+//	    Widgets *WidgetsClient
+//	}
+//
+//	func NewAPIClient(baseURL string, opts ...requester.Option) (*APIClient, error) {
+//	    c, err := requester.NewBaseClient(append([]requester.Option{requester.URL(baseURL)}, opts...)...)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    return &APIClient{
+//	        BaseClient: c,
+//	        Widgets:    &WidgetsClient{c.NewResourceClient("widgets/")},
+//	    }, nil
+//	}
+//
+// All the Requester's methods are available on the BaseClient.
+type BaseClient struct {
+	*Requester
+}
+
+// NewBaseClient returns a new BaseClient, with a Requester configured with the options.
+func NewBaseClient(opts ...Option) (*BaseClient, error) {
+	r, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &BaseClient{Requester: r}, nil
+}
+
+// NewResourceClient returns a child BaseClient, whose URL has the path elements appended, like
+// the AppendPath option.  Include a trailing slash to make the child's requests relative to
+// the resource path:
+//
+//	users := c.NewResourceClient("users/")
+//	users.Receive(&u, requester.Get("123"))  // GET <base>/users/123
+//
+// The child is a clone of the BaseClient's Requester, so it uses the same Doer, middleware,
+// headers, and other options.  Changes made to either afterward don't affect the other.
+func (c *BaseClient) NewResourceClient(elements ...string) *BaseClient {
+	return &BaseClient{Requester: c.MustWith(AppendPath(elements...))}
+}
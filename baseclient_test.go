@@ -0,0 +1,49 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseClient_NewResourceClient(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+	}))
+	defer ts.Close()
+
+	calls := 0
+	counter := Middleware(func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+			return next.Do(req)
+		})
+	})
+
+	c, err := NewBaseClient(URL(ts.URL+"/api/v1/"), Header("X-Api-Key", "secret"), counter)
+	require.NoError(t, err)
+
+	users := c.NewResourceClient("users/")
+	roles := users.NewResourceClient("123", "roles/")
+
+	_, err = users.Send(Get("123"))
+	require.NoError(t, err)
+	_, err = roles.Send(Get("admin"))
+	require.NoError(t, err)
+	_, err = c.Send(Get("health"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/api/v1/users/123", "/api/v1/users/123/roles/admin", "/api/v1/health"}, paths)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, ts.URL+"/api/v1/", c.URL.String())
+}
+
+func TestNewBaseClient_error(t *testing.T) {
+	_, err := NewBaseClient(URL(":bad"))
+	require.Error(t, err)
+}
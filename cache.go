@@ -0,0 +1,472 @@
+package requester
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// CacheStore stores cached responses for the Cache middleware.  Implementations
+// must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the value stored for key, if any.
+	Get(key string) ([]byte, bool)
+	// Set stores a value for key.
+	Set(key string, value []byte)
+	// Delete removes key from the store.
+	Delete(key string)
+}
+
+// DefaultCacheSize is the number of responses the store created by Cache(nil) holds.
+const DefaultCacheSize = 1000
+
+// DefaultCacheBytes is the total size of the responses the store created by Cache(nil) holds.
+const DefaultCacheBytes = 64 << 20
+
+// DefaultCacheMaxEntrySize is the size of the largest response body the Cache middleware stores,
+// unless CacheConfig.MaxEntrySize is set.
+const DefaultCacheMaxEntrySize = 1 << 20
+
+// CacheConfig defines settings for the Cache middleware.
+type CacheConfig struct {
+	// Store holds the cached responses.  If nil, an in-memory store holding up to
+	// DefaultCacheSize responses, and DefaultCacheBytes bytes, is used.
+	Store CacheStore
+	// MaxEntrySize is the size of the largest response body which is stored, in bytes.
+	// Defaults to DefaultCacheMaxEntrySize.
+	MaxEntrySize int64
+}
+
+func (c *CacheConfig) normalize() {
+	if c.Store == nil {
+		c.Store = NewMemoryCache(DefaultCacheSize, DefaultCacheBytes)
+	}
+	if c.MaxEntrySize <= 0 {
+		c.MaxEntrySize = DefaultCacheMaxEntrySize
+	}
+}
+
+// Cache is middleware which caches responses to GET requests, following the rules for
+// private caches in RFC 7234.  If store is nil, an in-memory store holding up to
+// DefaultCacheSize responses is used.  See CacheWithConfig.
+func Cache(store CacheStore) Middleware {
+	return CacheWithConfig(&CacheConfig{Store: store})
+}
+
+// CacheWithConfig is like Cache, with more settings.
+//
+// Fresh responses, according to the Cache-Control, Expires, Date, and Age headers,
+// are served from the store without contacting the server.  Stale responses with an ETag
+// or Last-Modified header are revalidated with a conditional request, and served from
+// the store if the server responds 304 Not Modified.  Responses with "Cache-Control: no-store",
+// or with "Vary: *", are never stored.  Stored responses are only served to requests which
+// match the values of the headers named by the response's Vary header.
+//
+// Only responses with a Content-Length no larger than MaxEntrySize are stored, so large or
+// streamed responses pass through without being read into memory.  Errors storing a response
+// are ignored: the response is still returned.
+//
+// Successful requests with unsafe methods, like POST or DELETE, evict the cached response
+// for their URL.
+func CacheWithConfig(config *CacheConfig) Middleware {
+	var c CacheConfig
+	if config != nil {
+		c = *config
+	}
+	c.normalize()
+	store := c.Store
+
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			key := req.URL.String()
+
+			switch req.Method {
+			case http.MethodGet:
+			case http.MethodHead, http.MethodOptions, http.MethodTrace:
+				return next.Do(req)
+			default:
+				resp, err := next.Do(req)
+				if err == nil && resp.StatusCode < 400 {
+					store.Delete(key)
+				}
+				return resp, err
+			}
+
+			reqCC := parseCacheControl(req.Header)
+			if _, ok := reqCC["no-store"]; ok || req.Header.Get("Range") != "" {
+				return next.Do(req)
+			}
+
+			entry, cached := loadCacheEntry(store, key, req)
+			if cached {
+				if entry.fresh(reqCC) {
+					return entry.response(req)
+				}
+
+				if condReq := entry.conditionalRequest(req); condReq != nil {
+					resp, err := next.Do(condReq)
+					if err != nil || resp.StatusCode != http.StatusNotModified {
+						return storeResponse(store, key, req, resp, err, c.MaxEntrySize)
+					}
+
+					drain(resp.Body)
+					entry.update(resp)
+					_ = saveCacheEntry(store, key, entry)
+					return entry.response(req)
+				}
+			}
+
+			resp, err := next.Do(req)
+			return storeResponse(store, key, req, resp, err, c.MaxEntrySize)
+		})
+	}
+}
+
+// cacheEntry is the value stored in a CacheStore.
+type cacheEntry struct {
+	StatusCode int
+	Proto      string
+	// Header is the header of the response, which is updated on revalidation.
+	Header http.Header
+	Body   []byte
+	// Vary holds the values of the request headers named by the response's Vary header.
+	Vary http.Header
+}
+
+func loadCacheEntry(store CacheStore, key string, req *http.Request) (*cacheEntry, bool) {
+	b, ok := store.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	var entry cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&entry); err != nil {
+		store.Delete(key)
+		return nil, false
+	}
+
+	for name, values := range entry.Vary {
+		if strings.Join(values, ", ") != strings.Join(req.Header.Values(name), ", ") {
+			return nil, false
+		}
+	}
+
+	return &entry, true
+}
+
+func saveCacheEntry(store CacheStore, key string, entry *cacheEntry) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return merry.Prepend(err, "encoding cache entry")
+	}
+	store.Set(key, buf.Bytes())
+	return nil
+}
+
+// storeResponse stores resp, if it's cacheable, and its body is no larger than max.
+func storeResponse(store CacheStore, key string, req *http.Request, resp *http.Response, err error, max int64) (*http.Response, error) {
+	if err != nil || resp == nil || !cacheable(resp) {
+		return resp, err
+	}
+	if resp.ContentLength < 0 || resp.ContentLength > max {
+		return resp, nil
+	}
+
+	var b []byte
+	if resp.Body != nil && resp.Body != http.NoBody {
+		body, err := bufRespBody(resp.Body)
+		if err != nil {
+			return resp, merry.Prepend(err, "reading response body")
+		}
+		resp.Body = body
+		b = body.(*bufferedBody).b
+	}
+
+	if resp.Header.Get("Date") == "" {
+		resp.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	entry := cacheEntry{
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		Header:     resp.Header.Clone(),
+		Body:       b,
+		Vary:       http.Header{},
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+				entry.Vary[name] = req.Header.Values(name)
+			}
+		}
+	}
+
+	_ = saveCacheEntry(store, key, &entry)
+	return resp, nil
+}
+
+func cacheable(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return false
+	}
+
+	if _, ok := parseCacheControl(resp.Header)["no-store"]; ok {
+		return false
+	}
+
+	for _, v := range resp.Header.Values("Vary") {
+		if strings.TrimSpace(v) == "*" {
+			return false
+		}
+	}
+
+	// without a freshness lifetime or a validator, the response can't be used again
+	return freshnessLifetime(resp.Header) > 0 ||
+		resp.Header.Get("ETag") != "" ||
+		resp.Header.Get("Last-Modified") != ""
+}
+
+// fresh returns true if the entry can be served without revalidation.
+func (e *cacheEntry) fresh(reqCC map[string]string) bool {
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	if _, ok := parseCacheControl(e.Header)["no-cache"]; ok {
+		return false
+	}
+
+	age := currentAge(e.Header)
+	if maxAge, ok := reqCC["max-age"]; ok {
+		if secs, err := strconv.Atoi(maxAge); err == nil && age > time.Duration(secs)*time.Second {
+			return false
+		}
+	}
+
+	return age < freshnessLifetime(e.Header)
+}
+
+// conditionalRequest returns a request which revalidates the entry, or nil if
+// the entry has no validators.
+func (e *cacheEntry) conditionalRequest(req *http.Request) *http.Request {
+	etag, lastModified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return nil
+	}
+
+	// the caller is already making its own conditional request
+	if req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return nil
+	}
+
+	req = req.Clone(req.Context())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// update replaces the entry's header fields with those of a 304 response.
+func (e *cacheEntry) update(resp *http.Response) {
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Transfer-Encoding", "Content-Encoding":
+			continue
+		}
+		e.Header[name] = values
+	}
+	if resp.Header.Get("Date") == "" {
+		e.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	e.Header.Del("Age")
+}
+
+// response recreates the stored response.
+func (e *cacheEntry) response(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         e.Proto,
+		Header:        e.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+	resp.ProtoMajor, resp.ProtoMinor, _ = http.ParseHTTPVersion(e.Proto)
+	resp.Header.Set("Age", strconv.Itoa(int(currentAge(e.Header).Seconds())))
+	return resp, nil
+}
+
+// freshnessLifetime returns how long a response is fresh for, from its max-age directive,
+// or its Expires header.
+func freshnessLifetime(h http.Header) time.Duration {
+	if maxAge, ok := parseCacheControl(h)["max-age"]; ok {
+		secs, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates, like "0", mean already expired
+			return 0
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			return 0
+		}
+		return exp.Sub(date)
+	}
+
+	return 0
+}
+
+// currentAge returns the age of a response, from its Date and Age headers.
+func currentAge(h http.Header) time.Duration {
+	var age time.Duration
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		age = time.Since(date)
+		if age < 0 {
+			age = 0
+		}
+	}
+	if secs, err := strconv.Atoi(h.Get("Age")); err == nil && secs > 0 {
+		age += time.Duration(secs) * time.Second
+	}
+	return age
+}
+
+// parseCacheControl parses the Cache-Control directives in h.  Directive names are
+// lower-cased, and directives without values map to an empty string.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := map[string]string{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i > -1 {
+				name, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	return cc
+}
+
+// MemoryCache is an in-memory CacheStore, which evicts the least recently
+// used entries when full.
+type MemoryCache struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	bytes   int64
+}
+
+type memoryCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCache returns a MemoryCache which holds up to maxEntries entries, and up to
+// maxBytes bytes of values.  If either is <= 0, that dimension is unlimited.  Values larger
+// than maxBytes aren't stored.
+func NewMemoryCache(maxEntries int, maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+// Get implements CacheStore.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*memoryCacheEntry).value, true
+}
+
+// Set implements CacheStore.
+func (c *MemoryCache) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxBytes > 0 && int64(len(value)) > c.maxBytes {
+		c.remove(key)
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		me := e.Value.(*memoryCacheEntry)
+		c.bytes += int64(len(value) - len(me.value))
+		me.value = value
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key: key, value: value})
+		c.bytes += int64(len(value))
+	}
+
+	for (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.remove(c.lru.Back().Value.(*memoryCacheEntry).key)
+	}
+}
+
+// Delete implements CacheStore.
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+}
+
+func (c *MemoryCache) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		c.bytes -= int64(len(e.Value.(*memoryCacheEntry).value))
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Size returns the total size of the values in the cache, in bytes.
+func (c *MemoryCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
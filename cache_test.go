@@ -0,0 +1,221 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	var hits int32
+	var version int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "fresh")
+	})
+	mux.HandleFunc("/expires", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		now := time.Now().UTC()
+		w.Header().Set("Date", now.Format(http.TimeFormat))
+		w.Header().Set("Expires", now.Add(time.Minute).Format(http.TimeFormat))
+		fmt.Fprint(w, "expires")
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		etag := `"v` + strconv.Itoa(int(atomic.LoadInt32(&version))) + `"`
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, etag)
+	})
+	mux.HandleFunc("/lastmodified", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		lm := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+		w.Header().Set("Last-Modified", lm)
+		if r.Header.Get("If-Modified-Since") == lm {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "lastmodified")
+	})
+	mux.HandleFunc("/nostore", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		fmt.Fprint(w, "nostore")
+	})
+	mux.HandleFunc("/vary", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept")
+		fmt.Fprint(w, r.Header.Get("Accept"))
+	})
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	newRequester := func() *Requester {
+		atomic.StoreInt32(&hits, 0)
+		return MustNew(URL(ts.URL), Cache(nil))
+	}
+
+	receive := func(t *testing.T, r *Requester, opts ...Option) string {
+		t.Helper()
+		resp, body, err := r.Receive(nil, opts...)
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		return string(body)
+	}
+
+	t.Run("fresh", func(t *testing.T) {
+		for _, path := range []string{"/fresh", "/expires"} {
+			r := newRequester()
+			assert.Equal(t, path[1:], receive(t, r, Get(path)))
+			assert.Equal(t, path[1:], receive(t, r, Get(path)))
+			assert.EqualValues(t, 1, atomic.LoadInt32(&hits), path)
+
+			// request no-cache forces a trip to the server
+			assert.Equal(t, path[1:], receive(t, r, Get(path), Header("Cache-Control", "no-cache")))
+			assert.EqualValues(t, 2, atomic.LoadInt32(&hits), path)
+		}
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		r := newRequester()
+		assert.Equal(t, `"v0"`, receive(t, r, Get("/etag")))
+		assert.Equal(t, `"v0"`, receive(t, r, Get("/etag")))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits), "should revalidate")
+
+		atomic.StoreInt32(&version, 1)
+		assert.Equal(t, `"v1"`, receive(t, r, Get("/etag")))
+		assert.Equal(t, `"v1"`, receive(t, r, Get("/etag")))
+		assert.EqualValues(t, 4, atomic.LoadInt32(&hits))
+	})
+
+	t.Run("lastmodified", func(t *testing.T) {
+		r := newRequester()
+		assert.Equal(t, "lastmodified", receive(t, r, Get("/lastmodified")))
+		resp, body, err := r.Receive(nil, Get("/lastmodified"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "lastmodified", string(body))
+		assert.NotEmpty(t, resp.Header.Get("Age"))
+	})
+
+	t.Run("nostore", func(t *testing.T) {
+		r := newRequester()
+		receive(t, r, Get("/nostore"))
+		receive(t, r, Get("/nostore"))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits))
+
+		receive(t, r, Get("/fresh"), Header("Cache-Control", "no-store"))
+		receive(t, r, Get("/fresh"))
+		assert.EqualValues(t, 4, atomic.LoadInt32(&hits), "request no-store should bypass the cache")
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		r := newRequester()
+		assert.Equal(t, "text/plain", receive(t, r, Get("/vary"), Accept("text/plain")))
+		assert.Equal(t, "text/plain", receive(t, r, Get("/vary"), Accept("text/plain")))
+		assert.EqualValues(t, 1, atomic.LoadInt32(&hits))
+		assert.Equal(t, "text/html", receive(t, r, Get("/vary"), Accept("text/html")))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits))
+	})
+
+	t.Run("entry size", func(t *testing.T) {
+		mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, "0123456789")
+		})
+		mux.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, "chunked")
+			// flushing before the handler returns means there's no Content-Length
+			w.(http.Flusher).Flush()
+		})
+
+		atomic.StoreInt32(&hits, 0)
+		store := NewMemoryCache(0, 0)
+		r := MustNew(URL(ts.URL), CacheWithConfig(&CacheConfig{Store: store, MaxEntrySize: 9}))
+		assert.Equal(t, "0123456789", receive(t, r, Get("/large")))
+		assert.Equal(t, "0123456789", receive(t, r, Get("/large")))
+		assert.EqualValues(t, 2, atomic.LoadInt32(&hits), "bodies over the max size aren't stored")
+
+		assert.Equal(t, "chunked", receive(t, r, Get("/chunked")))
+		assert.Equal(t, "chunked", receive(t, r, Get("/chunked")))
+		assert.EqualValues(t, 4, atomic.LoadInt32(&hits), "bodies of unknown length aren't stored")
+		assert.Zero(t, store.Len())
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		r := newRequester()
+		receive(t, r, Get("/fresh"))
+		_, _, err := r.Receive(nil, Post("/fresh"))
+		require.NoError(t, err)
+		receive(t, r, Get("/fresh"))
+		assert.EqualValues(t, 3, atomic.LoadInt32(&hits))
+	})
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := NewMemoryCache(2, 0)
+
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+
+	// touch a, so b is the least recently used
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "1", string(v))
+
+	c.Set("c", []byte("3"))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	c.Set("a", []byte("4"))
+	v, _ = c.Get("a")
+	assert.Equal(t, "4", string(v))
+
+	c.Delete("a")
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	t.Run("max bytes", func(t *testing.T) {
+		c := NewMemoryCache(0, 5)
+		c.Set("a", []byte("12"))
+		c.Set("b", []byte("34"))
+		assert.EqualValues(t, 4, c.Size())
+
+		// evicts a, the least recently used
+		c.Set("c", []byte("56"))
+		assert.EqualValues(t, 4, c.Size())
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+
+		// values larger than the cache aren't stored
+		c.Set("b", []byte("123456"))
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+		assert.EqualValues(t, 2, c.Size())
+
+		c.Delete("c")
+		assert.Zero(t, c.Size())
+	})
+}
@@ -0,0 +1,186 @@
+package requester
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester/httpclient"
+)
+
+// Config is a declarative Requester configuration, which can be unmarshaled from JSON or
+// YAML config files, so services can declare their HTTP clients' setup in config.  Use
+// FromConfig to build a Requester from it:
+//
+//	url: https://api.example.com/v1/
+//	headers:
+//	  Accept: application/json
+//	timeout: 10s
+//	retry:
+//	  maxAttempts: 5
+//	tls:
+//	  caFile: /etc/ssl/internal-ca.pem
+//
+// All fields are optional.  Zero values leave the Requester's defaults in place.
+type Config struct {
+	Method string `json:"method,omitempty" yaml:"method,omitempty"`
+	URL    string `json:"url,omitempty" yaml:"url,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Query   map[string]string `json:"query,omitempty" yaml:"query,omitempty"`
+
+	// Timeout is the overall timeout of requests, including reading the response body.
+	Timeout               Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	DialTimeout           Duration `json:"dialTimeout,omitempty" yaml:"dialTimeout,omitempty"`
+	TLSHandshakeTimeout   Duration `json:"tlsHandshakeTimeout,omitempty" yaml:"tlsHandshakeTimeout,omitempty"`
+	ResponseHeaderTimeout Duration `json:"responseHeaderTimeout,omitempty" yaml:"responseHeaderTimeout,omitempty"`
+
+	ProxyURL string `json:"proxyURL,omitempty" yaml:"proxyURL,omitempty"`
+
+	// Retry, if set, installs the Retry middleware.
+	Retry *RetryFileConfig `json:"retry,omitempty" yaml:"retry,omitempty"`
+
+	TLS *TLSFileConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// RetryFileConfig is the serializable subset of RetryConfig, used in Config.
+type RetryFileConfig struct {
+	// MaxAttempts defaults to 3.
+	MaxAttempts    int      `json:"maxAttempts,omitempty" yaml:"maxAttempts,omitempty"`
+	MaxRetryAfter  Duration `json:"maxRetryAfter,omitempty" yaml:"maxRetryAfter,omitempty"`
+	MaxElapsedTime Duration `json:"maxElapsedTime,omitempty" yaml:"maxElapsedTime,omitempty"`
+	AttemptHeader  string   `json:"attemptHeader,omitempty" yaml:"attemptHeader,omitempty"`
+	ReadResponse   bool     `json:"readResponse,omitempty" yaml:"readResponse,omitempty"`
+	// BaseDelay and MaxDelay, if set, replace the default backoff with an exponential
+	// backoff, with the same multiplier and jitter as DefaultBackoff.
+	BaseDelay Duration `json:"baseDelay,omitempty" yaml:"baseDelay,omitempty"`
+	MaxDelay  Duration `json:"maxDelay,omitempty" yaml:"maxDelay,omitempty"`
+}
+
+// TLSFileConfig configures TLS, with paths to PEM files, used in Config.
+type TLSFileConfig struct {
+	// CAFile is a file of root CAs, which are trusted in addition to the system's.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are a client certificate and key.
+	CertFile           string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// Duration is a time.Duration which is marshaled as a string, like "1m30s", in config
+// files.  Integers are also accepted, as a number of seconds.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	if secs, err := strconv.Atoi(s); err == nil {
+		*d = Duration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return merry.Prepend(err, "invalid duration")
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting strings or numbers of seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if uq, err := strconv.Unquote(s); err == nil {
+		s = uq
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// Options returns the options which configure a Requester as the Config declares.
+func (c *Config) Options() []Option {
+	var opts []Option
+	if c.Method != "" {
+		opts = append(opts, Method(c.Method))
+	}
+	if c.URL != "" {
+		opts = append(opts, URL(c.URL))
+	}
+	for k, v := range c.Headers {
+		opts = append(opts, Header(k, v))
+	}
+	for k, v := range c.Query {
+		opts = append(opts, QueryParam(k, v))
+	}
+
+	if copts := c.clientOptions(); len(copts) > 0 {
+		opts = append(opts, Client(copts...))
+	}
+
+	if rc := c.Retry; rc != nil {
+		cfg := RetryConfig{
+			MaxAttempts:    rc.MaxAttempts,
+			MaxRetryAfter:  time.Duration(rc.MaxRetryAfter),
+			MaxElapsedTime: time.Duration(rc.MaxElapsedTime),
+			AttemptHeader:  rc.AttemptHeader,
+			ReadResponse:   rc.ReadResponse,
+		}
+		if rc.BaseDelay != 0 || rc.MaxDelay != 0 {
+			b := DefaultBackoff
+			if rc.BaseDelay != 0 {
+				b.BaseDelay = time.Duration(rc.BaseDelay)
+			}
+			if rc.MaxDelay != 0 {
+				b.MaxDelay = time.Duration(rc.MaxDelay)
+			}
+			cfg.Backoff = &b
+		}
+		opts = append(opts, Retry(&cfg))
+	}
+	return opts
+}
+
+func (c *Config) clientOptions() []httpclient.Option {
+	var opts []httpclient.Option
+	if c.Timeout != 0 {
+		opts = append(opts, httpclient.Timeout(time.Duration(c.Timeout)))
+	}
+	if c.DialTimeout != 0 {
+		opts = append(opts, httpclient.DialTimeout(time.Duration(c.DialTimeout)))
+	}
+	if c.TLSHandshakeTimeout != 0 {
+		opts = append(opts, httpclient.TLSHandshakeTimeout(time.Duration(c.TLSHandshakeTimeout)))
+	}
+	if c.ResponseHeaderTimeout != 0 {
+		opts = append(opts, httpclient.ResponseHeaderTimeout(time.Duration(c.ResponseHeaderTimeout)))
+	}
+	if c.ProxyURL != "" {
+		opts = append(opts, httpclient.ProxyURL(c.ProxyURL))
+	}
+	if t := c.TLS; t != nil {
+		if t.CAFile != "" {
+			opts = append(opts, httpclient.AppendRootCAFile(t.CAFile))
+		}
+		if t.CertFile != "" || t.KeyFile != "" {
+			opts = append(opts, httpclient.ClientCert(t.CertFile, t.KeyFile))
+		}
+		if t.InsecureSkipVerify {
+			opts = append(opts, httpclient.SkipVerify(true))
+		}
+	}
+	return opts
+}
+
+// FromConfig returns a new Requester configured as the Config declares.  Additional
+// options are applied after the Config's, for settings which can't be serialized, like
+// middleware.
+func FromConfig(cfg Config, opts ...Option) (*Requester, error) {
+	r, err := New(append(cfg.Options(), opts...)...)
+	if err != nil {
+		return nil, merry.Prepend(err, "invalid config")
+	}
+	return r, nil
+}
@@ -0,0 +1,86 @@
+package requester
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromConfig(t *testing.T) {
+	var attempts []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, r.Header.Get("X-Attempt"))
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "/v1/", r.URL.Path)
+		assert.Equal(t, "red", r.URL.Query().Get("color"))
+		assert.Equal(t, "json", r.Header.Get("X-Format"))
+		if len(attempts) < 2 {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, ioutil.WriteFile(caFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"method": "PUT",
+		"url": "`+ts.URL+`/v1/",
+		"headers": {"X-Format": "json"},
+		"query": {"color": "red"},
+		"timeout": "5s",
+		"dialTimeout": 2,
+		"retry": {"maxAttempts": 3, "attemptHeader": "X-Attempt", "baseDelay": "1ms"},
+		"tls": {"caFile": "`+filepath.ToSlash(caFile)+`"}
+	}`), &cfg))
+
+	assert.Equal(t, Duration(5*time.Second), cfg.Timeout)
+	assert.Equal(t, Duration(2*time.Second), cfg.DialTimeout)
+
+	r, err := FromConfig(cfg)
+	require.NoError(t, err)
+	require.IsType(t, &http.Client{}, r.Doer)
+	assert.Equal(t, 5*time.Second, r.Doer.(*http.Client).Timeout)
+
+	resp, err := r.Send()
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []string{"1", "2"}, attempts)
+}
+
+func TestFromConfig_empty(t *testing.T) {
+	r, err := FromConfig(Config{}, Header("X-Extra", "1"))
+	require.NoError(t, err)
+	assert.Nil(t, r.Doer)
+	assert.Empty(t, r.Middleware)
+	assert.Equal(t, "1", r.Header.Get("X-Extra"))
+}
+
+func TestFromConfig_invalid(t *testing.T) {
+	_, err := FromConfig(Config{TLS: &TLSFileConfig{CAFile: "missing.pem"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid config")
+}
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	require.NoError(t, json.Unmarshal([]byte(`"1m30s"`), &d))
+	assert.Equal(t, Duration(90*time.Second), d)
+	require.NoError(t, json.Unmarshal([]byte(`10`), &d))
+	assert.Equal(t, Duration(10*time.Second), d)
+	assert.Error(t, json.Unmarshal([]byte(`"soon"`), &d))
+
+	b, err := json.Marshal(Duration(90 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, `"1m30s"`, string(b))
+}
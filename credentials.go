@@ -0,0 +1,234 @@
+package requester
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ansel1/merry"
+)
+
+// Credential is a secret used to authenticate requests.  Which fields are used
+// depends on the middleware consuming it.
+type Credential struct {
+	Username string
+	Password string
+	// Token is a bearer token, or API key.
+	Token string
+}
+
+// CredentialProvider supplies credentials when a request is sent, e.g. from HashiCorp Vault
+// or a KMS, rather than embedding them in the Requester when it's built.  Get is called for
+// each request, so providers which call remote services should cache credentials.
+type CredentialProvider interface {
+	Get(ctx context.Context) (Credential, error)
+}
+
+// CredentialProviderFunc adapts a function to the CredentialProvider interface.
+type CredentialProviderFunc func(ctx context.Context) (Credential, error)
+
+// Get implements CredentialProvider.
+func (f CredentialProviderFunc) Get(ctx context.Context) (Credential, error) {
+	return f(ctx)
+}
+
+// BasicAuthProvider is middleware which sets basic auth credentials, using the Username and
+// Password of the credential supplied by p for each request.
+func BasicAuthProvider(p CredentialProvider) Middleware {
+	return credentialAuth(p, func(req *http.Request, c Credential) {
+		req.SetBasicAuth(c.Username, c.Password)
+	})
+}
+
+// BearerAuthProvider is middleware which sets the Authorization header to "Bearer <token>",
+// using the Token of the credential supplied by p for each request.
+func BearerAuthProvider(p CredentialProvider) Middleware {
+	return credentialAuth(p, func(req *http.Request, c Credential) {
+		req.Header.Set(HeaderAuthorization, "Bearer "+c.Token)
+	})
+}
+
+// TokenSecret adapts a CredentialProvider to a SecretProvider, whose secret is the
+// credential's Token.  Use it with APIKeyHeader and APIKeyQuery:
+//
+//	requester.APIKeyHeader("X-Api-Key", requester.TokenSecret(vaultProvider))
+func TokenSecret(p CredentialProvider) SecretProvider {
+	return SecretProviderFunc(func(ctx context.Context) (string, error) {
+		c, err := p.Get(ctx)
+		return c.Token, err
+	})
+}
+
+func credentialAuth(p CredentialProvider, set func(req *http.Request, c Credential)) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			c, err := p.Get(req.Context())
+			if err != nil {
+				return nil, merry.Prepend(err, "fetching credentials")
+			}
+			req = req.Clone(req.Context())
+			set(req, c)
+			return next.Do(req)
+		})
+	}
+}
+
+// NetrcAuth is middleware which sets basic auth credentials for the request's host from a netrc
+// file.  If path is empty, the file named by the NETRC environment variable is used, or
+// .netrc in the user's home directory.  It's handy for CLI tools built on Requester.
+//
+// The file is read when the first request is sent.  If it doesn't exist, requests are sent
+// unchanged.  Entries are matched by host name, ignoring the port, and the default entry
+// is used for hosts with no entry.  Requests which already have an Authorization
+// header are sent unchanged.
+func NetrcAuth(path string) Middleware {
+	var once sync.Once
+	var entries []netrcEntry
+	var loadErr error
+
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			once.Do(func() {
+				entries, loadErr = loadNetrc(path)
+			})
+			if loadErr != nil {
+				return nil, loadErr
+			}
+
+			if req.Header.Get(HeaderAuthorization) == "" {
+				if e := findNetrcEntry(entries, req.URL.Hostname()); e != nil {
+					req = req.Clone(req.Context())
+					req.SetBasicAuth(e.login, e.password)
+				}
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+// EnvAuth is middleware which sets credentials from environment variables named with prefix.
+// If <prefix>_TOKEN is set, it's sent as a bearer token.  Otherwise, if <prefix>_USERNAME or
+// <prefix>_PASSWORD are set, they're sent with basic auth.  For example, with the
+// prefix "MYCLI", it reads MYCLI_TOKEN, MYCLI_USERNAME, and MYCLI_PASSWORD.
+//
+// The variables are read each time a request is sent.  The same credentials are sent to
+// every host.  Requests which already have an Authorization header are sent unchanged.
+func EnvAuth(prefix string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(HeaderAuthorization) != "" {
+				return next.Do(req)
+			}
+
+			token := os.Getenv(prefix + "_TOKEN")
+			user, pass := os.Getenv(prefix+"_USERNAME"), os.Getenv(prefix+"_PASSWORD")
+			switch {
+			case token != "":
+				req = req.Clone(req.Context())
+				req.Header.Set(HeaderAuthorization, "Bearer "+token)
+			case user != "" || pass != "":
+				req = req.Clone(req.Context())
+				req.SetBasicAuth(user, pass)
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+// netrcEntry is a machine or default entry in a netrc file.  machine is
+// empty for the default entry.
+type netrcEntry struct {
+	machine, login, password string
+}
+
+func loadNetrc(path string) ([]netrcEntry, error) {
+	if path == "" {
+		path = os.Getenv("NETRC")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, merry.Prepend(err, "reading netrc file")
+	}
+	return parseNetrc(string(b)), nil
+}
+
+// parseNetrc parses the contents of a netrc file.  Macro definitions are skipped.
+func parseNetrc(data string) []netrcEntry {
+	var entries []netrcEntry
+	var current *netrcEntry
+	inMacro := false
+
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// macros end at a blank line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next()})
+				current = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login":
+				if v := next(); current != nil {
+					current.login = v
+				}
+			case "password":
+				if v := next(); current != nil {
+					current.password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries
+}
+
+// findNetrcEntry returns the entry for host, or the default entry.
+func findNetrcEntry(entries []netrcEntry, host string) *netrcEntry {
+	var def *netrcEntry
+	for i := range entries {
+		e := &entries[i]
+		switch {
+		case e.machine == "" && def == nil:
+			def = e
+		case e.machine != "" && strings.EqualFold(e.machine, host):
+			return e
+		}
+	}
+	return def
+}
@@ -0,0 +1,125 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	var req *http.Request
+	doer := DoerFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return MockResponse(200), nil
+	})
+
+	var calls int
+	provider := CredentialProviderFunc(func(ctx context.Context) (Credential, error) {
+		calls++
+		return Credential{Username: "user", Password: "pass" + strconv.Itoa(calls), Token: "token" + strconv.Itoa(calls)}, nil
+	})
+
+	r := MustNew(WithDoer(doer), BasicAuthProvider(provider))
+	for i := 1; i <= 2; i++ {
+		_, err := r.Send()
+		require.NoError(t, err)
+		user, pass, _ := req.BasicAuth()
+		assert.Equal(t, "user", user)
+		assert.Equal(t, "pass"+strconv.Itoa(i), pass)
+	}
+
+	_, err := Send(WithDoer(doer), BearerAuthProvider(provider))
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token3", req.Header.Get(HeaderAuthorization))
+
+	_, err = Send(WithDoer(doer), APIKeyHeader("X-Api-Key", TokenSecret(provider)))
+	require.NoError(t, err)
+	assert.Equal(t, "token4", req.Header.Get("X-Api-Key"))
+
+	_, err = Send(WithDoer(doer), BearerAuthProvider(CredentialProviderFunc(func(ctx context.Context) (Credential, error) {
+		return Credential{}, errors.New("vault sealed")
+	})))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "vault sealed")
+}
+
+func TestNetrcAuth(t *testing.T) {
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	require.NoError(t, ioutil.WriteFile(netrc, []byte(`
+machine api.example.com
+  login alice
+  password secret1
+
+macdef init
+machine macro.example.com login mallory password nope
+
+machine other.example.com login bob password secret2 account acct
+default login anon password guest
+`), 0600))
+
+	var req *http.Request
+	doer := DoerFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return MockResponse(200), nil
+	})
+	r := MustNew(WithDoer(doer), NetrcAuth(netrc))
+
+	tests := []struct {
+		url, user, pass string
+	}{
+		{"http://api.example.com:8080/", "alice", "secret1"},
+		{"http://OTHER.example.com/", "bob", "secret2"},
+		{"http://macro.example.com/", "anon", "guest"},
+	}
+	for _, tc := range tests {
+		_, err := r.Send(Get(tc.url))
+		require.NoError(t, err)
+		user, pass, ok := req.BasicAuth()
+		assert.True(t, ok, tc.url)
+		assert.Equal(t, tc.user, user, tc.url)
+		assert.Equal(t, tc.pass, pass, tc.url)
+	}
+
+	// existing credentials aren't replaced
+	_, err := r.Send(Get("http://api.example.com/"), BearerAuth("token"))
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", req.Header.Get(HeaderAuthorization))
+
+	// a missing file is ignored
+	_, err = Send(WithDoer(doer), NetrcAuth(filepath.Join(t.TempDir(), "missing")), Get("http://api.example.com/"))
+	require.NoError(t, err)
+	assert.Empty(t, req.Header.Get(HeaderAuthorization))
+}
+
+func TestEnvAuth(t *testing.T) {
+	var req *http.Request
+	doer := DoerFunc(func(r *http.Request) (*http.Response, error) {
+		req = r
+		return MockResponse(200), nil
+	})
+	r := MustNew(WithDoer(doer), EnvAuth("REQUESTER_TEST"))
+
+	_, err := r.Send()
+	require.NoError(t, err)
+	assert.Empty(t, req.Header.Get(HeaderAuthorization))
+
+	t.Setenv("REQUESTER_TEST_USERNAME", "alice")
+	t.Setenv("REQUESTER_TEST_PASSWORD", "secret")
+	_, err = r.Send()
+	require.NoError(t, err)
+	user, pass, _ := req.BasicAuth()
+	assert.Equal(t, "alice", user)
+	assert.Equal(t, "secret", pass)
+
+	t.Setenv("REQUESTER_TEST_TOKEN", "token")
+	_, err = r.Send()
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer token", req.Header.Get(HeaderAuthorization))
+}
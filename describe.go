@@ -0,0 +1,260 @@
+package requester
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ansel1/merry"
+)
+
+// describeBodyLimit is the maximum length of Description.Body.
+const describeBodyLimit = 1024
+
+// Description is a summary of a Requester's configuration, returned by
+// Requester.Describe.  Credentials are redacted, so it's safe to log.
+type Description struct {
+	Method      string
+	URL         string
+	Header      http.Header
+	QueryParams url.Values
+	Marshaler   string
+	Unmarshaler string
+	Signer      string
+	Doer        string
+	// Middleware holds the names of the middleware, from outermost to innermost.
+	// Middleware installed with UseNamed is listed by the name it was installed with.
+	Middleware []string
+	// Retry is the configuration of the Retry middleware, if installed.
+	Retry *RetryConfig
+	// Body is a preview of the request body: the start of a string, []byte, or marshaled
+	// body, redacted.  Readers aren't read, so they're only described.
+	Body string
+}
+
+// Describe returns a summary of the Requester's configuration.  Sensitive headers,
+// query params, and passwords in the URL are redacted, according to DefaultRedaction.  Useful for logging a client's configuration at startup:
+//
+//	log.Println(r.Describe())
+func (r *Requester) Describe() Description {
+	d := Description{
+		Method:      r.Method,
+		Header:      DefaultRedaction.Header(r.Header),
+		QueryParams: DefaultRedaction.Query(r.QueryParams),
+		Marshaler:   typeName(r.Marshaler),
+		Unmarshaler: typeName(r.Unmarshaler),
+		Signer:      typeName(r.Signer),
+		Doer:        typeName(r.Doer),
+		Body:        r.describeBody(),
+	}
+
+	if r.URL != nil {
+		d.URL = DefaultRedaction.URL(r.URL).String()
+	}
+
+	names := r.MiddlewareNames()
+	for i, m := range r.Middleware {
+		name := names[i]
+		if c, ok := retryConfigOf(m); ok {
+			d.Retry = &c
+			if name == "" {
+				name = "requester.Retry"
+			}
+		} else if name == "" {
+			name = funcName(m)
+		}
+		d.Middleware = append(d.Middleware, name)
+	}
+
+	return d
+}
+
+// String implements fmt.Stringer.  It returns a human readable summary of the Requester's
+// configuration.  See Describe.
+func (r *Requester) String() string {
+	return r.Describe().String()
+}
+
+// describeBody returns a preview of the body, for Description.Body.
+func (r *Requester) describeBody() string {
+	switch r.Body.(type) {
+	case nil:
+		return ""
+	case io.Reader, func() (io.ReadCloser, error):
+		return fmt.Sprintf("(streamed %T)", r.Body)
+	}
+
+	body, ct, err := r.getRequestBody()
+	if err != nil {
+		return "(" + err.Error() + ")"
+	}
+	b, _ := io.ReadAll(body)
+	if ct == "" {
+		ct = r.Header.Get(HeaderContentType)
+	}
+	b = DefaultRedaction.Body(ct, b)
+
+	if !utf8.Valid(b) {
+		return fmt.Sprintf("(%d bytes of binary data)", len(b))
+	}
+	if len(b) > describeBodyLimit {
+		return string(b[:describeBodyLimit]) + "..."
+	}
+	return string(b)
+}
+
+// CurlCommand returns a curl command line which sends the request the Requester would
+// build with the options, for debugging or reproducing issues.  Like Describe, sensitive
+// headers, query params, and body fields are redacted, according to DefaultRedaction, so
+// they must be filled in before running it.
+//
+// Binary bodies are piped into curl, base64 encoded.  Bodies which can't be rewound, like
+// most io.Readers, aren't read: the command reads the body from stdin instead.
+//
+// The method is always set with -X when there's a body, since curl sends bodies with POST
+// by default.  HEAD requests use -I, since curl waits for a response body after -X HEAD.
+func (r *Requester) CurlCommand(opts ...Option) (string, error) {
+	req, err := r.Request(opts...)
+	if err != nil {
+		return "", err
+	}
+	hasBody := req.Body != nil && req.Body != http.NoBody
+
+	args := []string{"curl"}
+	switch {
+	case req.Method == http.MethodHead:
+		args = append(args, "-I")
+	case req.Method != http.MethodGet || hasBody:
+		args = append(args, "-X", req.Method)
+	}
+	args = append(args, shellQuote(DefaultRedaction.URL(req.URL).String()))
+
+	if req.Host != "" && req.Host != req.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+req.Host))
+	}
+	header := DefaultRedaction.Header(req.Header)
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+
+	var pipe string
+	if hasBody {
+		if req.GetBody == nil {
+			args = append(args, "--data-binary", "@-")
+		} else {
+			body, err := req.GetBody()
+			if err != nil {
+				return "", merry.Prepend(err, "reading body")
+			}
+			b, err := io.ReadAll(body)
+			body.Close()
+			if err != nil {
+				return "", merry.Prepend(err, "reading body")
+			}
+			b = DefaultRedaction.Body(req.Header.Get(HeaderContentType), b)
+			if utf8.Valid(b) {
+				args = append(args, "--data-binary", shellQuote(string(b)))
+			} else {
+				pipe = "echo " + base64.StdEncoding.EncodeToString(b) + " | base64 -d | "
+				args = append(args, "--data-binary", "@-")
+			}
+		}
+	}
+
+	return pipe + strings.Join(args, " "), nil
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// String returns a multi-line, human readable form of the Description.
+func (d Description) String() string {
+	var sb strings.Builder
+
+	line := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", name, value)
+		}
+	}
+
+	line("Method", d.Method)
+	line("URL", d.URL)
+
+	keys := make([]string, 0, len(d.Header))
+	for k := range d.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line("Header", k+": "+strings.Join(d.Header[k], ", "))
+	}
+
+	if len(d.QueryParams) > 0 {
+		line("QueryParams", d.QueryParams.Encode())
+	}
+
+	line("Marshaler", d.Marshaler)
+	line("Unmarshaler", d.Unmarshaler)
+	line("Signer", d.Signer)
+	line("Doer", d.Doer)
+	line("Middleware", strings.Join(d.Middleware, ", "))
+
+	if d.Retry != nil {
+		line("Retry", fmt.Sprintf("MaxAttempts=%d ShouldRetry=%s Backoff=%s ReadResponse=%v MaxRetryAfter=%v",
+			d.Retry.MaxAttempts, typeName(d.Retry.ShouldRetry), typeName(d.Retry.Backoff), d.Retry.ReadResponse,
+			d.Retry.MaxRetryAfter))
+	}
+
+	line("Body", d.Body)
+
+	return sb.String()
+}
+
+func typeName(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// funcName returns the name of the function which created fn, e.g. "requester.ExpectCode"
+// for the middleware returned by ExpectCode().
+func funcName(fn interface{}) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return typeName(fn)
+	}
+
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i > -1 {
+		name = name[i+1:]
+	}
+
+	// strip suffixes of closures and method values, e.g. requester.ExpectCode.func1
+	name = strings.TrimSuffix(name, "-fm")
+	for {
+		i := strings.LastIndex(name, ".func")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+
+	return name
+}
@@ -0,0 +1,170 @@
+package requester
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// checksum is the expected hash of a file downloaded by ReceiveFile.
+type checksum struct {
+	newHash  func() hash.Hash
+	expected string
+}
+
+// Checksum sets the expected checksum of files downloaded with ReceiveFile.  newHash
+// creates the hash, e.g. sha256.New, and expected is the hex-encoded sum.  If the
+// downloaded file doesn't match, it's deleted, and ReceiveFile returns an error.
+//
+// It has no effect on the other Send and Receive methods.
+func Checksum(newHash func() hash.Hash, expected string) Option {
+	return OptionFunc(func(r *Requester) error {
+		r.checksum = &checksum{newHash: newHash, expected: strings.ToLower(expected)}
+		return nil
+	})
+}
+
+// ReceiveFile sends a request, and streams the response body to the file at path.  The body
+// is written to path + ".part", which is renamed to path once the download is complete, so
+// path never holds a partial file.
+//
+// If a download is interrupted, the ".part" file is kept, and the next call resumes it by
+// requesting the rest of the file with a Range header.  If the server doesn't support
+// ranges, the download restarts from the beginning.  Resuming assumes the file hasn't changed
+// on the server in between: use Checksum to guard against that.
+//
+// Responses with status codes other than 2xx return an error, and path isn't
+// touched.  The response body is closed.
+func (r *Requester) ReceiveFile(path string, opts ...Option) (*http.Response, error) {
+	return r.ReceiveFileContext(context.Background(), path, opts...)
+}
+
+// ReceiveFileContext does the same as ReceiveFile, but requires a context.
+func (r *Requester) ReceiveFileContext(ctx context.Context, path string, opts ...Option) (*http.Response, error) {
+	reqs, err := r.withOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	partPath := path + ".part"
+	f, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, merry.Prepend(err, "opening partial file")
+	}
+
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, merry.Prepend(err, "reading partial file")
+	}
+
+	// don't leave behind an empty partial file if nothing is downloaded
+	started := false
+	defer func(created bool) {
+		if created && !started {
+			_ = os.Remove(partPath)
+		}
+	}(offset == 0)
+	// closed explicitly before renaming: this is a no-op then
+	defer f.Close()
+
+	resp, err := reqs.sendFrom(ctx, offset)
+	if err == nil && offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// the partial file doesn't fit the file on the server, start over
+		drain(resp.Body)
+		offset = 0
+		resp, err = reqs.sendFrom(ctx, offset)
+	}
+	if err != nil {
+		if resp != nil {
+			drain(resp.Body)
+		}
+		return resp, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if start := contentRangeStart(resp.Header.Get("Content-Range")); start != offset {
+			return resp, merry.Errorf("server returned the wrong range.  expected start: %d, received: %d", offset, start)
+		}
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		offset = 0
+	default:
+		return resp, merry.
+			Errorf("server returned an unsuccessful status code: %d", resp.StatusCode).
+			WithHTTPCode(resp.StatusCode)
+	}
+
+	started = true
+	if err := f.Truncate(offset); err != nil {
+		return resp, merry.Prepend(err, "truncating partial file")
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return resp, merry.Prepend(err, "seeking partial file")
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return resp, merry.Prepend(err, "downloading file")
+	}
+
+	if reqs.checksum != nil {
+		if err := reqs.checksum.verify(f); err != nil {
+			f.Close()
+			_ = os.Remove(partPath)
+			return resp, err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return resp, merry.Prepend(err, "writing partial file")
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return resp, merry.Prepend(err, "renaming partial file")
+	}
+	return resp, nil
+}
+
+// sendFrom sends the request, asking for the body starting at offset.
+func (r *Requester) sendFrom(ctx context.Context, offset int64) (*http.Response, error) {
+	if offset == 0 {
+		return r.SendContext(ctx)
+	}
+	return r.SendContext(ctx, Range(fmt.Sprintf("bytes=%d-", offset)))
+}
+
+func (c *checksum) verify(f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return merry.Prepend(err, "seeking partial file")
+	}
+	h := c.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return merry.Prepend(err, "hashing downloaded file")
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != c.expected {
+		return merry.Errorf("checksum mismatch.  expected: %s, received: %s", c.expected, sum)
+	}
+	return nil
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header, like
+// "bytes 100-199/200", or -1 if it can't be parsed.
+func contentRangeStart(contentRange string) int64 {
+	s := strings.TrimPrefix(contentRange, "bytes ")
+	i := strings.IndexByte(s, '-')
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
@@ -0,0 +1,142 @@
+package requester
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ansel1/merry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveFile(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	sum := sha256.Sum256(content)
+
+	var mu sync.Mutex
+	var ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get(HeaderRange))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(404)
+		case "/norange":
+			_, _ = w.Write(content)
+		default:
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+		}
+	}))
+	defer s.Close()
+
+	reset := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		r := ranges
+		ranges = nil
+		return r
+	}
+
+	t.Run("download", func(t *testing.T) {
+		reset()
+		path := filepath.Join(t.TempDir(), "file")
+		resp, err := ReceiveFile(path, Get(s.URL, "file"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.NoFileExists(t, path+".part")
+		assert.Equal(t, []string{""}, reset())
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		reset()
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, ioutil.WriteFile(path+".part", content[:4000], 0666))
+
+		resp, err := ReceiveFile(path, Get(s.URL, "file"), Checksum(sha256.New, hex.EncodeToString(sum[:])))
+		require.NoError(t, err)
+		assert.Equal(t, 206, resp.StatusCode)
+
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{"bytes=4000-"}, reset())
+	})
+
+	t.Run("resume unsupported", func(t *testing.T) {
+		reset()
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, ioutil.WriteFile(path+".part", []byte("garbage"), 0666))
+
+		_, err := ReceiveFile(path, Get(s.URL, "norange"))
+		require.NoError(t, err)
+
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+	})
+
+	t.Run("partial file too long", func(t *testing.T) {
+		reset()
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, ioutil.WriteFile(path+".part", append(content, content...), 0666))
+
+		_, err := ReceiveFile(path, Get(s.URL, "file"))
+		require.NoError(t, err)
+
+		b, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, content, b)
+		assert.Equal(t, []string{"bytes=20000-", ""}, reset())
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		_, err := ReceiveFile(path, Get(s.URL, "file"), Checksum(sha256.New, "00"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch")
+		assert.NoFileExists(t, path)
+		assert.NoFileExists(t, path+".part")
+	})
+
+	t.Run("unsuccessful", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		_, err := ReceiveFile(path, Get(s.URL, "missing"))
+		require.Error(t, err)
+		assert.Equal(t, 404, merry.HTTPCode(err))
+		assert.NoFileExists(t, path)
+		assert.NoFileExists(t, path+".part")
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "10000")
+			_, _ = w.Write(content[:3000])
+		}))
+		defer s.Close()
+
+		path := filepath.Join(t.TempDir(), "file")
+		_, err := ReceiveFile(path, Get(s.URL))
+		require.Error(t, err)
+		assert.NoFileExists(t, path)
+
+		// the partial file is kept, to resume later
+		fi, err := os.Stat(path + ".part")
+		require.NoError(t, err)
+		assert.EqualValues(t, 3000, fi.Size())
+	})
+}
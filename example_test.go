@@ -5,9 +5,12 @@ import (
 	. "github.com/gemalto/requester"
 	"github.com/gemalto/requester/httpclient"
 	"github.com/gemalto/requester/httptestutil"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -135,3 +138,30 @@ func Example_everything() {
 	// unmarshaled response body: {123 red}
 
 }
+
+func Example_unixSocket() {
+	dir, _ := ioutil.TempDir("", "requester")
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	// a local agent, listening on a unix socket
+	l, _ := net.Listen("unix", socket)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL.Path)
+	}))
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	// the host in the URL is ignored when connecting, but still sent in the Host header
+	r := MustNew(
+		Client(httpclient.UnixSocket(socket)),
+		URL("http://agent/v1/"),
+	)
+
+	_, body, _ := r.Receive(Get("status"))
+
+	fmt.Println(string(body))
+	// Output:
+	// agent /v1/status
+}
@@ -0,0 +1,196 @@
+package requester
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// DefaultFailoverCooldown is how long the Failover middleware skips a host after it fails.
+const DefaultFailoverCooldown = 30 * time.Second
+
+// FailoverConfig defines settings for the Failover middleware.
+type FailoverConfig struct {
+	// URLs are the alternate base URLs, in order of preference.  Only their scheme
+	// and host are used: they replace the scheme and host of the request URL.
+	URLs []string
+	// ShouldFailover tests whether the request should be tried against the next host.
+	// Defaults to DefaultShouldFailover.
+	ShouldFailover ShouldRetryer
+	// Cooldown is how long a host is skipped after it fails.  Defaults to
+	// DefaultFailoverCooldown.
+	Cooldown time.Duration
+}
+
+// DefaultShouldFailover is the default ShouldRetryer for the Failover middleware.  It fails over
+// in the same cases as DefaultShouldRetry, and also if the host can't be reached at all, e.g.
+// if the connection is refused, or the host name can't be resolved.
+func DefaultShouldFailover(attempt int, req *http.Request, resp *http.Response, err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+
+	switch {
+	case err == nil:
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.As(err, &dnsErr),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return true
+	}
+
+	return DefaultShouldRetry(attempt, req, resp, err)
+}
+
+func (c *FailoverConfig) normalize() {
+	if c.ShouldFailover == nil {
+		c.ShouldFailover = ShouldRetryerFunc(DefaultShouldFailover)
+	}
+
+	if c.Cooldown <= 0 {
+		c.Cooldown = DefaultFailoverCooldown
+	}
+}
+
+// Failover is middleware which tries the request against an ordered list of alternate base
+// URLs, when the request's own host fails with a connection error or a 5xx response.  It's
+// shorthand for FailoverWithConfig(&FailoverConfig{URLs: urls}).
+func Failover(urls ...string) Middleware {
+	return FailoverWithConfig(&FailoverConfig{URLs: urls})
+}
+
+// FailoverWithConfig is middleware which tries the request against an ordered list of
+// alternate base URLs, when the request's own host fails.  The request's host is tried first,
+// then each of the alternates, until one succeeds.  If config is nil, or has no URLs, requests
+// are sent unchanged.
+//
+// Hosts which fail are skipped by later requests for a cooldown period, so requests go
+// straight to a healthy host.  If every host is cooling down, they're all tried anyway, in
+// order.  The health of hosts is shared by all requests sent through the same middleware,
+// including by clones of the Requester.
+//
+// Like Retry, requests with bodies can only be failed over if the request's GetBody function
+// is set.
+func FailoverWithConfig(config *FailoverConfig) Middleware {
+	var c FailoverConfig
+	if config != nil {
+		c = *config
+	}
+
+	c.normalize()
+
+	health := &hostHealth{cooldown: c.Cooldown, downUntil: map[string]time.Time{}}
+
+	return func(next Doer) Doer {
+		if len(c.URLs) == 0 {
+			return next
+		}
+
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			targets := []*url.URL{{Scheme: req.URL.Scheme, Host: req.URL.Host}}
+			for _, s := range c.URLs {
+				u, err := url.Parse(s)
+				if err != nil {
+					return nil, merry.Prependf(err, "parsing failover URL %q", s)
+				}
+				targets = append(targets, &url.URL{Scheme: u.Scheme, Host: u.Host})
+			}
+			targets = health.order(targets)
+
+			// if the body can't be rewound, only one attempt can be made
+			if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+				targets = targets[:1]
+			}
+
+			var resp *http.Response
+			var err error
+			for i, target := range targets {
+				r := req
+				if i > 0 {
+					if r, err = resetRequest(req); err != nil {
+						return nil, err
+					}
+				}
+				r = withBaseURL(r, target)
+
+				resp, err = next.Do(r)
+				if !c.ShouldFailover.ShouldRetry(i+1, r, resp, err) {
+					health.up(target)
+					return resp, err
+				}
+				health.down(target)
+
+				if i < len(targets)-1 && resp != nil {
+					drain(resp.Body)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// withBaseURL returns a copy of req, sent to target's scheme and host.
+func withBaseURL(req *http.Request, target *url.URL) *http.Request {
+	if req.URL.Scheme == target.Scheme && req.URL.Host == target.Host {
+		return req
+	}
+
+	r := *req
+	r.URL = cloneURL(req.URL)
+	r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+
+	// only replace the Host if it wasn't overridden
+	if req.Host == "" || req.Host == req.URL.Host {
+		r.Host = target.Host
+	}
+	return &r
+}
+
+// hostHealth tracks hosts which have failed recently.
+type hostHealth struct {
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	downUntil map[string]time.Time
+}
+
+// order returns the healthy targets, followed by the targets which are cooling down.
+func (h *hostHealth) order(targets []*url.URL) []*url.URL {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*url.URL, 0, len(targets))
+	var down []*url.URL
+	for _, t := range targets {
+		until, ok := h.downUntil[t.String()]
+		switch {
+		case !ok:
+			healthy = append(healthy, t)
+		case now.After(until):
+			delete(h.downUntil, t.String())
+			healthy = append(healthy, t)
+		default:
+			down = append(down, t)
+		}
+	}
+	return append(healthy, down...)
+}
+
+func (h *hostHealth) up(target *url.URL) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.downUntil, target.String())
+}
+
+func (h *hostHealth) down(target *url.URL) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.downUntil[target.String()] = time.Now().Add(h.cooldown)
+}
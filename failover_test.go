@@ -0,0 +1,77 @@
+package requester
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	var primaryHits, backupHits int32
+	var primaryDown int32 = 1
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		if atomic.LoadInt32(&primaryDown) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "primary")
+	}))
+	defer primary.Close()
+
+	backup := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&backupHits, 1)
+		b, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprint(w, "backup"+r.URL.Path+string(b))
+	}))
+	defer backup.Close()
+
+	// a server which refuses connections
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	r := MustNew(
+		URL(primary.URL),
+		FailoverWithConfig(&FailoverConfig{URLs: []string{dead.URL, backup.URL + "/ignored"}, Cooldown: 100 * time.Millisecond}),
+	)
+
+	_, body, err := r.Receive(nil, Post("/colors"), Body("red"))
+	require.NoError(t, err)
+	assert.Equal(t, "backup/colorsred", string(body))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&primaryHits))
+
+	// failed hosts are skipped while cooling down
+	_, body, err = r.Receive(nil, Get("/colors"))
+	require.NoError(t, err)
+	assert.Equal(t, "backup/colors", string(body))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&primaryHits))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&backupHits))
+
+	// after the cooldown, the primary is tried again
+	atomic.StoreInt32(&primaryDown, 0)
+	time.Sleep(150 * time.Millisecond)
+	_, body, err = r.Receive(nil, Get("/colors"))
+	require.NoError(t, err)
+	assert.Equal(t, "primary", string(body))
+
+	t.Run("all down", func(t *testing.T) {
+		atomic.StoreInt32(&primaryDown, 1)
+		// the result of the last attempt is returned
+		resp, err := Send(Get(dead.URL), Failover(primary.URL))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		_, err := Send(Get(primary.URL), Failover("http://[::1"))
+		require.Error(t, err)
+	})
+}
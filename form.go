@@ -0,0 +1,231 @@
+package requester
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// nolint:gochecknoglobals
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// values encodes a struct into url.Values, using the FormMarshaler's settings.
+func (m *FormMarshaler) values(v interface{}) (url.Values, error) {
+	values := url.Values{}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return values, nil
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return nil, merry.Errorf("expected a struct, got %T", v)
+	}
+
+	if err := m.encodeStruct(values, "", rv); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (m *FormMarshaler) encodeStruct(values url.Values, prefix string, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			// unexported
+			continue
+		}
+
+		tag := field.Tag.Get("url")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i > -1 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		fv := rv.Field(i)
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+
+		// embedded structs without a name are flattened into the parent
+		if field.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+				if err := m.encodeStruct(values, prefix, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		if err := m.encode(values, m.key(prefix, name), fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *FormMarshaler) encode(values url.Values, key string, rv reflect.Value) error {
+	if !rv.CanInterface() {
+		// promoted through an unexported embedded struct
+		return nil
+	}
+
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	if s, ok, err := m.scalar(rv); ok || err != nil {
+		if err != nil {
+			return merry.Prependf(err, "encoding %s", key)
+		}
+		values.Add(key, s)
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		return m.encodeStruct(values, key, rv)
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			if err := m.encode(values, m.key(key, fmt.Sprint(k.Interface())), rv.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		if m.SliceDelimiter != "" {
+			if s, ok, err := m.joinSlice(rv); ok || err != nil {
+				if err != nil {
+					return merry.Prependf(err, "encoding %s", key)
+				}
+				values.Add(key, s)
+				return nil
+			}
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if err := m.encode(values, key, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		values.Add(key, fmt.Sprint(rv.Interface()))
+		return nil
+	}
+}
+
+// scalar formats values which are encoded as a single string: times, text marshalers,
+// and basic kinds.  ok is false if rv is not a scalar.
+func (m *FormMarshaler) scalar(rv reflect.Value) (s string, ok bool, err error) {
+	switch {
+	case rv.Type() == timeType:
+		layout := m.TimeLayout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return rv.Interface().(time.Time).Format(layout), true, nil
+	case rv.Type().Implements(textMarshalerType):
+		b, err := rv.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), true, err
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr, reflect.Interface:
+		return "", false, nil
+	default:
+		return fmt.Sprint(rv.Interface()), true, nil
+	}
+}
+
+// joinSlice joins the elements of a slice with the SliceDelimiter.  ok is false if
+// the elements aren't scalars.
+func (m *FormMarshaler) joinSlice(rv reflect.Value) (s string, ok bool, err error) {
+	elems := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		ev := rv.Index(i)
+		for ev.Kind() == reflect.Ptr || ev.Kind() == reflect.Interface {
+			if ev.IsNil() {
+				break
+			}
+			ev = ev.Elem()
+		}
+		if ev.Kind() == reflect.Ptr || ev.Kind() == reflect.Interface {
+			// nil
+			continue
+		}
+
+		s, ok, err := m.scalar(ev)
+		if !ok || err != nil {
+			return "", ok, err
+		}
+		elems = append(elems, s)
+	}
+	return strings.Join(elems, m.SliceDelimiter), true, nil
+}
+
+func (m *FormMarshaler) key(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case m.KeyStyle == FormKeyDotted:
+		return prefix + "." + name
+	default:
+		return prefix + "[" + name + "]"
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package grpcgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+// Code is a gRPC status code, as defined in google.rpc.Code.
+type Code int
+
+// gRPC status codes.
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+// nolint:gochecknoglobals
+var codeNames = [...]string{
+	"OK",
+	"Canceled",
+	"Unknown",
+	"InvalidArgument",
+	"DeadlineExceeded",
+	"NotFound",
+	"AlreadyExists",
+	"PermissionDenied",
+	"ResourceExhausted",
+	"FailedPrecondition",
+	"Aborted",
+	"OutOfRange",
+	"Unimplemented",
+	"Internal",
+	"Unavailable",
+	"DataLoss",
+	"Unauthenticated",
+}
+
+// String returns the name of the code.
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// Status is the JSON representation of google.rpc.Status, which grpc-gateway
+// returns in the body of error responses.  It implements error.
+//
+// Details are left undecoded, since decoding google.protobuf.Any values
+// requires the message types to be registered.
+type Status struct {
+	Code    Code              `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+}
+
+// Error implements error.
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code, s.Message)
+}
+
+// DecodeErrors is middleware which converts unsuccessful responses (status
+// codes outside of 200-299) into errors.  If the response body is a
+// google.rpc.Status, the error will wrap a *Status, which can be retrieved
+// with errors.As().  Otherwise, the error is the same as the one returned
+// by requester.ExpectSuccessCode().
+//
+// In either case, the error carries the response's status code (see merry.HTTPCode()),
+// and the response body is still returned.
+func DecodeErrors() requester.Middleware {
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.Do(req)
+			if err != nil || resp == nil || (resp.StatusCode >= 200 && resp.StatusCode < 300) {
+				return resp, err
+			}
+
+			var st *Status
+			if resp.Body != nil && resp.Body != http.NoBody {
+				body, readErr := ioutil.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				if readErr == nil {
+					st = decodeStatus(body)
+				}
+			}
+
+			if st == nil {
+				return resp, merry.
+					Errorf("server returned an unsuccessful status code: %d", resp.StatusCode).
+					WithHTTPCode(resp.StatusCode)
+			}
+			return resp, merry.WrapSkipping(st, 1).WithHTTPCode(resp.StatusCode)
+		})
+	}
+}
+
+func decodeStatus(body []byte) *Status {
+	var raw struct {
+		Code    *Code             `json:"code"`
+		Message string            `json:"message"`
+		Details []json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil || raw.Code == nil {
+		return nil
+	}
+	return &Status{
+		Code:    *raw.Code,
+		Message: raw.Message,
+		Details: raw.Details,
+	}
+}
@@ -0,0 +1,160 @@
+// Package grpcgateway contains requester Options and Middleware for calling
+// REST endpoints generated by grpc-gateway (or any other implementation of
+// the google.api.http transcoding rules).
+//
+// PathTemplate() expands a google.api.http path template into the request
+// URL, FieldMask() encodes a google.protobuf.FieldMask as a query parameter,
+// and DecodeErrors() converts the google.rpc.Status error bodies returned by
+// the gateway into *Status errors:
+//
+//	reqs := requester.MustNew(
+//	    requester.URL("https://api.example.com"),
+//	    grpcgateway.DecodeErrors(),
+//	)
+//
+//	var book Book
+//	_, _, err := reqs.Receive(&book,
+//	    requester.Patch(),
+//	    grpcgateway.PathTemplate("/v1/{book.name=shelves/*/books/*}", map[string]string{
+//	        "book.name": "shelves/1/books/2",
+//	    }),
+//	    grpcgateway.FieldMask("update_mask", "title", "author.name"),
+//	    requester.Body(&book),
+//	)
+//
+//	var st *grpcgateway.Status
+//	if errors.As(err, &st) && st.Code == grpcgateway.NotFound {
+//	    ...
+//	}
+package grpcgateway
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+)
+
+// PathTemplate expands a google.api.http path template with vars, and appends
+// the result to the request URL's path, using requester.AppendPath().
+//
+// See Expand() for the template syntax.
+func PathTemplate(template string, vars map[string]string) requester.Option {
+	return requester.OptionFunc(func(r *requester.Requester) error {
+		p, err := Expand(template, vars)
+		if err != nil {
+			return err
+		}
+		return requester.AppendPath(p).Apply(r)
+	})
+}
+
+// FieldMask adds a query parameter encoding a google.protobuf.FieldMask, as
+// expected by grpc-gateway: the paths are joined with commas.  If no paths are
+// passed, no parameter is added.
+//
+//	FieldMask("update_mask", "title", "author.name")  // ?update_mask=title,author.name
+func FieldMask(param string, paths ...string) requester.Option {
+	if len(paths) == 0 {
+		return requester.OptionFunc(func(*requester.Requester) error { return nil })
+	}
+	return requester.QueryParam(param, strings.Join(paths, ","))
+}
+
+// Expand expands a google.api.http path template, substituting variables with
+// the values in vars.  The template syntax is:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	FieldPath = IDENT { "." IDENT } ;
+//	Verb     = ":" LITERAL ;
+//
+// A simple variable, like "{id}", matches a single path segment, so its value
+// is fully escaped, including any slashes.  A variable with a pattern, like
+// "{name=shelves/*/books/*}", may span several segments: its value is checked
+// against the pattern, and each segment is escaped separately.
+//
+// An error is returned if the template is malformed, if a variable has no
+// value in vars, or if a value doesn't match its pattern.
+func Expand(template string, vars map[string]string) (string, error) {
+	if !strings.HasPrefix(template, "/") {
+		return "", merry.Errorf("invalid path template %q: must start with '/'", template)
+	}
+
+	var sb strings.Builder
+	rest := template
+	for rest != "" {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		sb.WriteString(rest[:i])
+		rest = rest[i+1:]
+
+		j := strings.IndexByte(rest, '}')
+		if j < 0 {
+			return "", merry.Errorf("invalid path template %q: unclosed variable", template)
+		}
+		variable := rest[:j]
+		rest = rest[j+1:]
+
+		field, pattern := variable, ""
+		if k := strings.IndexByte(variable, '='); k > -1 {
+			field, pattern = variable[:k], variable[k+1:]
+		}
+		if field == "" {
+			return "", merry.Errorf("invalid path template %q: empty variable name", template)
+		}
+
+		value, ok := vars[field]
+		if !ok {
+			return "", merry.Errorf("missing value for path template variable %q", field)
+		}
+
+		if pattern == "" {
+			sb.WriteString(url.PathEscape(value))
+			continue
+		}
+
+		segments := strings.Split(value, "/")
+		if !matchSegments(strings.Split(pattern, "/"), segments) {
+			return "", merry.Errorf("value %q for path template variable %q does not match pattern %q", value, field, pattern)
+		}
+		for n, s := range segments {
+			if n > 0 {
+				sb.WriteByte('/')
+			}
+			sb.WriteString(url.PathEscape(s))
+		}
+	}
+	return sb.String(), nil
+}
+
+// matchSegments matches path segments against a pattern.  "*" matches
+// a single, non-empty segment, "**" matches any remaining segments, and
+// anything else must match literally.
+func matchSegments(pattern, segments []string) bool {
+	for i, p := range pattern {
+		if p == "**" {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		switch p {
+		case "*":
+			if segments[i] == "" {
+				return false
+			}
+		default:
+			if segments[i] != p {
+				return false
+			}
+		}
+	}
+	return len(pattern) == len(segments)
+}
@@ -0,0 +1,129 @@
+package grpcgateway
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		template string
+		vars     map[string]string
+		expected string
+		err      bool
+	}{
+		{template: "/v1/books", expected: "/v1/books"},
+		{template: "/v1/books/{id}", vars: map[string]string{"id": "1"}, expected: "/v1/books/1"},
+		{template: "/v1/books/{id}", vars: map[string]string{"id": "a/b c"}, expected: "/v1/books/a%2Fb%20c"},
+		{template: "/v1/{book.name}:publish", vars: map[string]string{"book.name": "b1"}, expected: "/v1/b1:publish"},
+		{
+			template: "/v1/{name=shelves/*/books/*}",
+			vars:     map[string]string{"name": "shelves/1/books/a b"},
+			expected: "/v1/shelves/1/books/a%20b",
+		},
+		{
+			template: "/v1/{name=files/**}",
+			vars:     map[string]string{"name": "files/a/b/c"},
+			expected: "/v1/files/a/b/c",
+		},
+		{template: "/v1/{name=shelves/*}", vars: map[string]string{"name": "shelves/1/books/2"}, err: true},
+		{template: "/v1/{name=shelves/*}", vars: map[string]string{"name": "shelves/"}, err: true},
+		{template: "/v1/{name=shelves/*}", vars: map[string]string{"name": "books/1"}, err: true},
+		{template: "/v1/{id}", err: true},
+		{template: "/v1/{id", vars: map[string]string{"id": "1"}, err: true},
+		{template: "/v1/{}", err: true},
+		{template: "v1/books", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			p, err := Expand(tt.template, tt.vars)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	req, err := requester.Request(
+		requester.URL("http://test.com/api/"),
+		PathTemplate("/v1/{name=shelves/*}/books:search", map[string]string{"name": "shelves/s 1"}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.com/api/v1/shelves/s%201/books:search", req.URL.String())
+
+	_, err = requester.Request(PathTemplate("/v1/{id}", nil))
+	require.Error(t, err)
+}
+
+func TestFieldMask(t *testing.T) {
+	req, err := requester.Request(
+		requester.URL("http://test.com"),
+		FieldMask("update_mask", "title", "author.name"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "title,author.name", req.URL.Query().Get("update_mask"))
+
+	req, err = requester.Request(requester.URL("http://test.com"), FieldMask("update_mask"))
+	require.NoError(t, err)
+	assert.Empty(t, req.URL.RawQuery)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		body := `{"code":5,"message":"book not found","details":[{"@type":"type.googleapis.com/google.rpc.ResourceInfo"}]}`
+		resp, respBody, err := requester.Receive(
+			requester.MockDoer(404, requester.Body(body)),
+			DecodeErrors(),
+		)
+		require.Error(t, err)
+		assert.Equal(t, 404, resp.StatusCode)
+		assert.Equal(t, body, string(respBody))
+		assert.Equal(t, 404, merry.HTTPCode(err))
+		assert.Contains(t, err.Error(), "rpc error: code = NotFound desc = book not found")
+
+		var st *Status
+		require.True(t, errors.As(err, &st))
+		assert.Equal(t, NotFound, st.Code)
+		assert.Equal(t, "book not found", st.Message)
+		assert.Len(t, st.Details, 1)
+	})
+
+	t.Run("not a status", func(t *testing.T) {
+		_, respBody, err := requester.Receive(
+			requester.MockDoer(502, requester.Body("bad gateway")),
+			DecodeErrors(),
+		)
+		require.Error(t, err)
+		assert.Equal(t, "bad gateway", string(respBody))
+		assert.Equal(t, 502, merry.HTTPCode(err))
+
+		var st *Status
+		assert.False(t, errors.As(err, &st))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		_, respBody, err := requester.Receive(
+			requester.MockDoer(http.StatusOK, requester.Body(`{"code":5}`)),
+			DecodeErrors(),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, `{"code":5}`, string(respBody))
+	})
+}
+
+func TestCode_String(t *testing.T) {
+	assert.Equal(t, "OK", OK.String())
+	assert.Equal(t, "Unauthenticated", Unauthenticated.String())
+	assert.Equal(t, "Code(99)", Code(99).String())
+}
@@ -0,0 +1,351 @@
+package requester
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HARVersion is the version of the HTTP Archive format produced by NewHAR.
+const HARVersion = "1.2"
+
+// HAR is an HTTP Archive: a JSON log of HTTP exchanges, which browser dev tools and
+// HAR-based replay tools can load.  See http://www.softwareishard.com/blog/har-12-spec/.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the root of a HAR.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator describes the application which created a HAR.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single exchange in a HAR.
+type HAREntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total duration of the exchange, in milliseconds.
+	Time     float64     `json:"time"`
+	Request  HARRequest  `json:"request"`
+	Response HARResponse `json:"response"`
+	Cache    struct{}    `json:"cache"`
+	Timings  HARTimings  `json:"timings"`
+}
+
+// HARRequest is the request of a HAREntry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is the response of a HAREntry.  If the exchange failed without a response,
+// Status is 0, and Error holds the error.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	Error       string         `json:"_error,omitempty"`
+}
+
+// HARNameValue is a header or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie is a request or response cookie.
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// HARPostData is a request body.
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params"`
+	Text     string         `json:"text"`
+}
+
+// HARContent is a response body.  Bodies which aren't valid UTF-8 are base64 encoded, and
+// Encoding is set to "base64".
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings breaks down the entry's Time, in milliseconds.  Exchanges aren't timed in
+// detail, so the whole time is attributed to Wait.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR returns a HAR holding the entries.
+func NewHAR(entries ...HAREntry) *HAR {
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	return &HAR{Log: HARLog{
+		Version: HARVersion,
+		Creator: HARCreator{Name: "requester"},
+		Entries: entries,
+	}}
+}
+
+// HAREntry converts the exchange to a HAR entry.  Relative request URLs, as servers
+// receive them, are resolved against the request's Host.
+func (ex *Exchange) HAREntry() HAREntry {
+	ms := float64(ex.Duration) / float64(time.Millisecond)
+	e := HAREntry{
+		StartedDateTime: ex.Time,
+		Time:            ms,
+		Timings:         HARTimings{Wait: ms},
+	}
+
+	if req := ex.Request; req != nil {
+		u := *req.URL
+		if u.Host == "" {
+			u.Host = req.Host
+		}
+		if u.Scheme == "" {
+			u.Scheme = "http"
+			if req.TLS != nil {
+				u.Scheme = "https"
+			}
+		}
+		e.Request = HARRequest{
+			Method:      req.Method,
+			URL:         u.String(),
+			HTTPVersion: harProto(req.Proto),
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harValues(req.Header),
+			QueryString: harValues(u.Query()),
+			HeadersSize: -1,
+		}
+		if ex.RequestBody != nil && ex.RequestBody.Len() > 0 {
+			e.Request.BodySize = ex.RequestBody.Len()
+			e.Request.PostData = &HARPostData{
+				MimeType: req.Header.Get(HeaderContentType),
+				Params:   []HARNameValue{},
+				Text:     ex.RequestBody.String(),
+			}
+		}
+	}
+
+	resp := ex.Response
+	if resp == nil {
+		e.Response = HARResponse{
+			HTTPVersion: harProto(""),
+			Cookies:     []HARCookie{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+		if ex.Err != nil {
+			e.Response.Error = ex.Err.Error()
+		}
+		return e
+	}
+
+	e.Response = HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: harProto(resp.Proto),
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harValues(resp.Header),
+		Content:     HARContent{MimeType: resp.Header.Get(HeaderContentType)},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	if ex.ResponseBody != nil {
+		b := ex.ResponseBody.Bytes()
+		e.Response.BodySize = len(b)
+		e.Response.Content.Size = len(b)
+		if utf8.Valid(b) {
+			e.Response.Content.Text = string(b)
+		} else {
+			e.Response.Content.Text = base64.StdEncoding.EncodeToString(b)
+			e.Response.Content.Encoding = "base64"
+		}
+	}
+	return e
+}
+
+// ToHAR serializes the captured exchanges to HAR JSON.  In CaptureAll mode, it includes
+// all the exchanges not yet removed with NextExchange or Drain, otherwise, just the last
+// exchange.  The exchanges are left in place.
+func (i *Inspector) ToHAR() ([]byte, error) {
+	i.mu.Lock()
+	var entries []HAREntry
+	if i.CaptureAll {
+		for _, ex := range i.exchanges {
+			entries = append(entries, ex.HAREntry())
+		}
+	} else if i.last != nil {
+		entries = append(entries, i.last.HAREntry())
+	}
+	i.mu.Unlock()
+
+	return json.MarshalIndent(NewHAR(entries...), "", "  ")
+}
+
+func harProto(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// harValues converts headers or query parameters to name/value pairs, sorted by name.
+func harValues(m map[string][]string) []HARNameValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nvs := []HARNameValue{}
+	for _, k := range keys {
+		for _, v := range m[k] {
+			nvs = append(nvs, HARNameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+func harCookies(cookies []*http.Cookie) []HARCookie {
+	hcs := []HARCookie{}
+	for _, c := range cookies {
+		hc := HARCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			hc.Expires = &expires
+		}
+		hcs = append(hcs, hc)
+	}
+	return hcs
+}
+
+// harSkippedHeaders are request headers FromHAREntry doesn't replay, because the
+// transport manages them.
+// nolint:gochecknoglobals
+var harSkippedHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Accept-Encoding":   true,
+}
+
+// FromHAREntry returns an Option which sets the method, URL, headers, and body of a request
+// captured in a HAR entry, like one exported from browser dev tools, so it can be replayed
+// through a Requester's middleware:
+//
+//	var har requester.HAR
+//	_ = json.Unmarshal(b, &har)
+//	for _, e := range har.Log.Entries {
+//	    resp, err := r.Send(requester.FromHAREntry(e))
+//	}
+//
+// The entry's URL replaces the Requester's.  Its headers replace the Requester's headers
+// with the same names, and other headers are kept, like Authorization set by the
+// Requester.  Headers managed by the transport, like Content-Length and Accept-Encoding,
+// and HTTP/2 pseudo-headers, like ":authority", are skipped.  If the entry has cookies but no
+// Cookie header, the Cookie header is built from them.
+//
+// The body is the entry's post data text, or its params, form encoded.
+func FromHAREntry(entry HAREntry) Option {
+	return OptionFunc(func(r *Requester) error {
+		req := entry.Request
+		if req.Method != "" {
+			r.Method = req.Method
+		}
+		if req.URL != "" {
+			if err := URL(req.URL).Apply(r); err != nil {
+				return err
+			}
+		}
+
+		h := http.Header{}
+		for _, nv := range req.Headers {
+			if strings.HasPrefix(nv.Name, ":") || harSkippedHeaders[http.CanonicalHeaderKey(nv.Name)] {
+				continue
+			}
+			h.Add(nv.Name, nv.Value)
+		}
+		if h.Get("Cookie") == "" && len(req.Cookies) > 0 {
+			cookies := make([]string, len(req.Cookies))
+			for i, c := range req.Cookies {
+				cookies[i] = (&http.Cookie{Name: c.Name, Value: c.Value}).String()
+			}
+			h.Set("Cookie", strings.Join(cookies, "; "))
+		}
+		if r.Header == nil {
+			r.Header = make(http.Header, len(h))
+		}
+		for k, v := range h {
+			r.Header[k] = v
+		}
+
+		if pd := req.PostData; pd != nil {
+			mimeType := pd.MimeType
+			if pd.Text != "" || len(pd.Params) == 0 {
+				r.Body = pd.Text
+			} else {
+				form := url.Values{}
+				for _, p := range pd.Params {
+					form.Add(p.Name, p.Value)
+				}
+				r.Body = form.Encode()
+				if mimeType == "" {
+					mimeType = MediaTypeForm
+				}
+			}
+			if mimeType != "" && r.Header.Get(HeaderContentType) == "" {
+				r.Header.Set(HeaderContentType, mimeType)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,177 @@
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspector_ToHAR(t *testing.T) {
+	ts := httptest.NewServer(MockHandler(201,
+		JSON(false),
+		Body(map[string]string{"color": "red"}),
+		Header("Set-Cookie", "session=abc; Path=/; HttpOnly"),
+	))
+	defer ts.Close()
+
+	r := MustNew(URL(ts.URL))
+	i := &Inspector{CaptureAll: true}
+	r.MustApply(i)
+
+	_, _, err := r.Receive(Post("/things?size=big"), JSON(false), Body(map[string]string{"name": "bob"}),
+		Header("Cookie", "theme=dark"))
+	require.NoError(t, err)
+
+	b, err := i.ToHAR()
+	require.NoError(t, err)
+
+	var har HAR
+	require.NoError(t, json.Unmarshal(b, &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	assert.Equal(t, HARCreator{Name: "requester"}, har.Log.Creator)
+	require.Len(t, har.Log.Entries, 1)
+
+	e := har.Log.Entries[0]
+	assert.NotZero(t, e.StartedDateTime)
+	assert.Equal(t, "POST", e.Request.Method)
+	assert.Equal(t, ts.URL+"/things?size=big", e.Request.URL)
+	assert.Equal(t, []HARNameValue{{Name: "size", Value: "big"}}, e.Request.QueryString)
+	assert.Equal(t, []HARCookie{{Name: "theme", Value: "dark"}}, e.Request.Cookies)
+	assert.Contains(t, e.Request.Headers, HARNameValue{Name: "Content-Type", Value: "application/json"})
+	require.NotNil(t, e.Request.PostData)
+	assert.Equal(t, `{"name":"bob"}`, e.Request.PostData.Text)
+	assert.Equal(t, "application/json", e.Request.PostData.MimeType)
+	assert.Equal(t, 14, e.Request.BodySize)
+
+	assert.Equal(t, 201, e.Response.Status)
+	assert.Equal(t, "Created", e.Response.StatusText)
+	assert.Equal(t, "HTTP/1.1", e.Response.HTTPVersion)
+	assert.Equal(t, []HARCookie{{Name: "session", Value: "abc", Path: "/", HTTPOnly: true}}, e.Response.Cookies)
+	assert.Equal(t, HARContent{Size: 15, MimeType: "application/json", Text: `{"color":"red"}`}, e.Response.Content)
+
+	// exchanges are left in place
+	assert.NotNil(t, i.NextExchange())
+}
+
+func TestInspector_ToHAR_last(t *testing.T) {
+	i := &Inspector{}
+	b, err := i.ToHAR()
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"entries": []`)
+
+	r := MustNew(WithDoer(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("boom")
+	})), i)
+	_, err = r.Send(Get("http://example.com/a"))
+	require.Error(t, err)
+	_, err = r.Send(Get("http://example.com/b"))
+	require.Error(t, err)
+
+	b, err = i.ToHAR()
+	require.NoError(t, err)
+	var har HAR
+	require.NoError(t, json.Unmarshal(b, &har))
+	require.Len(t, har.Log.Entries, 1)
+	e := har.Log.Entries[0]
+	assert.Equal(t, "http://example.com/b", e.Request.URL)
+	assert.Equal(t, 0, e.Response.Status)
+	assert.Equal(t, "boom", e.Response.Error)
+}
+
+func TestExchange_HAREntry_binary(t *testing.T) {
+	ex := Exchange{
+		Request:      httptest.NewRequest("GET", "/bin", nil),
+		Response:     &http.Response{StatusCode: 200, Header: http.Header{}},
+		ResponseBody: bytes.NewBuffer([]byte{0xff, 0xfe}),
+	}
+	e := ex.HAREntry()
+	assert.Equal(t, "http://example.com/bin", e.Request.URL)
+	assert.Equal(t, "base64", e.Response.Content.Encoding)
+	assert.Equal(t, "//4=", e.Response.Content.Text)
+}
+
+func TestFromHAREntry(t *testing.T) {
+	var har HAR
+	require.NoError(t, json.Unmarshal([]byte(`{"log": {"version": "1.2", "entries": [{
+		"request": {
+			"method": "POST",
+			"url": "https://example.com/api/things?size=big",
+			"httpVersion": "HTTP/2",
+			"headers": [
+				{"name": ":authority", "value": "example.com"},
+				{"name": "accept", "value": "application/json"},
+				{"name": "accept-encoding", "value": "gzip, br"},
+				{"name": "content-length", "value": "14"},
+				{"name": "x-color", "value": "red"},
+				{"name": "x-color", "value": "blue"}
+			],
+			"cookies": [{"name": "session", "value": "abc"}, {"name": "theme", "value": "dark"}],
+			"postData": {"mimeType": "application/json", "text": "{\"name\":\"bob\"}"}
+		}
+	}]}}`), &har))
+
+	i := &Inspector{}
+	r := MustNew(BearerAuth("token"), Header("X-Color", "green"), MockDoer(204), i)
+
+	resp, err := r.Send(FromHAREntry(har.Log.Entries[0]))
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+
+	req := i.Request
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "https://example.com/api/things?size=big", req.URL.String())
+	assert.Equal(t, []string{"red", "blue"}, req.Header.Values("X-Color"))
+	assert.Equal(t, "application/json", req.Header.Get(HeaderAccept))
+	assert.Equal(t, "application/json", req.Header.Get(HeaderContentType))
+	assert.Equal(t, "Bearer token", req.Header.Get(HeaderAuthorization))
+	assert.Equal(t, "session=abc; theme=dark", req.Header.Get("Cookie"))
+	assert.Empty(t, req.Header.Get("Accept-Encoding"))
+	assert.Empty(t, req.Header.Get(":authority"))
+	assert.Equal(t, `{"name":"bob"}`, i.RequestBody.String())
+}
+
+func TestFromHAREntry_params(t *testing.T) {
+	entry := HAREntry{Request: HARRequest{
+		Method: "POST",
+		URL:    "http://example.com/login",
+		PostData: &HARPostData{Params: []HARNameValue{
+			{Name: "user", Value: "bob"},
+			{Name: "pass", Value: "a&b"},
+		}},
+	}}
+
+	req, err := Request(FromHAREntry(entry))
+	require.NoError(t, err)
+	assert.Equal(t, MediaTypeForm, req.Header.Get(HeaderContentType))
+	b, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "pass=a%26b&user=bob", string(b))
+
+	_, err = Request(FromHAREntry(HAREntry{Request: HARRequest{URL: ":bad"}}))
+	assert.Error(t, err)
+}
+
+func TestFromHAREntry_roundTrip(t *testing.T) {
+	ts := httptest.NewServer(MockHandler(200))
+	defer ts.Close()
+
+	i := &Inspector{}
+	_, err := Send(URL(ts.URL), Put("/things/1"), Header("X-Color", "red"), Body("hello"), i)
+	require.NoError(t, err)
+
+	ex := Exchange{Request: i.Request, RequestBody: i.RequestBody, Response: i.Response, ResponseBody: i.ResponseBody}
+	i2 := &Inspector{}
+	_, err = Send(FromHAREntry(ex.HAREntry()), i2)
+	require.NoError(t, err)
+
+	assert.Equal(t, "PUT", i2.Request.Method)
+	assert.Equal(t, ts.URL+"/things/1", i2.Request.URL.String())
+	assert.Equal(t, "red", i2.Request.Header.Get("X-Color"))
+	assert.Equal(t, "hello", i2.RequestBody.String())
+}
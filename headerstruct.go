@@ -0,0 +1,163 @@
+package requester
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// HeaderStruct sets request headers from the fields of a struct, like QueryParams does with
+// query params.  The header names come from the fields' "header" tags:
+//
+//	type Headers struct {
+//	    RequestID string    `header:"X-Request-Id"`
+//	    Tenant    string    `header:"X-Tenant,omitempty"`
+//	    Accept    []string  `header:"Accept"`
+//	    Since     time.Time `header:"If-Modified-Since,omitempty"`
+//	    Internal  string    `header:"-"`
+//	}
+//
+// Fields without a tag use the field name.  Fields tagged "-" are skipped, and fields
+// tagged with "omitempty" are skipped if they have their zero value.  Embedded structs
+// are flattened.
+//
+// Fields may be strings, bools, numbers, time.Times (formatted with http.TimeFormat),
+// fmt.Stringers, or pointers to those.  Nil pointers are skipped.  Each header is set,
+// replacing any existing values.  Slice fields set a header with multiple values.
+//
+// v may be a struct or a pointer to a struct.  An error is returned for any other type,
+// or for fields of unsupported types.
+func HeaderStruct(v interface{}) Option {
+	return OptionFunc(func(r *Requester) error {
+		rv := reflect.ValueOf(v)
+		for rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return nil
+			}
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return merry.Errorf("HeaderStruct requires a struct, got %T", v)
+		}
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		return setStructHeaders(r.Header, rv)
+	})
+}
+
+// nolint:gochecknoglobals
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+func setStructHeaders(h http.Header, rv reflect.Value) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, hasTag := sf.Tag.Lookup("header")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		omitEmpty := opts == "omitempty"
+
+		fv := rv.Field(i)
+		if sf.Anonymous && !hasTag {
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					break
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != timeType {
+				if err := setStructHeaders(h, fv); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			h.Del(name)
+			for j := 0; j < fv.Len(); j++ {
+				s, ok, err := headerValue(fv.Index(j))
+				if err != nil {
+					return merry.Prepend(err, "header field "+sf.Name)
+				}
+				if ok {
+					h.Add(name, s)
+				}
+			}
+			continue
+		}
+
+		s, ok, err := headerValue(fv)
+		if err != nil {
+			return merry.Prepend(err, "header field "+sf.Name)
+		}
+		if ok {
+			h.Set(name, s)
+		}
+	}
+	return nil
+}
+
+// headerValue formats a field value as a header value.  It returns false for nil pointers.
+func headerValue(v reflect.Value) (string, bool, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", false, nil
+		}
+		if v.Kind() == reflect.Ptr && v.Type().Implements(stringerType) && v.Type().Elem() != timeType {
+			// String may have a pointer receiver
+			break
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).UTC().Format(http.TimeFormat), true, nil
+	}
+	if v.Type().Implements(stringerType) {
+		return v.Interface().(fmt.Stringer).String(), true, nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), true, nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), true, nil
+		}
+		return "", false, merry.Errorf("unsupported type %s", v.Type())
+	default:
+		return "", false, merry.Errorf("unsupported type %s", v.Type())
+	}
+}
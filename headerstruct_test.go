@@ -0,0 +1,94 @@
+package requester
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type headerStringer struct{ s string }
+
+func (h *headerStringer) String() string {
+	return "stringer:" + h.s
+}
+
+type HeaderBase struct {
+	RequestID string `header:"X-Request-Id"`
+}
+
+func TestHeaderStruct(t *testing.T) {
+	since := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	count := 3
+
+	type headers struct {
+		HeaderBase
+		Tenant   string          `header:"X-Tenant,omitempty"`
+		Empty    string          `header:"X-Empty"`
+		Accept   []string        `header:"Accept"`
+		Since    *time.Time      `header:"If-Modified-Since"`
+		Until    time.Time       `header:"X-Until,omitempty"`
+		Count    *int            `header:"X-Count"`
+		Missing  *int            `header:"X-Missing"`
+		Ratio    float64         `header:"X-Ratio"`
+		Debug    bool            `header:"x-debug"`
+		Stringer *headerStringer `header:"X-Stringer"`
+		Raw      []byte          `header:"X-Raw"`
+		Skipped  string          `header:"-"`
+		Untagged string
+		private  string
+	}
+
+	reqs, err := New(
+		Header("Accept", "text/plain"),
+		Header("X-Other", "kept"),
+		HeaderStruct(&headers{
+			HeaderBase: HeaderBase{RequestID: "5"},
+			Accept:     []string{"application/json", "text/html"},
+			Since:      &since,
+			Count:      &count,
+			Ratio:      0.5,
+			Debug:      true,
+			Stringer:   &headerStringer{s: "x"},
+			Raw:        []byte("raw"),
+			Skipped:    "skipped",
+			Untagged:   "untagged",
+			private:    "private",
+		}),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.Header{
+		"X-Request-Id":      {"5"},
+		"X-Empty":           {""},
+		"Accept":            {"application/json", "text/html"},
+		"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"},
+		"X-Count":           {"3"},
+		"X-Ratio":           {"0.5"},
+		"X-Debug":           {"true"},
+		"X-Stringer":        {"stringer:x"},
+		"X-Raw":             {"raw"},
+		"Untagged":          {"untagged"},
+		"X-Other":           {"kept"},
+	}, reqs.Header)
+}
+
+func TestHeaderStruct_errors(t *testing.T) {
+	_, err := New(HeaderStruct("bad"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a struct")
+
+	_, err = New(HeaderStruct(struct {
+		M map[string]string `header:"X-Map"`
+	}{M: map[string]string{}}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "header field M")
+
+	// nil is a no-op
+	var h *HeaderBase
+	reqs, err := New(HeaderStruct(h))
+	require.NoError(t, err)
+	assert.Nil(t, reqs.Header)
+}
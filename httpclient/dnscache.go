@@ -0,0 +1,159 @@
+package httpclient
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ansel1/merry"
+)
+
+// DefaultDNSCacheTTL is how long the DNSCache option caches resolved addresses by default.
+const DefaultDNSCacheTTL = time.Minute
+
+// DNSCacheConfig defines settings for the DNSCache option.
+type DNSCacheConfig struct {
+	// TTL is how long resolved addresses are cached.  Defaults to DefaultDNSCacheTTL.
+	TTL time.Duration
+	// NegativeTTL is how long failed lookups are cached.  If zero, failures aren't cached,
+	// and every new connection to the host retries the lookup.
+	NegativeTTL time.Duration
+	// Resolver resolves host names.  Defaults to net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+func (c *DNSCacheConfig) normalize() {
+	if c.TTL <= 0 {
+		c.TTL = DefaultDNSCacheTTL
+	}
+
+	if c.Resolver == nil {
+		c.Resolver = net.DefaultResolver
+	}
+}
+
+// DNSCache configures the client to cache the addresses host names resolve to, which cuts
+// DNS latency for clients making many connections to the same hosts.  If config is nil,
+// the defaults are used.
+//
+// When connecting, the cached addresses are tried in order, until one connects.  Concurrent
+// connections to a host which isn't cached share a single lookup.  The cache wraps the
+// transport's current dialer, so it should be applied after options which replace the dialer.
+// It replaces the lookups done by those dialers, including any custom Resolver.
+func DNSCache(config *DNSCacheConfig) Option {
+	return TransportOption(func(t *http.Transport) error {
+		var c DNSCacheConfig
+		if config != nil {
+			c = *config
+		}
+		c.normalize()
+
+		cache := &dnsCache{
+			ttl:         c.TTL,
+			negativeTTL: c.NegativeTTL,
+			lookup:      c.Resolver.LookupHost,
+			entries:     map[string]*dnsCacheEntry{},
+		}
+
+		dial := dialContext(t)
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil || net.ParseIP(host) != nil {
+				return dial(ctx, network, addr)
+			}
+
+			addrs, err := cache.resolve(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, a := range addrs {
+				var conn net.Conn
+				conn, err = dial(ctx, network, net.JoinHostPort(a, port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		}
+		return nil
+	})
+}
+
+// dnsCache caches the results of host lookups.
+type dnsCache struct {
+	ttl, negativeTTL time.Duration
+	lookup           func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+}
+
+// dnsCacheEntry is a lookup result.  ready is closed when the lookup finishes.
+type dnsCacheEntry struct {
+	ready   chan struct{}
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e := c.entries[host]
+	if e != nil {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				e = nil
+			}
+		default:
+			// lookup in progress
+		}
+	}
+	owner := e == nil
+	if owner {
+		e = &dnsCacheEntry{ready: make(chan struct{})}
+		c.entries[host] = e
+	}
+	c.mu.Unlock()
+
+	if owner {
+		go c.fill(host, e)
+	}
+
+	select {
+	case <-e.ready:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fill does the lookup for an entry.  It isn't tied to the context of the request which
+// triggered it, since other requests may be waiting on it too.
+func (c *dnsCache) fill(host string, e *dnsCacheEntry) {
+	defer close(e.ready)
+
+	addrs, err := c.lookup(context.Background(), host)
+	switch {
+	case err != nil:
+		e.err = merry.Prependf(err, "resolving %s", host)
+		e.expires = time.Now().Add(c.negativeTTL)
+	case len(addrs) == 0:
+		e.err = merry.Errorf("no addresses found for %s", host)
+		e.expires = time.Now().Add(c.negativeTTL)
+	default:
+		e.addrs = addrs
+		e.expires = time.Now().Add(c.ttl)
+	}
+
+	if e.err != nil && c.negativeTTL <= 0 {
+		c.mu.Lock()
+		if c.entries[host] == e {
+			delete(c.entries, host)
+		}
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,121 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSCache(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	_, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	require.NoError(t, err)
+
+	c, err := New(DNSCache(nil))
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://localhost:" + port + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	t.Run("negative", func(t *testing.T) {
+		var queries int32
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				atomic.AddInt32(&queries, 1)
+				return nil, errors.New("no dns")
+			},
+		}
+
+		c, err := New(DNSCache(&DNSCacheConfig{Resolver: r, NegativeTTL: time.Minute}))
+		require.NoError(t, err)
+
+		_, err = c.Get("http://example.test/")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "resolving example.test")
+		n := atomic.LoadInt32(&queries)
+		assert.NotZero(t, n)
+
+		_, err = c.Get("http://example.test/")
+		require.Error(t, err)
+		assert.Equal(t, n, atomic.LoadInt32(&queries), "failure should have been cached")
+	})
+}
+
+func TestDNSCache_resolve(t *testing.T) {
+	var lookups int32
+	fail := false
+	release := make(chan struct{})
+	cache := &dnsCache{
+		ttl:     50 * time.Millisecond,
+		entries: map[string]*dnsCacheEntry{},
+		lookup: func(ctx context.Context, host string) ([]string, error) {
+			atomic.AddInt32(&lookups, 1)
+			<-release
+			if fail {
+				return nil, errors.New("boom")
+			}
+			return []string{"10.0.0.1", "10.0.0.2"}, nil
+		},
+	}
+
+	// concurrent lookups share one query
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := cache.resolve(context.Background(), "example.test")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, addrs)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	// cached
+	_, err := cache.resolve(context.Background(), "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	// expired
+	time.Sleep(60 * time.Millisecond)
+	_, err = cache.resolve(context.Background(), "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+
+	// failures aren't cached without a negative TTL
+	cache.entries = map[string]*dnsCacheEntry{}
+	fail = true
+	for i := 0; i < 2; i++ {
+		_, err = cache.resolve(context.Background(), "example.test")
+		require.Error(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&lookups))
+
+	// waiting is canceled with the context
+	hang := make(chan struct{})
+	defer close(hang)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		<-hang
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cache.resolve(ctx, "hang.test")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -1,8 +1,14 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"github.com/ansel1/merry"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/proxy"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -61,6 +67,80 @@ func ProxyURL(proxyURL string) Option {
 	})
 }
 
+// ProxyURLWithAuth will proxy all calls through a single proxy URL, authenticating
+// to the proxy with basic auth.  Credentials already in proxyURL are replaced.
+func ProxyURLWithAuth(proxyURL, user, pass string) Option {
+	return TransportOption(func(t *http.Transport) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		u.User = url.UserPassword(user, pass)
+		t.Proxy = http.ProxyURL(u)
+		return nil
+	})
+}
+
+// ProxyBasicAuth adds basic auth credentials to the URLs of the proxies the client uses,
+// e.g. from the environment, or from ProxyURL or ProxyFunc.  The transport sends them in the
+// Proxy-Authorization header, both on plain http requests sent to the proxy, and on the
+// CONNECT requests which tunnel https requests through it.  Proxy URLs which already have
+// credentials are unchanged.
+//
+// It wraps the transport's current proxy function, so it should be applied after options
+// which set the proxy.
+func ProxyBasicAuth(user, pass string) Option {
+	return TransportOption(func(t *http.Transport) error {
+		proxy := t.Proxy
+		if proxy == nil {
+			return nil
+		}
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := proxy(req)
+			if err != nil || u == nil || u.User != nil {
+				return u, err
+			}
+			u2 := *u
+			u2.User = url.UserPassword(user, pass)
+			return &u2, nil
+		}
+		return nil
+	})
+}
+
+// ProxyConnectHeader sets headers sent to proxies in CONNECT requests, which are used to
+// tunnel https requests through HTTP proxies.
+func ProxyConnectHeader(h http.Header) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.ProxyConnectHeader = h
+		return nil
+	})
+}
+
+// SOCKS5Proxy will connect through a SOCKS5 proxy at addr (host:port).  auth may be nil, if the
+// proxy doesn't require authentication.  Host names are resolved by the proxy.
+//
+// It replaces the transport's dialer, and disables any HTTP proxy.
+func SOCKS5Proxy(addr string, auth *proxy.Auth) Option {
+	return TransportOption(func(t *http.Transport) error {
+		forward := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		d, err := proxy.SOCKS5("tcp", addr, auth, forward)
+		if err != nil {
+			return merry.Prepend(err, "configuring SOCKS5 proxy")
+		}
+		cd, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return merry.Errorf("SOCKS5 dialer doesn't support contexts: %T", d)
+		}
+		t.DialContext = cd.DialContext
+		t.Proxy = nil
+		return nil
+	})
+}
+
 // ProxyFunc configures the client's proxy function.
 func ProxyFunc(f func(request *http.Request) (*url.URL, error)) Option {
 	return TransportOption(func(t *http.Transport) error {
@@ -77,6 +157,26 @@ func Timeout(d time.Duration) Option {
 	})
 }
 
+// WrapTransport wraps the client's transport with another http.RoundTripper, like
+// OpenTelemetry's otelhttp.Transport:
+//
+//	httpclient.New(httpclient.WrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+//		return otelhttp.NewTransport(rt)
+//	}))
+//
+// If the client has no transport yet, the default transport is wrapped.  Since the client's
+// transport is no longer a *http.Transport afterwards, WrapTransport must be applied after any
+// options which configure the transport.
+func WrapTransport(f func(http.RoundTripper) http.RoundTripper) Option {
+	return OptionFunc(func(client *http.Client) error {
+		if client.Transport == nil {
+			client.Transport = newDefaultTransport()
+		}
+		client.Transport = f(client.Transport)
+		return nil
+	})
+}
+
 // SkipVerify sets the TLS config's InsecureSkipVerify flag.
 func SkipVerify(skip bool) Option {
 	return TLSOption(func(c *tls.Config) error {
@@ -84,3 +184,304 @@ func SkipVerify(skip bool) Option {
 		return nil
 	})
 }
+
+// ClientCert loads a client certificate and its private key from a pair of PEM encoded files, and
+// adds it to the TLS config, for mutual TLS.
+func ClientCert(certFile, keyFile string) Option {
+	return TLSOption(func(c *tls.Config) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return merry.Prepend(err, "loading client certificate")
+		}
+		c.Certificates = append(c.Certificates, cert)
+		return nil
+	})
+}
+
+// ClientCertFromTLS adds a client certificate to the TLS config, for mutual TLS.
+func ClientCertFromTLS(cert tls.Certificate) Option {
+	return TLSOption(func(c *tls.Config) error {
+		c.Certificates = append(c.Certificates, cert)
+		return nil
+	})
+}
+
+// RootCAs sets the pool of root certificate authorities the client uses to verify
+// server certificates.  If nil, the host's root CAs are used.
+func RootCAs(pool *x509.CertPool) Option {
+	return TLSOption(func(c *tls.Config) error {
+		c.RootCAs = pool
+		return nil
+	})
+}
+
+// AppendRootCAFile adds the certificates in a PEM encoded file to the root certificate
+// authorities the client trusts.  If a pool was already set, e.g. with RootCAs, the certificates
+// are added to it.  Otherwise, they're added to a copy of the host's root CAs, so servers with
+// public certificates can still be verified.
+func AppendRootCAFile(path string) Option {
+	return TLSOption(func(c *tls.Config) error {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return merry.Prepend(err, "reading root CA file")
+		}
+
+		pool := c.RootCAs
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return merry.Errorf("no certificates found in %s", path)
+		}
+		c.RootCAs = pool
+		return nil
+	})
+}
+
+// HTTP2 enables or disables HTTP/2 on the client's transport.  When enabled, HTTP/2 is
+// negotiated with TLS servers which support it, even if the transport has a custom TLS config
+// or dialer (which otherwise disable the standard library's automatic HTTP/2 support).  When
+// disabled, only HTTP/1.1 is used.
+func HTTP2(enabled bool) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.ForceAttemptHTTP2 = enabled
+		if enabled {
+			if t.TLSNextProto != nil && len(t.TLSNextProto) == 0 {
+				// undo HTTP2(false), without discarding an HTTP/2 config from HTTP2Pings
+				t.TLSNextProto = nil
+			}
+		} else {
+			// a non-nil, empty map disables HTTP/2
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		return nil
+	})
+}
+
+// H2C configures the client to use HTTP/2 over cleartext TCP (h2c) with prior knowledge: http
+// URLs are sent with HTTP/2, without TLS, and without first negotiating an upgrade from
+// HTTP/1.1.  This is commonly used between internal services, e.g. for gRPC.  The server must
+// support h2c: requests to HTTP/1.1 only servers will fail.  https URLs are unaffected.
+//
+// It's an error to apply H2C to the same transport more than once.
+func H2C() Option {
+	return TransportOption(func(t *http.Transport) (err error) {
+		dial := dialContext(t)
+
+		h2c := &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, addr)
+			},
+		}
+
+		defer func() {
+			// RegisterProtocol panics if the scheme is already registered
+			if r := recover(); r != nil {
+				err = merry.Errorf("configuring h2c: %v", r)
+			}
+		}()
+		t.RegisterProtocol("http", h2c)
+		return nil
+	})
+}
+
+// UnixSocket configures the client to connect to a unix domain socket, instead of the host in the
+// request URL.  Request URLs still need a host, which is sent in the Host header, but any
+// host will do.  This is useful for talking to local daemons, like Docker:
+//
+//	c, _ := httpclient.New(httpclient.UnixSocket("/var/run/docker.sock"))
+//	resp, err := c.Get("http://docker/v1.41/containers/json")
+func UnixSocket(path string) Option {
+	return TransportOption(func(t *http.Transport) error {
+		dialer := &net.Dialer{}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		// proxies would be dialed over the socket too
+		t.Proxy = nil
+		return nil
+	})
+}
+
+// Resolver configures the client to resolve host names with a custom resolver, e.g. one which
+// queries a specific DNS server.  It replaces the transport's dialer, so it should be applied
+// before options which wrap the dialer, like HostAlias.
+func Resolver(r *net.Resolver) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Resolver:  r,
+		}).DialContext
+		return nil
+	})
+}
+
+// HostAlias configures the client to connect to different addresses for some hosts, like entries
+// in /etc/hosts.  Keys are host names, or host:port pairs, which take precedence.  Values are
+// IP addresses, host names, or host:port pairs.  If a value has no port, the request's port is
+// used:
+//
+//	httpclient.HostAlias(map[string]string{
+//		"api.example.com":      "10.0.0.5",
+//		"auth.example.com:443": "127.0.0.1:8443",
+//	})
+//
+// Only the connection is redirected: the Host header, and the server name used to verify TLS
+// certificates, are still those of the request URL.  Aliases are applied by wrapping the
+// transport's current dialer.
+func HostAlias(aliases map[string]string) Option {
+	return TransportOption(func(t *http.Transport) error {
+		dial := dialContext(t)
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dial(ctx, network, aliasAddr(aliases, addr))
+		}
+		return nil
+	})
+}
+
+func aliasAddr(aliases map[string]string, addr string) string {
+	if alias, ok := aliases[addr]; ok {
+		return withPort(alias, addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if alias, ok := aliases[host]; ok {
+		return withPort(alias, addr)
+	}
+	return addr
+}
+
+// withPort adds the port from addr to alias, if alias doesn't have one.
+func withPort(alias, addr string) string {
+	if _, _, err := net.SplitHostPort(alias); err == nil {
+		return alias
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return alias
+	}
+	return net.JoinHostPort(alias, port)
+}
+
+// DialContext sets the transport's dial function, replacing the default dialer.  Options which
+// wrap the dialer, like DialTimeout and HostAlias, should be applied after it.
+func DialContext(fn func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.DialContext = fn
+		return nil
+	})
+}
+
+// DialTimeout limits how long the client waits for a connection to be established.  It wraps
+// the transport's current dialer, so it works with custom dialers too.  A zero or negative
+// duration leaves the dialer's own timeout in place.  The default dialer times out after
+// 30 seconds.
+func DialTimeout(d time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		if d <= 0 {
+			return nil
+		}
+		dial := dialContext(t)
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return dial(ctx, network, addr)
+		}
+		return nil
+	})
+}
+
+// KeepAlive sets the period between TCP keep-alive probes on the client's connections.  A
+// negative duration disables keep-alive probes.  It wraps the transport's current dialer, and
+// only affects TCP connections.  The default dialer sends probes every 30 seconds.
+//
+// This is unrelated to HTTP keep-alives, i.e. connection reuse.
+func KeepAlive(d time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		dial := dialContext(t)
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				if err := setKeepAlive(tcp, d); err != nil {
+					conn.Close()
+					return nil, merry.Prepend(err, "setting TCP keep-alive")
+				}
+			}
+			return conn, nil
+		}
+		return nil
+	})
+}
+
+func setKeepAlive(conn *net.TCPConn, d time.Duration) error {
+	if d < 0 {
+		return conn.SetKeepAlive(false)
+	}
+	if err := conn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if d > 0 {
+		return conn.SetKeepAlivePeriod(d)
+	}
+	return nil
+}
+
+// dialContext returns the transport's dial function, or a plain dialer if it isn't set.
+func dialContext(t *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t.DialContext != nil {
+		return t.DialContext
+	}
+	return (&net.Dialer{}).DialContext
+}
+
+// TLSHandshakeTimeout sets the transport's TLSHandshakeTimeout, which limits how long the client
+// waits for a TLS handshake.  Zero means no timeout.  The default is 10 seconds.
+func TLSHandshakeTimeout(d time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.TLSHandshakeTimeout = d
+		return nil
+	})
+}
+
+// ResponseHeaderTimeout sets the transport's ResponseHeaderTimeout, which limits how long the
+// client waits for the response headers, after the request has been written.  It doesn't limit
+// reading the response body.  Zero means no timeout, which is the default.
+func ResponseHeaderTimeout(d time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t.ResponseHeaderTimeout = d
+		return nil
+	})
+}
+
+// HTTP2Pings enables HTTP/2 on the client's transport, and configures
+// it to send health check pings on HTTP/2 connections which haven't received
+// any frames in the last interval.  If the ping isn't answered within timeout,
+// the connection is closed.  A zero timeout defaults to 15 seconds.
+//
+// This keeps connections carrying long-running requests (like reports or exports, where
+// the server may be silent for minutes) from being dropped by intermediaries which
+// close idle connections.
+//
+// Pings are only sent on HTTP/2 connections.  The transport's TLS settings must
+// permit HTTP/2 to be negotiated.
+func HTTP2Pings(interval, timeout time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		t2, err := http2.ConfigureTransports(t)
+		if err != nil {
+			return merry.Prepend(err, "configuring http2")
+		}
+		t2.ReadIdleTimeout = interval
+		t2.PingTimeout = timeout
+		return nil
+	})
+}
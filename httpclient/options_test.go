@@ -0,0 +1,453 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/proxy"
+)
+
+func TestHTTP2Pings(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	c, err := New(SkipVerify(true), HTTP2Pings(time.Second, time.Second))
+	require.NoError(t, err)
+
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "HTTP/2.0", resp.Header.Get("X-Proto"))
+
+	// http2 can only be configured once on the same transport
+	err = Apply(c, HTTP2Pings(time.Second, time.Second))
+	assert.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-Certs", strconv.Itoa(len(r.TLS.PeerCertificates)))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// reuse the server's certificate as the client certificate
+	cert := ts.TLS.Certificates[0]
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600))
+	require.NoError(t, ioutil.WriteFile(caFile, certPEM, 0600))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"files", []Option{ClientCert(certFile, keyFile), AppendRootCAFile(caFile)}},
+		{"tls", []Option{ClientCertFromTLS(cert), RootCAs(pool)}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(tc.opts...)
+			require.NoError(t, err)
+
+			resp, err := c.Get(ts.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, "1", resp.Header.Get("X-Client-Certs"))
+		})
+	}
+
+	t.Run("no client cert", func(t *testing.T) {
+		c, err := New(RootCAs(pool))
+		require.NoError(t, err)
+		_, err = c.Get(ts.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("untrusted server", func(t *testing.T) {
+		c, err := New(ClientCertFromTLS(cert))
+		require.NoError(t, err)
+		_, err = c.Get(ts.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("bad files", func(t *testing.T) {
+		_, err := New(ClientCert(filepath.Join(dir, "missing.pem"), keyFile))
+		require.Error(t, err)
+		_, err = New(AppendRootCAFile(keyFile))
+		require.Error(t, err)
+	})
+}
+
+func TestHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	proto := func(t *testing.T, opts ...Option) string {
+		t.Helper()
+		c, err := New(opts...)
+		require.NoError(t, err)
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.Header.Get("X-Proto")
+	}
+
+	// the custom TLS config would normally disable HTTP/2, but the default transport forces it
+	assert.Equal(t, "HTTP/2.0", proto(t, SkipVerify(true)))
+	assert.Equal(t, "HTTP/1.1", proto(t, SkipVerify(true), HTTP2(false)))
+	assert.Equal(t, "HTTP/2.0", proto(t, SkipVerify(true), HTTP2(false), HTTP2(true)))
+}
+
+func TestH2C(t *testing.T) {
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}), &http2.Server{}))
+	defer ts.Close()
+
+	c, err := New(H2C())
+	require.NoError(t, err)
+
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/2.0", resp.Header.Get("X-Proto"))
+
+	// https is unaffected
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	defer tlsServer.Close()
+	require.NoError(t, Apply(c, SkipVerify(true), HTTP2(false)))
+	resp, err = c.Get(tlsServer.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "HTTP/1.1", resp.Header.Get("X-Proto"))
+
+	// h2c can only be configured once on the same transport
+	assert.Error(t, Apply(c, H2C()))
+}
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	ts.Listener = l
+	ts.Start()
+	defer ts.Close()
+
+	c, err := New(ProxyURL("http://proxy.invalid"), UnixSocket(socket))
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://docker/containers")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "docker", resp.Header.Get("X-Host"))
+}
+
+func TestHostAlias(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	defer ts.Close()
+
+	addr := ts.Listener.Addr().(*net.TCPAddr)
+	port := strconv.Itoa(addr.Port)
+
+	c, err := New(HostAlias(map[string]string{
+		"api.example.com":          addr.String(),
+		"auth.example.com":         "127.0.0.2:1",
+		"auth.example.com:" + port: "127.0.0.1",
+	}))
+	require.NoError(t, err)
+
+	for _, u := range []string{"http://api.example.com/", "http://auth.example.com:" + port + "/"} {
+		resp, err := c.Get(u)
+		require.NoError(t, err, u)
+		resp.Body.Close()
+		assert.Contains(t, u, resp.Header.Get("X-Host"))
+	}
+}
+
+func TestResolver(t *testing.T) {
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("custom resolver")
+		},
+	}
+
+	c, err := New(Resolver(r))
+	require.NoError(t, err)
+
+	_, err = c.Get("http://example.test/")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "custom resolver")
+}
+
+func TestProxyURLWithAuth(t *testing.T) {
+	var proxyAuth, target string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		target = r.URL.String()
+	}))
+	defer ts.Close()
+
+	c, err := New(ProxyURLWithAuth(ts.URL, "user", "pass"))
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://example.test/path")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "Basic dXNlcjpwYXNz", proxyAuth)
+	assert.Equal(t, "http://example.test/path", target)
+
+	_, err = New(ProxyURLWithAuth("http://[::1", "user", "pass"))
+	assert.Error(t, err)
+}
+
+func TestProxyConnectHeader(t *testing.T) {
+	var connectHeader http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connectHeader = r.Header
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	c, err := New(ProxyURL(ts.URL), ProxyConnectHeader(http.Header{"X-Tenant": []string{"acme"}}))
+	require.NoError(t, err)
+
+	_, err = c.Get("https://example.test/")
+	require.Error(t, err)
+	assert.Equal(t, "acme", connectHeader.Get("X-Tenant"))
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Host", r.Host)
+	}))
+	defer ts.Close()
+
+	// a minimal SOCKS5 proxy, which requires username/password auth, and
+	// connects every request to the test server
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	got := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		read := func(n int) []byte {
+			_, _ = io.ReadFull(conn, buf[:n])
+			return buf[:n]
+		}
+
+		// greeting: version, methods
+		methods := read(2)[1]
+		read(int(methods))
+		_, _ = conn.Write([]byte{0x05, 0x02})
+
+		// username/password auth
+		user := string(read(int(read(2)[1])))
+		pass := string(read(int(read(1)[0])))
+		got <- user + ":" + pass
+		_, _ = conn.Write([]byte{0x01, 0x00})
+
+		// connect request: version, cmd, reserved, address type, domain name, port
+		read(4)
+		host := string(read(int(read(1)[0])))
+		port := read(2)
+		got <- net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+		_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+
+		upstream, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer upstream.Close()
+		go func() { _, _ = io.Copy(upstream, conn) }()
+		_, _ = io.Copy(conn, upstream)
+	}()
+
+	c, err := New(
+		ProxyURL("http://proxy.invalid"),
+		SOCKS5Proxy(l.Addr().String(), &proxy.Auth{User: "user", Password: "pass"}),
+	)
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://example.test:8080/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "example.test:8080", resp.Header.Get("X-Host"))
+	assert.Equal(t, "user:pass", <-got)
+	assert.Equal(t, "example.test:8080", <-got)
+}
+
+func TestDialOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var dialed []string
+	var deadline time.Time
+	c, err := New(
+		DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			deadline, _ = ctx.Deadline()
+			return (&net.Dialer{}).DialContext(ctx, network, ts.Listener.Addr().String())
+		}),
+		DialTimeout(5*time.Second),
+		KeepAlive(-1),
+		TLSHandshakeTimeout(3*time.Second),
+		ResponseHeaderTimeout(4*time.Second),
+	)
+	require.NoError(t, err)
+
+	tr := c.Transport.(*http.Transport)
+	assert.Equal(t, 3*time.Second, tr.TLSHandshakeTimeout)
+	assert.Equal(t, 4*time.Second, tr.ResponseHeaderTimeout)
+
+	resp, err := c.Get("http://example.test/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, []string{"example.test:80"}, dialed)
+	assert.WithinDuration(t, time.Now().Add(5*time.Second), deadline, time.Second)
+
+	t.Run("timeout", func(t *testing.T) {
+		c, err := New(
+			DialContext(func(ctx context.Context, network, addr string) (net.Conn, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}),
+			DialTimeout(10*time.Millisecond),
+		)
+		require.NoError(t, err)
+
+		_, err = c.Get("http://example.test/")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	c, err := New(ResponseHeaderTimeout(10 * time.Millisecond))
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout awaiting response headers")
+}
+
+type headerTransport struct {
+	next        http.RoundTripper
+	name, value string
+}
+
+func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(h.name, h.value)
+	return h.next.RoundTrip(req)
+}
+
+func TestWrapTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen", r.Header.Get("X-Wrapped"))
+	}))
+	defer ts.Close()
+
+	var wrapped http.RoundTripper
+	c, err := New(
+		TLSHandshakeTimeout(time.Second),
+		WrapTransport(func(rt http.RoundTripper) http.RoundTripper {
+			wrapped = rt
+			return &headerTransport{next: rt, name: "X-Wrapped", value: "yes"}
+		}),
+	)
+	require.NoError(t, err)
+
+	// the default transport, with earlier options applied, is wrapped
+	require.IsType(t, &http.Transport{}, wrapped)
+	assert.Equal(t, time.Second, wrapped.(*http.Transport).TLSHandshakeTimeout)
+
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "yes", resp.Header.Get("X-Seen"))
+}
+
+func TestProxyBasicAuth(t *testing.T) {
+	var proxyAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+	}))
+	defer ts.Close()
+
+	c, err := New(ProxyURL(ts.URL), ProxyBasicAuth("user", "pass"))
+	require.NoError(t, err)
+
+	resp, err := c.Get("http://example.test/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Basic dXNlcjpwYXNz", proxyAuth)
+
+	// credentials already in the proxy URL take precedence
+	c, err = New(ProxyURLWithAuth(ts.URL, "other", "pass"), ProxyBasicAuth("user", "pass"))
+	require.NoError(t, err)
+
+	resp, err = c.Get("http://example.test/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Basic b3RoZXI6cGFzcw==", proxyAuth)
+}
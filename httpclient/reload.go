@@ -0,0 +1,206 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/ansel1/merry"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+)
+
+// GetClientCertificate sets the TLS config's GetClientCertificate function, which supplies the
+// client certificate for each TLS handshake.  Use it to plug in certificates from other sources,
+// like a SPIFFE workload API, which rotate without the client being restarted.
+func GetClientCertificate(f func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) Option {
+	return TLSOption(func(c *tls.Config) error {
+		c.GetClientCertificate = f
+		return nil
+	})
+}
+
+// ReloadClientCert is like ClientCert, but reloads the certificate and key files when they
+// change, so long-running clients pick up rotated certificates without restarting.  The
+// files are checked for changes at most once per interval, when a new connection is made.
+// A zero interval checks on every new connection.
+//
+// The files are loaded when the option is applied, so missing or invalid files are reported
+// immediately.  If reloading fails later, e.g. because the files are being rewritten, the last
+// certificate loaded is used, and reloading is tried again after the next interval.
+func ReloadClientCert(certFile, keyFile string, interval time.Duration) Option {
+	return TLSOption(func(c *tls.Config) error {
+		w := &fileWatcher{
+			paths:    []string{certFile, keyFile},
+			interval: interval,
+			load: func() (interface{}, error) {
+				cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				if err != nil {
+					return nil, merry.Prepend(err, "loading client certificate")
+				}
+				return &cert, nil
+			},
+		}
+		if _, err := w.get(); err != nil {
+			return err
+		}
+
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			v, err := w.get()
+			if err != nil {
+				return nil, err
+			}
+			return v.(*tls.Certificate), nil
+		}
+		return nil
+	})
+}
+
+// ReloadRootCAFile sets the root certificate authorities the client trusts to the certificates
+// in a PEM encoded file, and reloads the file when it changes, so rotated CAs are picked up
+// without restarting.  The file is checked for changes at most once per interval, when a new
+// connection is made.  A zero interval checks on every new connection.
+//
+// Since the TLS config's RootCAs can't be changed once the client is in use, this sets the
+// transport's DialTLSContext to a dialer which makes each TLS connection with a clone of the
+// transport's TLS config, with RootCAs set to the current certificates.  The server's
+// certificate is verified as usual.  Connections through a proxy aren't made by
+// DialTLSContext, and are verified against the certificates loaded when the option is applied.
+func ReloadRootCAFile(path string, interval time.Duration) Option {
+	return TransportOption(func(t *http.Transport) error {
+		w := &fileWatcher{
+			paths:    []string{path},
+			interval: interval,
+			load: func() (interface{}, error) {
+				pem, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, merry.Prepend(err, "reading root CA file")
+				}
+				pool := x509.NewCertPool()
+				if !pool.AppendCertsFromPEM(pem) {
+					return nil, merry.Errorf("no certificates found in %s", path)
+				}
+				return pool, nil
+			},
+		}
+		v, err := w.get()
+		if err != nil {
+			return err
+		}
+
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		t.TLSClientConfig.RootCAs = v.(*x509.CertPool)
+
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			v, err := w.get()
+			if err != nil {
+				return nil, err
+			}
+			return dialTLS(ctx, t, network, addr, v.(*x509.CertPool))
+		}
+		return nil
+	})
+}
+
+// dialTLS dials addr with the transport's dialer, and makes a TLS connection with a clone
+// of the transport's TLS config, using roots as the config's RootCAs.  It does what the
+// transport does itself when DialTLSContext isn't set.
+func dialTLS(ctx context.Context, t *http.Transport, network, addr string, roots *x509.CertPool) (net.Conn, error) {
+	conn, err := dialContext(t)(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := t.TLSClientConfig.Clone()
+	cfg.RootCAs = roots
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg.ServerName = host
+	}
+
+	if t.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+		defer cancel()
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// fileWatcher caches a value loaded from files, and reloads it when the
+// files' modification times change.
+type fileWatcher struct {
+	paths    []string
+	interval time.Duration
+	load     func() (interface{}, error)
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes []time.Time
+	value    interface{}
+}
+
+func (w *fileWatcher) get() (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.value != nil && time.Since(w.checked) < w.interval {
+		return w.value, nil
+	}
+	w.checked = time.Now()
+
+	modTimes := make([]time.Time, len(w.paths))
+	changed := w.value == nil
+	for i, p := range w.paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			if w.value != nil {
+				// keep using the last value
+				return w.value, nil
+			}
+			return nil, merry.Wrap(err)
+		}
+		modTimes[i] = fi.ModTime()
+		if w.modTimes == nil || !modTimes[i].Equal(w.modTimes[i]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return w.value, nil
+	}
+
+	v, err := w.load()
+	if err != nil {
+		if w.value != nil {
+			return w.value, nil
+		}
+		return nil, err
+	}
+	w.value, w.modTimes = v, modTimes
+	return v, nil
+}
@@ -0,0 +1,158 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert generates a self-signed certificate with the given common name, and writes
+// it and its key to PEM files, with the given modification time.
+func writeCert(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestReloadClientCert(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client-CN", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	now := time.Now()
+	writeCert(t, certFile, keyFile, "first", now.Add(-time.Minute))
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+
+	c, err := New(ReloadClientCert(certFile, keyFile, 0), ReloadRootCAFile(caFile, 0))
+	require.NoError(t, err)
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+
+	cn := func() string {
+		t.Helper()
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.Header.Get("X-Client-CN")
+	}
+
+	assert.Equal(t, "first", cn())
+
+	writeCert(t, certFile, keyFile, "second", now)
+	assert.Equal(t, "second", cn())
+
+	// invalid files are ignored, and the last certificate is used
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	assert.Equal(t, "second", cn())
+
+	t.Run("rotated CA", func(t *testing.T) {
+		// the server's certificate isn't signed by the new CA
+		writeCert(t, caFile, filepath.Join(dir, "otherkey.pem"), "other", now)
+		_, err := c.Get(ts.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("missing files", func(t *testing.T) {
+		_, err := New(ReloadClientCert(filepath.Join(dir, "missing.pem"), keyFile, 0))
+		require.Error(t, err)
+		_, err = New(ReloadRootCAFile(filepath.Join(dir, "missing.pem"), 0))
+		require.Error(t, err)
+	})
+}
+
+func TestReloadRootCAFile_verifyConnection(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	now := time.Now()
+	writeCert(t, caFile, filepath.Join(dir, "key.pem"), "other", now.Add(-time.Minute))
+
+	// the standard verification stays on, so replacing VerifyConnection after the
+	// option doesn't skip it, and VerifyConnection is only called for trusted servers
+	var called bool
+	c, err := New(
+		ReloadRootCAFile(caFile, 0),
+		TLSOption(func(c *tls.Config) error {
+			c.VerifyConnection = func(tls.ConnectionState) error {
+				called = true
+				return nil
+			}
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+	c.Transport.(*http.Transport).DisableKeepAlives = true
+	assert.False(t, c.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+
+	_, err = c.Get(ts.URL)
+	require.Error(t, err)
+	assert.False(t, called)
+
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600))
+	require.NoError(t, os.Chtimes(caFile, now, now))
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.True(t, called)
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
+
+func TestGetClientCertificate(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	var called bool
+	c, err := New(SkipVerify(true), GetClientCertificate(func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		called = true
+		return &ts.TLS.Certificates[0], nil
+	}))
+	require.NoError(t, err)
+
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.True(t, called)
+}
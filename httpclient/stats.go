@@ -0,0 +1,165 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnStats is a snapshot of the connection counters of an InstrumentedTransport.
+type ConnStats struct {
+	// New is the number of connections dialed.
+	New int64
+	// Reused is the number of requests sent on a previously used connection.
+	Reused int64
+	// Closed is the number of connections closed, for any reason.
+	Closed int64
+	// IdleEvicted is the number of connections closed while idle in the pool,
+	// e.g. because of the transport's IdleConnTimeout or MaxIdleConnsPerHost.
+	IdleEvicted int64
+	// HandshakeFailures is the number of TLS handshakes which failed.
+	HandshakeFailures int64
+}
+
+// StatsReporter is implemented by round trippers which count how their connections are
+// used, like InstrumentedTransport.
+type StatsReporter interface {
+	ConnStats() ConnStats
+}
+
+// ClientStats returns the connection stats of a client configured with Instrument.
+// Returns false if the client's transport doesn't implement StatsReporter.
+func ClientStats(c *http.Client) (ConnStats, bool) {
+	if sr, ok := c.Transport.(StatsReporter); ok {
+		return sr.ConnStats(), true
+	}
+	return ConnStats{}, false
+}
+
+// Instrument wraps the client's transport in an InstrumentedTransport, which counts new and
+// reused connections, idle connections evicted from the pool, and failed TLS handshakes.  It
+// shows whether keep-alive is actually working.  Read the counters with ClientStats.
+//
+// Since the client's transport is no longer a *http.Transport afterwards, Instrument must be
+// applied after any options which configure the transport.
+func Instrument() Option {
+	return OptionFunc(func(c *http.Client) error {
+		var it *InstrumentedTransport
+		err := TransportOption(func(t *http.Transport) error {
+			it = NewInstrumentedTransport(t)
+			return nil
+		}).Apply(c)
+		if err != nil {
+			return err
+		}
+		c.Transport = it
+		return nil
+	})
+}
+
+// InstrumentedTransport is an http.RoundTripper which counts how a *http.Transport's
+// connections are used.  Create it with NewInstrumentedTransport.  It's safe for
+// concurrent use.
+//
+// Idle evictions are only tracked for HTTP/1 connections.
+type InstrumentedTransport struct {
+	// Transport is the wrapped transport.
+	Transport *http.Transport
+
+	newConns, reused, closed, idleEvicted, handshakeFailures int64
+}
+
+// NewInstrumentedTransport wraps t.  The transport's dial function is wrapped to count
+// connections, so t shouldn't be used directly afterwards, and its dial function
+// shouldn't be changed.
+func NewInstrumentedTransport(t *http.Transport) *InstrumentedTransport {
+	it := &InstrumentedTransport{Transport: t}
+	dial := dialContext(t)
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&it.newConns, 1)
+		return &instrumentedConn{Conn: conn, it: it}, nil
+	}
+	return it
+}
+
+// RoundTrip implements http.RoundTripper.
+func (it *InstrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var conn *instrumentedConn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				atomic.AddInt64(&it.reused, 1)
+			}
+			conn = unwrapConn(info.Conn)
+			if conn != nil {
+				atomic.StoreInt32(&conn.idle, 0)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if err == nil && conn != nil {
+				atomic.StoreInt32(&conn.idle, 1)
+			}
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				atomic.AddInt64(&it.handshakeFailures, 1)
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return it.Transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the wrapped transport's idle connections.
+func (it *InstrumentedTransport) CloseIdleConnections() {
+	it.Transport.CloseIdleConnections()
+}
+
+// ConnStats implements StatsReporter.
+func (it *InstrumentedTransport) ConnStats() ConnStats {
+	return ConnStats{
+		New:               atomic.LoadInt64(&it.newConns),
+		Reused:            atomic.LoadInt64(&it.reused),
+		Closed:            atomic.LoadInt64(&it.closed),
+		IdleEvicted:       atomic.LoadInt64(&it.idleEvicted),
+		HandshakeFailures: atomic.LoadInt64(&it.handshakeFailures),
+	}
+}
+
+// instrumentedConn counts when it's closed, and whether it was idle at the time.
+type instrumentedConn struct {
+	net.Conn
+	it   *InstrumentedTransport
+	idle int32
+
+	closeOnce sync.Once
+}
+
+func (c *instrumentedConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&c.it.closed, 1)
+		if atomic.LoadInt32(&c.idle) == 1 {
+			atomic.AddInt64(&c.it.idleEvicted, 1)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// unwrapConn finds the instrumentedConn under a connection handed out by the transport,
+// which may be wrapped in a *tls.Conn.  tls.Conn.NetConn requires Go 1.18, so older
+// toolchains fail to build rather than silently not tracking HTTPS connections.
+func unwrapConn(conn net.Conn) *instrumentedConn {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	ic, _ := conn.(*instrumentedConn)
+	return ic
+}
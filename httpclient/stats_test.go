@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrument(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	c, err := New(RootCAs(ts.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs), Instrument())
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(ts.URL)
+		require.NoError(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	stats, ok := ClientStats(c)
+	require.True(t, ok)
+	assert.Equal(t, ConnStats{New: 1, Reused: 2}, stats)
+
+	c.CloseIdleConnections()
+
+	stats, _ = ClientStats(c)
+	assert.Equal(t, ConnStats{New: 1, Reused: 2, Closed: 1, IdleEvicted: 1}, stats)
+
+	// transport options can't be applied afterwards
+	assert.Error(t, Apply(c, SkipVerify(true)))
+
+	_, ok = ClientStats(&http.Client{})
+	assert.False(t, ok)
+}
+
+func TestInstrument_handshakeFailures(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	// the test server's certificate isn't trusted
+	c, err := New(Instrument())
+	require.NoError(t, err)
+
+	_, err = c.Get(ts.URL)
+	require.Error(t, err)
+
+	stats, _ := ClientStats(c)
+	assert.Equal(t, int64(1), stats.New)
+	assert.Equal(t, int64(1), stats.HandshakeFailures)
+	assert.Equal(t, int64(1), stats.Closed)
+	assert.Zero(t, stats.IdleEvicted)
+}
@@ -31,7 +31,7 @@ func DumpTo(handler http.Handler, writer io.Writer) http.Handler {
 
 		ex := Exchange{}
 
-		w = httpsnoop.Wrap(w, hooks(&ex))
+		w = httpsnoop.Wrap(w, hooks(&ex, 0))
 
 		handler.ServeHTTP(w, r)
 
@@ -55,14 +55,17 @@ func DumpTo(handler http.Handler, writer io.Writer) http.Handler {
 	})
 }
 
-// Dump writes requests and responses to the writer.
-func Dump(ts *httptest.Server, to io.Writer) {
-	ts.Config.Handler = DumpTo(ts.Config.Handler, to)
+// Dump writes requests and responses to the writer.  Call Uninstall on the returned
+// Installation to stop dumping.
+func Dump(ts *httptest.Server, to io.Writer) *Installation {
+	return install(ts, func(next http.Handler) http.Handler {
+		return DumpTo(next, to)
+	})
 }
 
 // DumpToStdout writes requests and responses to os.Stdout.
-func DumpToStdout(ts *httptest.Server) {
-	Dump(ts, os.Stdout)
+func DumpToStdout(ts *httptest.Server) *Installation {
+	return Dump(ts, os.Stdout)
 }
 
 type logFunc func(a ...interface{})
@@ -80,6 +83,6 @@ func (f logFunc) Write(p []byte) (n int, err error) {
 //	func TestHandler(t *testing.T) {
 //	    ...
 //	    DumpToLog(ts, t.Log)
-func DumpToLog(ts *httptest.Server, logf func(a ...interface{})) {
-	Dump(ts, logFunc(logf))
+func DumpToLog(ts *httptest.Server, logf func(a ...interface{})) *Installation {
+	return Dump(ts, logFunc(logf))
 }
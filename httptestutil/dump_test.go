@@ -114,3 +114,49 @@ func TestDumpTo_nilhandler(t *testing.T) {
 
 	require.NotEmpty(t, buf)
 }
+
+func TestInstallation_Uninstall(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201))
+	defer ts.Close()
+	orig := ts.Config.Handler
+
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			d := Dump(ts, &buf)
+			i := Inspect(ts)
+
+			_, _, err := Requester(ts).Receive(nil)
+			require.NoError(t, err)
+			assert.NotEmpty(t, buf.String())
+			assert.NotNil(t, i.LastExchange())
+
+			// uninstalled out of order: the dumper is disabled, but stays in place
+			d.Uninstall()
+			buf.Reset()
+			_, _, err = Requester(ts).Receive(nil)
+			require.NoError(t, err)
+			assert.Empty(t, buf.String())
+			assert.NotNil(t, i.LastExchange())
+
+			i.Uninstall()
+			i.Uninstall()
+			_, _, err = Requester(ts).Receive(nil)
+			require.NoError(t, err)
+			assert.Nil(t, i.LastExchange())
+
+			// inspector was on top, so the dumper's wrapper is now on top, disabled
+			_, ok := ts.Config.Handler.(*installedHandler)
+			assert.True(t, ok)
+			ts.Config.Handler = orig
+		})
+	}
+
+	// uninstalling in reverse order leaves no trace
+	j := Record(ts)
+	d := Dump(ts, &bytes.Buffer{})
+	d.Uninstall()
+	j.Uninstall()
+	_, ok := ts.Config.Handler.(*installedHandler)
+	assert.False(t, ok)
+}
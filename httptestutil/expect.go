@@ -0,0 +1,216 @@
+package httptestutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Matcher matches requests captured by an Inspector.  See Inspector.Expect.
+type Matcher interface {
+	// Match returns true if the exchange's request matches.
+	Match(ex *Exchange) bool
+	// String describes the requests which match, for failure messages.
+	String() string
+}
+
+type matcher struct {
+	desc  string
+	match func(ex *Exchange) bool
+}
+
+func (m *matcher) Match(ex *Exchange) bool {
+	return m.match(ex)
+}
+
+func (m *matcher) String() string {
+	return m.desc
+}
+
+// Method matches requests with the HTTP method m.
+func Method(m string) Matcher {
+	return &matcher{
+		desc: "method " + m,
+		match: func(ex *Exchange) bool {
+			return strings.EqualFold(ex.Request.Method, m)
+		},
+	}
+}
+
+// Path matches requests whose URL path matches pattern.  In the pattern,
+// "*" matches any sequence of characters, including "/".
+func Path(pattern string) Matcher {
+	return &matcher{
+		desc: "path " + pattern,
+		match: func(ex *Exchange) bool {
+			return globMatch(pattern, ex.Request.URL.Path)
+		},
+	}
+}
+
+// Query matches requests with a query param named key, whose value matches pattern.
+// In the pattern, "*" matches any sequence of characters.
+func Query(key, pattern string) Matcher {
+	return &matcher{
+		desc: "query " + key + "=" + pattern,
+		match: func(ex *Exchange) bool {
+			return anyGlobMatch(pattern, ex.Request.URL.Query()[key])
+		},
+	}
+}
+
+// HeaderMatch matches requests with a header whose value matches pattern.  In the
+// pattern, "*" matches any sequence of characters:
+//
+//	HeaderMatch("Authorization", "Bearer *")
+func HeaderMatch(name, pattern string) Matcher {
+	return &matcher{
+		desc: "header " + http.CanonicalHeaderKey(name) + ": " + pattern,
+		match: func(ex *Exchange) bool {
+			return anyGlobMatch(pattern, ex.Request.Header.Values(name))
+		},
+	}
+}
+
+// JSONBody matches requests whose body is JSON equivalent to v, ignoring
+// formatting and the order of object keys.  v may be a string or []byte of JSON,
+// or a value to marshal.
+func JSONBody(v interface{}) Matcher {
+	expected, err := expectedJSON(v)
+	if err != nil {
+		panic(fmt.Sprintf("marshaling expected JSON body: %v", err))
+	}
+
+	var want interface{}
+	if err := json.Unmarshal(expected, &want); err != nil {
+		panic(fmt.Sprintf("expected JSON body isn't valid JSON: %v", err))
+	}
+
+	return &matcher{
+		desc: "JSON body " + string(expected),
+		match: func(ex *Exchange) bool {
+			if ex.RequestBody == nil {
+				return false
+			}
+			var got interface{}
+			if err := json.Unmarshal(ex.RequestBody.Bytes(), &got); err != nil {
+				return false
+			}
+			return reflect.DeepEqual(want, got)
+		},
+	}
+}
+
+// TestingT is the subset of *testing.T used by AssertExpectations.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+type expectation struct {
+	matchers []Matcher
+	matched  bool
+}
+
+func (e *expectation) String() string {
+	descs := make([]string, len(e.matchers))
+	for i, m := range e.matchers {
+		descs[i] = m.String()
+	}
+	return strings.Join(descs, ", ")
+}
+
+// Expect adds an expectation that the server receives a request matching all the
+// matchers:
+//
+//	i.Expect(httptestutil.Method("POST"), httptestutil.Path("/users"))
+//	// ... send requests
+//	i.AssertExpectations(t)
+//
+// Expectations are matched against requests received after Expect is called, whether or
+// not they're also read from the Exchanges channel.
+func (b *Inspector) Expect(matchers ...Matcher) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expectations = append(b.expectations, &expectation{matchers: matchers})
+}
+
+// AssertExpectations reports an error to t for each expectation which no request has
+// matched, and returns true if all expectations were met.
+func (b *Inspector) AssertExpectations(t TestingT) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ok := true
+	for _, e := range b.expectations {
+		if e.matched {
+			continue
+		}
+		ok = false
+		received := " none"
+		if len(b.received) > 0 {
+			received = "\n\t" + strings.Join(b.received, "\n\t")
+		}
+		t.Errorf("expected a request matching: %s\nreceived requests:%s", e, received)
+	}
+	return ok
+}
+
+// match records the exchange against the expectations.
+func (b *Inspector) match(ex *Exchange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.expectations) == 0 {
+		return
+	}
+	b.received = append(b.received, ex.Request.Method+" "+ex.Request.URL.RequestURI())
+
+	for _, e := range b.expectations {
+		if e.matched {
+			continue
+		}
+		e.matched = true
+		for _, m := range e.matchers {
+			if !m.Match(ex) {
+				e.matched = false
+				break
+			}
+		}
+	}
+}
+
+func anyGlobMatch(pattern string, values []string) bool {
+	for _, v := range values {
+		if globMatch(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch returns true if s matches pattern, in which "*" matches
+// any sequence of characters.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
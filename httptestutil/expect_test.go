@@ -0,0 +1,133 @@
+package httptestutil
+
+import (
+	"fmt"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeT struct {
+	errors []string
+}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestInspector_Expect(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201))
+	defer ts.Close()
+
+	i := Inspect(ts)
+	i.Expect(Method("POST"), Path("/users"), HeaderMatch("Authorization", "Bearer *"), JSONBody(`{"name":"bob","age":5}`))
+	i.Expect(Method("get"), Path("/users/*/orders"), Query("sort", "date*"))
+
+	_, _, err := Requester(ts).Receive(
+		requester.Post("/users"),
+		requester.BearerAuth("token"),
+		requester.Body(map[string]interface{}{"age": 5, "name": "bob"}),
+	)
+	require.NoError(t, err)
+	_, _, err = Requester(ts).Receive(requester.Get("/users/5/orders"), requester.QueryParam("sort", "date:desc"))
+	require.NoError(t, err)
+
+	assert.True(t, i.AssertExpectations(t))
+
+	// requests are still captured
+	assert.Len(t, i.Drain(), 2)
+}
+
+func TestInspector_AssertExpectations(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201))
+	defer ts.Close()
+
+	i := Inspect(ts)
+
+	// no expectations
+	var ft fakeT
+	assert.True(t, i.AssertExpectations(&ft))
+	assert.Empty(t, ft.errors)
+
+	i.Expect(Method("POST"), Path("/users"))
+	assert.False(t, i.AssertExpectations(&ft))
+	require.Len(t, ft.errors, 1)
+	assert.Equal(t, "expected a request matching: method POST, path /users\nreceived requests: none", ft.errors[0])
+
+	// matching some, but not all, matchers isn't enough
+	_, _, err := Requester(ts).Receive(requester.Get("/users"), requester.QueryParam("color", "red"))
+	require.NoError(t, err)
+
+	ft = fakeT{}
+	assert.False(t, i.AssertExpectations(&ft))
+	require.Len(t, ft.errors, 1)
+	assert.Equal(t, "expected a request matching: method POST, path /users\nreceived requests:\n\tGET /users?color=red", ft.errors[0])
+}
+
+func TestMatchers(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201))
+	defer ts.Close()
+	i := Inspect(ts)
+
+	_, _, err := Requester(ts).Receive(
+		requester.Put("/a/b/c"),
+		requester.QueryParam("q", "x"),
+		requester.Header("X-Color", "red"),
+		requester.AddHeader("X-Color", "blue"),
+		requester.Body(`[1, 2]`),
+	)
+	require.NoError(t, err)
+	ex := i.LastExchange()
+	require.NotNil(t, ex)
+
+	tests := []struct {
+		m        Matcher
+		expected bool
+	}{
+		{Method("PUT"), true},
+		{Method("POST"), false},
+		{Path("/a/b/c"), true},
+		{Path("/a/*"), true},
+		{Path("*/c"), true},
+		{Path("/a/*/d"), false},
+		{Path("/a"), false},
+		{Query("q", "x"), true},
+		{Query("q", "y"), false},
+		{Query("r", "*"), false},
+		{HeaderMatch("x-color", "blue"), true},
+		{HeaderMatch("X-Color", "r*"), true},
+		{HeaderMatch("X-Color", "green"), false},
+		{JSONBody([]int{1, 2}), true},
+		{JSONBody([]byte("[1,2]")), true},
+		{JSONBody("[2, 1]"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.m.String(), func(t *testing.T) {
+			assert.Equal(t, test.expected, test.m.Match(ex))
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*b*c", "abbc", true},
+		{"a*b*c", "acb", false},
+		{"ab*bc", "abc", false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, globMatch(test.pattern, test.s), "%q %q", test.pattern, test.s)
+	}
+}
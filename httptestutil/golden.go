@@ -0,0 +1,51 @@
+package httptestutil
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateGoldenEnv is the environment variable which makes AssertGolden write golden files,
+// rather than comparing against them.  Set it to any non-empty value:
+//
+//	UPDATE_GOLDEN=1 go test ./...
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// AssertGolden asserts that actual matches the contents of the golden file at path, like
+// testdata/users.json.  Files with a .json extension are compared as JSON, ignoring
+// formatting.  It's handy for asserting on large bodies, like an Exchange's ResponseBody,
+// without embedding them in test code.
+//
+// If the UPDATE_GOLDEN environment variable is set, the golden file is written with
+// actual instead, creating any missing directories.
+func AssertGolden(t TestingT, path string, actual []byte) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Errorf("creating golden file directory: %v", err)
+			return false
+		}
+		if err := ioutil.WriteFile(path, actual, 0666); err != nil {
+			t.Errorf("writing golden file: %v", err)
+			return false
+		}
+		return true
+	}
+
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("reading golden file: %v.  Set %s=1 to create it.", err, UpdateGoldenEnv)
+		return false
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return assert.JSONEq(t, string(expected), string(actual), "doesn't match golden file %s", path)
+	}
+	return assert.Equal(t, string(expected), string(actual), "doesn't match golden file %s", path)
+}
@@ -0,0 +1,48 @@
+package httptestutil
+
+import (
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssertGolden(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.json")
+	require.NoError(t, ioutil.WriteFile(fixture, []byte(`{"name": "bob", "age": 5}`), 0666))
+
+	// serve a fixture, and compare the response to a golden file
+	ts := httptest.NewServer(requester.MockHandler(200, requester.BodyFile(fixture)))
+	defer ts.Close()
+
+	_, body, err := Requester(ts).Receive(nil)
+	require.NoError(t, err)
+
+	golden := filepath.Join(dir, "golden", "users.json")
+
+	var ft fakeT
+	assert.False(t, AssertGolden(&ft, golden, body))
+	require.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], "UPDATE_GOLDEN=1")
+
+	t.Setenv(UpdateGoldenEnv, "1")
+	assert.True(t, AssertGolden(t, golden, body))
+	t.Setenv(UpdateGoldenEnv, "")
+
+	assert.True(t, AssertGolden(t, golden, []byte(`{"age":5,"name":"bob"}`)))
+
+	ft = fakeT{}
+	assert.False(t, AssertGolden(&ft, golden, []byte(`{"age":6,"name":"bob"}`)))
+	assert.Len(t, ft.errors, 1)
+
+	// other files are compared exactly
+	text := filepath.Join(dir, "golden.txt")
+	require.NoError(t, ioutil.WriteFile(text, []byte("hello"), 0666))
+	assert.True(t, AssertGolden(t, text, []byte("hello")))
+	ft = fakeT{}
+	assert.False(t, AssertGolden(&ft, text, []byte("hello ")))
+}
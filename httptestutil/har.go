@@ -0,0 +1,43 @@
+package httptestutil
+
+import (
+	"encoding/json"
+	"github.com/gemalto/requester"
+	"net/http"
+)
+
+// DrainHAR drains the captured exchanges, like Drain, and serializes them to HTTP Archive
+// (HAR) JSON, which browser dev tools and HAR-based replay tools can load.
+func (b *Inspector) DrainHAR() ([]byte, error) {
+	var entries []requester.HAREntry
+	for _, ex := range b.Drain() {
+		entries = append(entries, ex.HAREntry())
+	}
+	return json.MarshalIndent(requester.NewHAR(entries...), "", "  ")
+}
+
+// HAREntry converts the exchange to a HAR entry.
+func (e *Exchange) HAREntry() requester.HAREntry {
+	code := e.StatusCode
+	if code == 0 {
+		// the handler wrote nothing, so the server sent a 200
+		code = http.StatusOK
+	}
+	proto := ""
+	if e.Request != nil {
+		proto = e.Request.Proto
+	}
+	ex := requester.Exchange{
+		Request:     e.Request,
+		RequestBody: e.RequestBody,
+		Response: &http.Response{
+			StatusCode: code,
+			Proto:      proto,
+			Header:     e.Header,
+		},
+		ResponseBody: e.ResponseBody,
+		Time:         e.Time,
+		Duration:     e.Duration,
+	}
+	return ex.HAREntry()
+}
@@ -0,0 +1,52 @@
+package httptestutil
+
+import (
+	"encoding/json"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInspector_DrainHAR(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(202)
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	i := Inspect(ts)
+
+	_, _, err := Requester(ts).Receive(requester.Put("/ping?a=1"), requester.Body("ping"))
+	require.NoError(t, err)
+	_, _, err = Requester(ts).Receive(requester.Get("/empty"))
+	require.NoError(t, err)
+
+	b, err := i.DrainHAR()
+	require.NoError(t, err)
+
+	var har requester.HAR
+	require.NoError(t, json.Unmarshal(b, &har))
+	require.Len(t, har.Log.Entries, 2)
+
+	e := har.Log.Entries[0]
+	assert.NotZero(t, e.StartedDateTime)
+	assert.Equal(t, "PUT", e.Request.Method)
+	assert.Equal(t, ts.URL+"/ping?a=1", e.Request.URL)
+	assert.Equal(t, "HTTP/1.1", e.Request.HTTPVersion)
+	require.NotNil(t, e.Request.PostData)
+	assert.Equal(t, "ping", e.Request.PostData.Text)
+	assert.Equal(t, 202, e.Response.Status)
+	assert.Equal(t, requester.HARContent{Size: 4, MimeType: "text/plain", Text: "pong"}, e.Response.Content)
+
+	assert.Equal(t, 200, har.Log.Entries[1].Response.Status)
+
+	// the exchanges were drained
+	assert.Nil(t, i.NextExchange())
+}
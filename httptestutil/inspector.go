@@ -6,6 +6,8 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // Exchange is a snapshot of one request/response exchange with
@@ -17,6 +19,11 @@ type Exchange struct {
 	StatusCode   int
 	Header       http.Header
 	ResponseBody *bytes.Buffer
+
+	// Time is when the request was received.
+	Time time.Time
+	// Duration is how long the handler took.
+	Duration time.Duration
 }
 
 // Inspector is server-side middleware which captures server exchanges in a buffer.
@@ -25,8 +32,26 @@ type Exchange struct {
 //
 // Exchanges can be received directly from the channel, or you can use the NextExchange()
 // and LastExchange() convenience methods.
+//
+// Requests can also be asserted on declaratively, with Expect() and AssertExpectations().
 type Inspector struct {
 	Exchanges chan Exchange
+
+	// Filter, if set, selects the exchanges to capture.  Exchanges for which it returns
+	// false aren't captured, or matched against expectations.
+	Filter func(r *http.Request) bool
+
+	// MaxBodySize, if greater than zero, caps the size of the captured request and
+	// response bodies, in bytes.  Only the start of longer bodies is captured, and only
+	// that much of the request body is buffered.
+	MaxBodySize int64
+
+	installation *Installation
+
+	mu           sync.Mutex
+	expectations []*expectation
+	// received describes the requests received since the first expectation
+	received []string
 }
 
 // NewInspector creates a new Inspector with the requested channel buffer size.  If 0,
@@ -89,6 +114,15 @@ func (b *Inspector) Clear() {
 	b.LastExchange()
 }
 
+// Uninstall removes the inspector from the test server it was installed in by Inspect.
+// See Installation.Uninstall.  Exchanges already captured are kept.
+func (b *Inspector) Uninstall() {
+	if b == nil {
+		return
+	}
+	b.installation.Uninstall()
+}
+
 // Wrap installs the inspector in an HTTP server by wrapping
 // the server's Handler.
 func (b *Inspector) Wrap(next http.Handler) http.Handler {
@@ -99,25 +133,43 @@ func (b *Inspector) Wrap(next http.Handler) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ex := Exchange{}
+		if b.Filter != nil && !b.Filter(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ex := Exchange{Time: time.Now()}
 		ex.Request = r
 		if r.Body != nil && r.Body != http.NoBody {
 			ex.RequestBody = &bytes.Buffer{}
-			if _, err := ex.RequestBody.ReadFrom(r.Body); err != nil {
-				panic(err)
-			}
-			if err := r.Body.Close(); err != nil {
-				panic(err)
-			}
+			if b.MaxBodySize > 0 {
+				if _, err := ex.RequestBody.ReadFrom(io.LimitReader(r.Body, b.MaxBodySize)); err != nil {
+					panic(err)
+				}
+				r.Body = &struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(ex.RequestBody.Bytes()), r.Body), r.Body}
+			} else {
+				if _, err := ex.RequestBody.ReadFrom(r.Body); err != nil {
+					panic(err)
+				}
+				if err := r.Body.Close(); err != nil {
+					panic(err)
+				}
 
-			r.Body = ioutil.NopCloser(bytes.NewReader(ex.RequestBody.Bytes()))
+				r.Body = ioutil.NopCloser(bytes.NewReader(ex.RequestBody.Bytes()))
+			}
 		} else {
 			ex.RequestBody = nil
 		}
 
-		w = httpsnoop.Wrap(w, hooks(&ex))
+		w = httpsnoop.Wrap(w, hooks(&ex, b.MaxBodySize))
 
 		next.ServeHTTP(w, r)
+		ex.Duration = time.Since(ex.Time)
+
+		b.match(&ex)
 
 		select {
 		case b.Exchanges <- ex:
@@ -127,14 +179,24 @@ func (b *Inspector) Wrap(next http.Handler) http.Handler {
 	})
 }
 
-func hooks(ex *Exchange) httpsnoop.Hooks {
+// hooks captures the response in ex.  If maxBody is greater than zero, only
+// that much of the response body is captured.
+func hooks(ex *Exchange, maxBody int64) httpsnoop.Hooks {
 	if ex.ResponseBody == nil {
 		ex.ResponseBody = &bytes.Buffer{}
 	}
+	capture := func(b []byte) {
+		if maxBody > 0 {
+			if room := maxBody - int64(ex.ResponseBody.Len()); int64(len(b)) > room {
+				b = b[:room]
+			}
+		}
+		ex.ResponseBody.Write(b)
+	}
 	return httpsnoop.Hooks{
 		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
 			return func(b []byte) (int, error) {
-				ex.ResponseBody.Write(b)
+				capture(b)
 				return next(b)
 			}
 		},
@@ -152,6 +214,13 @@ func hooks(ex *Exchange) httpsnoop.Hooks {
 		},
 		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
 			return func(src io.Reader) (int64, error) {
+				if maxBody > 0 {
+					// don't buffer the whole source
+					return next(io.TeeReader(src, writerFunc(func(b []byte) (int, error) {
+						capture(b)
+						return len(b), nil
+					})))
+				}
 				l := ex.ResponseBody.Len()
 				n, err := ex.ResponseBody.ReadFrom(src)
 				if err != nil {
@@ -162,3 +231,9 @@ func hooks(ex *Exchange) httpsnoop.Hooks {
 		},
 	}
 }
+
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
@@ -264,3 +264,54 @@ func ExampleInspector_LastExchange() {
 	// ping2
 	// <nil>
 }
+
+func TestInspector_Filter(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201, requester.Body("pong")))
+	defer ts.Close()
+
+	is := Inspect(ts)
+	is.Filter = func(r *http.Request) bool {
+		return r.URL.Path != "/health"
+	}
+
+	_, _, err := Requester(ts).Receive(requester.Get("/health"))
+	require.NoError(t, err)
+	_, _, err = Requester(ts).Receive(requester.Get("/users"))
+	require.NoError(t, err)
+
+	exs := is.Drain()
+	require.Len(t, exs, 1)
+	assert.Equal(t, "/users", exs[0].Request.URL.Path)
+}
+
+func TestInspector_MaxBodySize(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+		if r.URL.Path == "/readfrom" {
+			_, _ = io.Copy(w, strings.NewReader("0123456789"))
+			return
+		}
+		_, _ = w.Write([]byte("01234"))
+		_, _ = w.Write([]byte("56789"))
+	}))
+	defer ts.Close()
+
+	is := Inspect(ts)
+	is.MaxBodySize = 4
+
+	for _, path := range []string{"/write", "/readfrom"} {
+		t.Run(path, func(t *testing.T) {
+			_, body, err := Requester(ts).Receive(requester.Post(path), requester.Body("abcdefgh"))
+			require.NoError(t, err)
+			assert.Equal(t, "abcdefgh", received)
+			assert.Equal(t, "0123456789", string(body))
+
+			ex := is.LastExchange()
+			require.NotNil(t, ex)
+			assert.Equal(t, "abcd", ex.RequestBody.String())
+			assert.Equal(t, "0123", ex.ResponseBody.String())
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package httptestutil
+
+import (
+	"github.com/felixge/httpsnoop"
+	"github.com/gemalto/requester"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sides of an exchange recorded in a Journal.
+const (
+	ClientSide = "client"
+	ServerSide = "server"
+)
+
+// JournalEntry is a request recorded in a Journal.
+type JournalEntry struct {
+	// Seq is the entry's position in the journal, starting at 1.  Entries are numbered in
+	// the order the requests started.
+	Seq int
+	// Time is when the request started.
+	Time time.Time
+	// Side is ClientSide or ServerSide.
+	Side   string
+	Method string
+	Path   string
+	// StatusCode is the response's status code, or 0 if there was no response, or the
+	// exchange is still in progress.
+	StatusCode int
+	// Duration is how long the exchange took, or 0 if it's still in progress.
+	Duration time.Duration
+}
+
+// Journal records the requests to a server, or from a client, in order, for verifying
+// multi-call protocol flows.  Install it in servers with Record or Wrap, and in
+// Requesters with Middleware.  A Journal can be installed in both the client and server:
+// each exchange is then recorded once per side.
+type Journal struct {
+	installation *Installation
+
+	mu      sync.Mutex
+	entries []*JournalEntry
+}
+
+// Record installs and returns a Journal, which records the requests to the test server.
+// Like Inspect, it should be called after the real Handler has been installed.
+func Record(ts *httptest.Server) *Journal {
+	j := &Journal{}
+	j.installation = install(ts, j.Wrap)
+	return j
+}
+
+// Uninstall removes the journal from the test server it was installed in by Record.  See
+// Installation.Uninstall.  Entries already recorded are kept.
+func (j *Journal) Uninstall() {
+	j.installation.Uninstall()
+}
+
+// Wrap installs the journal in an HTTP server by wrapping the server's Handler.
+func (j *Journal) Wrap(next http.Handler) http.Handler {
+	// use the same default as http.Server
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := j.start(ServerSide, r)
+		m := httpsnoop.CaptureMetrics(next, w, r)
+		j.finish(e, m.Code)
+	})
+}
+
+// Middleware returns requester Middleware, which records the requests sent by a Requester.
+func (j *Journal) Middleware() requester.Middleware {
+	return func(next requester.Doer) requester.Doer {
+		return requester.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			e := j.start(ClientSide, req)
+			resp, err := next.Do(req)
+			code := 0
+			if resp != nil {
+				code = resp.StatusCode
+			}
+			j.finish(e, code)
+			return resp, err
+		})
+	}
+}
+
+func (j *Journal) start(side string, r *http.Request) *JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := &JournalEntry{
+		Seq:    len(j.entries) + 1,
+		Time:   time.Now(),
+		Side:   side,
+		Method: r.Method,
+		Path:   r.URL.Path,
+	}
+	j.entries = append(j.entries, e)
+	return e
+}
+
+func (j *Journal) finish(e *JournalEntry, statusCode int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.StatusCode = statusCode
+	e.Duration = time.Since(e.Time)
+}
+
+// Entries returns a copy of the entries recorded so far, in order.
+func (j *Journal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, len(j.entries))
+	for i, e := range j.entries {
+		entries[i] = *e
+	}
+	return entries
+}
+
+// Clear removes all entries.
+func (j *Journal) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = nil
+}
+
+// AssertOrder asserts that requests with the paths were recorded in the order given.  Other
+// requests may come before, after, or in between.  In the paths, "*" matches any sequence
+// of characters.  If the journal recorded both sides, the order is asserted on each side.
+//
+//	j.AssertOrder(t, "/login", "/token", "/data")
+func (j *Journal) AssertOrder(t TestingT, paths ...string) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	bySide := map[string][]JournalEntry{}
+	var sides []string
+	for _, e := range j.Entries() {
+		if _, ok := bySide[e.Side]; !ok {
+			sides = append(sides, e.Side)
+		}
+		bySide[e.Side] = append(bySide[e.Side], e)
+	}
+	if len(sides) == 0 {
+		// assert against an empty journal, so the failure is reported
+		sides = []string{ServerSide}
+	}
+
+	ok := true
+	for _, side := range sides {
+		entries := bySide[side]
+		next := 0
+		for _, e := range entries {
+			if next < len(paths) && globMatch(paths[next], e.Path) {
+				next++
+			}
+		}
+		if next == len(paths) {
+			continue
+		}
+
+		ok = false
+		received := make([]string, len(entries))
+		for i, e := range entries {
+			received[i] = e.Method + " " + e.Path
+		}
+		t.Errorf("expected %s requests in order: %s\nmissing or out of order: %s\nreceived: %s",
+			side, strings.Join(paths, ", "), paths[next], strings.Join(received, ", "))
+	}
+	return ok
+}
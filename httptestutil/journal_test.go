@@ -0,0 +1,50 @@
+package httptestutil
+
+import (
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(201))
+	defer ts.Close()
+
+	j := Record(ts)
+	r := Requester(ts, j.Middleware())
+
+	for _, path := range []string{"/login", "/health", "/token", "/data/1"} {
+		_, _, err := r.Receive(requester.Get(path))
+		require.NoError(t, err)
+	}
+
+	entries := j.Entries()
+	require.Len(t, entries, 8)
+	for i, e := range entries {
+		assert.Equal(t, i+1, e.Seq)
+		assert.Equal(t, "GET", e.Method)
+		assert.Equal(t, 201, e.StatusCode)
+		assert.NotZero(t, e.Time)
+	}
+	// client request starts before the server's
+	assert.Equal(t, ClientSide, entries[0].Side)
+	assert.Equal(t, ServerSide, entries[1].Side)
+	assert.Equal(t, "/login", entries[1].Path)
+
+	assert.True(t, j.AssertOrder(t, "/login", "/token", "/data/*"))
+
+	var ft fakeT
+	assert.False(t, j.AssertOrder(&ft, "/token", "/login"))
+	require.Len(t, ft.errors, 2)
+	assert.Equal(t, "expected client requests in order: /token, /login\nmissing or out of order: /login\n"+
+		"received: GET /login, GET /health, GET /token, GET /data/1", ft.errors[0])
+
+	j.Clear()
+	assert.Empty(t, j.Entries())
+
+	ft = fakeT{}
+	assert.False(t, j.AssertOrder(&ft, "/login"))
+	assert.Len(t, ft.errors, 1)
+}
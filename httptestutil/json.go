@@ -0,0 +1,58 @@
+package httptestutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ansel1/merry"
+	"github.com/stretchr/testify/assert"
+)
+
+// JSONEq asserts that a captured body, like an Exchange's RequestBody, is JSON equivalent
+// to expected, ignoring formatting and the order of object keys.  expected may be a
+// string or []byte of JSON, or a value to marshal.
+//
+//	httptestutil.JSONEq(t, `{"color":"red"}`, ex.RequestBody)
+//	httptestutil.JSONEq(t, Widget{Color: "red"}, ex.ResponseBody)
+func JSONEq(t TestingT, expected interface{}, actual *bytes.Buffer, msgAndArgs ...interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	b, err := expectedJSON(expected)
+	if err != nil {
+		return assert.Fail(t, "marshaling expected JSON: "+err.Error(), msgAndArgs...)
+	}
+	if actual == nil {
+		return assert.Fail(t, "no body was captured", msgAndArgs...)
+	}
+	return assert.JSONEq(t, string(b), actual.String(), msgAndArgs...)
+}
+
+// BindRequestJSON unmarshals the captured request body into v.
+func (e *Exchange) BindRequestJSON(v interface{}) error {
+	return bindJSON(e.RequestBody, v)
+}
+
+// BindResponseJSON unmarshals the captured response body into v.
+func (e *Exchange) BindResponseJSON(v interface{}) error {
+	return bindJSON(e.ResponseBody, v)
+}
+
+func bindJSON(body *bytes.Buffer, v interface{}) error {
+	if body == nil {
+		return merry.New("no body was captured")
+	}
+	return merry.Prepend(json.Unmarshal(body.Bytes(), v), "unmarshaling captured body")
+}
+
+// expectedJSON returns v as JSON.  v may be a string or []byte of JSON, or a value to marshal.
+func expectedJSON(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	default:
+		return json.Marshal(v)
+	}
+}
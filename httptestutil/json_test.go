@@ -0,0 +1,58 @@
+package httptestutil
+
+import (
+	"bytes"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONEq(t *testing.T) {
+	body := bytes.NewBufferString(`{"color":"red", "size": 2}`)
+
+	assert.True(t, JSONEq(t, `{"size":2,"color":"red"}`, body))
+	assert.True(t, JSONEq(t, []byte(`{"size":2,"color":"red"}`), body))
+	assert.True(t, JSONEq(t, map[string]interface{}{"color": "red", "size": 2}, body))
+
+	var ft fakeT
+	assert.False(t, JSONEq(&ft, `{"color":"blue"}`, body))
+	assert.Len(t, ft.errors, 1)
+
+	ft = fakeT{}
+	assert.False(t, JSONEq(&ft, `{}`, nil))
+	require.Len(t, ft.errors, 1)
+	assert.Contains(t, ft.errors[0], "no body was captured")
+}
+
+func TestExchange_BindJSON(t *testing.T) {
+	ts := httptest.NewServer(requester.MockHandler(200, requester.JSON(false), requester.Body(map[string]int{"id": 5})))
+	defer ts.Close()
+	i := Inspect(ts)
+
+	type widget struct {
+		Color string `json:"color"`
+		ID    int    `json:"id"`
+	}
+
+	_, _, err := Requester(ts).Receive(requester.Post("/widgets"), requester.Body(widget{Color: "red"}))
+	require.NoError(t, err)
+
+	ex := i.LastExchange()
+	require.NotNil(t, ex)
+
+	var w widget
+	require.NoError(t, ex.BindRequestJSON(&w))
+	assert.Equal(t, widget{Color: "red"}, w)
+
+	w = widget{}
+	require.NoError(t, ex.BindResponseJSON(&w))
+	assert.Equal(t, widget{ID: 5}, w)
+
+	ex.RequestBody = bytes.NewBufferString("not json")
+	assert.Error(t, ex.BindRequestJSON(&w))
+
+	ex.RequestBody = nil
+	assert.EqualError(t, ex.BindRequestJSON(&w), "no body was captured")
+}
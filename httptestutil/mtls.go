@@ -0,0 +1,131 @@
+package httptestutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/ansel1/merry"
+	"github.com/gemalto/requester"
+	"github.com/gemalto/requester/httpclient"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// CertAuthority is a throwaway certificate authority, for issuing certificates in tests.
+type CertAuthority struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// Pool contains Cert.  Use it as the server's ClientCAs, or the client's RootCAs.
+	Pool *x509.CertPool
+}
+
+// NewCertAuthority creates a CertAuthority with a new, self-signed certificate.
+func NewCertAuthority() (*CertAuthority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, merry.Prepend(err, "generating CA key")
+	}
+
+	tmpl := certTemplate("Test CA")
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, merry.Prepend(err, "creating CA certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, merry.Prepend(err, "parsing CA certificate")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &CertAuthority{Cert: cert, Key: key, Pool: pool}, nil
+}
+
+// IssueCert issues a certificate, which can be used by clients and servers.  hosts are the
+// DNS names and IP addresses the certificate is valid for, when used by a server.
+func (ca *CertAuthority) IssueCert(commonName string, hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, merry.Prepend(err, "generating key")
+	}
+
+	tmpl := certTemplate(commonName)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return tls.Certificate{}, merry.Prepend(err, "creating certificate")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, merry.Prepend(err, "parsing certificate")
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func certTemplate(commonName string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+}
+
+// NewMutualTLSServer starts a TLS test server which requires clients to present a
+// certificate issued by one of clientCAs.  If serverCert is nil, the server uses the
+// default httptest certificate.
+//
+// Use MutualTLSRequester to send requests to it.  The handler can inspect the client's
+// certificate in the request's TLS.PeerCertificates.
+func NewMutualTLSServer(handler http.Handler, serverCert *tls.Certificate, clientCAs *x509.CertPool) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	if serverCert != nil {
+		ts.TLS.Certificates = []tls.Certificate{*serverCert}
+	}
+	ts.StartTLS()
+	return ts
+}
+
+// MutualTLSRequester creates a Requester which sends requests to a TLS test server, like
+// one started by NewMutualTLSServer, presenting clientCert.  The Requester is configured
+// with the server's base URL, and trusts the server's certificate.  Its http.Client is
+// built with the httpclient options, so it exercises the same code paths as
+// production clients configured with httpclient.ClientCertFromTLS.
+func MutualTLSRequester(ts *httptest.Server, clientCert tls.Certificate, options ...requester.Option) *requester.Requester {
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+
+	r := requester.MustNew(
+		requester.URL(ts.URL),
+		requester.Client(
+			httpclient.RootCAs(roots),
+			httpclient.ClientCertFromTLS(clientCert),
+		),
+	)
+	r.MustApply(options...)
+	return r
+}
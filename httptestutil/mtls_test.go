@@ -0,0 +1,59 @@
+package httptestutil
+
+import (
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func TestNewMutualTLSServer(t *testing.T) {
+	ca, err := NewCertAuthority()
+	require.NoError(t, err)
+
+	clientCert, err := ca.IssueCert("client")
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	})
+
+	t.Run("default server cert", func(t *testing.T) {
+		ts := NewMutualTLSServer(handler, nil, ca.Pool)
+		defer ts.Close()
+
+		_, body, err := MutualTLSRequester(ts, clientCert).Receive(requester.Get("/"))
+		require.NoError(t, err)
+		assert.Equal(t, "client", string(body))
+	})
+
+	t.Run("issued server cert", func(t *testing.T) {
+		serverCert, err := ca.IssueCert("server", "127.0.0.1", "localhost")
+		require.NoError(t, err)
+
+		ts := NewMutualTLSServer(handler, &serverCert, ca.Pool)
+		defer ts.Close()
+
+		_, body, err := MutualTLSRequester(ts, clientCert).Receive(requester.Get("/"))
+		require.NoError(t, err)
+		assert.Equal(t, "client", string(body))
+	})
+
+	t.Run("untrusted client cert", func(t *testing.T) {
+		other, err := NewCertAuthority()
+		require.NoError(t, err)
+		otherCert, err := other.IssueCert("intruder")
+		require.NoError(t, err)
+
+		ts := NewMutualTLSServer(handler, nil, ca.Pool)
+		defer ts.Close()
+
+		_, _, err = MutualTLSRequester(ts, otherCert).Receive(requester.Get("/"))
+		require.Error(t, err)
+
+		// no client cert
+		_, _, err = Requester(ts).Receive(requester.Get("/"))
+		require.Error(t, err)
+	})
+}
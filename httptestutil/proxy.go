@@ -0,0 +1,56 @@
+package httptestutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+)
+
+// NewProxyServer starts an httptest.Server which reverse proxies all requests to
+// the target URL.  Since the result is still an *httptest.Server, Inspect(), Dump(),
+// and Requester() can be used with it exactly as with a server backed by a local
+// handler: exchanges with the real service are recorded as they pass through the proxy.
+//
+// The caller should call Close when finished, to shut it down.
+func NewProxyServer(target *url.URL) *httptest.Server {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	// set the Host header to the target, so services which
+	// route on virtual hosts still work
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+	}
+
+	return httptest.NewServer(proxy)
+}
+
+// NewServerFromEnv starts an httptest.Server for use in tests which should run either
+// against a local, mock handler, or against a real, external service (e.g. a service
+// running in a docker container).
+//
+// If the environment variable named by envVar is set to a base URL, the server is a
+// reverse proxy to that URL (see NewProxyServer).  Otherwise, the server is a plain
+// httptest.Server serving handler.  Either way, the same test code can be used to send
+// requests to the server and inspect the traffic:
+//
+//	ts := httptestutil.NewServerFromEnv("USERS_API_URL", mockUsersHandler)
+//	defer ts.Close()
+//
+//	is := httptestutil.Inspect(ts)
+//	resp, body, err := httptestutil.Requester(ts).Receive(requester.Get("/users"))
+//
+// Panics if the environment variable is not a valid URL.
+func NewServerFromEnv(envVar string, handler http.Handler) *httptest.Server {
+	if s := os.Getenv(envVar); s != "" {
+		u, err := url.Parse(s)
+		if err != nil {
+			panic("httptestutil: invalid URL in " + envVar + ": " + err.Error())
+		}
+		return NewProxyServer(u)
+	}
+	return httptest.NewServer(handler)
+}
@@ -0,0 +1,71 @@
+package httptestutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyServer(t *testing.T) {
+	var receivedHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHost = r.Host
+		w.WriteHeader(201)
+		_, _ = w.Write([]byte("pong " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	ts := NewProxyServer(u)
+	defer ts.Close()
+
+	is := Inspect(ts)
+
+	resp, body, err := Requester(ts).Receive(requester.Get("/test"), requester.Body("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "pong /test", string(body))
+	assert.Equal(t, u.Host, receivedHost)
+
+	ex := is.LastExchange()
+	require.NotNil(t, ex)
+	assert.Equal(t, "ping", ex.RequestBody.String())
+	assert.Equal(t, 201, ex.StatusCode)
+	assert.Equal(t, "pong /test", ex.ResponseBody.String())
+}
+
+func TestNewServerFromEnv(t *testing.T) {
+	const envVar = "HTTPTESTUTIL_TEST_BASE_URL"
+
+	backend := httptest.NewServer(requester.MockHandler(200, requester.Body("real")))
+	defer backend.Close()
+
+	mock := requester.MockHandler(200, requester.Body("mock"))
+
+	t.Setenv(envVar, "")
+	ts := NewServerFromEnv(envVar, mock)
+	_, body, err := Requester(ts).Receive(nil)
+	ts.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "mock", string(body))
+
+	t.Setenv(envVar, backend.URL)
+
+	ts = NewServerFromEnv(envVar, mock)
+	_, body, err = Requester(ts).Receive(nil)
+	ts.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "real", string(body))
+
+	t.Setenv(envVar, "://bad")
+	assert.Panics(t, func() {
+		NewServerFromEnv(envVar, mock)
+	})
+}
@@ -0,0 +1,81 @@
+package httptestutil
+
+import (
+	"context"
+	"github.com/ansel1/merry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+)
+
+// RestartableServer is an httptest.Server which can be shut down, and restarted on the same
+// address, to simulate outages in tests of clients' reconnect and retry logic.
+//
+// Restart replaces the embedded Server, so don't hold on to it: use the RestartableServer.
+// The new Server keeps the old one's Handler, so wrappers installed with Inspect, Dump, or
+// Record keep working after a restart, and can still be uninstalled.
+type RestartableServer struct {
+	*httptest.Server
+	tls     bool
+	running bool
+}
+
+// NewRestartableServer starts and returns a new RestartableServer.
+func NewRestartableServer(handler http.Handler) *RestartableServer {
+	return &RestartableServer{Server: httptest.NewServer(handler), running: true}
+}
+
+// NewRestartableTLSServer starts and returns a new RestartableServer using TLS.  It keeps the
+// same certificate when it's restarted, so clients from Client() keep working.
+func NewRestartableTLSServer(handler http.Handler) *RestartableServer {
+	return &RestartableServer{Server: httptest.NewTLSServer(handler), tls: true, running: true}
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting connections, and waits for
+// requests in progress to finish, or for ctx to be done.  Connections still open then are
+// closed.  Clients see connection errors until the server is restarted.
+func (s *RestartableServer) Shutdown(ctx context.Context) error {
+	if !s.running {
+		return nil
+	}
+	s.running = false
+	err := s.Config.Shutdown(ctx)
+	s.CloseClientConnections()
+	s.Server.Close()
+	return err
+}
+
+// Close shuts down the server abruptly, closing all connections.
+func (s *RestartableServer) Close() {
+	if !s.running {
+		return
+	}
+	s.running = false
+	s.CloseClientConnections()
+	s.Server.Close()
+}
+
+// Restart starts the server again, on the same address, after it was shut down.  If it's
+// running, it's closed first.
+func (s *RestartableServer) Restart() error {
+	s.Close()
+
+	l, err := net.Listen("tcp", s.Listener.Addr().String())
+	if err != nil {
+		return merry.Prepend(err, "listening on the server's address")
+	}
+
+	ts := &httptest.Server{
+		Listener: l,
+		Config:   &http.Server{Handler: s.Config.Handler},
+	}
+	if s.tls {
+		ts.TLS = s.Server.TLS
+		ts.StartTLS()
+	} else {
+		ts.Start()
+	}
+	s.Server = ts
+	s.running = true
+	return nil
+}
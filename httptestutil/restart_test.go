@@ -0,0 +1,86 @@
+package httptestutil
+
+import (
+	"context"
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRestartableServer(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		t.Run(map[bool]string{false: "http", true: "tls"}[useTLS], func(t *testing.T) {
+			handler := requester.MockHandler(200, requester.Body("up"))
+			var s *RestartableServer
+			if useTLS {
+				s = NewRestartableTLSServer(handler)
+			} else {
+				s = NewRestartableServer(handler)
+			}
+			defer s.Close()
+
+			url := s.URL
+			r := requester.MustNew(requester.URL(url), requester.WithDoer(s.Client()))
+
+			_, body, err := r.Receive(nil)
+			require.NoError(t, err)
+			assert.Equal(t, "up", string(body))
+
+			require.NoError(t, s.Shutdown(context.Background()))
+			_, _, err = r.Receive(nil)
+			require.Error(t, err)
+
+			// the client keeps working after the restart
+			require.NoError(t, s.Restart())
+			assert.Equal(t, url, s.URL)
+			_, body, err = r.Receive(nil)
+			require.NoError(t, err)
+			assert.Equal(t, "up", string(body))
+
+			// restart while running
+			require.NoError(t, s.Restart())
+			_, _, err = r.Receive(nil)
+			require.NoError(t, err)
+
+			// installed wrappers survive restarts
+			i := Inspect(s.Server)
+			require.NoError(t, s.Restart())
+			_, _, err = r.Receive(nil)
+			require.NoError(t, err)
+			assert.NotNil(t, i.LastExchange())
+
+			i.Uninstall()
+			require.NoError(t, s.Restart())
+			_, _, err = r.Receive(nil)
+			require.NoError(t, err)
+			assert.Nil(t, i.LastExchange())
+		})
+	}
+}
+
+func TestRestartableServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	s := NewRestartableServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer s.Close()
+
+	errs := make(chan error, 1)
+	bodies := make(chan string, 1)
+	go func() {
+		_, body, err := requester.Receive(requester.Get(s.URL))
+		errs <- err
+		bodies <- string(body)
+	}()
+
+	<-started
+	// waits for the request in progress
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.NoError(t, <-errs)
+	assert.Equal(t, "done", <-bodies)
+}
@@ -6,7 +6,9 @@ package httptestutil
 
 import (
 	"github.com/gemalto/requester"
+	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 )
 
 // Requester creates a Requester instance which is pre-configured to send requests to
@@ -23,11 +25,69 @@ func Requester(ts *httptest.Server, options ...requester.Option) *requester.Requ
 // and the outgoing responses and response bodies.
 //
 // Inspect wraps and replaces the server's Handler.  It should be called after the real
-// Handler has been installed.
+// Handler has been installed.  Call the Inspector's Uninstall method to remove it again.
 func Inspect(ts *httptest.Server) *Inspector {
 
 	i := NewInspector(0)
-	ts.Config.Handler = i.Wrap(ts.Config.Handler)
+	i.installation = install(ts, i.Wrap)
 
 	return i
 }
+
+// Installation is a handle to a handler wrapper installed in a test server, by functions
+// like Dump.  It lets a long-lived test server enable and disable the wrapper, per subtest
+// for example:
+//
+//	t.Run("sub", func(t *testing.T) {
+//	    defer httptestutil.Dump(ts, os.Stdout).Uninstall()
+//	    ...
+//	})
+type Installation struct {
+	ts      *httptest.Server
+	prev    http.Handler
+	handler *installedHandler
+}
+
+type installedHandler struct {
+	next, wrapped http.Handler
+	removed       int32
+}
+
+func (h *installedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.removed) == 1 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	h.wrapped.ServeHTTP(w, r)
+}
+
+// install wraps and replaces the server's Handler.
+func install(ts *httptest.Server, wrap func(http.Handler) http.Handler) *Installation {
+	next := ts.Config.Handler
+	// use the same default as http.Server
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	in := &Installation{
+		ts:      ts,
+		prev:    ts.Config.Handler,
+		handler: &installedHandler{next: next, wrapped: wrap(next)},
+	}
+	ts.Config.Handler = in.handler
+	return in
+}
+
+// Uninstall removes the wrapper from the server.  If nothing else has been installed since,
+// the server's previous Handler is restored.  Otherwise, the wrapper is disabled, and just
+// passes requests through.  It's safe to call more than once.
+//
+// Like installing, uninstalling shouldn't be done while the server is handling requests.
+func (in *Installation) Uninstall() {
+	if in == nil {
+		return
+	}
+	atomic.StoreInt32(&in.handler.removed, 1)
+	if h, ok := in.ts.Config.Handler.(*installedHandler); ok && h == in.handler {
+		in.ts.Config.Handler = in.prev
+	}
+}
@@ -0,0 +1,140 @@
+package httptestutil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/gemalto/requester"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WebSocket opcodes.  See RFC 6455.
+const (
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+// WebSocketEchoHandler returns a handler which accepts WebSocket handshakes, and echoes back
+// each frame the client sends, unmasked.  Pings are answered with pongs, and close frames
+// are echoed before the connection is closed.  Requests which aren't WebSocket handshakes
+// get a 400.
+//
+// It's meant for testing clients which drive WebSocket endpoints, like Requester.WebSocket.
+func WebSocketEchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+			http.Error(w, "not a websocket handshake", http.StatusBadRequest)
+			return
+		}
+
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "connection can't be hijacked", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + requester.WebSocketAccept(key) + "\r\n\r\n")
+		if err := rw.Flush(); err != nil {
+			return
+		}
+
+		for {
+			fin, op, payload, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			if op == wsOpPing {
+				op = wsOpPong
+			}
+			if err := writeFrame(rw.Writer, fin, op, payload); err != nil {
+				return
+			}
+			if op == wsOpClose {
+				return
+			}
+		}
+	})
+}
+
+// readFrame reads a single frame from a client, and unmasks its payload.
+func readFrame(r *bufio.Reader) (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	fin = hdr[0]&0x80 != 0
+	op = hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// writeFrame writes a single unmasked frame, as servers send them.
+func writeFrame(w *bufio.Writer, fin bool, op byte, payload []byte) error {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	hdr := []byte{b0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n <= 0xFFFF:
+		hdr = append(hdr, 126, 0, 0)
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr = append(hdr, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,79 @@
+package httptestutil
+
+import (
+	"github.com/gemalto/requester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// maskedFrame builds a final client frame, masked as clients must.
+func maskedFrame(op byte, payload string) []byte {
+	mask := []byte{1, 2, 3, 4}
+	b := append([]byte{0x80 | op, 0x80 | byte(len(payload))}, mask...)
+	for i := 0; i < len(payload); i++ {
+		b = append(b, payload[i]^mask[i%4])
+	}
+	return b
+}
+
+func TestWebSocketEchoHandler(t *testing.T) {
+	ts := httptest.NewServer(WebSocketEchoHandler())
+	defer ts.Close()
+
+	resp, conn, err := Requester(ts).WebSocket(requester.Get("/echo"))
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, 101, resp.StatusCode)
+
+	_, err = conn.Write(maskedFrame(0x1, "hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 7)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x81, 5}, "hello"...), buf)
+
+	// ping gets a pong
+	_, err = conn.Write(maskedFrame(0x9, "hi"))
+	require.NoError(t, err)
+	buf = make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x8A, 2}, "hi"...), buf)
+
+	// close is echoed, then the connection is closed
+	_, err = conn.Write(maskedFrame(0x8, ""))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x88, 0}, rest)
+}
+
+func TestWebSocketEchoHandler_tls(t *testing.T) {
+	ts := httptest.NewTLSServer(WebSocketEchoHandler())
+	defer ts.Close()
+
+	wsURL := "wss" + strings.TrimPrefix(ts.URL, "https")
+	_, conn, err := Requester(ts, requester.URL(wsURL)).WebSocket()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(maskedFrame(0x2, "abc"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, append([]byte{0x82, 3}, "abc"...), buf)
+}
+
+func TestWebSocketEchoHandler_notHandshake(t *testing.T) {
+	ts := httptest.NewServer(WebSocketEchoHandler())
+	defer ts.Close()
+
+	resp, _, err := Requester(ts).Receive(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 400, resp.StatusCode)
+}
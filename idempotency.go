@@ -0,0 +1,125 @@
+package requester
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
+
+// HeaderIdempotencyKey is the header defined by the IETF Idempotency-Key draft.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// IdempotencyConfig defines settings for the Idempotency middleware.
+type IdempotencyConfig struct {
+	// Header is the name of the header which carries the key.
+	// Defaults to Idempotency-Key.
+	Header string
+	// Methods are the HTTP methods which get a key.  Defaults to POST and PATCH,
+	// the methods which aren't idempotent by definition.
+	Methods []string
+	// NewKey generates keys.  Defaults to NewUUID, which generates random UUIDs.
+	NewKey func() (string, error)
+	// Store, if set, remembers the keys generated for operation IDs (see WithOperationID),
+	// so repeated attempts at the same operation, even by separate calls to the Requester,
+	// send the same key.
+	Store CacheStore
+}
+
+func (c *IdempotencyConfig) normalize() {
+	if c.Header == "" {
+		c.Header = HeaderIdempotencyKey
+	}
+
+	if c.Methods == nil {
+		c.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+
+	if c.NewKey == nil {
+		c.NewKey = NewUUID
+	}
+}
+
+type operationIDCtxKey struct{}
+
+// WithOperationID returns a context which identifies the logical operation a request is
+// part of.  If the Idempotency middleware is configured with a Store, requests with the same
+// operation ID get the same idempotency key.
+func WithOperationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, operationIDCtxKey{}, id)
+}
+
+// Idempotency is middleware which attaches an idempotency key to requests, per the
+// IETF Idempotency-Key draft, so servers can safely de-duplicate retried requests.
+// If config is nil, the default configuration is used.
+//
+// Requests which already have the header are sent unchanged.  Otherwise, the header is
+// set on the request in place, so retries of the same request, including by the Retry
+// middleware, send the same key, whether Idempotency is installed before or after Retry.
+func Idempotency(config *IdempotencyConfig) Middleware {
+	var c IdempotencyConfig
+	if config != nil {
+		c = *config
+	}
+
+	c.normalize()
+
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if !c.applies(req) {
+				return next.Do(req)
+			}
+
+			key, err := c.key(req.Context())
+			if err != nil {
+				return nil, merry.Prepend(err, "generating idempotency key")
+			}
+
+			req.Header.Set(c.Header, key)
+			return next.Do(req)
+		})
+	}
+}
+
+func (c *IdempotencyConfig) applies(req *http.Request) bool {
+	if req.Header.Get(c.Header) != "" {
+		return false
+	}
+	for _, m := range c.Methods {
+		if m == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *IdempotencyConfig) key(ctx context.Context) (string, error) {
+	id, _ := ctx.Value(operationIDCtxKey{}).(string)
+	if id == "" || c.Store == nil {
+		return c.NewKey()
+	}
+
+	if key, ok := c.Store.Get(id); ok {
+		return string(key), nil
+	}
+
+	key, err := c.NewKey()
+	if err != nil {
+		return "", err
+	}
+	c.Store.Set(id, []byte(key))
+	return key, nil
+}
+
+// NewUUID returns a random (version 4) UUID.
+func NewUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", merry.Wrap(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
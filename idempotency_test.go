@@ -0,0 +1,125 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(HeaderIdempotencyKey))
+		mu.Unlock()
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	retry := Retry(&RetryConfig{MaxAttempts: 3, Backoff: NoBackoff()})
+
+	reset := func() {
+		mu.Lock()
+		keys = nil
+		mu.Unlock()
+	}
+
+	t.Run("preserved across retries", func(t *testing.T) {
+		for name, mw := range map[string][]Option{
+			"outside retry": {Idempotency(nil), retry},
+			"inside retry":  {retry, Idempotency(nil)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				reset()
+				_, _, err := Receive(nil, append(mw, Post(ts.URL), Body("red"))...)
+				require.NoError(t, err)
+				require.Len(t, keys, 3)
+				assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, keys[0])
+				assert.Equal(t, keys[0], keys[1])
+				assert.Equal(t, keys[0], keys[2])
+			})
+		}
+	})
+
+	t.Run("new key per request", func(t *testing.T) {
+		reset()
+		r := MustNew(Post(ts.URL), Idempotency(nil))
+		_, _, err := r.Receive(nil)
+		require.NoError(t, err)
+		_, _, err = r.Receive(nil)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		assert.NotEqual(t, keys[0], keys[1])
+	})
+
+	t.Run("methods", func(t *testing.T) {
+		reset()
+		for _, m := range []string{http.MethodGet, http.MethodPut, http.MethodDelete, http.MethodPatch} {
+			_, _, err := Receive(Method(m, ts.URL), Idempotency(nil))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []string{"", "", ""}, keys[:3])
+		assert.NotEmpty(t, keys[3])
+
+		reset()
+		_, _, err := Receive(Put(ts.URL), Idempotency(&IdempotencyConfig{Methods: []string{http.MethodPut}}))
+		require.NoError(t, err)
+		assert.NotEmpty(t, keys[0])
+	})
+
+	t.Run("explicit key", func(t *testing.T) {
+		reset()
+		_, _, err := Receive(Post(ts.URL), Header(HeaderIdempotencyKey, "abc"), Idempotency(nil))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"abc"}, keys)
+	})
+
+	t.Run("custom header and generator", func(t *testing.T) {
+		var got string
+		_, _, err := Receive(
+			Post(ts.URL),
+			Idempotency(&IdempotencyConfig{
+				Header: "X-Request-Id",
+				NewKey: func() (string, error) { return "key1", nil },
+			}),
+			Middleware(func(next Doer) Doer {
+				return DoerFunc(func(req *http.Request) (*http.Response, error) {
+					got = req.Header.Get("X-Request-Id")
+					return next.Do(req)
+				})
+			}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "key1", got)
+
+		_, _, err = Receive(Post(ts.URL), Idempotency(&IdempotencyConfig{
+			NewKey: func() (string, error) { return "", errors.New("boom") },
+		}))
+		require.Error(t, err)
+	})
+
+	t.Run("store", func(t *testing.T) {
+		reset()
+		r := MustNew(Post(ts.URL), Idempotency(&IdempotencyConfig{Store: NewMemoryCache(0, 0)}))
+
+		ctx := WithOperationID(context.Background(), "op1")
+		_, _, err := r.ReceiveContext(ctx, nil)
+		require.NoError(t, err)
+		_, _, err = r.ReceiveContext(ctx, nil)
+		require.NoError(t, err)
+		_, _, err = r.ReceiveContext(WithOperationID(context.Background(), "op2"), nil)
+		require.NoError(t, err)
+
+		require.Len(t, keys, 3)
+		assert.Equal(t, keys[0], keys[1])
+		assert.NotEqual(t, keys[0], keys[2])
+	})
+}
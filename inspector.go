@@ -2,8 +2,11 @@ package requester
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // Inspect installs and returns an Inspector.  The Inspector captures the last
@@ -18,6 +21,10 @@ func Inspect(r *Requester) *Inspector {
 // Inspector is a Requester Option which captures requests and responses.
 // It's useful for inspecting the contents of exchanges in tests.
 //
+// By default, it only holds the last exchange, in its fields.  Those fields
+// can't be read safely while requests are in flight.  To inspect concurrent
+// requests, set CaptureAll, and read the exchanges with NextExchange and Drain.
+//
 // It not an efficient way to capture bodies, and keeps requests
 // and responses around longer than their intended lifespan, so it
 // should not be used in production code or benchmarks.
@@ -34,6 +41,48 @@ type Inspector struct {
 
 	// The last client response body
 	ResponseBody *bytes.Buffer
+
+	// CaptureAll, if true, captures every exchange, rather than only the last.
+	// Read them with NextExchange and Drain.
+	CaptureAll bool
+
+	// Redaction, if set, is applied to the captured requests, responses, and bodies.  The
+	// exchange itself isn't modified.  By default, nothing is redacted, so tests can
+	// inspect everything sent.
+	Redaction *Redaction
+
+	// Filter, if set, selects the exchanges to capture.  Exchanges for which it returns
+	// false are passed through untouched, and the fields keep their previous values.
+	Filter func(req *http.Request) bool
+
+	// MaxBodySize, if greater than zero, caps the size of the captured bodies, in bytes.
+	// Only the start of longer bodies is captured, and only that much is buffered: the
+	// rest is streamed through.  Truncated JSON bodies can't be redacted, so if the
+	// Redaction redacts JSON fields, they're captured as empty.
+	MaxBodySize int64
+
+	mu        sync.Mutex
+	exchanges []*Exchange
+	// last is the last exchange, which the fields were set from
+	last *Exchange
+}
+
+// Exchange is a request/response exchange captured by an Inspector.
+type Exchange struct {
+	Request     *http.Request
+	RequestBody *bytes.Buffer
+
+	// Response is nil if the Doer returned no response.
+	Response     *http.Response
+	ResponseBody *bytes.Buffer
+
+	// Err is the error returned by the Doer.
+	Err error
+
+	// Time is when the request was sent.
+	Time time.Time
+	// Duration is how long the exchange took, including reading the response body.
+	Duration time.Duration
 }
 
 // Clear clears the inspector's fields.
@@ -41,10 +90,36 @@ func (i *Inspector) Clear() {
 	if i == nil {
 		return
 	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	i.RequestBody = nil
 	i.ResponseBody = nil
 	i.Request = nil
 	i.Response = nil
+	i.exchanges = nil
+	i.last = nil
+}
+
+// NextExchange removes and returns the oldest exchange captured in CaptureAll mode,
+// or nil if there are none.
+func (i *Inspector) NextExchange() *Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.exchanges) == 0 {
+		return nil
+	}
+	ex := i.exchanges[0]
+	i.exchanges = i.exchanges[1:]
+	return ex
+}
+
+// Drain removes and returns all the exchanges captured in CaptureAll mode, oldest first.
+func (i *Inspector) Drain() []*Exchange {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	exs := i.exchanges
+	i.exchanges = nil
+	return exs
 }
 
 // Apply implements Option
@@ -55,22 +130,90 @@ func (i *Inspector) Apply(r *Requester) error {
 // Wrap implements Middleware
 func (i *Inspector) Wrap(next Doer) Doer {
 	return DoerFunc(func(req *http.Request) (*http.Response, error) {
-		i.Request = req
+		if i.Filter != nil && !i.Filter(req) {
+			return next.Do(req)
+		}
+
+		ex := Exchange{Time: time.Now()}
+
 		// capture the body
 		if req.Body != nil {
-			reqBody, _ := ioutil.ReadAll(req.Body)
-			req.Body.Close()
-			req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
-			i.RequestBody = bytes.NewBuffer(reqBody)
+			var reqBody []byte
+			reqBody, req.Body = i.captureBody(req.Body)
+			ex.RequestBody = bytes.NewBuffer(i.redactBody(req.Header.Get(HeaderContentType), reqBody))
 		}
+		ex.Request = i.redactRequest(req)
+		i.record(func() {
+			i.Request, i.RequestBody = ex.Request, ex.RequestBody
+		})
+
 		resp, err := next.Do(req)
-		i.Response = resp
+		ex.Response = i.redactResponse(resp)
+		ex.Err = err
 		if resp != nil && resp.Body != nil {
-			respBody, _ := ioutil.ReadAll(resp.Body)
-			resp.Body.Close()
-			resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
-			i.ResponseBody = bytes.NewBuffer(respBody)
+			var respBody []byte
+			respBody, resp.Body = i.captureBody(resp.Body)
+			ex.ResponseBody = bytes.NewBuffer(i.redactBody(resp.Header.Get(HeaderContentType), respBody))
 		}
+		ex.Duration = time.Since(ex.Time)
+		i.record(func() {
+			i.Response, i.ResponseBody = ex.Response, ex.ResponseBody
+			i.last = &ex
+			if i.CaptureAll {
+				i.exchanges = append(i.exchanges, &ex)
+			}
+		})
 		return resp, err
 	})
 }
+
+// record updates the inspector, holding its lock.
+func (i *Inspector) record(f func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	f()
+}
+
+// captureBody reads the body, up to MaxBodySize, and returns the bytes read, and a
+// replacement body which yields the whole original body.
+func (i *Inspector) captureBody(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	if i.MaxBodySize <= 0 {
+		b, _ := ioutil.ReadAll(body)
+		body.Close()
+		return b, ioutil.NopCloser(bytes.NewReader(b))
+	}
+
+	b, _ := ioutil.ReadAll(io.LimitReader(body, i.MaxBodySize))
+	return b, &struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), body), body}
+}
+
+func (i *Inspector) redactBody(contentType string, body []byte) []byte {
+	if i.MaxBodySize > 0 && int64(len(body)) >= i.MaxBodySize &&
+		i.Redaction != nil && len(i.Redaction.JSONFields) > 0 && isJSONContentType(contentType) {
+		// possibly truncated, which Redaction can't parse
+		return nil
+	}
+	return i.Redaction.Body(contentType, body)
+}
+
+func (i *Inspector) redactRequest(req *http.Request) *http.Request {
+	if i.Redaction == nil {
+		return req
+	}
+	r2 := *req
+	r2.Header = i.Redaction.Header(req.Header)
+	r2.URL = i.Redaction.URL(req.URL)
+	return &r2
+}
+
+func (i *Inspector) redactResponse(resp *http.Response) *http.Response {
+	if i.Redaction == nil || resp == nil {
+		return resp
+	}
+	r2 := *resp
+	r2.Header = i.Redaction.Header(resp.Header)
+	return &r2
+}
@@ -2,12 +2,15 @@ package requester
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -102,3 +105,132 @@ func ExampleInspect() {
 	// 201
 	// pong
 }
+
+func TestInspector_Redaction(t *testing.T) {
+	var sentAuth string
+	var doer DoerFunc = func(req *http.Request) (*http.Response, error) {
+		sentAuth = req.Header.Get(HeaderAuthorization)
+		resp := &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {MediaTypeJSON}},
+			Body:       ioutil.NopCloser(strings.NewReader(`{"access_token":"xyz"}`)),
+		}
+		return resp, nil
+	}
+
+	i := Inspector{Redaction: DefaultRedaction}
+
+	_, body, err := Receive(&i, doer, BearerAuth("xyz"), QueryParam("access_token", "xyz"), JSON(false), Body(map[string]string{"password": "secret"}))
+	require.NoError(t, err)
+
+	// the exchange itself isn't redacted
+	assert.Equal(t, "Bearer xyz", sentAuth)
+	assert.Equal(t, `{"access_token":"xyz"}`, string(body))
+
+	assert.Equal(t, "REDACTED", i.Request.Header.Get(HeaderAuthorization))
+	assert.Equal(t, "REDACTED", i.Request.URL.Query().Get("access_token"))
+	assert.JSONEq(t, `{"password":"REDACTED"}`, i.RequestBody.String())
+	assert.JSONEq(t, `{"access_token":"REDACTED"}`, i.ResponseBody.String())
+}
+
+func TestInspector_Filter(t *testing.T) {
+	i := Inspector{Filter: func(req *http.Request) bool {
+		return req.Method == http.MethodPost
+	}}
+	r := MustNew(MockDoer(200, Body("pong")), &i)
+
+	_, _, err := r.Receive(Post("/users"), Body("ping"))
+	require.NoError(t, err)
+	require.NotNil(t, i.Request)
+	assert.Equal(t, "ping", i.RequestBody.String())
+
+	// not captured: still the last POST
+	_, body, err := r.Receive(Get("/health"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(body))
+	assert.Equal(t, "/users", i.Request.URL.Path)
+}
+
+func TestInspector_MaxBodySize(t *testing.T) {
+	var sent []byte
+	doer := DoerFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = ioutil.ReadAll(req.Body)
+		return MockResponse(200, Body("0123456789")), nil
+	})
+
+	i := Inspector{MaxBodySize: 4}
+	_, body, err := Receive(doer, &i, Body("abcdefgh"))
+	require.NoError(t, err)
+
+	// the exchange is intact
+	assert.Equal(t, "abcdefgh", string(sent))
+	assert.Equal(t, "0123456789", string(body))
+
+	assert.Equal(t, "abcd", i.RequestBody.String())
+	assert.Equal(t, "0123", i.ResponseBody.String())
+
+	t.Run("redaction", func(t *testing.T) {
+		i := Inspector{MaxBodySize: 10, Redaction: &Redaction{JSONFields: []string{"password"}}}
+		_, _, err := Receive(doer, &i, JSON(false), Body(map[string]string{"password": "secret"}))
+		require.NoError(t, err)
+		assert.Empty(t, i.RequestBody.String())
+
+		// short enough to redact
+		i.MaxBodySize = 100
+		_, _, err = Receive(doer, &i, JSON(false), Body(map[string]string{"password": "secret"}))
+		require.NoError(t, err)
+		assert.NotContains(t, i.RequestBody.String(), "secret")
+		assert.Contains(t, i.RequestBody.String(), "password")
+	})
+}
+
+func TestInspector_CaptureAll(t *testing.T) {
+	i := Inspector{CaptureAll: true}
+	r := MustNew(DoerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/err" {
+			return nil, errors.New("boom")
+		}
+		return MockResponse(200, Body(req.URL.Path)), nil
+	}), &i)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			_, _, _ = r.Receive(Post(fmt.Sprintf("/%d", n)), Body(strconv.Itoa(n)))
+		}(n)
+	}
+	wg.Wait()
+
+	exs := i.Drain()
+	require.Len(t, exs, 10)
+	for _, ex := range exs {
+		assert.Equal(t, "/"+ex.RequestBody.String(), ex.Request.URL.Path)
+		assert.Equal(t, ex.Request.URL.Path, ex.ResponseBody.String())
+		assert.Equal(t, 200, ex.Response.StatusCode)
+	}
+	assert.Empty(t, i.Drain())
+
+	_, _, err := r.Receive(Get("/err"))
+	require.Error(t, err)
+	_, _, err = r.Receive(Get("/ok"))
+	require.NoError(t, err)
+
+	ex := i.NextExchange()
+	require.NotNil(t, ex)
+	assert.Nil(t, ex.Response)
+	assert.EqualError(t, ex.Err, "boom")
+
+	ex = i.NextExchange()
+	require.NotNil(t, ex)
+	assert.Equal(t, "/ok", ex.ResponseBody.String())
+	assert.Nil(t, i.NextExchange())
+
+	// the last exchange is still kept in the fields
+	assert.Equal(t, "/ok", i.Request.URL.Path)
+
+	_, _, _ = r.Receive(Get("/ok"))
+	i.Clear()
+	assert.Nil(t, i.NextExchange())
+}
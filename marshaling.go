@@ -1,13 +1,26 @@
 package requester
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"encoding/xml"
 	"github.com/ansel1/merry"
 	goquery "github.com/google/go-querystring/query"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/ioutil"
 	"mime"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 )
 
 // Requester marshals values into request bodies, and unmarshals
@@ -16,12 +29,13 @@ import (
 // be installed in a requester with the WithMarshaler and WithUnmarshaler Options.
 //
 // This package comes with a number of implementations built in, which can
-// be installed with the JSON(), XML(), and Form() Options.
+// be installed with the JSON(), XML(), MsgPack(), Protobuf(), Gob(), OctetStream(), and Form() Options.
 //
 // If not set, requesters fall back on the DefaultMarshaler and
 // DefaultUnmarshaler.  The DefaultMarshaler marshals into JSON, and the
 // DefaultUnmarshaler uses the response's Content-Type header to
-// determine which unmarshaler to delegate it.  It supports JSON and XML.
+// determine which unmarshaler to delegate it.  It supports JSON, XML, MessagePack,
+// Protocol Buffers, gob, and raw octet streams.
 
 // DefaultMarshaler is used by Requester if Requester.Marshaler is nil.
 // nolint:gochecknoglobals
@@ -51,6 +65,13 @@ type Unmarshaler interface {
 	Unmarshal(data []byte, contentType string, v interface{}) error
 }
 
+// StreamUnmarshaler is an optional interface for Unmarshalers which can decode
+// directly from a stream, without reading the entire response body into memory first.
+// See Requester.StreamResponse.
+type StreamUnmarshaler interface {
+	UnmarshalReader(r io.Reader, contentType string, v interface{}) error
+}
+
 // MarshalFunc adapts a function to the Marshaler interface.
 type MarshalFunc func(v interface{}) ([]byte, string, error)
 
@@ -87,20 +108,81 @@ func (f UnmarshalFunc) Unmarshal(data []byte, contentType string, v interface{})
 //	r := requester.Requester{
 //	    Body: &JSONMarshaler{},
 //	}
+//
+// By default, JSONMarshaler uses the encoding/json package.  Alternate JSON
+// libraries can be plugged in by setting Encode, Decode, and NewDecoder.  For
+// example, with github.com/json-iterator/go:
+//
+//	var jsoniterAPI = jsoniter.ConfigCompatibleWithStandardLibrary
+//
+//	m := &JSONMarshaler{
+//	    Encode: jsoniterAPI.Marshal,
+//	    Decode: jsoniterAPI.Unmarshal,
+//	    NewDecoder: func(r io.Reader) JSONDecoder {
+//	        return jsoniterAPI.NewDecoder(r)
+//	    },
+//	}
 type JSONMarshaler struct {
 	Indent bool
+
+	// Encode, if set, is used instead of json.Marshal.  If Indent
+	// is true, the output will be indented with json.Indent.
+	Encode func(v interface{}) ([]byte, error)
+
+	// Decode, if set, is used by Unmarshal instead of json.Unmarshal.
+	Decode func(data []byte, v interface{}) error
+
+	// NewDecoder, if set, is used by UnmarshalReader instead of json.NewDecoder.
+	// If nil, but Decode is set, UnmarshalReader reads the entire stream into
+	// memory and calls Decode.
+	NewDecoder func(r io.Reader) JSONDecoder
+}
+
+// JSONDecoder decodes JSON values from a stream.  It is implemented by *json.Decoder,
+// and by the decoders of most third-party JSON libraries.
+type JSONDecoder interface {
+	Decode(v interface{}) error
 }
 
 // Unmarshal implements Unmarshaler.
 func (m *JSONMarshaler) Unmarshal(data []byte, _ string, v interface{}) error {
+	if m.Decode != nil {
+		return merry.Wrap(m.Decode(data, v))
+	}
 	return merry.Wrap(json.Unmarshal(data, v))
 }
 
+// UnmarshalReader implements StreamUnmarshaler.  It decodes JSON directly from
+// a stream, without buffering the entire stream in memory first.
+func (m *JSONMarshaler) UnmarshalReader(r io.Reader, _ string, v interface{}) error {
+	switch {
+	case m.NewDecoder != nil:
+		return merry.Wrap(m.NewDecoder(r).Decode(v))
+	case m.Decode != nil:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return merry.Wrap(err)
+		}
+		return merry.Wrap(m.Decode(data, v))
+	default:
+		return merry.Wrap(json.NewDecoder(r).Decode(v))
+	}
+}
+
 // Marshal implements Marshaler.
 func (m *JSONMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
-	if m.Indent {
+	switch {
+	case m.Encode != nil:
+		data, err = m.Encode(v)
+		if err == nil && m.Indent {
+			var buf bytes.Buffer
+			if err = json.Indent(&buf, data, "", "  "); err == nil {
+				data = buf.Bytes()
+			}
+		}
+	case m.Indent:
 		data, err = json.MarshalIndent(v, "", "  ")
-	} else {
+	default:
 		data, err = json.Marshal(v)
 	}
 
@@ -144,13 +226,197 @@ func (m *XMLMarshaler) Apply(r *Requester) error {
 	return nil
 }
 
+// MsgPackMarshaler implements Marshaler and Unmarshaler.  It marshals values to
+// and from MessagePack, using github.com/vmihailenco/msgpack.
+//
+//	r := requester.Requester{
+//	    Marshaler: &MsgPackMarshaler{},
+//	}
+type MsgPackMarshaler struct{}
+
+// Unmarshal implements Unmarshaler.
+func (*MsgPackMarshaler) Unmarshal(data []byte, _ string, v interface{}) error {
+	return merry.Wrap(msgpack.Unmarshal(data, v))
+}
+
+// Marshal implements Marshaler.
+func (*MsgPackMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+	data, err = msgpack.Marshal(v)
+	return data, MediaTypeMsgPack, merry.Wrap(err)
+}
+
+// Apply implements Option.
+func (m *MsgPackMarshaler) Apply(r *Requester) error {
+	r.Marshaler = m
+	return nil
+}
+
+// ProtoMarshaler implements Marshaler and Unmarshaler.  It marshals proto.Message
+// values to and from the Protocol Buffers binary wire format.  Marshaling or unmarshaling
+// any other type of value returns an error.
+//
+//	r := requester.Requester{
+//	    Marshaler: &ProtoMarshaler{},
+//	}
+type ProtoMarshaler struct{}
+
+// Unmarshal implements Unmarshaler.
+func (*ProtoMarshaler) Unmarshal(data []byte, _ string, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return merry.Errorf("can't unmarshal protobuf into %T: not a proto.Message", v)
+	}
+	return merry.Wrap(proto.Unmarshal(data, m))
+}
+
+// Marshal implements Marshaler.
+func (*ProtoMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, "", merry.Errorf("can't marshal %T to protobuf: not a proto.Message", v)
+	}
+	data, err = proto.Marshal(m)
+	return data, MediaTypeProtobuf, merry.Wrap(err)
+}
+
+// Apply implements Option.
+func (m *ProtoMarshaler) Apply(r *Requester) error {
+	r.Marshaler = m
+	return nil
+}
+
+// GobMarshaler implements Marshaler and Unmarshaler.  It marshals values to
+// and from encoding/gob, which is handy for services which are both written in Go.
+//
+//	r := requester.Requester{
+//	    Marshaler: &GobMarshaler{},
+//	}
+//
+// Decoding gob from an untrusted server isn't safe, so it isn't registered with the
+// default Unmarshalers.  Opt in with the Gob option, UnmarshalerFor, or RegisterUnmarshaler:
+//
+//	requester.RegisterUnmarshaler(requester.MediaTypeGob, &requester.GobMarshaler{})
+type GobMarshaler struct{}
+
+// Unmarshal implements Unmarshaler.
+func (*GobMarshaler) Unmarshal(data []byte, _ string, v interface{}) error {
+	return merry.Wrap(gob.NewDecoder(bytes.NewReader(data)).Decode(v))
+}
+
+// UnmarshalReader implements StreamUnmarshaler.
+func (*GobMarshaler) UnmarshalReader(r io.Reader, _ string, v interface{}) error {
+	return merry.Wrap(gob.NewDecoder(r).Decode(v))
+}
+
+// Marshal implements Marshaler.
+func (*GobMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), MediaTypeGob, merry.Wrap(err)
+}
+
+// Apply implements Option.
+func (m *GobMarshaler) Apply(r *Requester) error {
+	r.Marshaler = m
+	return nil
+}
+
+// OctetStreamMarshaler implements Marshaler and Unmarshaler.  It passes raw bytes
+// through unchanged, with the content type application/octet-stream.
+//
+// It marshals []byte, string, and io.Reader values, and unmarshals into *[]byte,
+// *string, and io.Writer values.  Any other type of value returns an error.
+type OctetStreamMarshaler struct{}
+
+// Unmarshal implements Unmarshaler.
+func (*OctetStreamMarshaler) Unmarshal(data []byte, _ string, v interface{}) error {
+	switch t := v.(type) {
+	case *[]byte:
+		*t = append((*t)[:0], data...)
+	case *string:
+		*t = string(data)
+	case io.Writer:
+		_, err := t.Write(data)
+		return merry.Wrap(err)
+	default:
+		return merry.Errorf("can't unmarshal octet stream into %T", v)
+	}
+	return nil
+}
+
+// UnmarshalReader implements StreamUnmarshaler.  Values which are io.Writers
+// are copied to directly from the stream.
+func (m *OctetStreamMarshaler) UnmarshalReader(r io.Reader, contentType string, v interface{}) error {
+	if w, ok := v.(io.Writer); ok {
+		_, err := io.Copy(w, r)
+		return merry.Wrap(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return merry.Wrap(err)
+	}
+	return m.Unmarshal(data, contentType, v)
+}
+
+// Marshal implements Marshaler.
+func (*OctetStreamMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+	switch t := v.(type) {
+	case []byte:
+		data = t
+	case string:
+		data = []byte(t)
+	case io.Reader:
+		data, err = ioutil.ReadAll(t)
+	default:
+		return nil, "", merry.Errorf("can't marshal %T to octet stream", v)
+	}
+	return data, MediaTypeOctetStream, merry.Wrap(err)
+}
+
+// Apply implements Option.
+func (m *OctetStreamMarshaler) Apply(r *Requester) error {
+	r.Marshaler = m
+	return nil
+}
+
 // FormMarshaler implements Marshaler.  It marshals values into URL-Encoded form data.
 //
 // The value can be either a map[string][]string, map[string]string, url.Values, or a struct with `url` tags.
-type FormMarshaler struct{}
+//
+// By default, structs are encoded with github.com/google/go-querystring, which supports a
+// number of options in the `url` tag.  If KeyStyle is set to FormKeyBrackets or FormKeyDotted,
+// structs are encoded by FormMarshaler itself, which only supports the field name, "omitempty",
+// and "-" in the `url` tag, but encodes nested structs, maps, slices, and times as configured.
+type FormMarshaler struct {
+	// KeyStyle selects how structs are encoded, and how the keys of nested struct fields
+	// and maps are named.  Defaults to FormKeyQueryString.
+	KeyStyle FormKeyStyle
+
+	// SliceDelimiter, if set, joins the values of slices and arrays into a
+	// single value, e.g. "a=1,2,3".  By default, each value is encoded
+	// separately, e.g. "a=1&a=2&a=3".  It requires FormKeyBrackets or FormKeyDotted.
+	SliceDelimiter string
+
+	// TimeLayout is the layout used to format time.Time values.  Defaults to
+	// time.RFC3339.  It requires FormKeyBrackets or FormKeyDotted.
+	TimeLayout string
+}
+
+// FormKeyStyle controls how FormMarshaler encodes structs, and names the keys of nested values.
+type FormKeyStyle int
+
+const (
+	// FormKeyQueryString encodes structs with github.com/google/go-querystring.  This is the
+	// default.
+	FormKeyQueryString FormKeyStyle = iota
+	// FormKeyBrackets names nested keys like "a[b][c]".
+	FormKeyBrackets
+	// FormKeyDotted names nested keys like "a.b.c".
+	FormKeyDotted
+)
 
 // Marshal implements Marshaler.
-func (*FormMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+func (m *FormMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
 	switch t := v.(type) {
 	case map[string][]string:
 		urlV := url.Values(t)
@@ -164,7 +430,18 @@ func (*FormMarshaler) Marshal(v interface{}) (data []byte, contentType string, e
 	case url.Values:
 		return []byte(t.Encode()), contentTypeForm, nil
 	default:
-		values, err := goquery.Values(v)
+		var values url.Values
+		switch m.KeyStyle {
+		case FormKeyQueryString:
+			if m.SliceDelimiter != "" || m.TimeLayout != "" {
+				return nil, "", merry.New("SliceDelimiter and TimeLayout require KeyStyle FormKeyBrackets or FormKeyDotted")
+			}
+			values, err = goquery.Values(v)
+		case FormKeyBrackets, FormKeyDotted:
+			values, err = m.values(v)
+		default:
+			return nil, "", merry.Errorf("invalid FormKeyStyle %d", m.KeyStyle)
+		}
 		if err != nil {
 			return nil, "", merry.Prepend(err, "invalid form struct")
 		}
@@ -191,12 +468,49 @@ func (m *FormMarshaler) Apply(r *Requester) error {
 // If the full media type has no match, but there is a suffix, it will look for an Unmarshaler
 // registered for <type>/<suffix>.  For example, if there was no match for `application/vnd.api+json`,
 // it will look for `application/json`.
+//
+// The exception is the charset parameter.  If the content type specifies a charset other than
+// UTF-8, and an encoding is registered for that charset in Charsets, the data is transcoded
+// to UTF-8 before it is passed to the Unmarshaler.  Charsets with no registered encoding are
+// passed through unchanged.
+//
+// If the content type is missing, can't be parsed, or has no registered Unmarshaler, the
+// content type can optionally be sniffed from the data (see Sniff).  Failing that, the data
+// is passed to the Fallback Unmarshaler, if set.
 type ContentTypeUnmarshaler struct {
 	Unmarshalers map[string]Unmarshaler
+
+	// Sniff enables guessing the media type from the data, if the content type
+	// doesn't resolve to a registered Unmarshaler.  Data starting with "{" or "["
+	// is treated as application/json, and data starting with "<" is treated as
+	// application/xml.  Leading whitespace is ignored.
+	Sniff bool
+
+	// Fallback, if set, is used if no Unmarshaler could be selected by the content
+	// type (or sniffing).
+	Fallback Unmarshaler
+
+	// Charsets maps lower-case charset names to encodings.  If nil, DefaultCharsets
+	// is used.
+	Charsets map[string]encoding.Encoding
+}
+
+// DefaultCharsets is the default registry of charsets used by ContentTypeUnmarshaler
+// to transcode response bodies to UTF-8.  Keys are lower-case charset names.
+// Additional charsets can be registered here, or in ContentTypeUnmarshaler.Charsets.
+// nolint:gochecknoglobals
+var DefaultCharsets = map[string]encoding.Encoding{
+	"iso-8859-1":   charmap.ISO8859_1,
+	"latin1":       charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+	"utf-16":       unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
 }
 
 // NewContentTypeUnmarshaler returns a new ContentTypeUnmarshaler preconfigured to
-// handle application/json and application/xml.
+// handle application/json, application/xml, application/msgpack, application/x-protobuf,
+// and application/octet-stream.
 func NewContentTypeUnmarshaler() *ContentTypeUnmarshaler {
 	// install defaults
 	return &ContentTypeUnmarshaler{
@@ -204,43 +518,225 @@ func NewContentTypeUnmarshaler() *ContentTypeUnmarshaler {
 	}
 }
 
+// RegisterUnmarshaler registers an Unmarshaler for a media type with all
+// ContentTypeUnmarshalers, including the DefaultUnmarshaler, so vendor media types like
+// application/problem+json can be handled by all Requesters which don't set their own
+// Unmarshaler.  It's safe to call while requests are in flight.
+//
+// Unmarshalers in a ContentTypeUnmarshaler's Unmarshalers take precedence over registered
+// ones.  Registration has no effect on Requesters whose Unmarshaler isn't a
+// ContentTypeUnmarshaler, including when the DefaultUnmarshaler has been replaced.
+func RegisterUnmarshaler(mediaType string, u Unmarshaler) {
+	registeredUnmarshalers.register(mediaType, u)
+}
+
+// unmarshalerRegistry holds the Unmarshalers registered with RegisterUnmarshaler.
+type unmarshalerRegistry struct {
+	mu           sync.RWMutex
+	unmarshalers map[string]Unmarshaler
+}
+
+// nolint:gochecknoglobals
+var registeredUnmarshalers unmarshalerRegistry
+
+func (r *unmarshalerRegistry) register(mediaType string, u Unmarshaler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.unmarshalers == nil {
+		r.unmarshalers = map[string]Unmarshaler{}
+	}
+	r.unmarshalers[mediaType] = u
+}
+
+func (r *unmarshalerRegistry) get(mediaType string) Unmarshaler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.unmarshalers[mediaType]
+}
+
+// zeroValueUnmarshalers are used by ContentTypeUnmarshalers with no Unmarshalers.  It's never
+// modified.
+// nolint:gochecknoglobals
+var zeroValueUnmarshalers = defaultUnmarshalers()
+
 func defaultUnmarshalers() map[string]Unmarshaler {
 	return map[string]Unmarshaler{
-		MediaTypeJSON: &JSONMarshaler{},
-		MediaTypeXML:  &XMLMarshaler{},
+		MediaTypeJSON:        &JSONMarshaler{},
+		MediaTypeXML:         &XMLMarshaler{},
+		MediaTypeMsgPack:     &MsgPackMarshaler{},
+		MediaTypeXMsgPack:    &MsgPackMarshaler{},
+		MediaTypeProtobuf:    &ProtoMarshaler{},
+		MediaTypeOctetStream: &OctetStreamMarshaler{},
+	}
+}
+
+// unmarshalers returns the Unmarshalers.  Zero value ContentTypeUnmarshalers use the
+// defaults.  This allows ContentTypeUnmarshaler to be a drop in replacement for
+// MultiUnmarshaler.  c may be shared by many Requesters, so it isn't modified.
+func (c *ContentTypeUnmarshaler) unmarshalers() map[string]Unmarshaler {
+	if c.Unmarshalers == nil {
+		return zeroValueUnmarshalers
 	}
+	return c.Unmarshalers
+}
+
+// lookup returns the Unmarshaler for the media type, from unmarshalers, or the Unmarshalers
+// registered with RegisterUnmarshaler.
+func lookup(unmarshalers map[string]Unmarshaler, mediaType string) Unmarshaler {
+	if u := unmarshalers[mediaType]; u != nil {
+		return u
+	}
+	return registeredUnmarshalers.get(mediaType)
+}
+
+// clone returns a copy of c, with a copy of the Unmarshalers.
+func (c *ContentTypeUnmarshaler) clone() *ContentTypeUnmarshaler {
+	unmarshalers := c.unmarshalers()
+	c2 := &ContentTypeUnmarshaler{
+		Unmarshalers: make(map[string]Unmarshaler, len(unmarshalers)),
+		Sniff:        c.Sniff,
+		Fallback:     c.Fallback,
+		Charsets:     c.Charsets,
+	}
+	for k, v := range unmarshalers {
+		c2.Unmarshalers[k] = v
+	}
+	return c2
 }
 
 // Unmarshal implements Unmarshaler.
 //
 // If media type parsing fails, or no Unmarshaler is found, an error is returned.
 func (c *ContentTypeUnmarshaler) Unmarshal(data []byte, contentType string, v interface{}) error {
-	// for zero value ContentTypeUnmarshaler, initialize with defaults.
-	// This allows ContentTypeUnmarshaler to be a drop in replacement for MultiUnmarshaler
-	if c.Unmarshalers == nil {
-		c.Unmarshalers = map[string]Unmarshaler{
-			MediaTypeJSON: &JSONMarshaler{},
-			MediaTypeXML:  &XMLMarshaler{},
+	u, charset, err := c.unmarshaler(contentType, data)
+	if err != nil {
+		return err
+	}
+
+	if dec := c.charsetDecoder(charset); dec != nil {
+		data, err = dec.Bytes(data)
+		if err != nil {
+			return merry.Prependf(err, "failed to transcode from charset %s", charset)
 		}
 	}
 
-	mediaType, _, err := mime.ParseMediaType(contentType)
+	return u.Unmarshal(data, contentType, v)
+}
+
+// UnmarshalReader implements StreamUnmarshaler.  If the Unmarshaler selected for the
+// content type implements StreamUnmarshaler, the stream is passed to it directly.
+// Otherwise, the stream is read into memory and passed to Unmarshal.
+func (c *ContentTypeUnmarshaler) UnmarshalReader(r io.Reader, contentType string, v interface{}) error {
+	var peek []byte
+	if c.Sniff {
+		br := bufio.NewReader(r)
+		// errors will surface again when the stream is read
+		peek, _ = br.Peek(512)
+		r = br
+	}
+
+	u, charset, err := c.unmarshaler(contentType, peek)
 	if err != nil {
-		return merry.Prependf(err, "failed to parse content type: %s", contentType)
+		return err
 	}
 
-	if u := c.Unmarshalers[mediaType]; u != nil {
-		return u.Unmarshal(data, contentType, v)
+	su, ok := u.(StreamUnmarshaler)
+	if !ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return merry.Prepend(err, "reading body")
+		}
+		return c.Unmarshal(data, contentType, v)
+	}
+
+	if dec := c.charsetDecoder(charset); dec != nil {
+		r = transform.NewReader(r, dec)
+	}
+
+	return su.UnmarshalReader(r, contentType, v)
+}
+
+// unmarshaler selects the Unmarshaler for the content type, and returns the
+// charset from the content type's parameters.  data is only used for sniffing,
+// and may be a prefix of the whole body.
+func (c *ContentTypeUnmarshaler) unmarshaler(contentType string, data []byte) (Unmarshaler, string, error) {
+	unmarshalers := c.unmarshalers()
+
+	// fast path for a bare media type, which avoids parsing it
+	if u := lookup(unmarshalers, contentType); u != nil {
+		return u, "", nil
+	}
+
+	var u Unmarshaler
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		u = lookup(unmarshalers, mediaType)
+		if u == nil {
+			// If exact match didn't find anything, try falling back to a looser match.
+			if ct := generalMediaType(mediaType); ct != "" {
+				u = lookup(unmarshalers, ct)
+			}
+		}
 	}
 
-	// If exact match didn't find anything, try falling back to a looser match.
-	if ct := generalMediaType(mediaType); ct != "" {
-		if u := c.Unmarshalers[ct]; u != nil {
-			return u.Unmarshal(data, contentType, v)
+	if u == nil && c.Sniff {
+		if ct := sniffMediaType(data); ct != "" {
+			u = lookup(unmarshalers, ct)
 		}
 	}
 
-	return merry.Errorf("unsupported content type: %s", contentType)
+	if u == nil {
+		u = c.Fallback
+	}
+
+	switch {
+	case u != nil:
+		return u, params["charset"], nil
+	case contentType == "":
+		return nil, "", merry.New("no content type")
+	case err != nil:
+		return nil, "", merry.Prependf(err, "failed to parse content type: %s", contentType)
+	default:
+		return nil, "", merry.Errorf("unsupported content type: %s", contentType)
+	}
+}
+
+// charsetDecoder returns a decoder which converts from charset to UTF-8.  If the
+// charset is UTF-8, or isn't registered, it returns nil.
+func (c *ContentTypeUnmarshaler) charsetDecoder(charset string) *encoding.Decoder {
+	charset = strings.ToLower(charset)
+	switch charset {
+	case "", "utf-8", "utf8":
+		return nil
+	}
+
+	charsets := c.Charsets
+	if charsets == nil {
+		charsets = DefaultCharsets
+	}
+
+	enc := charsets[charset]
+	if enc == nil {
+		return nil
+	}
+	return enc.NewDecoder()
+}
+
+// sniffMediaType guesses the media type of data from its first
+// non-whitespace character.  Returns an empty string if no guess can be made.
+func sniffMediaType(data []byte) string {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(data) == 0 {
+		return ""
+	}
+	switch data[0] {
+	case '{', '[':
+		return MediaTypeJSON
+	case '<':
+		return MediaTypeXML
+	}
+	return ""
 }
 
 // Apply implements Option
@@ -268,5 +764,183 @@ func generalMediaType(s string) string {
 	return ""
 }
 
+// HeaderUnmarshaler selects an unmarshaler based on the value of an arbitrary response
+// header, rather than the Content-Type header.  This is useful for gateways which always
+// report the same Content-Type, but indicate the actual payload schema in another header:
+//
+//	u := &HeaderUnmarshaler{
+//	    Header: "X-Payload-Schema",
+//	    Unmarshalers: map[string]Unmarshaler{
+//	        "v1": &JSONMarshaler{},
+//	        "v2": &XMLMarshaler{},
+//	    },
+//	}
+//
+// If the header is missing, or there is no Unmarshaler registered for its value, the
+// Fallback Unmarshaler is used, with the response's Content-Type.  Fallback defaults to
+// DefaultUnmarshaler.
+//
+// The selected Unmarshaler is still passed the response's Content-Type.
+//
+// HeaderUnmarshaler can only select by header when used by the Receive methods.  When
+// invoked directly with Unmarshal(), it always delegates to Fallback.
+type HeaderUnmarshaler struct {
+	Header       string
+	Unmarshalers map[string]Unmarshaler
+	Fallback     Unmarshaler
+}
+
+// Unmarshal implements Unmarshaler.  It always delegates to Fallback.
+func (h *HeaderUnmarshaler) Unmarshal(data []byte, contentType string, v interface{}) error {
+	return h.fallback().Unmarshal(data, contentType, v)
+}
+
+func (h *HeaderUnmarshaler) unmarshalResponse(resp *http.Response, data []byte, v interface{}) error {
+	contentType := resp.Header.Get(HeaderContentType)
+	if u := h.Unmarshalers[resp.Header.Get(h.Header)]; u != nil {
+		return u.Unmarshal(data, contentType, v)
+	}
+	return h.fallback().Unmarshal(data, contentType, v)
+}
+
+func (h *HeaderUnmarshaler) fallback() Unmarshaler {
+	if h.Fallback == nil {
+		return DefaultUnmarshaler
+	}
+	return h.Fallback
+}
+
+// Apply implements Option
+func (h *HeaderUnmarshaler) Apply(r *Requester) error {
+	r.Unmarshaler = h
+	return nil
+}
+
+// responseUnmarshaler is implemented by Unmarshalers which need access to
+// the whole response, not just its Content-Type.
+type responseUnmarshaler interface {
+	unmarshalResponse(resp *http.Response, data []byte, v interface{}) error
+}
+
+// unmarshalResponse unmarshals the response body using u.
+func unmarshalResponse(u Unmarshaler, resp *http.Response, data []byte, v interface{}) error {
+	if ru, ok := u.(responseUnmarshaler); ok {
+		return ru.unmarshalResponse(resp, data, v)
+	}
+	return u.Unmarshal(data, resp.Header.Get(HeaderContentType), v)
+}
+
 // MultiUnmarshaler is a legacy alias for ContentTypeUnmarshaler.
 type MultiUnmarshaler = ContentTypeUnmarshaler
+
+// ContentTypeMarshaler selects a marshaler based on the Content-Type header explicitly
+// set on the request.  This allows a single Requester to send different body formats,
+// just by changing the header:
+//
+//	r := requester.MustNew(requester.NewContentTypeMarshaler())
+//	req, err := r.Request(requester.Body(v), requester.ContentType(requester.MediaTypeForm))
+//
+// Marshalers are matched to the media type the same way as ContentTypeUnmarshaler: parameters
+// are ignored, and if there's no match for the full media type, a Marshaler registered for
+// <type>/<suffix> is used.
+//
+// If the request has no Content-Type header, Default is used, which defaults to DefaultMarshaler.
+// If the Content-Type header is set, but no Marshaler is registered for it, an error is returned.
+//
+// ContentTypeMarshaler can only select by header when used by a Requester.  When
+// invoked directly with Marshal(), it always delegates to Default.
+type ContentTypeMarshaler struct {
+	Marshalers map[string]Marshaler
+	Default    Marshaler
+}
+
+// NewContentTypeMarshaler returns a new ContentTypeMarshaler preconfigured to
+// handle application/json, application/xml, application/x-www-form-urlencoded,
+// application/msgpack, application/x-msgpack, application/x-protobuf, and
+// application/octet-stream.
+func NewContentTypeMarshaler() *ContentTypeMarshaler {
+	return &ContentTypeMarshaler{
+		Marshalers: defaultMarshalers(),
+	}
+}
+
+// zeroValueMarshalers are used by ContentTypeMarshalers with no Marshalers.  It's never modified.
+// nolint:gochecknoglobals
+var zeroValueMarshalers = defaultMarshalers()
+
+func defaultMarshalers() map[string]Marshaler {
+	return map[string]Marshaler{
+		MediaTypeJSON:        &JSONMarshaler{},
+		MediaTypeXML:         &XMLMarshaler{},
+		MediaTypeForm:        &FormMarshaler{},
+		MediaTypeMsgPack:     &MsgPackMarshaler{},
+		MediaTypeXMsgPack:    &MsgPackMarshaler{},
+		MediaTypeProtobuf:    &ProtoMarshaler{},
+		MediaTypeOctetStream: &OctetStreamMarshaler{},
+	}
+}
+
+// Marshal implements Marshaler.  It always delegates to Default.
+func (c *ContentTypeMarshaler) Marshal(v interface{}) (data []byte, contentType string, err error) {
+	return c.defaultMarshaler().Marshal(v)
+}
+
+func (c *ContentTypeMarshaler) marshalRequest(header http.Header, v interface{}) (data []byte, contentType string, err error) {
+	contentType = header.Get(HeaderContentType)
+	if contentType == "" {
+		return c.defaultMarshaler().Marshal(v)
+	}
+
+	// zero value ContentTypeMarshalers use the defaults.  c may be shared by many
+	// Requesters, so it isn't modified.
+	marshalers := c.Marshalers
+	if marshalers == nil {
+		marshalers = zeroValueMarshalers
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, "", merry.Prependf(err, "failed to parse content type: %s", contentType)
+	}
+
+	m := marshalers[mediaType]
+	if m == nil {
+		// If exact match didn't find anything, try falling back to a looser match.
+		if ct := generalMediaType(mediaType); ct != "" {
+			m = marshalers[ct]
+		}
+	}
+
+	if m == nil {
+		return nil, "", merry.Errorf("unsupported content type: %s", contentType)
+	}
+
+	return m.Marshal(v)
+}
+
+func (c *ContentTypeMarshaler) defaultMarshaler() Marshaler {
+	if c.Default == nil {
+		return DefaultMarshaler
+	}
+	return c.Default
+}
+
+// Apply implements Option
+func (c *ContentTypeMarshaler) Apply(r *Requester) error {
+	r.Marshaler = c
+	return nil
+}
+
+// requestMarshaler is implemented by Marshalers which need access to
+// the request's header.
+type requestMarshaler interface {
+	marshalRequest(header http.Header, v interface{}) (data []byte, contentType string, err error)
+}
+
+// marshalRequest marshals the request body using m.
+func marshalRequest(m Marshaler, header http.Header, v interface{}) ([]byte, string, error) {
+	if rm, ok := m.(requestMarshaler); ok {
+		return rm.marshalRequest(header, v)
+	}
+	return m.Marshal(v)
+}
@@ -1,13 +1,26 @@
 package requester
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestJSONMarshaler_Marshal(t *testing.T) {
@@ -43,6 +56,84 @@ func TestJSONMarshaler_Unmarshal(t *testing.T) {
 	require.Equal(t, map[string]interface{}{"color": "red"}, v)
 }
 
+func TestJSONMarshaler_custom(t *testing.T) {
+	var encoded, decoded bool
+	m := JSONMarshaler{
+		Encode: func(v interface{}) ([]byte, error) {
+			encoded = true
+			return json.Marshal(v)
+		},
+		Decode: func(data []byte, v interface{}) error {
+			decoded = true
+			return json.Unmarshal(data, v)
+		},
+	}
+
+	d, ct, err := m.Marshal(map[string]interface{}{"color": "red"})
+	require.NoError(t, err)
+	assert.True(t, encoded)
+	assert.Equal(t, "application/json; charset=UTF-8", ct)
+	assert.Equal(t, `{"color":"red"}`, string(d))
+
+	m.Indent = true
+	d, _, err = m.Marshal(map[string]interface{}{"color": "red"})
+	require.NoError(t, err)
+	assert.Equal(t, "{\n  \"color\": \"red\"\n}", string(d))
+
+	var v map[string]interface{}
+	err = m.Unmarshal(d, "", &v)
+	require.NoError(t, err)
+	assert.True(t, decoded)
+	assert.Equal(t, map[string]interface{}{"color": "red"}, v)
+
+	m.Encode = func(v interface{}) ([]byte, error) {
+		return nil, errors.New("boom")
+	}
+	_, _, err = m.Marshal(v)
+	require.EqualError(t, err, "boom")
+}
+
+func TestJSONMarshaler_UnmarshalReader(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		m := JSONMarshaler{}
+		var v map[string]interface{}
+		err := m.UnmarshalReader(strings.NewReader(`{"color":"red"}`), "", &v)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"color": "red"}, v)
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		var decoded bool
+		m := JSONMarshaler{Decode: func(data []byte, v interface{}) error {
+			decoded = true
+			return json.Unmarshal(data, v)
+		}}
+		var v map[string]interface{}
+		err := m.UnmarshalReader(strings.NewReader(`{"color":"red"}`), "", &v)
+		require.NoError(t, err)
+		assert.True(t, decoded)
+		assert.Equal(t, map[string]interface{}{"color": "red"}, v)
+	})
+
+	t.Run("new decoder", func(t *testing.T) {
+		var created bool
+		m := JSONMarshaler{NewDecoder: func(r io.Reader) JSONDecoder {
+			created = true
+			d := json.NewDecoder(r)
+			d.DisallowUnknownFields()
+			return d
+		}}
+		var v testModel
+		err := m.UnmarshalReader(strings.NewReader(`{"color":"red"}`), "", &v)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, testModel{Color: "red"}, v)
+
+		err = m.UnmarshalReader(strings.NewReader(`{"flavor":"red"}`), "", &v)
+		require.Error(t, err)
+	})
+}
+
 type testModel struct {
 	Color string `xml:"color" json:"color" url:"color"`
 	Count int    `xml:"count" json:"count" url:"count"`
@@ -79,6 +170,120 @@ func TestXMLMarshaler_Unmarshal(t *testing.T) {
 	assert.Equal(t, testModel{"red", 30}, v)
 }
 
+func TestMsgPackMarshaler_Marshal(t *testing.T) {
+	m := MsgPackMarshaler{}
+
+	b, ct, err := m.Marshal(testModel{"red", 30})
+	require.NoError(t, err)
+
+	assert.Equal(t, "application/msgpack", ct)
+
+	var v testModel
+	require.NoError(t, msgpack.Unmarshal(b, &v))
+	assert.Equal(t, testModel{"red", 30}, v)
+}
+
+func TestMsgPackMarshaler_Unmarshal(t *testing.T) {
+	m := MsgPackMarshaler{}
+
+	data, err := msgpack.Marshal(testModel{"red", 30})
+	require.NoError(t, err)
+
+	var v testModel
+	err = m.Unmarshal(data, "", &v)
+	require.NoError(t, err)
+
+	assert.Equal(t, testModel{"red", 30}, v)
+}
+
+func TestProtoMarshaler_Marshal(t *testing.T) {
+	m := ProtoMarshaler{}
+
+	b, ct, err := m.Marshal(wrapperspb.String("red"))
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-protobuf", ct)
+
+	var v wrapperspb.StringValue
+	require.NoError(t, proto.Unmarshal(b, &v))
+	assert.Equal(t, "red", v.GetValue())
+
+	_, _, err = m.Marshal(testModel{"red", 30})
+	require.Error(t, err)
+}
+
+func TestProtoMarshaler_Unmarshal(t *testing.T) {
+	m := ProtoMarshaler{}
+
+	data, err := proto.Marshal(wrapperspb.String("red"))
+	require.NoError(t, err)
+
+	var v wrapperspb.StringValue
+	err = m.Unmarshal(data, "", &v)
+	require.NoError(t, err)
+	assert.Equal(t, "red", v.GetValue())
+
+	err = m.Unmarshal(data, "", &testModel{})
+	require.Error(t, err)
+}
+
+func TestGobMarshaler(t *testing.T) {
+	m := GobMarshaler{}
+
+	b, ct, err := m.Marshal(testModel{"red", 30})
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-gob", ct)
+
+	var v testModel
+	require.NoError(t, m.Unmarshal(b, ct, &v))
+	assert.Equal(t, testModel{"red", 30}, v)
+
+	v = testModel{}
+	require.NoError(t, m.UnmarshalReader(bytes.NewReader(b), ct, &v))
+	assert.Equal(t, testModel{"red", 30}, v)
+
+	require.Error(t, m.Unmarshal([]byte("garbage"), ct, &v))
+}
+
+func TestOctetStreamMarshaler_Marshal(t *testing.T) {
+	m := OctetStreamMarshaler{}
+
+	for _, in := range []interface{}{[]byte("red"), "red", strings.NewReader("red")} {
+		b, ct, err := m.Marshal(in)
+		require.NoError(t, err)
+		assert.Equal(t, MediaTypeOctetStream, ct)
+		assert.Equal(t, "red", string(b))
+	}
+
+	_, _, err := m.Marshal(testModel{})
+	require.Error(t, err)
+}
+
+func TestOctetStreamMarshaler_Unmarshal(t *testing.T) {
+	m := OctetStreamMarshaler{}
+
+	var b []byte
+	require.NoError(t, m.Unmarshal([]byte("red"), "", &b))
+	assert.Equal(t, "red", string(b))
+
+	var s string
+	require.NoError(t, m.Unmarshal([]byte("red"), "", &s))
+	assert.Equal(t, "red", s)
+
+	var buf bytes.Buffer
+	require.NoError(t, m.Unmarshal([]byte("red"), "", &buf))
+	assert.Equal(t, "red", buf.String())
+
+	buf.Reset()
+	require.NoError(t, m.UnmarshalReader(strings.NewReader("red"), "", &buf))
+	assert.Equal(t, "red", buf.String())
+
+	s = ""
+	require.NoError(t, m.UnmarshalReader(strings.NewReader("red"), "", &s))
+	assert.Equal(t, "red", s)
+
+	require.Error(t, m.Unmarshal([]byte("red"), "", &testModel{}))
+}
+
 func TestMultiUnmarshaler_Unmarshal(t *testing.T) {
 	m := MultiUnmarshaler{}
 
@@ -154,6 +359,27 @@ func TestContentTypeUnmarshaler_Unmarshal(t *testing.T) {
 		})
 	}
 
+	msgpackData, err := msgpack.Marshal(testModel{"red", 30})
+	require.NoError(t, err)
+
+	for _, ct := range []string{MediaTypeMsgPack, MediaTypeXMsgPack} {
+		t.Run(ct, func(t *testing.T) {
+			var v testModel
+			err := m.Unmarshal(msgpackData, ct, &v)
+			require.NoError(t, err)
+			require.Equal(t, testModel{"red", 30}, v)
+		})
+	}
+
+	t.Run(MediaTypeProtobuf, func(t *testing.T) {
+		data, err := proto.Marshal(wrapperspb.String("red"))
+		require.NoError(t, err)
+		var v wrapperspb.StringValue
+		err = m.Unmarshal(data, MediaTypeProtobuf, &v)
+		require.NoError(t, err)
+		require.Equal(t, "red", v.GetValue())
+	})
+
 	t.Run("unknown", func(t *testing.T) {
 		err := m.Unmarshal([]byte(`{"color":"red","count":30}`), "application/unknown", &testModel{})
 		require.Error(t, err)
@@ -165,6 +391,136 @@ func TestContentTypeUnmarshaler_Unmarshal(t *testing.T) {
 	})
 }
 
+func TestContentTypeUnmarshaler_Unmarshal_charset(t *testing.T) {
+	m := NewContentTypeUnmarshaler()
+
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(`{"color":"rouge écarlate"}`)
+	require.NoError(t, err)
+
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(`{"color":"rouge écarlate"}`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		input       string
+		contentType string
+	}{
+		{`{"color":"rouge écarlate"}`, "application/json"},
+		{`{"color":"rouge écarlate"}`, "application/json; charset=UTF-8"},
+		{latin1, "application/json; charset=ISO-8859-1"},
+		{latin1, "application/json; charset=latin1"},
+		{utf16, "application/json; charset=utf-16"},
+		{`{"color":"rouge écarlate"}`, "application/json; charset=unknown"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.contentType, func(t *testing.T) {
+			var v testModel
+			err := m.Unmarshal([]byte(c.input), c.contentType, &v)
+			require.NoError(t, err)
+			require.Equal(t, "rouge écarlate", v.Color)
+		})
+	}
+
+	t.Run("custom registry", func(t *testing.T) {
+		m := NewContentTypeUnmarshaler()
+		m.Charsets = map[string]encoding.Encoding{"x-custom": charmap.ISO8859_1}
+
+		var v testModel
+		err := m.Unmarshal([]byte(latin1), "application/json; charset=X-Custom", &v)
+		require.NoError(t, err)
+		require.Equal(t, "rouge écarlate", v.Color)
+
+		// default charsets are not consulted
+		v = testModel{}
+		err = m.Unmarshal([]byte(latin1), "application/json; charset=ISO-8859-1", &v)
+		require.NoError(t, err)
+		require.NotEqual(t, "rouge écarlate", v.Color)
+	})
+}
+
+func TestContentTypeUnmarshaler_Unmarshal_sniffAndFallback(t *testing.T) {
+	jsonData := []byte(" \n{\"color\":\"red\",\"count\":30}")
+	xmlData := []byte(`<testModel><color>red</color><count>30</count></testModel>`)
+
+	m := NewContentTypeUnmarshaler()
+
+	// without sniffing or a fallback, missing, invalid, or unsupported content types are errors
+	for _, ct := range []string{"", "application/json; charset", "text/plain"} {
+		var v testModel
+		require.Error(t, m.Unmarshal(jsonData, ct, &v), "content type: %q", ct)
+	}
+
+	m.Sniff = true
+
+	for _, ct := range []string{"", "application/json; charset", "text/plain"} {
+		t.Run("sniff "+ct, func(t *testing.T) {
+			var v testModel
+			require.NoError(t, m.Unmarshal(jsonData, ct, &v))
+			require.Equal(t, testModel{"red", 30}, v)
+
+			v = testModel{}
+			require.NoError(t, m.Unmarshal(xmlData, ct, &v))
+			require.Equal(t, testModel{"red", 30}, v)
+		})
+	}
+
+	// sniffing doesn't override a registered content type
+	var v testModel
+	require.Error(t, m.Unmarshal(xmlData, "application/json", &v))
+
+	// unrecognized data still fails without a fallback
+	require.Error(t, m.Unmarshal([]byte("red"), "", &v))
+
+	var called bool
+	m.Fallback = UnmarshalFunc(func(data []byte, contentType string, v interface{}) error {
+		called = true
+		return nil
+	})
+
+	require.NoError(t, m.Unmarshal([]byte("red"), "", &v))
+	require.True(t, called)
+
+	// fallback is used when sniffing is disabled
+	m.Sniff = false
+	m.Fallback = &JSONMarshaler{}
+	v = testModel{}
+	require.NoError(t, m.Unmarshal(jsonData, "", &v))
+	require.Equal(t, testModel{"red", 30}, v)
+}
+
+func TestContentTypeUnmarshaler_UnmarshalReader(t *testing.T) {
+	m := NewContentTypeUnmarshaler()
+
+	latin1, err := charmap.ISO8859_1.NewEncoder().String(`{"color":"rouge écarlate","count":30}`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name        string
+		input       string
+		contentType string
+		sniff       bool
+	}{
+		{"json", `{"color":"rouge écarlate","count":30}`, "application/json", false},
+		{"charset", latin1, "application/json; charset=ISO-8859-1", false},
+		{"nonstreaming", `<testModel><color>rouge écarlate</color><count>30</count></testModel>`, "application/xml", false},
+		{"sniff", ` {"color":"rouge écarlate","count":30}`, "", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m.Sniff = c.sniff
+			var v testModel
+			err := m.UnmarshalReader(strings.NewReader(c.input), c.contentType, &v)
+			require.NoError(t, err)
+			require.Equal(t, testModel{"rouge écarlate", 30}, v)
+		})
+	}
+
+	m.Sniff = false
+	var v testModel
+	require.Error(t, m.UnmarshalReader(strings.NewReader(`{}`), "text/plain", &v))
+}
+
 func TestContentTypeUnmarshaler_Apply(t *testing.T) {
 	r := MustNew()
 	r.Marshaler = nil
@@ -175,6 +531,188 @@ func TestContentTypeUnmarshaler_Apply(t *testing.T) {
 	assert.Equal(t, m, r.Unmarshaler)
 }
 
+func TestRegisterUnmarshaler(t *testing.T) {
+	defer func() {
+		registeredUnmarshalers = unmarshalerRegistry{}
+	}()
+
+	RegisterUnmarshaler("application/vnd.custom", &XMLMarshaler{})
+
+	var v testModel
+	_, _, err := Receive(&v, MockDoer(200,
+		ContentType("application/vnd.custom; charset=utf-8"),
+		Body(`<testModel><color>red</color><count>30</count></testModel>`),
+	))
+	require.NoError(t, err)
+	assert.Equal(t, testModel{"red", 30}, v)
+
+	// registered with all ContentTypeUnmarshalers, without modifying them
+	m := &ContentTypeUnmarshaler{}
+	v = testModel{}
+	require.NoError(t, m.Unmarshal([]byte(`<testModel><color>blue</color></testModel>`), "application/vnd.custom", &v))
+	assert.Equal(t, "blue", v.Color)
+	assert.Nil(t, m.Unmarshalers)
+	assert.Nil(t, DefaultUnmarshaler.(*ContentTypeUnmarshaler).Unmarshalers["application/vnd.custom"])
+
+	// a ContentTypeUnmarshaler's own Unmarshalers take precedence
+	m = &ContentTypeUnmarshaler{Unmarshalers: map[string]Unmarshaler{"application/vnd.custom": &JSONMarshaler{}}}
+	v = testModel{}
+	require.NoError(t, m.Unmarshal([]byte(`{"color":"green"}`), "application/vnd.custom", &v))
+	assert.Equal(t, "green", v.Color)
+
+	// concurrent registration and unmarshaling
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			RegisterUnmarshaler(fmt.Sprintf("application/vnd.custom%d", i), &JSONMarshaler{})
+		}(i)
+		go func() {
+			defer wg.Done()
+			var v testModel
+			assert.NoError(t, DefaultUnmarshaler.Unmarshal([]byte(`<testModel/>`), "application/vnd.custom", &v))
+		}()
+	}
+	wg.Wait()
+
+	// doesn't panic if the DefaultUnmarshaler has been replaced
+	defer func(u Unmarshaler) {
+		DefaultUnmarshaler = u
+	}(DefaultUnmarshaler)
+	DefaultUnmarshaler = &JSONMarshaler{}
+	assert.NotPanics(t, func() {
+		RegisterUnmarshaler("application/vnd.custom", &XMLMarshaler{})
+	})
+}
+
+func TestHeaderUnmarshaler(t *testing.T) {
+	h := &HeaderUnmarshaler{
+		Header: "X-Payload-Schema",
+		Unmarshalers: map[string]Unmarshaler{
+			"xml": &XMLMarshaler{},
+		},
+	}
+
+	cases := []struct {
+		name   string
+		header string
+		input  string
+	}{
+		{name: "match", header: "xml", input: `<testModel><color>red</color><count>30</count></testModel>`},
+		{name: "no match", header: "json", input: `{"color":"red","count":30}`},
+		{name: "missing", input: `{"color":"red","count":30}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var v testModel
+			_, _, err := Receive(&v,
+				h,
+				MockDoer(200,
+					ContentType(MediaTypeJSON),
+					Header("X-Payload-Schema", c.header),
+					Body(c.input),
+				),
+			)
+			require.NoError(t, err)
+			require.Equal(t, testModel{"red", 30}, v)
+		})
+	}
+
+	t.Run("direct", func(t *testing.T) {
+		var v testModel
+		err := h.Unmarshal([]byte(`{"color":"red","count":30}`), MediaTypeJSON, &v)
+		require.NoError(t, err)
+		require.Equal(t, testModel{"red", 30}, v)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		h := &HeaderUnmarshaler{Header: "X-Payload-Schema", Fallback: &XMLMarshaler{}}
+		var v testModel
+		_, _, err := Receive(&v, h, MockDoer(200,
+			ContentType(MediaTypeJSON),
+			Body(`<testModel><color>red</color><count>30</count></testModel>`),
+		))
+		require.NoError(t, err)
+		require.Equal(t, testModel{"red", 30}, v)
+	})
+}
+
+func TestContentTypeMarshaler(t *testing.T) {
+	r := MustNew(NewContentTypeMarshaler(), Body(testModel{"red", 30}))
+
+	cases := []struct {
+		contentType string
+		expected    string
+	}{
+		{"", `{"color":"red","count":30}`},
+		{MediaTypeJSON, `{"color":"red","count":30}`},
+		{"application/vnd.api+json; charset=UTF-8", `{"color":"red","count":30}`},
+		{MediaTypeXML, `<testModel><color>red</color><count>30</count></testModel>`},
+		{MediaTypeForm, `color=red&count=30`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.contentType, func(t *testing.T) {
+			var opts []Option
+			if c.contentType != "" {
+				opts = append(opts, ContentType(c.contentType))
+			}
+			req, err := r.Request(opts...)
+			require.NoError(t, err)
+
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, string(b))
+
+			if c.contentType != "" {
+				// an explicit Content-Type header always wins
+				assert.Equal(t, c.contentType, req.Header.Get(HeaderContentType))
+			} else {
+				assert.Equal(t, contentTypeJSON, req.Header.Get(HeaderContentType))
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := r.Request(ContentType("text/plain"))
+		require.Error(t, err)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		req, err := r.Request(WithMarshaler(&ContentTypeMarshaler{Default: &XMLMarshaler{}}))
+		require.NoError(t, err)
+		assert.Equal(t, contentTypeXML, req.Header.Get(HeaderContentType))
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		b, ct, err := NewContentTypeMarshaler().Marshal(testModel{"red", 30})
+		require.NoError(t, err)
+		assert.Equal(t, `{"color":"red","count":30}`, string(b))
+		assert.Equal(t, contentTypeJSON, ct)
+	})
+
+	t.Run("zero value", func(t *testing.T) {
+		// a shared zero value isn't modified, so concurrent requests don't race
+		m := &ContentTypeMarshaler{}
+		zr := MustNew(m, Body(testModel{"red", 30}))
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := zr.Request(ContentType(MediaTypeXML))
+				if assert.NoError(t, err) {
+					assert.Equal(t, MediaTypeXML, req.Header.Get(HeaderContentType))
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Nil(t, m.Marshalers)
+	})
+}
+
 func TestFormMarshaler_Marshal(t *testing.T) {
 
 	testCases := []struct {
@@ -215,6 +753,128 @@ func TestFormMarshaler_Marshal(t *testing.T) {
 	// assert.Equal(t, "color=red&count=30", string(d))
 }
 
+func TestFormMarshaler_Marshal_options(t *testing.T) {
+	type address struct {
+		City string `url:"city"`
+		Zip  string `url:"zip,omitempty"`
+	}
+
+	type Embedded struct {
+		Flag bool `url:"flag"`
+	}
+
+	type form struct {
+		Embedded
+		Name     string            `url:"name"`
+		Address  address           `url:"address"`
+		Previous *address          `url:"previous,omitempty"`
+		Tags     []string          `url:"tags"`
+		Scores   []int             `url:"scores,omitempty"`
+		Labels   map[string]string `url:"labels"`
+		Created  time.Time         `url:"created"`
+		Updated  time.Time         `url:"updated,omitempty"`
+		Ignored  string            `url:"-"`
+		private  string
+	}
+
+	v := form{
+		Embedded: Embedded{Flag: true},
+		Name:     "bob",
+		Address:  address{City: "Paris"},
+		Tags:     []string{"a", "b"},
+		Labels:   map[string]string{"x": "1", "y": "2"},
+		Created:  time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Ignored:  "ignored",
+		private:  "private",
+	}
+
+	cases := []struct {
+		name     string
+		m        FormMarshaler
+		expected url.Values
+	}{
+		{
+			name: "brackets",
+			m:    FormMarshaler{KeyStyle: FormKeyBrackets, TimeLayout: time.RFC3339},
+			expected: url.Values{
+				"flag":          {"true"},
+				"name":          {"bob"},
+				"address[city]": {"Paris"},
+				"tags":          {"a", "b"},
+				"labels[x]":     {"1"},
+				"labels[y]":     {"2"},
+				"created":       {"2021-03-04T05:06:07Z"},
+			},
+		},
+		{
+			name: "dotted",
+			m:    FormMarshaler{KeyStyle: FormKeyDotted},
+			expected: url.Values{
+				"flag":         {"true"},
+				"name":         {"bob"},
+				"address.city": {"Paris"},
+				"tags":         {"a", "b"},
+				"labels.x":     {"1"},
+				"labels.y":     {"2"},
+				"created":      {"2021-03-04T05:06:07Z"},
+			},
+		},
+		{
+			name: "delimiter and layout",
+			m:    FormMarshaler{KeyStyle: FormKeyBrackets, SliceDelimiter: ",", TimeLayout: "2006-01-02"},
+			expected: url.Values{
+				"flag":          {"true"},
+				"name":          {"bob"},
+				"address[city]": {"Paris"},
+				"tags":          {"a,b"},
+				"labels[x]":     {"1"},
+				"labels[y]":     {"2"},
+				"created":       {"2021-03-04"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, ct, err := c.m.Marshal(&v)
+			require.NoError(t, err)
+			assert.Equal(t, contentTypeForm, ct)
+			assert.Equal(t, c.expected.Encode(), string(d))
+		})
+	}
+
+	t.Run("not a struct", func(t *testing.T) {
+		m := FormMarshaler{KeyStyle: FormKeyDotted}
+		_, _, err := m.Marshal(5)
+		require.Error(t, err)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		m := FormMarshaler{KeyStyle: FormKeyDotted}
+		d, _, err := m.Marshal((*form)(nil))
+		require.NoError(t, err)
+		assert.Empty(t, d)
+	})
+
+	t.Run("query string", func(t *testing.T) {
+		// the default encodes with go-querystring, which doesn't support the other settings
+		m := FormMarshaler{KeyStyle: FormKeyQueryString}
+		d, _, err := m.Marshal(&v)
+		require.NoError(t, err)
+		assert.Contains(t, string(d), "address%5Bcity%5D=Paris")
+
+		m.SliceDelimiter = ","
+		_, _, err = m.Marshal(&v)
+		require.Error(t, err)
+	})
+
+	t.Run("invalid key style", func(t *testing.T) {
+		m := FormMarshaler{KeyStyle: FormKeyStyle(99)}
+		_, _, err := m.Marshal(&v)
+		require.Error(t, err)
+	})
+}
+
 func TestMarshalFunc_Apply(t *testing.T) {
 	var mf MarshalFunc = func(v interface{}) (bytes []byte, s string, e error) {
 		return nil, "red", nil
@@ -272,3 +932,10 @@ func ExampleXMLMarshaler() {
 	// <Resource><Color>red</Color></Resource>
 	// application/xml; charset=UTF-8
 }
+
+func assertBody(t *testing.T, req *http.Request, expected string) {
+	t.Helper()
+	b, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(b))
+}
@@ -1,12 +1,24 @@
 package requester
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"encoding/hex"
+	"fmt"
 	"github.com/ansel1/merry"
 	"io"
+	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // Middleware can be used to wrap Doers with additional functionality.
@@ -28,10 +40,31 @@ func Wrap(d Doer, m ...Middleware) Doer {
 }
 
 // Dump dumps requests and responses to a writer.  Just intended for debugging.
+//
+// The entire request and response bodies are dumped, and sensitive values are
+// redacted according to DefaultRedaction.  Use DumpWith to change that.
 func Dump(w io.Writer) Middleware {
+	return DumpWith(w)
+}
+
+// DumpWith is like Dump, but options can limit the size of dumped bodies, control how
+// binary bodies are dumped, and change what is redacted.
+//
+// If reading the request body fails, the request isn't sent, since the body has been
+// partially consumed, and the error is returned.
+func DumpWith(w io.Writer, opts ...DumpOption) Middleware {
+	c := dumpConfig{redaction: DefaultRedaction}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
 	return func(next Doer) Doer {
 		return DoerFunc(func(req *http.Request) (*http.Response, error) {
-			dump, dumperr := httputil.DumpRequestOut(req, true)
+			dumpReq, dump, dumperr := c.dumpRequest(req)
+			if dumpReq == nil {
+				return nil, merry.Prepend(dumperr, "dumping request body")
+			}
+			req = dumpReq
 			// Write the entire request and response out as a single Write() call
 			// So if this is being redirected to a logger, it's all sent in a single
 			// package
@@ -42,7 +75,11 @@ func Dump(w io.Writer) Middleware {
 			}
 			resp, err := next.Do(req)
 			if resp != nil {
-				dump, dumperr = httputil.DumpResponse(resp, true)
+				if c == (dumpConfig{}) {
+					dump, dumperr = httputil.DumpResponse(resp, true)
+				} else {
+					dump, dumperr = c.dumpResponse(resp)
+				}
 				if dumperr != nil {
 					_, _ = io.WriteString(w, "Error dumping response: "+dumperr.Error()+"\n")
 				} else {
@@ -54,16 +91,207 @@ func Dump(w io.Writer) Middleware {
 	}
 }
 
+// DumpOption configures the middleware returned by DumpWith.
+type DumpOption func(*dumpConfig)
+
+// DumpBinaryMode controls how Dump writes binary bodies.
+type DumpBinaryMode int
+
+const (
+	// DumpBinaryRaw dumps binary bodies as is.  This is the default.
+	DumpBinaryRaw DumpBinaryMode = iota
+	// DumpBinaryOmit replaces binary bodies with a placeholder.
+	DumpBinaryOmit
+	// DumpBinaryHex dumps binary bodies in the format of hex.Dump.
+	DumpBinaryHex
+)
+
+// DumpBodyLimit limits the number of body bytes dumped for each request and response.
+// Only that many bytes are buffered, so large bodies can be dumped without holding them in
+// memory.  n <= 0 means no limit.
+func DumpBodyLimit(n int) DumpOption {
+	return func(c *dumpConfig) {
+		c.bodyLimit = n
+	}
+}
+
+// DumpBinaryBodies sets how binary bodies are dumped.  Bodies are considered binary if they
+// have a Content-Encoding, like gzip, or if they aren't valid UTF-8 text.
+func DumpBinaryBodies(mode DumpBinaryMode) DumpOption {
+	return func(c *dumpConfig) {
+		c.binary = mode
+	}
+}
+
+// DumpRedaction sets the values which are redacted from dumps.  nil disables redaction.
+// JSON fields aren't redacted from bodies truncated by DumpBodyLimit, since they can't
+// be parsed.
+func DumpRedaction(redaction *Redaction) DumpOption {
+	return func(c *dumpConfig) {
+		c.redaction = redaction
+	}
+}
+
+type dumpConfig struct {
+	bodyLimit int
+	binary    DumpBinaryMode
+	redaction *Redaction
+}
+
+// dumpRequest dumps the request, and returns the request to send in its place.  If
+// reading the body fails, the returned request is nil: the body has been partially
+// consumed, so the request can't be sent.
+func (c dumpConfig) dumpRequest(req *http.Request) (*http.Request, []byte, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if c == (dumpConfig{}) {
+		dump, err := httputil.DumpRequestOut(req, true)
+		if err != nil && hasBody {
+			return nil, nil, err
+		}
+		return req, dump, err
+	}
+
+	view := req
+	if c.redaction != nil {
+		// shallow copy, so only the dumped request is redacted
+		r2 := *req
+		r2.Header = c.redaction.Header(req.Header)
+		r2.URL = c.redaction.URL(req.URL)
+		view = &r2
+	}
+
+	head, err := httputil.DumpRequestOut(view, false)
+	if err != nil || !hasBody {
+		return req, head, err
+	}
+
+	prefix, truncated, body, err := peekBody(req.Body, c.bodyLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// shallow copy, so the caller's request isn't modified
+	r2 := *req
+	r2.Body = body
+
+	return &r2, c.formatBody(head, req.Header, prefix, truncated), nil
+}
+
+func (c dumpConfig) dumpResponse(resp *http.Response) ([]byte, error) {
+	view := resp
+	if c.redaction != nil {
+		r2 := *resp
+		r2.Header = c.redaction.Header(resp.Header)
+		view = &r2
+	}
+
+	head, err := httputil.DumpResponse(view, false)
+	if err != nil || resp.Body == nil || resp.Body == http.NoBody {
+		return head, err
+	}
+
+	prefix, truncated, body, err := peekBody(resp.Body, c.bodyLimit)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = body
+
+	return c.formatBody(head, resp.Header, prefix, truncated), nil
+}
+
+func (c dumpConfig) formatBody(head []byte, header http.Header, body []byte, truncated bool) []byte {
+	buf := bytes.NewBuffer(head)
+
+	switch {
+	case len(body) == 0:
+	case c.binary != DumpBinaryRaw && isBinaryBody(header, body, truncated):
+		if c.binary == DumpBinaryOmit {
+			fmt.Fprintf(buf, "[binary body omitted]")
+		} else {
+			buf.WriteString(hex.Dump(body))
+		}
+	case truncated:
+		// a partial document can't be parsed, so it can't be redacted
+		buf.Write(body)
+	default:
+		buf.Write(c.redaction.Body(header.Get(HeaderContentType), body))
+	}
+
+	if truncated {
+		fmt.Fprintf(buf, "\n[body truncated to %d bytes]", len(body))
+	}
+	return buf.Bytes()
+}
+
+func isBinaryBody(header http.Header, body []byte, truncated bool) bool {
+	if ce := header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		return true
+	}
+
+	if truncated {
+		// the prefix may end in the middle of a rune
+		for i := 0; i < utf8.UTFMax && len(body) > 0 && !utf8.Valid(body); i++ {
+			body = body[:len(body)-1]
+		}
+	}
+
+	return !utf8.Valid(body) || bytes.IndexByte(body, 0) > -1
+}
+
+// peekBody reads up to n bytes from body, or all of body if n <= 0.  It returns the bytes
+// read, whether there were more bytes in body, and a ReadCloser which replays the
+// bytes read, followed by the rest of body.
+func peekBody(body io.ReadCloser, n int) (prefix []byte, truncated bool, replay io.ReadCloser, err error) {
+	if n <= 0 {
+		prefix, err = ioutil.ReadAll(body)
+		_ = body.Close()
+		if err != nil {
+			return nil, false, nil, err
+		}
+		return prefix, false, ioutil.NopCloser(bytes.NewReader(prefix)), nil
+	}
+
+	// read one byte more than the limit, to find out if there's more
+	buf := make([]byte, n+1)
+	read, err := io.ReadFull(body, buf)
+	switch err {
+	case nil, io.EOF, io.ErrUnexpectedEOF:
+	default:
+		return nil, false, nil, err
+	}
+	buf = buf[:read]
+
+	replay = &struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), body), body}
+
+	if read > n {
+		return buf[:n], true, replay, nil
+	}
+	return buf, false, replay, nil
+}
+
 // DumpToStout dumps requests and responses to os.Stdout
 func DumpToStout() Middleware {
 	return Dump(os.Stdout)
 }
 
+// DumpToStoutWith dumps requests and responses to os.Stdout, with options.  See DumpWith.
+func DumpToStoutWith(opts ...DumpOption) Middleware {
+	return DumpWith(os.Stdout, opts...)
+}
+
 // DumpToStderr dumps requests and responses to os.Stderr
 func DumpToStderr() Middleware {
 	return Dump(os.Stderr)
 }
 
+// DumpToStderrWith dumps requests and responses to os.Stderr, with options.  See DumpWith.
+func DumpToStderrWith(opts ...DumpOption) Middleware {
+	return DumpWith(os.Stderr, opts...)
+}
+
 type logFunc func(a ...interface{})
 
 func (f logFunc) Write(p []byte) (n int, err error) {
@@ -82,6 +310,47 @@ func DumpToLog(logf func(a ...interface{})) Middleware {
 	return Dump(logFunc(logf))
 }
 
+// DumpToLogWith dumps the request and response to a logging function, with options.
+// See DumpToLog and DumpWith.
+func DumpToLogWith(logf func(a ...interface{}), opts ...DumpOption) Middleware {
+	return DumpWith(logFunc(logf), opts...)
+}
+
+// CaptureWire records the serialized bytes of the outgoing request into buf, using
+// httputil.DumpRequestOut, which renders the request as the http.Transport
+// would write it to the wire.  buf is reset before each request is
+// recorded, so after sending, buf holds the last request sent.  This is
+// useful for debugging request signatures, or for byte-exact contract tests:
+//
+//	var buf bytes.Buffer
+//	resp, err := reqs.Send(requester.CaptureWire(&buf))
+//	fmt.Println(buf.String())
+//
+// It sees the request after the middleware installed before it, so it should be
+// installed last, to capture the request as it's sent to the Doer (including each
+// attempt of the Retry middleware).  Writes to buf are serialized, so the middleware can
+// be used by concurrent requests, but buf shouldn't be read until they are done.
+//
+// If the request can't be dumped, buf will be left empty, and the request will
+// still be sent.
+func CaptureWire(buf *bytes.Buffer) Middleware {
+	var mu sync.Mutex
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			dump, err := httputil.DumpRequestOut(req, true)
+
+			mu.Lock()
+			buf.Reset()
+			if err == nil {
+				buf.Write(dump)
+			}
+			mu.Unlock()
+
+			return next.Do(req)
+		})
+	}
+}
+
 // ExpectCode generates an error if the response's status code does not match
 // the expected code.
 //
@@ -114,7 +383,10 @@ func ExpectSuccessCode() Middleware {
 
 type ctxKey int
 
-const expectCodeCtxKey ctxKey = iota
+const (
+	expectCodeCtxKey ctxKey = iota
+	expectContentTypeCtxKey
+)
 
 const expectSuccessCode = -1
 
@@ -147,3 +419,279 @@ func getCodeChecker(req *http.Request) (*http.Request, *codeChecker) {
 	}
 	return req, c
 }
+
+// ExpectContentType is middleware which generates an error if the response's Content-Type
+// doesn't match one of the expected media types.  This catches responses which can't be
+// unmarshaled early, like HTML error pages from a proxy, masquerading as API responses.
+//
+// Media types are compared case-insensitively, ignoring parameters like charset.  An expected
+// type with a wildcard subtype, like "text/*", matches any subtype, and "*/*" matches any
+// content type, even a missing one.  Responses without a body, like 204 No Content, aren't
+// checked.  If ExpectContentType is applied more than once, the latest one wins.
+//
+// The response body will still be read and returned.
+func ExpectContentType(contentTypes ...string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			r, c := getContentTypeChecker(req)
+			c.contentTypes = contentTypes
+			resp, err := next.Do(r)
+			return c.checkContentType(resp, err)
+		})
+	}
+}
+
+type contentTypeChecker struct {
+	contentTypes []string
+}
+
+func (c *contentTypeChecker) checkContentType(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil || resp == nil || resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified || resp.ContentLength == 0 {
+		return resp, err
+	}
+
+	ct := resp.Header.Get(HeaderContentType)
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	for _, expected := range c.contentTypes {
+		if mt, _, perr := mime.ParseMediaType(expected); perr == nil {
+			expected = mt
+		}
+		if expected == "*/*" || mediaType == expected ||
+			strings.HasSuffix(expected, "/*") && strings.HasPrefix(mediaType, expected[:len(expected)-1]) {
+			return resp, nil
+		}
+	}
+
+	return resp, merry.Errorf("server returned unexpected content type.  expected: %s, received: %s",
+		strings.Join(c.contentTypes, ", "), ct)
+}
+
+func getContentTypeChecker(req *http.Request) (*http.Request, *contentTypeChecker) {
+	c, _ := req.Context().Value(expectContentTypeCtxKey).(*contentTypeChecker)
+	if c == nil {
+		c = &contentTypeChecker{}
+		req = req.WithContext(context.WithValue(req.Context(), expectContentTypeCtxKey, c))
+	}
+	return req, c
+}
+
+// CompressRequest is middleware which compresses the request body, and sets the Content-Encoding
+// header.  Supported encodings are "gzip" and "deflate".
+//
+// The compressed body is buffered in memory, so Content-Length is set, and GetBody returns the
+// compressed body.  If CompressRequest is installed inside the Retry middleware, each attempt is
+// compressed again from the original body.
+//
+// Requests with no body, or which already have a Content-Encoding header, are sent unchanged.
+func CompressRequest(encoding string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+				return next.Do(req)
+			}
+
+			data, err := compress(encoding, req.Body)
+			if err != nil {
+				return nil, err
+			}
+
+			req = req.Clone(req.Context())
+			req.Header.Set("Content-Encoding", encoding)
+			req.ContentLength = int64(len(data))
+			req.Body = io.NopCloser(bytes.NewReader(data))
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}
+
+			return next.Do(req)
+		})
+	}
+}
+
+// compress reads and closes body, and returns the compressed data.
+func compress(encoding string, body io.ReadCloser) ([]byte, error) {
+	defer body.Close()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		// the HTTP deflate encoding is actually the zlib format
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, merry.Errorf("unsupported content encoding: %s", encoding)
+	}
+
+	if _, err := io.Copy(w, body); err != nil {
+		return nil, merry.Prepend(err, "compressing request body")
+	}
+	if err := w.Close(); err != nil {
+		return nil, merry.Prepend(err, "compressing request body")
+	}
+	return buf.Bytes(), nil
+}
+
+// ErrBodyTooLarge is returned when reading a response body which exceeds a configured
+// maximum size: Requester.MaxResponseBody, or the MaxSize of the Decompress middleware.
+// nolint:gochecknoglobals
+var ErrBodyTooLarge = merry.New("response body exceeds max size")
+
+// DecompressOption configures the Decompress middleware.
+type DecompressOption func(*decompressConfig)
+
+type decompressConfig struct {
+	maxSize int64
+}
+
+// MaxSize limits the size of the decompressed response body to n bytes.  Reading
+// beyond the limit returns ErrBodyTooLarge.  This guards against decompression bombs
+// from untrusted servers.  n <= 0 means no limit.
+func MaxSize(n int64) DecompressOption {
+	return func(c *decompressConfig) {
+		c.maxSize = n
+	}
+}
+
+// Decompress is middleware which decompresses response bodies encoded with gzip or deflate.
+// If the request has no Accept-Encoding header, it's set to "gzip, deflate".
+//
+// Decompressed responses have the Content-Encoding and Content-Length headers removed,
+// and resp.Uncompressed set to true.  Responses with other encodings are returned unchanged.
+func Decompress(opts ...DecompressOption) Middleware {
+	var c decompressConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Accept-Encoding") == "" {
+				req = req.Clone(req.Context())
+				req.Header.Set("Accept-Encoding", "gzip, deflate")
+			}
+
+			resp, err := next.Do(req)
+			if err != nil || resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+				return resp, err
+			}
+
+			var r io.ReadCloser
+			switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+			case "gzip":
+				r, err = gzip.NewReader(resp.Body)
+			case "deflate":
+				r, err = zlib.NewReader(resp.Body)
+			default:
+				return resp, nil
+			}
+			if err != nil {
+				_ = resp.Body.Close()
+				return resp, merry.Prepend(err, "decompressing response body")
+			}
+
+			var body io.Reader = r
+			if c.maxSize > 0 {
+				body = &maxSizeReader{r: r, n: c.maxSize}
+			}
+
+			resp.Body = &decompressedBody{Reader: body, decompressor: r, body: resp.Body}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			resp.Uncompressed = true
+			return resp, nil
+		})
+	}
+}
+
+type decompressedBody struct {
+	io.Reader
+	decompressor io.Closer
+	body         io.Closer
+}
+
+func (d *decompressedBody) Close() error {
+	_ = d.decompressor.Close()
+	return d.body.Close()
+}
+
+// maxSizeReader returns ErrBodyTooLarge if more than n bytes are read from r.
+type maxSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	// read one byte past the limit, to detect whether there's more
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+	n, err := m.r.Read(p)
+	if int64(n) > m.n {
+		n = int(m.n)
+		m.n = 0
+		return n, ErrBodyTooLarge.Here()
+	}
+	m.n -= int64(n)
+	return n, err
+}
+
+// RouteByHost is middleware which sends each request through a different chain of middleware,
+// depending on the request's host, so a single Requester can talk to several backends with
+// different policies, like authentication or retries:
+//
+//	requester.RouteByHost(map[string][]requester.Middleware{
+//		"api.example.com":  {requester.Retry(nil)},
+//		"*.internal.local":  {requester.DumpToLog(log.Println)},
+//	})
+//
+// Keys are matched against the request URL's host, case-insensitively.  A key with a port
+// only matches requests to that port, and takes precedence over a key without a port.  A key
+// starting with "*." matches any subdomain of the rest of the key, and the longest matching
+// wildcard wins.  The chain under the key "*" applies to requests which match no other key.
+// Requests which match no key at all are passed to the next Doer unchanged.
+func RouteByHost(routes map[string][]Middleware) Middleware {
+	return func(next Doer) Doer {
+		doers := make(map[string]Doer, len(routes))
+		for host, mw := range routes {
+			doers[strings.ToLower(host)] = Wrap(next, mw...)
+		}
+
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if d := routeHost(doers, req.URL); d != nil {
+				return d.Do(req)
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+func routeHost(doers map[string]Doer, u *url.URL) Doer {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+
+	lookup := func(h string) Doer {
+		if port != "" {
+			if d, ok := doers[net.JoinHostPort(h, port)]; ok {
+				return d
+			}
+		}
+		return doers[h]
+	}
+
+	if d := lookup(host); d != nil {
+		return d
+	}
+
+	// wildcards, from the longest suffix to the shortest
+	for h := host; strings.Contains(h, "."); {
+		h = h[strings.Index(h, ".")+1:]
+		if d := lookup("*." + h); d != nil {
+			return d
+		}
+	}
+
+	return doers["*"]
+}
@@ -2,16 +2,22 @@ package requester
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
 	"fmt"
 	"github.com/ansel1/merry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
+	"io/ioutil"
 	sdklog "log"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -34,6 +40,124 @@ func TestDump(t *testing.T) {
 	assert.Contains(t, b.String(), `{"color":"red"}`)
 }
 
+func TestDump_options(t *testing.T) {
+	binary := []byte{0x1f, 0x8b, 0x00, 0xff, 0xfe}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// echo the request body, to make sure it's passed through intact
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer ts.Close()
+
+	t.Run("limit", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, body, err := Receive(Post(ts.URL), Body("redgreenblue"), DumpWith(b, DumpBodyLimit(5)))
+		require.NoError(t, err)
+
+		assert.Equal(t, "redgreenblue", string(body))
+		assert.Contains(t, b.String(), "POST / HTTP/1.1")
+		assert.Contains(t, b.String(), "HTTP/1.1 200 OK")
+		assert.Contains(t, b.String(), "redgr\n[body truncated to 5 bytes]")
+		assert.NotContains(t, b.String(), "redgreen")
+	})
+
+	t.Run("under limit", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, body, err := Receive(Post(ts.URL), Body("red"), DumpWith(b, DumpBodyLimit(5)))
+		require.NoError(t, err)
+
+		assert.Equal(t, "red", string(body))
+		assert.NotContains(t, b.String(), "truncated")
+	})
+
+	t.Run("omit binary", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, body, err := Receive(Post(ts.URL), Body(binary), DumpWith(b, DumpBinaryBodies(DumpBinaryOmit)))
+		require.NoError(t, err)
+
+		assert.Equal(t, binary, body)
+		assert.Equal(t, 2, strings.Count(b.String(), "[binary body omitted]"))
+	})
+
+	t.Run("hex binary", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, _, err := Receive(Post(ts.URL), Body(binary), DumpWith(b, DumpBinaryBodies(DumpBinaryHex)))
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, strings.Count(b.String(), hex.Dump(binary)))
+	})
+
+	t.Run("text is not binary", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, _, err := Receive(Post(ts.URL), Body("rouge écarlate"), DumpWith(b, DumpBinaryBodies(DumpBinaryOmit), DumpBodyLimit(7)))
+		require.NoError(t, err)
+
+		// the limit splits the é, but it's still text
+		assert.NotContains(t, b.String(), "[binary body omitted]")
+		assert.Contains(t, b.String(), "[body truncated to 7 bytes]")
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		_, _, err := Receive(Post(ts.URL), Body("red"), Header("Content-Encoding", "gzip"), DumpWith(b, DumpBinaryBodies(DumpBinaryOmit)))
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, strings.Count(b.String(), "[binary body omitted]"))
+	})
+
+	t.Run("request body error", func(t *testing.T) {
+		var sent bool
+		doer := DoerFunc(func(req *http.Request) (*http.Response, error) {
+			sent = true
+			return MockResponse(200), nil
+		})
+		body := io.MultiReader(strings.NewReader("red"), &errReader{merry.New("boom")})
+
+		for _, m := range []Middleware{Dump(ioutil.Discard), DumpWith(ioutil.Discard, DumpBodyLimit(10))} {
+			_, err := Send(Post("http://example.com"), WithDoer(doer), Body(body), m)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "boom")
+			assert.False(t, sent, "partially read request shouldn't be sent")
+		}
+	})
+}
+
+// the original signatures are kept, so they can be used as function values
+var (
+	_ func(io.Writer) Middleware              = Dump
+	_ func() Middleware                       = DumpToStout
+	_ func() Middleware                       = DumpToStderr
+	_ func(func(a ...interface{})) Middleware = DumpToLog
+)
+
+func TestDump_redaction(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Write([]byte(`{"access_token":"xyz","color":"red"}`))
+	}))
+	defer ts.Close()
+
+	b := &bytes.Buffer{}
+	_, body, err := Receive(Post(ts.URL), BearerAuth("xyz"), QueryParam("api_key", "xyz"), JSON(false), Body(map[string]string{"password": "secret"}), Dump(b))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), "xyz", "response should not be modified")
+	assert.NotContains(t, b.String(), "xyz")
+	assert.NotContains(t, b.String(), "secret")
+	assert.NotContains(t, b.String(), "session=abc")
+	assert.Contains(t, b.String(), "Authorization: REDACTED")
+	assert.Contains(t, b.String(), "api_key=REDACTED")
+	assert.Contains(t, b.String(), `{"password":"REDACTED"}`)
+	assert.Contains(t, b.String(), `"color":"red"`)
+
+	b.Reset()
+	_, _, err = Receive(Get(ts.URL), BearerAuth("xyz"), DumpWith(b, DumpRedaction(nil)))
+	require.NoError(t, err)
+	assert.Contains(t, b.String(), "Authorization: Bearer xyz")
+}
+
 func TestDumpToLog(t *testing.T) {
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -122,6 +246,58 @@ func TestDumpToSterr(t *testing.T) {
 	assert.Contains(t, out, `{"color":"red"}`)
 }
 
+func TestCaptureWire(t *testing.T) {
+	ts := httptest.NewServer(MockHandler(200, Body("pong")))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+
+	mw := Middleware(func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Set("X-Signature", "abc")
+			return next.Do(req)
+		})
+	})
+
+	_, body, err := Receive(
+		Post(ts.URL, "/resources"),
+		Body("ping"),
+		mw,
+		CaptureWire(&buf),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(body))
+
+	s := buf.String()
+	assert.Contains(t, s, "POST /resources HTTP/1.1\r\n")
+	assert.Contains(t, s, "X-Signature: abc\r\n")
+	assert.True(t, strings.HasSuffix(s, "\r\n\r\nping"), s)
+
+	// buffer is reset for each request
+	_, _, err = Receive(Get(ts.URL), CaptureWire(&buf))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), "GET / HTTP/1.1"))
+	assert.NotContains(t, buf.String(), "ping")
+
+	// concurrent requests (run with -race)
+	reqs := MustNew(Get(ts.URL), CaptureWire(&buf))
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := reqs.Receive(nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, strings.HasPrefix(buf.String(), "GET / HTTP/1.1"))
+
+	// the Doer isn't replaced, so NoDefaultClient still applies
+	_, err = Send(Get(ts.URL), NoDefaultClient(), CaptureWire(&buf))
+	assert.True(t, merry.Is(err, ErrDefaultClient))
+}
+
 func TestExpectCode(t *testing.T) {
 
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -165,6 +341,50 @@ func TestExpectCode(t *testing.T) {
 
 }
 
+func TestExpectContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Path == "/untyped" {
+			w.Header()["Content-Type"] = nil
+			w.Write([]byte("hi"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(502)
+		w.Write([]byte("<html>bad gateway</html>"))
+	}))
+	defer ts.Close()
+
+	r := MustNew(Get(ts.URL), ExpectContentType("application/json"))
+
+	resp, body, err := r.Receive(nil)
+	// body and response should still be returned
+	assert.Equal(t, 502, resp.StatusCode)
+	assert.Equal(t, "<html>bad gateway</html>", string(body))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected: application/json")
+	assert.Contains(t, err.Error(), "received: text/html; charset=utf-8")
+
+	// parameters are ignored, and wildcards match any subtype
+	for _, ct := range []string{"TEXT/HTML", "text/html; charset=iso-8859-1", "text/*", "*/*"} {
+		_, _, err = r.Receive(ExpectContentType("application/json", ct))
+		assert.NoError(t, err, ct)
+	}
+
+	// */* matches a missing content type too
+	_, _, err = r.Receive(Get("/untyped"))
+	require.Error(t, err)
+	_, _, err = r.Receive(Get("/untyped"), ExpectContentType("*/*"))
+	require.NoError(t, err)
+
+	// responses without bodies aren't checked
+	_, _, err = r.Receive(Get("/empty"))
+	require.NoError(t, err)
+}
+
 func TestExpectSuccessCode(t *testing.T) {
 
 	codeToReturn := 407
@@ -260,3 +480,219 @@ func ExampleExpectCode() {
 
 	// Output: server returned unexpected status code.  expected: 201, received: 400
 }
+
+func TestCompressRequest(t *testing.T) {
+	var encodings []string
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+
+		var rd io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			rd = gz
+		case "deflate":
+			zr, err := zlib.NewReader(r.Body)
+			require.NoError(t, err)
+			rd = zr
+		}
+		b, err := io.ReadAll(rd)
+		require.NoError(t, err)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(500)
+	}))
+	defer ts.Close()
+
+	for _, enc := range []string{"gzip", "deflate"} {
+		t.Run(enc, func(t *testing.T) {
+			encodings, bodies = nil, nil
+
+			// retried requests are compressed again
+			resp, _, err := Receive(Post(ts.URL), Body(map[string]string{"color": "red"}),
+				Retry(&RetryConfig{MaxAttempts: 2, Backoff: NoBackoff()}),
+				CompressRequest(enc),
+			)
+			require.NoError(t, err)
+			assert.Equal(t, 500, resp.StatusCode)
+			assert.Equal(t, []string{enc, enc}, encodings)
+			assert.Equal(t, []string{`{"color":"red"}`, `{"color":"red"}`}, bodies)
+		})
+	}
+
+	t.Run("already encoded", func(t *testing.T) {
+		encodings, bodies = nil, nil
+		_, _, err := Receive(Post(ts.URL), Body("red"), Header("Content-Encoding", "identity"), CompressRequest("gzip"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"identity"}, encodings)
+		assert.Equal(t, []string{"red"}, bodies)
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		encodings, bodies = nil, nil
+		_, _, err := Receive(Get(ts.URL), CompressRequest("gzip"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{""}, encodings)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, _, err := Receive(Post(ts.URL), Body("red"), CompressRequest("br"))
+		require.Error(t, err)
+	})
+}
+
+func TestDecompress(t *testing.T) {
+	payload := strings.Repeat("red", 1000)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip, deflate", r.Header.Get("Accept-Encoding"))
+
+		var buf bytes.Buffer
+		var zw io.WriteCloser
+		switch r.URL.Path {
+		case "/gzip":
+			zw = gzip.NewWriter(&buf)
+		case "/deflate":
+			zw = zlib.NewWriter(&buf)
+		default:
+			w.Write([]byte(payload))
+			return
+		}
+		zw.Write([]byte(payload))
+		zw.Close()
+		w.Header().Set("Content-Encoding", r.URL.Path[1:])
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	for _, enc := range []string{"gzip", "deflate", "identity"} {
+		t.Run(enc, func(t *testing.T) {
+			resp, body, err := Receive(Get(ts.URL, enc), Decompress())
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(body))
+			assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		})
+	}
+
+	t.Run("max size", func(t *testing.T) {
+		_, _, err := Receive(Get(ts.URL, "gzip"), Decompress(MaxSize(int64(len(payload)))))
+		require.NoError(t, err)
+
+		_, _, err = Receive(Get(ts.URL, "gzip"), Decompress(MaxSize(int64(len(payload)-1))))
+		require.Error(t, err)
+		assert.True(t, merry.Is(err, ErrBodyTooLarge))
+	})
+}
+
+func TestNamedMiddleware(t *testing.T) {
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				return next.Do(req)
+			})
+		}
+	}
+
+	var doer DoerFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}
+
+	send := func(t *testing.T, r *Requester) []string {
+		t.Helper()
+		calls = nil
+		_, err := r.Send()
+		require.NoError(t, err)
+		return calls
+	}
+
+	base := MustNew(doer, UseNamed("a", mw("a")), Use(mw("unnamed")), UseNamed("b", mw("b")))
+	assert.Equal(t, []string{"a", "", "b"}, base.MiddlewareNames())
+	assert.Equal(t, []string{"a", "unnamed", "b"}, send(t, base))
+
+	err := base.Apply(UseNamed("a", mw("a2")))
+	require.Error(t, err, "duplicate names should be rejected")
+
+	r := base.Clone()
+	require.NoError(t, r.ReplaceMiddleware("a", mw("a2")))
+	require.NoError(t, r.InsertBefore("b", "c", mw("c")))
+	require.NoError(t, r.InsertAfter("b", "", mw("d")))
+	require.NoError(t, r.RemoveMiddleware("a"))
+	assert.Equal(t, []string{"", "c", "b", ""}, r.MiddlewareNames())
+	assert.Equal(t, []string{"unnamed", "c", "b", "d"}, send(t, r))
+
+	// the original isn't affected
+	assert.Equal(t, []string{"a", "unnamed", "b"}, send(t, base))
+
+	err = r.RemoveMiddleware("a")
+	assert.True(t, merry.Is(err, ErrMiddlewareNotFound))
+	assert.True(t, merry.Is(r.ReplaceMiddleware("", mw("x")), ErrMiddlewareNotFound))
+	assert.Error(t, r.InsertAfter("b", "c", mw("c")), "duplicate names should be rejected")
+
+	// middleware appended directly is unnamed
+	r.Middleware = append(r.Middleware, mw("e"))
+	assert.Equal(t, []string{"", "c", "b", "", ""}, r.MiddlewareNames())
+	require.NoError(t, r.InsertBefore("c", "f", mw("f")))
+	assert.Equal(t, []string{"", "f", "c", "b", "", ""}, r.MiddlewareNames())
+	assert.Equal(t, []string{"unnamed", "f", "c", "b", "d", "e"}, send(t, r))
+
+	assert.Equal(t, []string{"f", "c", "b"}, r.Describe().Middleware[1:4])
+}
+
+func TestRouteByHost(t *testing.T) {
+	var routes []string
+	tag := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				routes = append(routes, name)
+				return next.Do(req)
+			})
+		}
+	}
+
+	var doer DoerFunc = func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}
+
+	mw := RouteByHost(map[string][]Middleware{
+		"api.example.com":      {tag("api")},
+		"api.example.com:8443": {tag("api8443")},
+		"*.example.com":        {tag("example")},
+		"*.b.example.com":      {tag("b")},
+	})
+
+	tests := []struct {
+		url      string
+		expected []string
+	}{
+		{"http://api.example.com/x", []string{"api"}},
+		{"http://API.Example.com:8080/x", []string{"api"}},
+		{"https://api.example.com:8443/x", []string{"api8443"}},
+		{"http://www.example.com/x", []string{"example"}},
+		{"http://a.b.example.com/x", []string{"b"}},
+		{"http://example.com/x", nil},
+		{"http://other.com/x", nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			routes = nil
+			_, err := Send(Get(tc.url), doer, mw)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, routes)
+		})
+	}
+
+	t.Run("default", func(t *testing.T) {
+		routes = nil
+		mw := RouteByHost(map[string][]Middleware{
+			"api.example.com": {tag("api")},
+			"*":               {tag("default"), tag("default2")},
+		})
+		_, err := Send(Get("http://other.com"), doer, mw)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"default", "default2"}, routes)
+	})
+}
@@ -0,0 +1,144 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// MockRouter is an http.Handler which routes requests to mocked responses by method and
+// path, for writing tests.  Create one with MockMux.
+type MockRouter struct {
+	mu     sync.Mutex
+	routes []*MockRoute
+}
+
+// MockMux returns a MockRouter, a lightweight mock server:
+//
+//	m := requester.MockMux()
+//	users := m.On("GET", "/users/{id}").Respond(200, requester.JSON(false), requester.Body(u))
+//	m.On("DELETE", "/users/{id}").Respond(204)
+//
+//	ts := httptest.NewServer(m)
+//	// ... send requests
+//	assert.Equal(t, 1, users.Calls())
+//
+// Requests which match no route get a 404 Not Found.
+func MockMux() *MockRouter {
+	return &MockRouter{}
+}
+
+// On adds a route, which matches requests with the method and path.  If method is "" or
+// "*", it matches any method.  Path segments like "{id}" match any single segment, and
+// capture it as a path param, available to handlers from MockPathParam.  Routes are
+// matched in the order they were added.
+//
+// Until a response is set with Respond, RespondSeq, or Handle, the route responds with
+// 200 OK and no body.
+func (m *MockRouter) On(method, path string) *MockRoute {
+	route := &MockRoute{
+		method:   method,
+		segments: strings.Split(strings.Trim(path, "/"), "/"),
+		handler:  MockHandler(http.StatusOK),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, route)
+	return route
+}
+
+// ServeHTTP implements http.Handler.
+func (m *MockRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	routes := m.routes
+	m.mu.Unlock()
+
+	for _, route := range routes {
+		params, ok := route.match(r)
+		if !ok {
+			continue
+		}
+		route.mu.Lock()
+		route.calls++
+		handler := route.handler
+		route.mu.Unlock()
+
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), mockPathParamsCtxKey{}, params))
+		}
+		handler.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "no mock route for "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+}
+
+// MockRoute is a route added to a MockRouter with On.
+type MockRoute struct {
+	method   string
+	segments []string
+
+	mu      sync.Mutex
+	handler http.Handler
+	calls   int
+}
+
+// Respond sets the route's response, built from the args like MockHandler.
+func (r *MockRoute) Respond(statusCode int, options ...Option) *MockRoute {
+	return r.Handle(MockHandler(statusCode, options...))
+}
+
+// RespondSeq sets the route's responses to a sequence, like MockHandlerSeq.
+func (r *MockRoute) RespondSeq(specs ...MockSpec) *MockRoute {
+	return r.Handle(MockHandlerSeq(specs...))
+}
+
+// Handle sets the handler for the route's requests.
+func (r *MockRoute) Handle(h http.Handler) *MockRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handler = h
+	return r
+}
+
+// Calls returns the number of requests the route has matched.
+func (r *MockRoute) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func (r *MockRoute) match(req *http.Request) (map[string]string, bool) {
+	if r.method != "" && r.method != "*" && !strings.EqualFold(r.method, req.Method) {
+		return nil, false
+	}
+
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type mockPathParamsCtxKey struct{}
+
+// MockPathParam returns the value of a path param captured by a MockRouter route, like
+// the "id" in "/users/{id}", from a request passed to the route's handler.
+func MockPathParam(req *http.Request, name string) string {
+	params, _ := req.Context().Value(mockPathParamsCtxKey{}).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,64 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockMux(t *testing.T) {
+	m := MockMux()
+	getUser := m.On("GET", "/users/{id}").Respond(200, JSON(false), Body(map[string]string{"name": "bob"}))
+	deleteUser := m.On("DELETE", "/users/{id}").Respond(204)
+	orders := m.On("*", "/users/{id}/orders/{order}").Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(MockPathParam(r, "id") + ":" + MockPathParam(r, "order")))
+	}))
+	seq := m.On("", "/flaky").RespondSeq(NewMockSpec(503), NewMockSpec(200).Repeated())
+	m.On("GET", "/default")
+
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	r := MustNew(URL(ts.URL))
+
+	var user map[string]string
+	resp, _, err := r.Receive(&user, Get("/users/5"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, map[string]string{"name": "bob"}, user)
+
+	resp, _, err = r.Receive(Delete("/users/5"))
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+
+	_, body, err := r.Receive(Post("/users/5/orders/7"))
+	require.NoError(t, err)
+	assert.Equal(t, "5:7", string(body))
+
+	resp, _, err = r.Receive(Get("/flaky"))
+	require.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	resp, _, err = r.Receive(Put("/flaky"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp, _, err = r.Receive(Get("/default"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// no route
+	for _, opt := range []Option{Put("/users/5"), Get("/users"), Get("/users/5/extra")} {
+		resp, body, err = r.Receive(opt)
+		require.NoError(t, err)
+		assert.Equal(t, 404, resp.StatusCode)
+		assert.Contains(t, string(body), "no mock route")
+	}
+
+	assert.Equal(t, 1, getUser.Calls())
+	assert.Equal(t, 1, deleteUser.Calls())
+	assert.Equal(t, 1, orders.Calls())
+	assert.Equal(t, 2, seq.Calls())
+}
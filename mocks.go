@@ -1,9 +1,19 @@
 package requester
 
 import (
+	"bytes"
+	"context"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ansel1/merry"
 )
 
 // These are tools for writing tests.
@@ -17,23 +27,156 @@ import (
 // http.Request.  The fields of the template request are copied into
 // the mocked responses (http.Request and http.Response share most fields,
 // so we're leveraging the rich set of requester.Options to build the response).
+//
+// If Informational options are passed, they are reported to the request's
+// httptrace.ClientTrace.Got1xxResponse hook, if there is one, before the
+// mocked response is returned.
+//
+// The MockDelay, MockErr, and MockAfter options simulate slow responses and transport errors.
 func MockDoer(statusCode int, options ...Option) DoerFunc {
+	stages := mockStages(options)
+
 	return func(req *http.Request) (*http.Response, error) {
-		resp := MockResponse(statusCode, options...)
+		stage := stages.next()
+		if err := stage.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		for _, info := range stage.infos {
+			if info.err != nil {
+				return nil, info.err
+			}
+		}
+
+		if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.Got1xxResponse != nil {
+			for _, info := range stage.infos {
+				if err := trace.Got1xxResponse(info.statusCode, textproto.MIMEHeader(info.header)); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if stage.err != nil {
+			return nil, stage.err
+		}
+
+		resp := MockResponse(statusCode, stage.options...)
 		resp.Request = req
 		return resp, nil
 	}
 }
 
+// MockSpec specifies one response in a sequence mocked by MockDoerSeq and MockHandlerSeq.
+// The StatusCode and Options are used like the arguments to MockDoer and MockHandler.
+type MockSpec struct {
+	StatusCode int
+	Options    []Option
+	// Repeat, if true, makes this response the reply to all later requests.  Any specs
+	// after it are ignored.
+	Repeat bool
+}
+
+// NewMockSpec returns a MockSpec.
+func NewMockSpec(statusCode int, options ...Option) MockSpec {
+	return MockSpec{StatusCode: statusCode, Options: options}
+}
+
+// Repeated returns a copy of the spec, with Repeat set.
+func (s MockSpec) Repeated() MockSpec {
+	s.Repeat = true
+	return s
+}
+
+// ErrMockSeqExhausted is returned by MockDoerSeq when it's called more times than it has
+// responses.
+// nolint:gochecknoglobals
+var ErrMockSeqExhausted = merry.New("mock sequence exhausted")
+
+// MockDoerSeq creates a Doer which returns a sequence of mocked responses, one per request,
+// in order.  Once they're exhausted, it returns ErrMockSeqExhausted, unless the last spec
+// is Repeated.  It's handy for testing multi-step flows, like retries, pagination, or
+// refreshing credentials:
+//
+//	requester.MockDoerSeq(
+//	    requester.NewMockSpec(503),
+//	    requester.NewMockSpec(200, requester.Body("ok")).Repeated(),
+//	)
+func MockDoerSeq(specs ...MockSpec) DoerFunc {
+	doers := make([]DoerFunc, len(specs))
+	for i, spec := range specs {
+		doers[i] = MockDoer(spec.StatusCode, spec.Options...)
+	}
+	next := mockSeq(specs)
+
+	return func(req *http.Request) (*http.Response, error) {
+		i := next()
+		if i < 0 {
+			return nil, ErrMockSeqExhausted.Here()
+		}
+		return doers[i](req)
+	}
+}
+
+// MockHandlerSeq is like MockDoerSeq, but returns an http.Handler, like MockHandler.  Once
+// the sequence is exhausted, it responds with 500 Internal Server Error, and the text of
+// ErrMockSeqExhausted.
+func MockHandlerSeq(specs ...MockSpec) http.Handler {
+	handlers := make([]http.Handler, len(specs))
+	for i, spec := range specs {
+		handlers[i] = MockHandler(spec.StatusCode, spec.Options...)
+	}
+	next := mockSeq(specs)
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		i := next()
+		if i < 0 {
+			http.Error(writer, ErrMockSeqExhausted.Error(), http.StatusInternalServerError)
+			return
+		}
+		handlers[i].ServeHTTP(writer, request)
+	})
+}
+
+// mockSeq returns a function which returns the index of the spec for the next
+// request, or -1 when the sequence is exhausted.
+func mockSeq(specs []MockSpec) func() int {
+	var mu sync.Mutex
+	n := 0
+
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		i := n
+		if i >= len(specs) {
+			return -1
+		}
+		if !specs[i].Repeat {
+			n++
+		}
+		return i
+	}
+}
+
 // ChannelDoer returns a DoerFunc and a channel.  The DoerFunc will return the responses
-// send on the channel.
-func ChannelDoer() (chan<- *http.Response, DoerFunc) {
-	input := make(chan *http.Response, 1)
+// send on the channel.  It blocks until a response is sent, or the request's context is
+// done.
+//
+// Pass CaptureRequests to receive the requests as they arrive, and Lockstep to orchestrate
+// concurrent requests step by step.
+func ChannelDoer(options ...ChannelOption) (chan<- *http.Response, DoerFunc) {
+	c := newChannelMock(options)
 
-	return input, func(req *http.Request) (*http.Response, error) {
-		resp := <-input
-		resp.Request = req
-		return resp, nil
+	return c.input, func(req *http.Request) (*http.Response, error) {
+		if err := c.capture(req); err != nil {
+			return nil, err
+		}
+		select {
+		case resp := <-c.input:
+			resp.Request = req
+			return resp, nil
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
 	}
 }
 
@@ -70,44 +213,353 @@ func MockResponse(statusCode int, options ...Option) *http.Response {
 // MockHandler returns an http.Handler which returns responses built from the args.
 // The Option arguments are used to build an http.Request, then the header and body
 // of the request are copied into an http.Response object.
+//
+// If the options set any trailers, they are declared in the Trailer header and sent
+// after the body.  If Informational options are passed, those informational responses
+// are written before the final response.
+//
+// The MockDelay, MockErr, and MockAfter options simulate slow responses and dropped connections.
 func MockHandler(statusCode int, options ...Option) http.Handler {
 
-	r := MustNew(options...)
+	stages := mockStages(options)
+	requesters := make(map[*mockStage]*Requester, len(stages.stages))
+	for _, stage := range stages.stages {
+		requesters[stage] = MustNew(stage.options...)
+	}
 
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		req, err := r.RequestContext(request.Context())
+		stage := stages.next()
+		if err := stage.wait(request.Context()); err != nil {
+			return
+		}
+		if stage.err != nil {
+			// drop the connection
+			panic(http.ErrAbortHandler)
+		}
+
+		req, err := requesters[stage].RequestContext(request.Context())
 		if err != nil {
 			panic(err)
 		}
 
 		h := writer.Header()
+		for _, info := range stage.infos {
+			for key, value := range info.header {
+				h[key] = value
+			}
+			writer.WriteHeader(info.statusCode)
+			// the header map is shared with the final response
+			for key := range info.header {
+				delete(h, key)
+			}
+		}
+
 		for key, value := range req.Header {
 			h[key] = value
 		}
 
+		declareTrailers(h, req.Trailer)
+
 		writer.WriteHeader(statusCode)
 
 		if req.Body != nil {
 			_, _ = io.Copy(writer, req.Body)
 		}
+
+		for key, value := range req.Trailer {
+			h[key] = value
+		}
 	})
 }
 
 // ChannelHandler returns an http.Handler and an input channel.  The Handler returns the http.Responses sent to
-// the channel.
-func ChannelHandler() (chan<- *http.Response, http.Handler) {
-	input := make(chan *http.Response, 1)
+// the channel.  It accepts the same options as ChannelDoer.
+func ChannelHandler(options ...ChannelOption) (chan<- *http.Response, http.Handler) {
+	c := newChannelMock(options)
 
-	return input, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		resp := <-input
+	return c.input, http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := c.capture(request); err != nil {
+			return
+		}
+		var resp *http.Response
+		select {
+		case resp = <-c.input:
+		case <-request.Context().Done():
+			return
+		}
 
 		h := writer.Header()
 		for key, value := range resp.Header {
 			h[key] = value
 		}
 
+		declareTrailers(h, resp.Trailer)
+
 		writer.WriteHeader(resp.StatusCode)
 
 		_, _ = io.Copy(writer, resp.Body)
+
+		for key, value := range resp.Trailer {
+			h[key] = value
+		}
+	})
+}
+
+// ChannelOption configures the mocks returned by ChannelDoer and ChannelHandler.
+type ChannelOption func(*channelMock)
+
+// CaptureRequests returns a ChannelOption which makes ChannelDoer and ChannelHandler send each
+// request they receive to requests, before waiting for a response.  The requests are
+// copies, with their bodies buffered, so they can be read after the response is sent.
+// Sending blocks until the request is received, or the request's context is done.
+func CaptureRequests(requests chan<- *http.Request) ChannelOption {
+	return func(c *channelMock) {
+		c.requests = requests
+	}
+}
+
+// Lockstep returns a ChannelOption which makes the input channel returned by ChannelDoer and
+// ChannelHandler unbuffered, so sending a response blocks until a request is waiting for
+// it.  With CaptureRequests, tests can then step through concurrent requests one at a time:
+//
+//	reqs := make(chan *http.Request)
+//	in, d := ChannelDoer(CaptureRequests(reqs), Lockstep())
+//	go r.Send(d)
+//	req := <-reqs    // request has arrived
+//	in <- resp       // returns once the request has taken the response
+func Lockstep() ChannelOption {
+	return func(c *channelMock) {
+		c.lockstep = true
+	}
+}
+
+type channelMock struct {
+	input    chan *http.Response
+	requests chan<- *http.Request
+	lockstep bool
+}
+
+func newChannelMock(options []ChannelOption) *channelMock {
+	c := &channelMock{}
+	for _, opt := range options {
+		opt(c)
+	}
+	size := 1
+	if c.lockstep {
+		size = 0
+	}
+	c.input = make(chan *http.Response, size)
+	return c
+}
+
+// capture sends a copy of the request, with its body buffered, to the requests channel.
+// The request's body is replaced, so it can still be read.
+func (c *channelMock) capture(req *http.Request) error {
+	if c.requests == nil {
+		return nil
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return merry.Prepend(err, "reading request body")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	ctx := req.Context()
+	cp := req.Clone(ctx)
+	if body != nil {
+		cp.Body = io.NopCloser(bytes.NewReader(body))
+		cp.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	select {
+	case c.requests <- cp:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// declareTrailers announces the trailer keys in the Trailer header, which
+// must be done before the response header is written.
+func declareTrailers(h, trailer http.Header) {
+	for key := range trailer {
+		h.Add("Trailer", key)
+	}
+}
+
+// Informational returns an Option which adds an informational (1xx) response,
+// like 103 Early Hints, to the responses mocked by MockHandler and MockDoer.
+// The options are used to build the header of the informational response, in
+// the same way MockResponse builds a response header.  Informational responses
+// are sent in the order they are passed.  MockHandler writes them with
+// ResponseWriter.WriteHeader, which net/http servers only support for 1xx codes
+// since Go 1.19.
+//
+// Informational has no effect on a Requester.  If the options fail, the error is
+// returned when the Option is applied, and by MockDoer.
+func Informational(statusCode int, options ...Option) Option {
+	r, err := Request(options...)
+	if err != nil {
+		return &informational{err: merry.Prepend(err, "building informational response")}
+	}
+	return &informational{statusCode: statusCode, header: r.Header}
+}
+
+type informational struct {
+	statusCode int
+	header     http.Header
+	err        error
+}
+
+// Apply implements Option.  Informational responses are only meaningful to
+// the mocks, so it only reports errors building the response.
+func (i *informational) Apply(*Requester) error {
+	return i.err
+}
+
+func informationalResponses(options []Option) []*informational {
+	var infos []*informational
+	for _, opt := range options {
+		if info, ok := opt.(*informational); ok {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// MockDelay returns an Option which delays the responses mocked by MockHandler and MockDoer
+// by d, to simulate slow responses.  If the request's context is done first, MockDoer
+// returns the context's error.
+//
+// MockDelay has no effect on a Requester.
+func MockDelay(d time.Duration) Option {
+	return mockDelay(d)
+}
+
+type mockDelay time.Duration
+
+// Apply implements Option.  It's a no-op.
+func (mockDelay) Apply(*Requester) error {
+	return nil
+}
+
+// MockErr returns an Option which makes MockDoer return err instead of a response, to simulate
+// transport errors.  MockHandler drops the connection instead of responding.  Combine it
+// with MockAfter to fail only the first attempts:
+//
+//	// fails once, then succeeds
+//	requester.MockDoer(200, requester.MockErr(syscall.ECONNRESET), requester.MockAfter(1))
+//
+// MockErr has no effect on a Requester.
+func MockErr(err error) Option {
+	return &mockErr{err: err}
+}
+
+type mockErr struct {
+	err error
+}
+
+// Apply implements Option.  It's a no-op.
+func (*mockErr) Apply(*Requester) error {
+	return nil
+}
+
+// MockAfter returns an Option which changes the responses mocked by MockHandler and MockDoer
+// after the first n requests.  Later requests are mocked with the status code, and options,
+// instead of the other options.  If MockAfter is passed more than once, the one with the
+// largest n which has been reached applies.
+//
+// MockAfter has no effect on a Requester.
+func MockAfter(n int, options ...Option) Option {
+	return &mockAfter{n: n, options: options}
+}
+
+type mockAfter struct {
+	n       int
+	options []Option
+}
+
+// Apply implements Option.  It's a no-op.
+func (*mockAfter) Apply(*Requester) error {
+	return nil
+}
+
+// mockStage is how a mock responds to a range of requests.
+type mockStage struct {
+	// after is the number of requests before the stage applies
+	after   int
+	options []Option
+	infos   []*informational
+	delay   time.Duration
+	err     error
+}
+
+func newMockStage(after int, options []Option) *mockStage {
+	stage := &mockStage{after: after, infos: informationalResponses(options)}
+	for _, opt := range options {
+		switch t := opt.(type) {
+		case *mockAfter:
+			continue
+		case mockDelay:
+			stage.delay = time.Duration(t)
+		case *mockErr:
+			stage.err = t.err
+		}
+		stage.options = append(stage.options, opt)
+	}
+	return stage
+}
+
+// wait waits for the stage's delay, or until ctx is done.
+func (s *mockStage) wait(ctx context.Context) error {
+	if s.delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(s.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mockStageSeq selects the stage for each request.
+type mockStageSeq struct {
+	// stages are ordered by after
+	stages []*mockStage
+	calls  int64
+}
+
+func mockStages(options []Option) *mockStageSeq {
+	seq := &mockStageSeq{stages: []*mockStage{newMockStage(0, options)}}
+	for _, opt := range options {
+		if a, ok := opt.(*mockAfter); ok {
+			seq.stages = append(seq.stages, newMockStage(a.n, a.options))
+		}
+	}
+	sort.SliceStable(seq.stages, func(i, j int) bool {
+		return seq.stages[i].after < seq.stages[j].after
 	})
+	return seq
+}
+
+func (m *mockStageSeq) next() *mockStage {
+	n := atomic.AddInt64(&m.calls, 1)
+	stage := m.stages[0]
+	for _, s := range m.stages[1:] {
+		if int64(s.after) < n {
+			stage = s
+		}
+	}
+	return stage
 }
@@ -1,14 +1,21 @@
 package requester
 
 import (
+	"context"
 	"fmt"
+	"github.com/ansel1/merry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestMockHandler(t *testing.T) {
@@ -29,6 +36,53 @@ func TestMockHandler(t *testing.T) {
 	assert.Contains(t, resp.Header.Get(HeaderContentType), MediaTypeJSON)
 }
 
+func TestMockHandler_trailers(t *testing.T) {
+	h := MockHandler(200,
+		Body("pong"),
+		Trailer("Checksum", "abc"),
+	)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	resp, body, err := Receive(Get(ts.URL))
+	require.NoError(t, err)
+
+	assert.Equal(t, "pong", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("Checksum"))
+	assert.Empty(t, resp.Header.Get("Checksum"))
+}
+
+func TestMockHandler_informational(t *testing.T) {
+	h := MockHandler(201,
+		Body("pong"),
+		Informational(http.StatusEarlyHints, Header("Link", "</style.css>; rel=preload")),
+		Informational(http.StatusEarlyHints, Header("Link", "</script.js>; rel=preload")),
+	)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	var codes []int
+	var links []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			codes = append(codes, code)
+			links = append(links, header.Get("Link"))
+			return nil
+		},
+	}
+
+	resp, body, err := ReceiveContext(httptrace.WithClientTrace(context.Background(), trace), Get(ts.URL))
+	require.NoError(t, err)
+
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "pong", string(body))
+	assert.Equal(t, []int{103, 103}, codes)
+	assert.Equal(t, []string{"</style.css>; rel=preload", "</script.js>; rel=preload"}, links)
+	assert.Empty(t, resp.Header.Get("Link"))
+}
+
 func TestChannelHandler(t *testing.T) {
 
 	in, h := ChannelHandler()
@@ -89,6 +143,35 @@ func TestMockDoer(t *testing.T) {
 	assert.JSONEq(t, `{"color":"blue"}`, string(b))
 }
 
+func TestMockDoer_informational(t *testing.T) {
+	d := MockDoer(201, Informational(http.StatusEarlyHints, Header("Link", "</style.css>; rel=preload")))
+
+	var links []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			assert.Equal(t, http.StatusEarlyHints, code)
+			links = append(links, header.Get("Link"))
+			return nil
+		},
+	}
+
+	resp, _, err := ReceiveContext(httptrace.WithClientTrace(context.Background(), trace), d)
+	require.NoError(t, err)
+
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, []string{"</style.css>; rel=preload"}, links)
+	assert.Empty(t, resp.Header.Get("Link"))
+
+	t.Run("invalid options", func(t *testing.T) {
+		opt := Informational(http.StatusEarlyHints, URL("%"))
+		_, err := New(opt)
+		require.Error(t, err)
+
+		_, _, err = Receive(MockDoer(201, opt))
+		require.Error(t, err)
+	})
+}
+
 func TestChannelDoer(t *testing.T) {
 	in, d := ChannelDoer()
 
@@ -114,6 +197,74 @@ func TestChannelDoer(t *testing.T) {
 	assert.JSONEq(t, `{"color":"blue"}`, string(b))
 }
 
+func TestChannelDoer_lockstep(t *testing.T) {
+	reqs := make(chan *http.Request)
+	in, d := ChannelDoer(CaptureRequests(reqs), Lockstep())
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, name := range []string{"first", "second"} {
+		go func(name string) {
+			_, body, err := Receive(d, Post("/"+name), Body(name))
+			results <- result{string(body), err}
+		}(name)
+	}
+
+	for i := 0; i < 2; i++ {
+		req := <-reqs
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, req.URL.Path, "/"+string(b))
+
+		// nothing completes until the response is sent
+		select {
+		case <-results:
+			t.Fatal("request completed before the response was sent")
+		default:
+		}
+
+		// with lockstep, this returns only once the request has taken the response
+		in <- MockResponse(200, Body("re:"+string(b)))
+		r := <-results
+		require.NoError(t, r.err)
+		assert.Equal(t, "re:"+string(b), r.body)
+	}
+}
+
+func TestChannelDoer_canceled(t *testing.T) {
+	_, d := ChannelDoer(Lockstep())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := SendContext(ctx, d)
+	require.Error(t, err)
+	assert.True(t, merry.Is(err, context.DeadlineExceeded))
+}
+
+func TestChannelHandler_captureRequests(t *testing.T) {
+	reqs := make(chan *http.Request, 1)
+	in, h := ChannelHandler(CaptureRequests(reqs))
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	in <- MockResponse(201)
+
+	resp, _, err := Receive(Put(ts.URL+"/profile"), Body("bob"))
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+
+	req := <-reqs
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, "/profile", req.URL.Path)
+	b, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", string(b))
+}
+
 func ExampleMockDoer() {
 	d := MockDoer(201,
 		JSON(false),
@@ -193,3 +344,126 @@ func ExampleChannelHandler() {
 	// 201
 	// pong
 }
+
+func TestMockDoerSeq(t *testing.T) {
+	d := MockDoerSeq(NewMockSpec(503), NewMockSpec(201, Body("created")))
+
+	resp, _, err := Receive(d)
+	require.NoError(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+
+	resp, body, err := Receive(d)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "created", string(body))
+
+	_, _, err = Receive(d)
+	assert.True(t, merry.Is(err, ErrMockSeqExhausted))
+
+	t.Run("repeated", func(t *testing.T) {
+		d := MockDoerSeq(NewMockSpec(503), NewMockSpec(200, Body("ok")).Repeated(), NewMockSpec(404))
+
+		var codes []int
+		for i := 0; i < 4; i++ {
+			resp, _, err := Receive(d)
+			require.NoError(t, err)
+			codes = append(codes, resp.StatusCode)
+		}
+		assert.Equal(t, []int{503, 200, 200, 200}, codes)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		d := MockDoerSeq(NewMockSpec(503), NewMockSpec(503), NewMockSpec(200))
+		resp, err := Send(d, Retry(&RetryConfig{MaxAttempts: 3, Backoff: NoBackoff()}))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	})
+}
+
+func TestMockHandlerSeq(t *testing.T) {
+	ts := httptest.NewServer(MockHandlerSeq(NewMockSpec(401), NewMockSpec(200, Body("hi"))))
+	defer ts.Close()
+
+	resp, _, err := Receive(Get(ts.URL))
+	require.NoError(t, err)
+	assert.Equal(t, 401, resp.StatusCode)
+
+	resp, body, err := Receive(Get(ts.URL))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hi", string(body))
+
+	resp, body, err = Receive(Get(ts.URL))
+	require.NoError(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Contains(t, string(body), ErrMockSeqExhausted.Error())
+}
+
+func TestMockDoer_simulations(t *testing.T) {
+	t.Run("delay", func(t *testing.T) {
+		d := MockDoer(200, MockDelay(50*time.Millisecond))
+		start := time.Now()
+		resp, err := Send(d)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(50*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = SendContext(ctx, MockDoer(200, MockDelay(time.Second)))
+		assert.True(t, merry.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("err then success", func(t *testing.T) {
+		d := MockDoer(200, MockErr(io.ErrUnexpectedEOF), MockAfter(1, Body("ok")))
+
+		_, err := Send(d)
+		assert.True(t, merry.Is(err, io.ErrUnexpectedEOF))
+
+		_, body, err := Receive(d)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		var attempts int
+		resp, err := Send(
+			MockDoer(200, MockErr(syscall.ECONNRESET), MockAfter(2)),
+			Retry(&RetryConfig{MaxAttempts: 3, Backoff: NoBackoff()}),
+			Middleware(func(next Doer) Doer {
+				return DoerFunc(func(req *http.Request) (*http.Response, error) {
+					attempts++
+					return next.Do(req)
+				})
+			}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("multiple after", func(t *testing.T) {
+		d := MockDoer(200, Body("a"), MockAfter(3, Body("c")), MockAfter(1, Body("b")))
+		var bodies []string
+		for i := 0; i < 5; i++ {
+			_, body, err := Receive(d)
+			require.NoError(t, err)
+			bodies = append(bodies, string(body))
+		}
+		assert.Equal(t, []string{"a", "b", "b", "c", "c"}, bodies)
+	})
+}
+
+func TestMockHandler_simulations(t *testing.T) {
+	ts := httptest.NewServer(MockHandler(200, MockErr(io.ErrUnexpectedEOF), MockAfter(1, Body("ok"), MockDelay(20*time.Millisecond))))
+	defer ts.Close()
+
+	_, _, err := Receive(Get(ts.URL))
+	require.Error(t, err)
+
+	start := time.Now()
+	_, body, err := Receive(Get(ts.URL))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(20*time.Millisecond))
+}
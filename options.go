@@ -2,8 +2,13 @@ package requester
 
 import (
 	"encoding/base64"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -14,10 +19,12 @@ import (
 
 // HTTP constants.
 const (
-	HeaderAccept        = "Accept"
-	HeaderContentType   = "Content-Type"
-	HeaderAuthorization = "Authorization"
-	HeaderRange         = "Range"
+	HeaderAccept             = "Accept"
+	HeaderContentType        = "Content-Type"
+	HeaderAuthorization      = "Authorization"
+	HeaderProxyAuthorization = "Proxy-Authorization"
+	HeaderRange              = "Range"
+	HeaderUserAgent          = "User-Agent"
 
 	MediaTypeJSON          = "application/json"
 	MediaTypeXML           = "application/xml"
@@ -26,6 +33,10 @@ const (
 	MediaTypeTextPlain     = "text/plain"
 	MediaTypeMultipart     = "multipart/mixed"
 	MediaTypeMultipartForm = "multipart/form-data"
+	MediaTypeMsgPack       = "application/msgpack"
+	MediaTypeXMsgPack      = "application/x-msgpack"
+	MediaTypeProtobuf      = "application/x-protobuf"
+	MediaTypeGob           = "application/x-gob"
 )
 
 // Option applies some setting to a Requester object.  Options can be passed
@@ -176,6 +187,24 @@ func DeleteHeader(key string) Option {
 	})
 }
 
+// ReplaceHeaders replaces all the Requester's headers with a copy of h, rather than merging
+// them, so a derived request inherits none of the original headers, like Authorization.
+// If h is nil, all the headers are removed.
+func ReplaceHeaders(h http.Header) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Header = cloneHeader(h)
+		return nil
+	})
+}
+
+// Trailer sets a trailer value, using Header.Set()
+func Trailer(key, value string) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Trailers().Set(key, value)
+		return nil
+	})
+}
+
 // BasicAuth sets the Authorization header to "Basic <encoded username and password>".
 // If username and password are empty, it deletes the Authorization header.
 func BasicAuth(username, password string) Option {
@@ -365,6 +394,100 @@ func QueryParam(k, v string) Option {
 	})
 }
 
+// RawQuery sets the URL's query string to q, which must already be encoded, without the
+// leading "?".  It's sent as is, preserving its order and encoding, unless QueryParams are
+// also set: merging them re-encodes the whole query.
+func RawQuery(q string) Option {
+	return OptionFunc(func(r *Requester) error {
+		if r.URL == nil {
+			r.URL = &url.URL{}
+		}
+		r.URL.RawQuery = q
+		r.URL.ForceQuery = false
+		return nil
+	})
+}
+
+// Fragment sets the URL's fragment, unescaped, without the leading "#".  Fragments aren't
+// sent to servers, but are kept in the request's URL, for middleware or signers to use.
+func Fragment(f string) Option {
+	return OptionFunc(func(r *Requester) error {
+		if r.URL == nil {
+			r.URL = &url.URL{}
+		}
+		r.URL.Fragment = f
+		r.URL.RawFragment = ""
+		return nil
+	})
+}
+
+// SetQueryParam sets a query parameter, replacing any values it already has, in
+// QueryParams or the URL's query.
+func SetQueryParam(k, v string) Option {
+	return OptionFunc(func(s *Requester) error {
+		if k == "" {
+			return nil
+		}
+		deleteURLQuery(s.URL, k)
+		if s.QueryParams == nil {
+			s.QueryParams = url.Values{}
+		}
+		s.QueryParams.Set(k, v)
+		return nil
+	})
+}
+
+// DeleteQueryParam deletes a query parameter, from QueryParams and the URL's query.
+// It's useful for removing a parameter inherited from the Requester a clone was made from.
+func DeleteQueryParam(k string) Option {
+	return OptionFunc(func(s *Requester) error {
+		deleteURLQuery(s.URL, k)
+		s.QueryParams.Del(k)
+		return nil
+	})
+}
+
+// ClearQueryParams deletes all the query parameters, in QueryParams and the URL's query.
+func ClearQueryParams() Option {
+	return OptionFunc(func(s *Requester) error {
+		if s.URL != nil {
+			s.URL.RawQuery = ""
+			s.URL.ForceQuery = false
+		}
+		s.QueryParams = nil
+		return nil
+	})
+}
+
+// ReplaceQueryParams replaces all the query parameters, in QueryParams and the URL's
+// query, with a copy of v, rather than merging them.  If v is nil, all the query
+// parameters are removed.
+func ReplaceQueryParams(v url.Values) Option {
+	return OptionFunc(func(s *Requester) error {
+		if err := ClearQueryParams().Apply(s); err != nil {
+			return err
+		}
+		s.QueryParams = cloneValues(v)
+		return nil
+	})
+}
+
+// deleteURLQuery deletes the key from the URL's raw query.  The query is only re-encoded
+// if the key is present.
+func deleteURLQuery(u *url.URL, k string) {
+	if u == nil || u.RawQuery == "" {
+		return
+	}
+	values, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return
+	}
+	if _, ok := values[k]; ok {
+		values.Del(k)
+		u.RawQuery = values.Encode()
+	}
+}
+
 // Body sets the body of the request.
 //
 // If the body value is a string, []byte, io.Reader, the
@@ -381,6 +504,82 @@ func Body(body interface{}) Option {
 	})
 }
 
+// StreamBody sets the body of the request to r, which is streamed to the server as the
+// request is sent, rather than being read into memory first.  If contentLength is
+// positive, it's sent as the Content-Length, and r must yield exactly that many bytes.
+// Otherwise, the body is sent with chunked transfer encoding.
+//
+// A stream can only be read once, so only one request should be sent with it.  Retry
+// won't retry the request, even if RetryConfig.BufferRequestBody is set.  To stream a
+// body which can be retried, use StreamBodyFunc.
+func StreamBody(r io.Reader, contentLength int64) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Body = &streamReader{r}
+		b.GetBody = nil
+		b.ContentLength = streamLength(contentLength)
+		return nil
+	})
+}
+
+// StreamBodyFunc is like StreamBody, but getBody is called to open the body of each
+// request, e.g. by opening a file.  It's also used as the request's GetBody, so
+// Retry can rewind the body by opening it again, without buffering it.
+//
+//	requester.StreamBodyFunc(func() (io.ReadCloser, error) {
+//	    return os.Open("upload.bin")
+//	}, size)
+func StreamBodyFunc(getBody func() (io.ReadCloser, error), contentLength int64) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Body = getBody
+		b.GetBody = nil
+		b.ContentLength = streamLength(contentLength)
+		return nil
+	})
+}
+
+// BodyFile sets the body of the request to the contents of the file at path.  The file is
+// opened when each request is created, and streamed, so it's never held in memory.  It's
+// handy for large fixtures with MockHandler and MockResponse, as well as uploads.
+//
+// If the Requester has no Content-Type header, it's set from the file's extension, if
+// it's known.
+func BodyFile(path string) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Body = func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}
+		b.GetBody = nil
+		b.ContentLength = 0
+		if b.Header.Get(HeaderContentType) == "" {
+			if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+				b.Headers().Set(HeaderContentType, ct)
+			}
+		}
+		return nil
+	})
+}
+
+func streamLength(contentLength int64) int64 {
+	if contentLength > 0 {
+		return contentLength
+	}
+	// unknown: send chunked
+	return -1
+}
+
+// streamReader marks a body set with StreamBody, so Retry knows not to buffer it.
+type streamReader struct {
+	io.Reader
+}
+
+// Close closes the underlying reader, if it's an io.Closer.
+func (s *streamReader) Close() error {
+	if c, ok := s.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // WithMarshaler sets Requester.WithMarshaler
 func WithMarshaler(m Marshaler) Option {
 	return OptionFunc(func(b *Requester) error {
@@ -397,11 +596,175 @@ func WithUnmarshaler(m Unmarshaler) Option {
 	})
 }
 
+// UnmarshalOn sets Requester.Unmarshaler to a HeaderUnmarshaler, which selects the
+// Unmarshaler from the value of the named response header, rather than from the
+// Content-Type.  Responses without a matching header value fall back to the
+// DefaultUnmarshaler.
+//
+//	requester.UnmarshalOn("X-Payload-Schema", map[string]requester.Unmarshaler{
+//	    "v1": &requester.JSONMarshaler{},
+//	    "v2": &requester.XMLMarshaler{},
+//	})
+func UnmarshalOn(header string, unmarshalers map[string]Unmarshaler) Option {
+	return &HeaderUnmarshaler{
+		Header:       header,
+		Unmarshalers: unmarshalers,
+	}
+}
+
+// UnmarshalerFor registers an Unmarshaler for a media type on this Requester only.
+// If the Requester's Unmarshaler is nil, it's initialized with a copy of the
+// DefaultUnmarshaler.  The Requester's Unmarshaler must be a *ContentTypeUnmarshaler,
+// which is copied before registering, so other Requesters sharing it aren't affected.
+//
+//	requester.UnmarshalerFor("application/problem+json", &requester.JSONMarshaler{})
+func UnmarshalerFor(mediaType string, u Unmarshaler) Option {
+	return OptionFunc(func(r *Requester) error {
+		current := r.Unmarshaler
+		if current == nil {
+			current = DefaultUnmarshaler
+		}
+
+		c, ok := current.(*ContentTypeUnmarshaler)
+		if !ok {
+			return merry.Errorf("UnmarshalerFor requires a *ContentTypeUnmarshaler, but the Unmarshaler is a %T", current)
+		}
+
+		c = c.clone()
+		c.Unmarshalers[mediaType] = u
+		r.Unmarshaler = c
+		return nil
+	})
+}
+
+// WithSigner sets Requester.Signer
+func WithSigner(s Signer) Option {
+	return OptionFunc(func(b *Requester) error {
+		b.Signer = s
+		return nil
+	})
+}
+
+// Charset sets Requester.Charset, which controls the charset parameter
+// of the Content-Type header supplied by the Marshaler.  The built-in
+// marshalers for text formats specify "charset=UTF-8" by default.  Passing
+// an empty string removes the charset parameter, which some strict servers
+// require:
+//
+//	requester.Charset("")  // Content-Type: application/json
+//
+// Content-Type headers set explicitly with Header() or ContentType() are
+// not affected.
+func Charset(charset string) Option {
+	return OptionFunc(func(r *Requester) error {
+		r.Charset = &charset
+		return nil
+	})
+}
+
+// StreamResponse sets Requester.StreamResponse, so the Receive methods unmarshal
+// response bodies directly from the response stream.  See StreamUnmarshaler.
+func StreamResponse() Option {
+	return OptionFunc(func(r *Requester) error {
+		r.StreamResponse = true
+		return nil
+	})
+}
+
+// MaxResponseBody sets Requester.MaxResponseBody, which limits the size of the response
+// bodies read by the Receive methods.
+func MaxResponseBody(n int64) Option {
+	return OptionFunc(func(r *Requester) error {
+		r.MaxResponseBody = n
+		return nil
+	})
+}
+
 // Accept sets the Accept header.
 func Accept(accept string) Option {
 	return Header(HeaderAccept, accept)
 }
 
+// Negotiate sets the Accept header to the media types, weighted in the order given, from
+// most to least preferred:
+//
+//	Negotiate("application/json", "application/xml", "*/*")
+//	// Accept: application/json, application/xml;q=0.9, */*;q=0.8
+//
+// Types which already have a q parameter keep it.  It also configures the Unmarshaler to
+// fall back to the most preferred type it supports, if a response has no Content-Type, or
+// one it doesn't recognize.  The Unmarshaler is left alone if it isn't a
+// ContentTypeUnmarshaler.
+func Negotiate(accepts ...string) Option {
+	return OptionFunc(func(r *Requester) error {
+		if len(accepts) == 0 {
+			return nil
+		}
+		if err := Accept(weightedList(accepts)).Apply(r); err != nil {
+			return err
+		}
+
+		u := r.Unmarshaler
+		if u == nil {
+			u = DefaultUnmarshaler
+		}
+		ct, ok := u.(*ContentTypeUnmarshaler)
+		if !ok {
+			return nil
+		}
+		ct = ct.clone()
+		ct.Fallback = nil
+		for _, accept := range accepts {
+			mediaType, _, err := mime.ParseMediaType(accept)
+			if err != nil || strings.Contains(mediaType, "*") {
+				continue
+			}
+			if fallback, _, err := ct.unmarshaler(mediaType, nil); err == nil {
+				ct.Fallback = fallback
+				break
+			}
+		}
+		if ct.Fallback == nil {
+			ct.Fallback = u.(*ContentTypeUnmarshaler).Fallback
+		}
+		r.Unmarshaler = ct
+		return nil
+	})
+}
+
+// AcceptLanguage sets the Accept-Language header to the language tags, weighted in the
+// order given, from most to least preferred:
+//
+//	AcceptLanguage("fr-CH", "fr", "en")
+//	// Accept-Language: fr-CH, fr;q=0.9, en;q=0.8
+func AcceptLanguage(tags ...string) Option {
+	if len(tags) == 0 {
+		return DeleteHeader("Accept-Language")
+	}
+	return Header("Accept-Language", weightedList(tags))
+}
+
+// weightedList joins the values into a header value, adding q parameters which decrease
+// by 0.1 from 1, down to 0.1.  Values which already have a q parameter are kept as is.
+func weightedList(values []string) string {
+	var sb strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(v)
+		if i == 0 || strings.Contains(strings.ReplaceAll(v, " ", ""), ";q=") {
+			continue
+		}
+		q := 10 - i
+		if q < 1 {
+			q = 1
+		}
+		sb.WriteString(";q=0." + strconv.Itoa(q))
+	}
+	return sb.String()
+}
+
 // ContentType sets the Content-Type header.
 func ContentType(contentType string) Option {
 	return Header(HeaderContentType, contentType)
@@ -420,6 +783,22 @@ func Host(host string) Option {
 	})
 }
 
+// UserAgent adds a "product/version" token to the User-Agent header.  If the header
+// isn't set yet, the token is appended to DefaultUserAgent.  If the product is already
+// in the header, its version is replaced.  version may be empty.
+//
+//	requester.UserAgent("myapp", "1.2.0")
+func UserAgent(product, version string) Option {
+	return OptionFunc(func(r *Requester) error {
+		ua := r.Header.Get(HeaderUserAgent)
+		if ua == "" {
+			ua = DefaultUserAgent
+		}
+		r.Headers().Set(HeaderUserAgent, appendProduct(ua, product, version))
+		return nil
+	})
+}
+
 func joinOpts(opts ...Option) Option {
 	return OptionFunc(func(r *Requester) error {
 		for _, opt := range opts {
@@ -456,6 +835,62 @@ func XML(indent bool) Option {
 	)
 }
 
+// MsgPack sets Requester.Marshaler to the MsgPackMarshaler.
+// The MsgPackMarshaler will set the Content-Type header to
+// "application/msgpack" unless explicitly overwritten.
+func MsgPack() Option {
+	return joinOpts(
+		WithMarshaler(&MsgPackMarshaler{}),
+		ContentType(MediaTypeMsgPack),
+		Accept(MediaTypeMsgPack),
+	)
+}
+
+// Protobuf sets Requester.Marshaler to the ProtoMarshaler.
+// The ProtoMarshaler will set the Content-Type header to
+// "application/x-protobuf" unless explicitly overwritten.
+func Protobuf() Option {
+	return joinOpts(
+		WithMarshaler(&ProtoMarshaler{}),
+		ContentType(MediaTypeProtobuf),
+		Accept(MediaTypeProtobuf),
+	)
+}
+
+// Gob sets Requester.Marshaler to the GobMarshaler.
+// The GobMarshaler will set the Content-Type header to
+// "application/x-gob" unless explicitly overwritten.
+//
+// If the Requester's Unmarshaler is nil or a *ContentTypeUnmarshaler, the GobMarshaler is
+// also registered on it for application/x-gob responses, as with UnmarshalerFor.  Gob isn't
+// one of the default Unmarshalers.
+func Gob() Option {
+	return joinOpts(
+		WithMarshaler(&GobMarshaler{}),
+		ContentType(MediaTypeGob),
+		Accept(MediaTypeGob),
+		OptionFunc(func(r *Requester) error {
+			switch r.Unmarshaler.(type) {
+			case nil, *ContentTypeUnmarshaler:
+				return UnmarshalerFor(MediaTypeGob, &GobMarshaler{}).Apply(r)
+			}
+			return nil
+		}),
+	)
+}
+
+// OctetStream sets Requester.Marshaler to the OctetStreamMarshaler, and sets
+// the Content-Type and Accept headers to "application/octet-stream".  The
+// Content-Type header is also applied to []byte, string, and io.Reader
+// bodies, which are sent without marshaling.
+func OctetStream() Option {
+	return joinOpts(
+		WithMarshaler(&OctetStreamMarshaler{}),
+		ContentType(MediaTypeOctetStream),
+		Accept(MediaTypeOctetStream),
+	)
+}
+
 // Form sets Requester.Marshaler to the FormMarshaler,
 // which marshals the body into form-urlencoded.
 // The FormMarshaler will set the Content-Type header to
@@ -486,6 +921,21 @@ func Use(m ...Middleware) Option {
 	})
 }
 
+// ErrDefaultClient is returned when sending requests with a Requester configured with
+// NoDefaultClient, if its Doer is nil or http.DefaultClient.
+// nolint:gochecknoglobals
+var ErrDefaultClient = merry.New("http.DefaultClient is forbidden: set a Doer")
+
+// NoDefaultClient forbids sending requests with http.DefaultClient, which is used when
+// Requester.Doer is nil.  Requests fail with ErrDefaultClient instead.  It guards libraries
+// against sharing, and being affected by changes to, the global client.
+func NoDefaultClient() Option {
+	return OptionFunc(func(r *Requester) error {
+		r.noDefaultClient = true
+		return nil
+	})
+}
+
 // WithDoer replaces Requester.Doer.  If nil, Requester will
 // revert to using the http.DefaultClient.
 func WithDoer(d Doer) Option {
@@ -494,3 +944,48 @@ func WithDoer(d Doer) Option {
 		return nil
 	})
 }
+
+// If applies the options only if cond is true, so option lists can include conditional
+// configuration:
+//
+//	requester.New(
+//	    requester.URL(baseURL),
+//	    requester.If(token != "", requester.BearerAuth(token)),
+//	)
+func If(cond bool, opts ...Option) Option {
+	if !cond {
+		return OptionFunc(func(*Requester) error { return nil })
+	}
+	return joinOpts(opts...)
+}
+
+// IfEnv applies the options only if the environment variable is set, and isn't a false value
+// according to strconv.ParseBool: "0", "f", "F", "false", "FALSE", or "False".  Any other
+// non-empty value, like "1", "true", or "yes", applies the options.  The variable is read
+// when the option is applied, not when IfEnv is called:
+//
+//	requester.IfEnv("DEBUG", requester.DumpToStderr())
+func IfEnv(name string, opts ...Option) Option {
+	return OptionFunc(func(r *Requester) error {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil
+		}
+		if b, err := strconv.ParseBool(v); err == nil && !b {
+			return nil
+		}
+		return joinOpts(opts...).Apply(r)
+	})
+}
+
+// Lazy calls f when the option is applied, and applies the option it returns, if not nil.
+// It defers work, like reading credentials, until the Requester is configured.
+func Lazy(f func() Option) Option {
+	return OptionFunc(func(r *Requester) error {
+		opt := f()
+		if opt == nil {
+			return nil
+		}
+		return opt.Apply(r)
+	})
+}
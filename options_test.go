@@ -2,13 +2,19 @@ package requester
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gemalto/requester/httpclient"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -302,6 +308,13 @@ func TestHeader(t *testing.T) {
 	}
 }
 
+func TestTrailer(t *testing.T) {
+	reqs, err := New(Trailer("checksum", "a"), Trailer("Checksum", "b"))
+	require.NoError(t, err)
+	require.Equal(t, http.Header{"Checksum": []string{"b"}}, reqs.Trailer)
+	require.Empty(t, reqs.Header)
+}
+
 func TestBasicAuth(t *testing.T) {
 	cases := []struct {
 		options      []Option
@@ -439,12 +452,286 @@ func TestQueryParam(t *testing.T) {
 	})
 }
 
+func TestNegotiate(t *testing.T) {
+	reqs := MustNew(Negotiate("application/vnd.api+json", "application/xml", "text/html;q=0.5", "*/*"))
+	assert.Equal(t, "application/vnd.api+json, application/xml;q=0.9, text/html;q=0.5, */*;q=0.7",
+		reqs.Header.Get(HeaderAccept))
+
+	type widget struct {
+		Color string `json:"color" xml:"color"`
+	}
+
+	// responses without a content type are unmarshaled as the preferred type
+	var w widget
+	_, _, err := reqs.Receive(&w, MockDoer(200, Body(`{"color":"red"}`)))
+	require.NoError(t, err)
+	assert.Equal(t, "red", w.Color)
+
+	w = widget{}
+	_, _, err = Receive(&w, Negotiate("image/png", "application/xml"),
+		MockDoer(200, Body(`<widget><color>blue</color></widget>`)))
+	require.NoError(t, err)
+	assert.Equal(t, "blue", w.Color)
+
+	// content types are still used
+	w = widget{}
+	_, _, err = reqs.Receive(&w, MockDoer(200, Body(`<widget><color>green</color></widget>`),
+		ContentType(MediaTypeXML)))
+	require.NoError(t, err)
+	assert.Equal(t, "green", w.Color)
+
+	// the default unmarshaler isn't modified
+	assert.Nil(t, DefaultUnmarshaler.(*ContentTypeUnmarshaler).Fallback)
+
+	// custom unmarshalers are left alone
+	custom := UnmarshalFunc(func([]byte, string, interface{}) error { return nil })
+	reqs = MustNew(custom, Negotiate("application/json"))
+	assert.IsType(t, custom, reqs.Unmarshaler)
+	assert.Equal(t, "application/json", reqs.Header.Get(HeaderAccept))
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	reqs := MustNew(AcceptLanguage("fr-CH", "fr", "en"))
+	assert.Equal(t, "fr-CH, fr;q=0.9, en;q=0.8", reqs.Header.Get("Accept-Language"))
+
+	reqs.MustApply(AcceptLanguage())
+	assert.Empty(t, reqs.Header.Get("Accept-Language"))
+
+	tags := make([]string, 12)
+	for i := range tags {
+		tags[i] = "x" + strconv.Itoa(i)
+	}
+	reqs = MustNew(AcceptLanguage(tags...))
+	assert.True(t, strings.HasSuffix(reqs.Header.Get("Accept-Language"), "x9;q=0.1, x10;q=0.1, x11;q=0.1"))
+}
+
+func TestReplaceHeaders(t *testing.T) {
+	parent := MustNew(BearerAuth("secret"), Header("X-Color", "red"))
+	h := http.Header{"X-Size": {"big"}}
+
+	reqs := parent.MustWith(ReplaceHeaders(h))
+	assert.Equal(t, http.Header{"X-Size": {"big"}}, reqs.Header)
+	assert.Equal(t, "Bearer secret", parent.Header.Get(HeaderAuthorization))
+
+	// it's a copy
+	h.Set("X-Size", "small")
+	assert.Equal(t, "big", reqs.Header.Get("X-Size"))
+
+	reqs = parent.MustWith(ReplaceHeaders(nil))
+	assert.Empty(t, reqs.Header)
+}
+
+func TestReplaceQueryParams(t *testing.T) {
+	parent := MustNew(URL("http://example.com/a?token=abc"), QueryParam("color", "red"))
+	v := url.Values{"size": {"big"}}
+
+	reqs := parent.MustWith(ReplaceQueryParams(v))
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a?size=big", req.URL.String())
+
+	v.Set("size", "small")
+	assert.Equal(t, "big", reqs.QueryParams.Get("size"))
+
+	req, err = parent.MustWith(ReplaceQueryParams(nil)).Request()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", req.URL.String())
+}
+
+func TestRawQuery(t *testing.T) {
+	reqs := MustNew(URL("http://example.com/a?x=1"), RawQuery("b=2&a=1&c=%2f"))
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a?b=2&a=1&c=%2f", req.URL.String())
+
+	// merging QueryParams re-encodes
+	req, err = reqs.Request(QueryParam("d", "4"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1&b=2&c=%2F&d=4", req.URL.RawQuery)
+
+	reqs = MustNew(RawQuery("a=1"))
+	assert.Equal(t, "?a=1", reqs.URL.String())
+}
+
+func TestFragment(t *testing.T) {
+	reqs := MustNew(URL("http://example.com/a#old"), Fragment("section 2"))
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "section 2", req.URL.Fragment)
+	assert.Equal(t, "http://example.com/a#section%202", req.URL.String())
+
+	reqs = MustNew(Fragment("top"))
+	assert.Equal(t, "#top", reqs.URL.String())
+}
+
+func TestSetQueryParam(t *testing.T) {
+	parent := MustNew(URL("http://example.com/a?color=red&size=big"), QueryParam("color", "green"))
+	reqs := parent.MustWith(SetQueryParam("color", "blue"))
+
+	assert.Equal(t, url.Values{"color": []string{"blue"}}, reqs.QueryParams)
+	assert.Equal(t, "size=big", reqs.URL.RawQuery)
+
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "color=blue&size=big", req.URL.RawQuery)
+
+	// the parent is unchanged
+	assert.Equal(t, "color=red&size=big", parent.URL.RawQuery)
+	assert.Equal(t, url.Values{"color": []string{"green"}}, parent.QueryParams)
+
+	assert.Nil(t, MustNew(SetQueryParam("", "red")).QueryParams)
+}
+
+func TestDeleteQueryParam(t *testing.T) {
+	parent := MustNew(URL("http://example.com/a?color=red&size=big"), QueryParam("color", "green"), QueryParam("page", "2"))
+	reqs := parent.MustWith(DeleteQueryParam("color"))
+
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "page=2&size=big", req.URL.RawQuery)
+
+	req, err = parent.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "color=red&color=green&page=2&size=big", req.URL.RawQuery)
+
+	// no-op if there are no params
+	reqs = MustNew(DeleteQueryParam("color"))
+	assert.Nil(t, reqs.QueryParams)
+}
+
+func TestClearQueryParams(t *testing.T) {
+	reqs := MustNew(URL("http://example.com/a?color=red"), QueryParam("size", "big"))
+	reqs.MustApply(ClearQueryParams())
+
+	req, err := reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a", req.URL.String())
+
+	reqs.MustApply(ClearQueryParams(), QueryParam("page", "1"))
+	req, err = reqs.Request()
+	require.NoError(t, err)
+	assert.Equal(t, "http://example.com/a?page=1", req.URL.String())
+}
+
 func TestBody(t *testing.T) {
 	reqs, err := New(Body("hey"))
 	require.NoError(t, err)
 	require.Equal(t, "hey", reqs.Body)
 }
 
+func TestStreamBody(t *testing.T) {
+	type received struct {
+		body             string
+		contentLength    int64
+		transferEncoding []string
+	}
+	var got received
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got = received{string(b), r.ContentLength, r.TransferEncoding}
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		name     string
+		opt      Option
+		expected received
+	}{
+		{
+			name:     "known length",
+			opt:      StreamBody(strings.NewReader("hello"), 5),
+			expected: received{"hello", 5, nil},
+		},
+		{
+			name:     "chunked",
+			opt:      StreamBody(strings.NewReader("hello"), 0),
+			expected: received{"hello", -1, []string{"chunked"}},
+		},
+		{
+			name: "func",
+			opt: StreamBodyFunc(func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(strings.NewReader("hello")), nil
+			}, -1),
+			expected: received{"hello", -1, []string{"chunked"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got = received{}
+			_, err := Send(Post(s.URL), test.opt)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+
+	t.Run("closes", func(t *testing.T) {
+		f, err := os.Open("options.go")
+		require.NoError(t, err)
+		_, err = Send(Post(s.URL), StreamBody(f, 0))
+		require.NoError(t, err)
+		_, err = f.Read(make([]byte, 1))
+		assert.Error(t, err)
+	})
+
+	t.Run("open error", func(t *testing.T) {
+		_, err := Send(Post(s.URL), StreamBodyFunc(func() (io.ReadCloser, error) {
+			return nil, errors.New("boom")
+		}, 0))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "boom")
+	})
+
+	t.Run("replaces GetBody", func(t *testing.T) {
+		reqs, err := New(Body("hi"))
+		require.NoError(t, err)
+		reqs.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("hi")), nil
+		}
+		require.NoError(t, reqs.Apply(StreamBody(strings.NewReader("hello"), 5)))
+		req, err := reqs.Request()
+		require.NoError(t, err)
+		assert.Nil(t, req.GetBody)
+	})
+}
+
+func TestBodyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"color":"red"}`), 0666))
+
+	t.Run("request", func(t *testing.T) {
+		req, err := Request(Post("/"), BodyFile(path))
+		require.NoError(t, err)
+		assert.EqualValues(t, 15, req.ContentLength)
+		assert.Equal(t, "application/json", req.Header.Get(HeaderContentType))
+		assertBody(t, req, `{"color":"red"}`)
+
+		// replayable
+		b, err := req.GetBody()
+		require.NoError(t, err)
+		defer b.Close()
+		replayed, _ := ioutil.ReadAll(b)
+		assert.Equal(t, `{"color":"red"}`, string(replayed))
+	})
+
+	t.Run("explicit content type", func(t *testing.T) {
+		req, err := Request(ContentType("text/plain"), BodyFile(path))
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain", req.Header.Get(HeaderContentType))
+	})
+
+	t.Run("mock", func(t *testing.T) {
+		var into map[string]string
+		_, _, err := Receive(&into, MockDoer(200, BodyFile(path)))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"color": "red"}, into)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := Request(BodyFile(path + ".missing"))
+		require.Error(t, err)
+	})
+}
+
 type testMarshaler struct{}
 
 func (*testMarshaler) Unmarshal(_ []byte, _ string, _ interface{}) error {
@@ -497,6 +784,92 @@ func TestXML(t *testing.T) {
 	}
 }
 
+func TestUnmarshalOn(t *testing.T) {
+	m := map[string]Unmarshaler{"v2": &XMLMarshaler{}}
+	reqs := MustNew(UnmarshalOn("X-Schema", m))
+	if assert.IsType(t, &HeaderUnmarshaler{}, reqs.Unmarshaler) {
+		h := reqs.Unmarshaler.(*HeaderUnmarshaler)
+		assert.Equal(t, "X-Schema", h.Header)
+		assert.Equal(t, m, h.Unmarshalers)
+	}
+}
+
+func TestUnmarshalerFor(t *testing.T) {
+	var called bool
+	u := UnmarshalFunc(func([]byte, string, interface{}) error {
+		called = true
+		return nil
+	})
+
+	reqs := MustNew(UnmarshalerFor("application/problem+json", u))
+	if assert.IsType(t, &ContentTypeUnmarshaler{}, reqs.Unmarshaler) {
+		c := reqs.Unmarshaler.(*ContentTypeUnmarshaler)
+		require.NoError(t, c.Unmarshal(nil, "application/problem+json", nil))
+		assert.True(t, called)
+	}
+	assert.Nil(t, DefaultUnmarshaler.(*ContentTypeUnmarshaler).Unmarshalers["application/problem+json"], "should not modify DefaultUnmarshaler")
+
+	// the existing ContentTypeUnmarshaler is copied, not modified
+	c := &ContentTypeUnmarshaler{Sniff: true}
+	reqs = MustNew(c, UnmarshalerFor("application/problem+json", u))
+	assert.NotSame(t, c, reqs.Unmarshaler)
+	assert.Nil(t, c.Unmarshalers["application/problem+json"])
+	assert.True(t, reqs.Unmarshaler.(*ContentTypeUnmarshaler).Sniff)
+	assert.NotNil(t, reqs.Unmarshaler.(*ContentTypeUnmarshaler).Unmarshalers[MediaTypeJSON])
+
+	_, err := New(&HeaderUnmarshaler{}, UnmarshalerFor("application/problem+json", u))
+	require.Error(t, err)
+}
+
+func TestMsgPack(t *testing.T) {
+	reqs, err := New(MsgPack())
+	require.NoError(t, err)
+	assert.IsType(t, &MsgPackMarshaler{}, reqs.Marshaler)
+	assert.Equal(t, MediaTypeMsgPack, reqs.Header.Get(HeaderContentType))
+	assert.Equal(t, MediaTypeMsgPack, reqs.Header.Get(HeaderAccept))
+}
+
+func TestGob(t *testing.T) {
+	reqs, err := New(Gob())
+	require.NoError(t, err)
+	assert.IsType(t, &GobMarshaler{}, reqs.Marshaler)
+	assert.Equal(t, MediaTypeGob, reqs.Header.Get(HeaderContentType))
+	assert.Equal(t, MediaTypeGob, reqs.Header.Get(HeaderAccept))
+
+	// gob responses are only unmarshaled after opting in
+	var v testModel
+	_, _, err = Receive(&v, MockDoer(200, Gob(), Body(testModel{"red", 30})))
+	require.Error(t, err)
+
+	_, _, err = Receive(&v, Gob(), MockDoer(200, Gob(), Body(testModel{"red", 30})))
+	require.NoError(t, err)
+	assert.Equal(t, testModel{"red", 30}, v)
+
+	reqs, err = New(WithUnmarshaler(&JSONMarshaler{}), Gob())
+	require.NoError(t, err)
+	assert.IsType(t, &JSONMarshaler{}, reqs.Unmarshaler)
+}
+
+func TestOctetStream(t *testing.T) {
+	reqs, err := New(OctetStream())
+	require.NoError(t, err)
+	assert.IsType(t, &OctetStreamMarshaler{}, reqs.Marshaler)
+
+	// the content type applies to raw bodies too
+	req, err := reqs.Request(Body([]byte("red")))
+	require.NoError(t, err)
+	assert.Equal(t, MediaTypeOctetStream, req.Header.Get(HeaderContentType))
+	assert.Equal(t, MediaTypeOctetStream, req.Header.Get(HeaderAccept))
+}
+
+func TestProtobuf(t *testing.T) {
+	reqs, err := New(Protobuf())
+	require.NoError(t, err)
+	assert.IsType(t, &ProtoMarshaler{}, reqs.Marshaler)
+	assert.Equal(t, MediaTypeProtobuf, reqs.Header.Get(HeaderContentType))
+	assert.Equal(t, MediaTypeProtobuf, reqs.Header.Get(HeaderAccept))
+}
+
 func TestForm(t *testing.T) {
 	reqs, err := New(Form())
 	require.NoError(t, err)
@@ -780,3 +1153,43 @@ func ExampleRequester_Clone() {
 	bar.Apply(Get("bar/"))
 
 }
+
+func TestIf(t *testing.T) {
+	reqs := MustNew(
+		If(true, Header("X-A", "a"), Header("X-B", "b")),
+		If(false, Header("X-C", "c")),
+	)
+	assert.Equal(t, http.Header{"X-A": {"a"}, "X-B": {"b"}}, reqs.Header)
+}
+
+func TestIfEnv(t *testing.T) {
+	opt := IfEnv("REQUESTER_TEST_IFENV", Header("X-Debug", "on"))
+
+	for _, v := range []string{"", "0", "f", "F", "false", "FALSE", "False"} {
+		t.Setenv("REQUESTER_TEST_IFENV", v)
+		assert.Nil(t, MustNew(opt).Header, "value %q", v)
+	}
+
+	// the variable is read when the option is applied
+	for _, v := range []string{"1", "true", "yes"} {
+		t.Setenv("REQUESTER_TEST_IFENV", v)
+		assert.Equal(t, "on", MustNew(opt).Header.Get("X-Debug"), "value %q", v)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	calls := 0
+	opt := Lazy(func() Option {
+		calls++
+		return Header("X-Calls", strconv.Itoa(calls))
+	})
+	assert.Equal(t, 0, calls)
+
+	assert.Equal(t, "1", MustNew(opt).Header.Get("X-Calls"))
+	assert.Equal(t, "2", MustNew(opt).Header.Get("X-Calls"))
+
+	assert.Nil(t, MustNew(Lazy(func() Option { return nil })).Header)
+
+	_, err := New(Lazy(func() Option { return URL(":bad") }))
+	assert.Error(t, err)
+}
@@ -3,35 +3,67 @@ package requester
 import (
 	"context"
 	"net/http"
+	"sync/atomic"
 )
 
-// DefaultRequester is the singleton used by the package-level Request/Send/Receive functions.
+// DefaultRequester is the singleton used by the package-level Request/Send/Receive functions,
+// until SetDefault is called.  Modifying it changes the behavior of every package using
+// the package-level functions: prefer SetDefault, or better, a Requester of your own.
 // nolint:gochecknoglobals
 var DefaultRequester = Requester{}
 
-// Request uses the DefaultRequester to create a request.
+// defaultOverride holds the *Requester set with SetDefault.
+// nolint:gochecknoglobals
+var defaultOverride atomic.Value
+
+// SetDefault replaces the Requester used by the package-level functions.  A clone of r is
+// stored, so changing r afterward has no effect.  If r is nil, the package-level functions
+// revert to using DefaultRequester.  It's safe to call concurrently with requests.
+func SetDefault(r *Requester) {
+	if r != nil {
+		r = r.Clone()
+	}
+	defaultOverride.Store(&r)
+}
+
+// Default returns a clone of the Requester used by the package-level functions.  Since
+// it's a clone, changes to it don't affect other users of the package-level functions.
+func Default() *Requester {
+	return defaultRequester().Clone()
+}
+
+// defaultRequester returns the Requester used by the package-level functions.  It mustn't
+// be modified.
+func defaultRequester() *Requester {
+	if p, _ := defaultOverride.Load().(**Requester); p != nil && *p != nil {
+		return *p
+	}
+	return &DefaultRequester
+}
+
+// Request uses the default Requester to create a request.
 //
 // See Requester.Request() for more details.
 func Request(opts ...Option) (*http.Request, error) {
-	return DefaultRequester.Request(opts...)
+	return defaultRequester().Request(opts...)
 }
 
 // RequestContext does the same as Request(), but attaches a Context to the request.
 func RequestContext(ctx context.Context, opts ...Option) (*http.Request, error) {
-	return DefaultRequester.RequestContext(ctx, opts...)
+	return defaultRequester().RequestContext(ctx, opts...)
 }
 
-// Send uses the DefaultRequester to create a request and execute it.
+// Send uses the default Requester to create a request and execute it.
 // The body will not be read or closed.
 //
 // See Requester.Send() for more details.
 func Send(opts ...Option) (*http.Response, error) {
-	return DefaultRequester.Send(opts...)
+	return defaultRequester().Send(opts...)
 }
 
 // SendContext does the same as Send(), but attaches a Context to the request.
 func SendContext(ctx context.Context, opts ...Option) (*http.Response, error) {
-	return DefaultRequester.SendContext(ctx, opts...)
+	return defaultRequester().SendContext(ctx, opts...)
 }
 
 // ReceiveContext does the same as Receive(), but attaches a Context to
@@ -40,10 +72,10 @@ func SendContext(ctx context.Context, opts ...Option) (*http.Response, error) {
 // The second argument may be nil, an Option, or a value to unmarshal the
 // response body into.
 func ReceiveContext(ctx context.Context, into interface{}, opts ...Option) (*http.Response, []byte, error) {
-	return DefaultRequester.ReceiveContext(ctx, into, opts...)
+	return defaultRequester().ReceiveContext(ctx, into, opts...)
 }
 
-// Receive uses the DefaultRequester to create a request, execute it, and read the response.
+// Receive uses the default Requester to create a request, execute it, and read the response.
 // The response body will be fully read and closed.
 //
 // See Requester.Receive() for more details.
@@ -51,5 +83,30 @@ func ReceiveContext(ctx context.Context, into interface{}, opts ...Option) (*htt
 // The first argument may be nil, an Option, or a value to unmarshal the
 // response body into.
 func Receive(into interface{}, opts ...Option) (*http.Response, []byte, error) {
-	return DefaultRequester.Receive(into, opts...)
+	return defaultRequester().Receive(into, opts...)
+}
+
+// ReceiveFile uses the default Requester to download the response body to a file.
+//
+// See Requester.ReceiveFile() for more details.
+func ReceiveFile(path string, opts ...Option) (*http.Response, error) {
+	return defaultRequester().ReceiveFile(path, opts...)
+}
+
+// ReceiveFileContext does the same as ReceiveFile(), but attaches a Context to the request.
+func ReceiveFileContext(ctx context.Context, path string, opts ...Option) (*http.Response, error) {
+	return defaultRequester().ReceiveFileContext(ctx, path, opts...)
+}
+
+// ReceiveSpooled uses the default Requester to send a request, and read the response body
+// into memory, or a temp file if it's larger than maxMemory.
+//
+// See Requester.ReceiveSpooled() for more details.
+func ReceiveSpooled(maxMemory int64, opts ...Option) (*http.Response, *SpooledBody, error) {
+	return defaultRequester().ReceiveSpooled(maxMemory, opts...)
+}
+
+// ReceiveSpooledContext does the same as ReceiveSpooled(), but attaches a Context to the request.
+func ReceiveSpooledContext(ctx context.Context, maxMemory int64, opts ...Option) (*http.Response, *SpooledBody, error) {
+	return defaultRequester().ReceiveSpooledContext(ctx, maxMemory, opts...)
 }
@@ -3,8 +3,10 @@ package requester
 import (
 	"context"
 	"fmt"
+	"github.com/ansel1/merry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net/http"
 	"testing"
 )
 
@@ -123,3 +125,41 @@ func ExampleRequest() {
 
 	// Output: http://api.com/resource <nil>
 }
+
+func TestSetDefault(t *testing.T) {
+	defer SetDefault(nil)
+
+	r := MustNew(URL("http://blue.com/"), Header("X-Color", "blue"), MockDoer(201))
+	SetDefault(r)
+
+	// changes to r after SetDefault don't affect the default
+	r.MustApply(Header("X-Color", "red"))
+
+	i := Inspector{}
+	resp, err := Send(Get("green"), &i)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "http://blue.com/green", i.Request.URL.String())
+	assert.Equal(t, "blue", i.Request.Header.Get("X-Color"))
+
+	// Default returns a clone
+	d := Default()
+	d.MustApply(Header("X-Color", "green"))
+	assert.Equal(t, "blue", Default().Header.Get("X-Color"))
+
+	SetDefault(nil)
+	assert.Nil(t, Default().URL)
+}
+
+func TestNoDefaultClient(t *testing.T) {
+	_, err := Send(Get("http://blue.com/"), NoDefaultClient())
+	require.Error(t, err)
+	assert.True(t, merry.Is(err, ErrDefaultClient))
+
+	_, err = Send(Get("http://blue.com/"), NoDefaultClient(), WithDoer(http.DefaultClient))
+	assert.True(t, merry.Is(err, ErrDefaultClient))
+
+	resp, err := Send(Get("http://blue.com/"), NoDefaultClient(), MockDoer(204))
+	require.NoError(t, err)
+	assert.Equal(t, 204, resp.StatusCode)
+}
@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"io"
+	"net/http"
+)
+
+// ProgressFunc is called as a body is transferred, with the number of bytes transferred
+// so far, and the total size of the body.  total is -1 if the size isn't known.
+type ProgressFunc func(transferred, total int64)
+
+// OnUploadProgress is middleware which calls fn as the request body is sent.  It's handy
+// for CLIs which upload large files.
+//
+// fn is called from the goroutine writing the request, after each read of the body, and
+// when the body is exhausted.  If the body is rewound, e.g. by Retry, progress restarts
+// from zero.
+func OnUploadProgress(fn ProgressFunc) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Body == nil || req.Body == http.NoBody {
+				return next.Do(req)
+			}
+
+			total := req.ContentLength
+			if total == 0 {
+				total = -1
+			}
+
+			req = req.Clone(req.Context())
+			req.Body = &progressReader{ReadCloser: req.Body, total: total, fn: fn}
+			if getBody := req.GetBody; getBody != nil {
+				req.GetBody = func() (io.ReadCloser, error) {
+					b, err := getBody()
+					if err != nil {
+						return nil, err
+					}
+					return &progressReader{ReadCloser: b, total: total, fn: fn}, nil
+				}
+			}
+			return next.Do(req)
+		})
+	}
+}
+
+// OnDownloadProgress is middleware which calls fn as the response body is read.  total is
+// the response's Content-Length, or -1 if it's unknown.
+//
+// fn is called from the goroutine reading the body, after each read, and when the body
+// is exhausted.
+func OnDownloadProgress(fn ProgressFunc) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.Do(req)
+			if resp != nil && resp.Body != nil && resp.Body != http.NoBody {
+				resp.Body = &progressReader{ReadCloser: resp.Body, total: resp.ContentLength, fn: fn}
+			}
+			return resp, err
+		})
+	}
+}
+
+// progressReader calls fn after each read.
+type progressReader struct {
+	io.ReadCloser
+	transferred, total int64
+	fn                 ProgressFunc
+	done               bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.transferred += int64(n)
+	switch {
+	case err == io.EOF && !p.done:
+		p.done = true
+		p.fn(p.transferred, p.total)
+	case n > 0:
+		p.fn(p.transferred, p.total)
+	}
+	return n, err
+}
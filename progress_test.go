@@ -0,0 +1,107 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type progressRecorder struct {
+	sync.Mutex
+	calls [][2]int64
+}
+
+func (p *progressRecorder) record(transferred, total int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.calls = append(p.calls, [2]int64{transferred, total})
+}
+
+func (p *progressRecorder) last() [2]int64 {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.calls) == 0 {
+		return [2]int64{}
+	}
+	return p.calls[len(p.calls)-1]
+}
+
+func TestOnUploadProgress(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer s.Close()
+
+	body := strings.Repeat("x", 100000)
+
+	t.Run("known length", func(t *testing.T) {
+		var p progressRecorder
+		_, err := Send(Post(s.URL), Body(body), OnUploadProgress(p.record))
+		require.NoError(t, err)
+		assert.Equal(t, [2]int64{100000, 100000}, p.last())
+		assert.Greater(t, len(p.calls), 1)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		var p progressRecorder
+		_, err := Send(Post(s.URL), StreamBody(strings.NewReader(body), 0), OnUploadProgress(p.record))
+		require.NoError(t, err)
+		assert.Equal(t, [2]int64{100000, -1}, p.last())
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		var p progressRecorder
+		_, err := Send(Get(s.URL), OnUploadProgress(p.record))
+		require.NoError(t, err)
+		assert.Empty(t, p.calls)
+	})
+
+	t.Run("rewound", func(t *testing.T) {
+		var p progressRecorder
+		var rewound *http.Request
+		_, err := Send(Post(s.URL), Body("hello"), OnUploadProgress(p.record), Middleware(func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				rewound = req
+				return next.Do(req)
+			})
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, [2]int64{5, 5}, p.last())
+		n := len(p.calls)
+
+		// progress restarts from zero
+		b, err := rewound.GetBody()
+		require.NoError(t, err)
+		_, _ = ioutil.ReadAll(b)
+		assert.Greater(t, len(p.calls), n)
+		assert.Equal(t, [2]int64{5, 5}, p.last())
+	})
+}
+
+func TestOnDownloadProgress(t *testing.T) {
+	body := strings.Repeat("x", 100000)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", "100000")
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer s.Close()
+
+	var p progressRecorder
+	_, b, err := Receive(Get(s.URL), OnDownloadProgress(p.record))
+	require.NoError(t, err)
+	assert.Len(t, b, 100000)
+	assert.Equal(t, [2]int64{100000, 100000}, p.last())
+
+	p = progressRecorder{}
+	_, _, err = Receive(Get(s.URL), QueryParam("chunked", "1"), OnDownloadProgress(p.record))
+	require.NoError(t, err)
+	assert.Equal(t, [2]int64{100000, -1}, p.last())
+}
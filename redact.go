@@ -0,0 +1,178 @@
+package requester
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces sensitive values.
+const redacted = "REDACTED"
+
+// Redaction lists values which should be hidden when requests and responses are logged
+// or captured.  It's honored by Dump, Inspector, and Requester.Describe.
+//
+// A nil *Redaction doesn't redact anything, except passwords in URLs.
+type Redaction struct {
+	// Headers are the names of headers whose values are redacted.  Names are case-insensitive.
+	Headers []string
+
+	// QueryParams are the names of query parameters whose values are redacted.  Passwords in
+	// URLs are always redacted.
+	QueryParams []string
+
+	// JSONFields are paths of fields in JSON bodies whose values are redacted.  Paths are
+	// dot-separated field names, starting at the root of the document, e.g. "user.password".
+	// Arrays are traversed transparently, so "users.password" matches the password field
+	// of each element of the users array.  A "*" segment matches any field.
+	JSONFields []string
+}
+
+// DefaultRedaction is the Redaction used by Dump and Requester.Describe, unless
+// configured otherwise.  Additional names can be appended to its fields.
+// nolint:gochecknoglobals
+var DefaultRedaction = &Redaction{
+	Headers: []string{
+		HeaderAuthorization,
+		HeaderProxyAuthorization,
+		"Cookie",
+		"Set-Cookie",
+		"X-Api-Key",
+		"X-Auth-Token",
+	},
+	QueryParams: []string{
+		"access_token",
+		"api_key",
+		"client_secret",
+		"password",
+	},
+	JSONFields: []string{
+		"access_token",
+		"client_secret",
+		"id_token",
+		"password",
+		"refresh_token",
+	},
+}
+
+// Header returns a copy of h, with the values of sensitive headers redacted.
+func (r *Redaction) Header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		if r != nil && containsFold(r.Headers, k) {
+			h2[k] = []string{redacted}
+			continue
+		}
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// URL returns a copy of u, with the password and the values of sensitive query params redacted.
+func (r *Redaction) URL(u *url.URL) *url.URL {
+	if u == nil {
+		return nil
+	}
+	u2 := cloneURL(u)
+	if _, ok := u2.User.Password(); ok {
+		u2.User = url.UserPassword(u2.User.Username(), redacted)
+	}
+	if u2.RawQuery != "" {
+		u2.RawQuery = r.Query(u2.Query()).Encode()
+	}
+	return u2
+}
+
+// Query returns a copy of q, with the values of sensitive params redacted.
+func (r *Redaction) Query(q url.Values) url.Values {
+	if q == nil {
+		return nil
+	}
+	q2 := make(url.Values, len(q))
+	for k, v := range q {
+		if r != nil && containsFold(r.QueryParams, k) {
+			q2[k] = []string{redacted}
+			continue
+		}
+		q2[k] = append([]string(nil), v...)
+	}
+	return q2
+}
+
+// Body redacts the sensitive fields in a JSON body.  Bodies which aren't JSON, according
+// to their content type, or which can't be parsed, are returned unchanged.  If no fields
+// are redacted, the original body is returned, otherwise the body is re-encoded.
+func (r *Redaction) Body(contentType string, body []byte) []byte {
+	if r == nil || len(r.JSONFields) == 0 || len(body) == 0 || !isJSONContentType(contentType) {
+		return body
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+
+	var changed bool
+	for _, path := range r.JSONFields {
+		if redactJSON(doc, strings.Split(path, ".")) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactJSON replaces the values at path in doc.  Returns true if anything was replaced.
+func redactJSON(doc interface{}, path []string) bool {
+	var changed bool
+	switch t := doc.(type) {
+	case []interface{}:
+		for _, e := range t {
+			if redactJSON(e, path) {
+				changed = true
+			}
+		}
+	case map[string]interface{}:
+		for k, v := range t {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				t[k] = redacted
+				changed = true
+			} else if redactJSON(v, path[1:]) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == MediaTypeJSON || generalMediaType(mediaType) == MediaTypeJSON
+}
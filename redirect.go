@@ -0,0 +1,163 @@
+package requester
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+type redirectsCtxKey struct{}
+
+// RedirectsFromContext returns the redirect responses followed by the FollowRedirects
+// middleware, in order.  Pass it the context of the final response's request:
+//
+//	resp, err := r.Send(requester.FollowRedirects(10, false))
+//	for _, redirect := range requester.RedirectsFromContext(resp.Request.Context()) {
+//		fmt.Println(redirect.Request.URL, redirect.StatusCode, redirect.Header.Get("Location"))
+//	}
+//
+// The bodies of the redirect responses have already been closed.  Returns nil if no
+// redirects were followed.
+func RedirectsFromContext(ctx context.Context) []*http.Response {
+	redirects, _ := ctx.Value(redirectsCtxKey{}).([]*http.Response)
+	return redirects
+}
+
+// FollowRedirects is middleware which follows redirects, up to max redirects.  Unlike the
+// redirect policy of http.Client, it works with any Doer, including mocks, and Doers which
+// wrap other Requesters.  The redirects followed are recorded in the context of the final
+// response's request, and can be retrieved with RedirectsFromContext.
+//
+// If sameHostOnly is true, redirects to other hosts aren't followed: the redirect response is
+// returned instead.  If max redirects have been followed, and the server redirects again, an
+// error is returned, along with the last redirect response.
+//
+// Redirects follow the same rules as http.Client: 301, 302, and 303 redirects are followed with
+// a GET (or HEAD) and no body, and 307 and 308 redirects repeat the method and body, if
+// the request's GetBody is set.  Sensitive headers, like Authorization and Cookie, aren't
+// forwarded to other hosts.
+//
+// If the Doer is an http.Client, which follows redirects itself, it should be configured not
+// to, with httpclient.NoRedirects().
+func FollowRedirects(max int, sameHostOnly bool) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			var redirects []*http.Response
+
+			for {
+				resp, err := next.Do(req)
+				if err != nil || resp == nil {
+					return resp, err
+				}
+				if resp.Request == nil {
+					resp.Request = req
+				}
+
+				nextReq, err := redirectRequest(req, resp, sameHostOnly)
+				if err != nil || nextReq == nil {
+					return resp, err
+				}
+
+				if len(redirects) >= max {
+					return resp, merry.Errorf("stopped after %d redirects", len(redirects))
+				}
+
+				drain(resp.Body)
+				redirects = append(redirects, resp)
+				req = nextReq.WithContext(context.WithValue(ctx, redirectsCtxKey{}, redirects[:len(redirects):len(redirects)]))
+			}
+		})
+	}
+}
+
+// redirectRequest returns the request which follows the redirect in resp, or nil if
+// the redirect shouldn't be followed.
+func redirectRequest(req *http.Request, resp *http.Response, sameHostOnly bool) (*http.Request, error) {
+	method := req.Method
+	keepBody := false
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther:
+		// same as http.Client: only HEAD is preserved
+		if method != http.MethodHead {
+			method = http.MethodGet
+		}
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		keepBody = true
+	default:
+		return nil, nil
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, nil
+	}
+
+	u, err := req.URL.Parse(location)
+	if err != nil {
+		return nil, merry.Prependf(err, "parsing redirect location %q", location)
+	}
+
+	sameHost := strings.EqualFold(u.Host, req.URL.Host)
+	if sameHostOnly && !sameHost {
+		return nil, nil
+	}
+
+	nextReq, err := http.NewRequestWithContext(req.Context(), method, u.String(), nil)
+	if err != nil {
+		return nil, merry.Wrap(err)
+	}
+
+	if keepBody && req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			// the body can't be replayed, so the redirect can't be followed
+			return nil, nil
+		}
+		b, err := req.GetBody()
+		if err != nil {
+			return nil, merry.Prepend(err, "calling req.GetBody")
+		}
+		nextReq.Body, nextReq.GetBody, nextReq.ContentLength = b, req.GetBody, req.ContentLength
+	}
+
+	nextReq.Header = redirectHeader(req.Header, keepBody, sameHost)
+	if ref := refererForRedirect(req.URL, u); ref != "" {
+		nextReq.Header.Set("Referer", ref)
+	}
+
+	return nextReq, nil
+}
+
+// redirectHeader returns the headers to send with a redirected request.
+func redirectHeader(h http.Header, keepBody, sameHost bool) http.Header {
+	h2 := h.Clone()
+	if h2 == nil {
+		h2 = http.Header{}
+	}
+	if !keepBody {
+		h2.Del(HeaderContentType)
+		h2.Del("Content-Length")
+	}
+	if !sameHost {
+		for _, k := range []string{HeaderAuthorization, "Www-Authenticate", "Cookie", "Cookie2", HeaderProxyAuthorization} {
+			h2.Del(k)
+		}
+	}
+	return h2
+}
+
+// refererForRedirect returns the Referer header for a redirect from from to to.  Like
+// http.Client, no Referer is sent from https to http.
+func refererForRedirect(from, to *url.URL) string {
+	if from.Scheme == "https" && to.Scheme == "http" {
+		return ""
+	}
+	ref := *from
+	ref.User = nil
+	ref.Fragment = ""
+	return ref.String()
+}
@@ -0,0 +1,86 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowRedirects(t *testing.T) {
+	type sent struct {
+		method, url, body, auth string
+	}
+	var requests []sent
+
+	// a mock Doer, which http.Client's redirect policy wouldn't apply to
+	var doer DoerFunc = func(req *http.Request) (*http.Response, error) {
+		var body []byte
+		if req.Body != nil {
+			body, _ = ioutil.ReadAll(req.Body)
+		}
+		requests = append(requests, sent{req.Method, req.URL.String(), string(body), req.Header.Get(HeaderAuthorization)})
+
+		resp := MockResponse(200)
+		switch req.URL.Path {
+		case "/see-other":
+			resp = MockResponse(303, Header("Location", "/temporary"))
+		case "/temporary":
+			resp = MockResponse(307, Header("Location", "http://other.com/final"))
+		case "/loop":
+			resp = MockResponse(302, Header("Location", "/loop"))
+		}
+		resp.Request = req
+		return resp, nil
+	}
+
+	r := MustNew(doer, URL("http://example.com"), BearerAuth("token"))
+
+	resp, err := r.Send(Post("/see-other"), Body("red"), FollowRedirects(10, false))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []sent{
+		{"POST", "http://example.com/see-other", "red", "Bearer token"},
+		// 303 switches to GET, without a body
+		{"GET", "http://example.com/temporary", "", "Bearer token"},
+		// credentials aren't forwarded to other hosts
+		{"GET", "http://other.com/final", "", ""},
+	}, requests)
+
+	redirects := RedirectsFromContext(resp.Request.Context())
+	require.Len(t, redirects, 2)
+	assert.Equal(t, 303, redirects[0].StatusCode)
+	assert.Equal(t, "http://example.com/see-other", redirects[0].Request.URL.String())
+	assert.Equal(t, 307, redirects[1].StatusCode)
+	assert.Equal(t, "http://example.com/temporary", redirects[1].Request.URL.String())
+
+	t.Run("307 keeps the method and body", func(t *testing.T) {
+		requests = nil
+		_, err := r.Send(Put("/temporary"), Body("blue"), FollowRedirects(10, false))
+		require.NoError(t, err)
+		assert.Equal(t, sent{"PUT", "http://other.com/final", "blue", ""}, requests[1])
+	})
+
+	t.Run("same host only", func(t *testing.T) {
+		resp, err := r.Send(Get("/temporary"), FollowRedirects(10, true))
+		require.NoError(t, err)
+		assert.Equal(t, 307, resp.StatusCode)
+	})
+
+	t.Run("max", func(t *testing.T) {
+		requests = nil
+		resp, err := r.Send(Get("/loop"), FollowRedirects(3, false))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "stopped after 3 redirects")
+		assert.Equal(t, 302, resp.StatusCode)
+		assert.Len(t, requests, 4)
+	})
+
+	t.Run("no redirects", func(t *testing.T) {
+		resp, err := r.Send(Get("/final"), FollowRedirects(3, false))
+		require.NoError(t, err)
+		assert.Nil(t, RedirectsFromContext(resp.Request.Context()))
+	})
+}
@@ -5,10 +5,13 @@ import (
 	"context"
 	"github.com/ansel1/merry"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Requester is an HTTP request builder and HTTP client.
@@ -99,6 +102,8 @@ type Requester struct {
 	// Body can be set to a string, []byte, io.Reader, or a struct.
 	// If set to a string, []byte, or io.Reader,
 	// the value will be used as the body of the request.
+	// If set to a func() (io.ReadCloser, error), it's called to
+	// open the body of each request, and used as the request's GetBody.
 	// If set to a struct, the Marshaler
 	// will be used to marshal the value into the request body.
 	Body interface{}
@@ -111,6 +116,17 @@ type Requester struct {
 	// Marshaler will supply an appropriate one.
 	Marshaler Marshaler
 
+	// Charset, if not nil, replaces the charset parameter of the Content-Type
+	// supplied by the Marshaler.  If it points to an empty string, the charset
+	// parameter is removed.  It has no effect on a Content-Type explicitly
+	// set in Header.
+	Charset *string
+
+	// Signer, if set, is invoked on each request after it is fully built
+	// but before it is sent.  It may modify the request, e.g. adding a
+	// signature header.
+	Signer Signer
+
 	//  Attributes related to sending requester and handling
 	//  responses.
 	//  -----------------------------------------------------
@@ -123,15 +139,41 @@ type Requester struct {
 	// to innermost.
 	Middleware []Middleware
 
+	// middlewareNames holds the names of the middleware installed with UseNamed,
+	// by position in Middleware.
+	middlewareNames []string
+
+	// noDefaultClient is set by the NoDefaultClient option.
+	noDefaultClient bool
+
+	// queryCache holds the last encoded query string.  It's created by New, and Clone
+	// gives each clone its own.  Requesters which weren't created by New or Clone don't
+	// cache the query.
+	queryCache *queryCacheHolder
+
+	// checksum, if set, verifies files downloaded by ReceiveFile.
+	checksum *checksum
+
 	// Unmarshaler will be used by the Receive methods to unmarshal
 	// the response body.  Defaults to DefaultUnmarshaler, which unmarshals
 	// multiple content types based on the Content-Type response header.
 	Unmarshaler Unmarshaler
+
+	// MaxResponseBody, if greater than zero, is the largest response body the
+	// Receive methods will read, in bytes, including bodies streamed with StreamResponse.
+	// Longer bodies return ErrBodyTooLarge.
+	MaxResponseBody int64
+
+	// StreamResponse, if true, causes the Receive methods to unmarshal the
+	// response body directly from the response stream, if the Unmarshaler
+	// implements StreamUnmarshaler.  This avoids holding large responses in
+	// memory, but the Receive methods will not return the body bytes.
+	StreamResponse bool
 }
 
 // New returns a new Requester, applying all options.
 func New(options ...Option) (*Requester, error) {
-	b := &Requester{}
+	b := &Requester{queryCache: &queryCacheHolder{}}
 	err := b.Apply(options...)
 	if err != nil {
 		return nil, merry.Wrap(err)
@@ -142,7 +184,7 @@ func New(options ...Option) (*Requester, error) {
 // MustNew creates a new Requester, applying all options.  If
 // an error occurs applying options, this will panic.
 func MustNew(options ...Option) *Requester {
-	b := &Requester{}
+	b := &Requester{queryCache: &queryCacheHolder{}}
 	b.MustApply(options...)
 	return b
 }
@@ -184,6 +226,11 @@ func (r *Requester) Clone() *Requester {
 	s2.Trailer = cloneHeader(r.Trailer)
 	s2.URL = cloneURL(r.URL)
 	s2.QueryParams = cloneValues(r.QueryParams)
+	if r.Middleware != nil {
+		s2.Middleware = append([]Middleware(nil), r.Middleware...)
+	}
+	s2.middlewareNames = append([]string(nil), r.middlewareNames...)
+	s2.queryCache = &queryCacheHolder{}
 	return &s2
 }
 
@@ -218,18 +265,26 @@ func (r *Requester) RequestContext(ctx context.Context, opts ...Option) (*http.R
 		return nil, err
 	}
 
-	urlS := ""
-	if reqs.URL != nil {
-		urlS = reqs.URL.String()
-	}
-
-	req, err := http.NewRequest(reqs.Method, urlS, bodyData)
+	// create the request with an empty URL, then set a copy of ours,
+	// which is cheaper than formatting and re-parsing it
+	req, err := http.NewRequestWithContext(ctx, reqs.Method, "", bodyData)
 	if err != nil {
 		return nil, merry.Prepend(err, "creating request")
 	}
+	if reqs.URL != nil {
+		req.URL = cloneURL(reqs.URL)
+		req.URL.Host = removeEmptyPort(req.URL.Host)
+		req.Host = req.URL.Host
+	}
 
 	// if we marshaled the body, use our content type
 	if ct != "" {
+		if reqs.Charset != nil {
+			ct, err = setCharset(ct, *reqs.Charset)
+			if err != nil {
+				return nil, err
+			}
+		}
 		req.Header.Set("Content-Type", ct)
 	}
 
@@ -239,6 +294,14 @@ func (r *Requester) RequestContext(ctx context.Context, opts ...Option) (*http.R
 
 	if reqs.GetBody != nil {
 		req.GetBody = reqs.GetBody
+	} else if getBody, ok := reqs.Body.(func() (io.ReadCloser, error)); ok {
+		req.GetBody = getBody
+		// send files, like those opened by BodyFile, with a Content-Length
+		if f, ok := bodyData.(*os.File); ok && req.ContentLength == 0 {
+			if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+				req.ContentLength = fi.Size()
+			}
+		}
 	}
 
 	// copy the host
@@ -255,22 +318,121 @@ func (r *Requester) RequestContext(ctx context.Context, opts ...Option) (*http.R
 		req.Header[k] = v
 	}
 
+	if DefaultUserAgent != "" && req.Header.Get(HeaderUserAgent) == "" {
+		req.Header.Set(HeaderUserAgent, DefaultUserAgent)
+	}
+
 	if len(reqs.QueryParams) > 0 {
-		if req.URL.RawQuery != "" {
-			existingValues := req.URL.Query()
-			for key, value := range reqs.QueryParams {
-				for _, v := range value {
-					existingValues.Add(key, v)
-				}
+		req.URL.RawQuery = reqs.encodedQuery(req.URL.RawQuery)
+	}
+
+	if reqs.Signer != nil {
+		if err := reqs.Signer.Sign(req); err != nil {
+			return nil, merry.Prepend(err, "signing request")
+		}
+	}
+
+	return req, nil
+}
+
+// removeEmptyPort strips the empty port in "host:", like http.NewRequest does.
+func removeEmptyPort(host string) string {
+	if strings.LastIndex(host, ":") > strings.LastIndex(host, "]") {
+		return strings.TrimSuffix(host, ":")
+	}
+	return host
+}
+
+// queryCacheHolder holds a Requester's queryCache.  It's held by pointer, so
+// copying a Requester doesn't copy the atomic.
+type queryCacheHolder struct {
+	last atomic.Pointer[queryCache]
+}
+
+func (h *queryCacheHolder) load() *queryCache {
+	if h == nil {
+		return nil
+	}
+	return h.last.Load()
+}
+
+func (h *queryCacheHolder) store(c *queryCache) {
+	if h == nil {
+		return
+	}
+	h.last.Store(c)
+}
+
+// queryCache holds the last query string encoded by encodedQuery, and
+// the inputs it was encoded from.  It's immutable.
+type queryCache struct {
+	rawQuery string
+	// params is a copy of the QueryParams, flattened to cut allocations: each key
+	// is followed by its values.  counts holds the number of values of each key.
+	params  []string
+	counts  []int
+	encoded string
+}
+
+// encodedQuery merges the QueryParams into the URL's raw query, and encodes the result.
+// Encoding shows up in profiles, so the result is cached, and reused until the URL's
+// query or the QueryParams change.
+func (r *Requester) encodedQuery(rawQuery string) string {
+	if c := r.queryCache.load(); c != nil && c.rawQuery == rawQuery && c.matches(r.QueryParams) {
+		return c.encoded
+	}
+
+	var encoded string
+	if rawQuery != "" {
+		existingValues, _ := url.ParseQuery(rawQuery)
+		for key, value := range r.QueryParams {
+			for _, v := range value {
+				existingValues.Add(key, v)
 			}
-			req.URL.RawQuery = existingValues.Encode()
-		} else {
-			req.URL.RawQuery = reqs.QueryParams.Encode()
 		}
+		encoded = existingValues.Encode()
+	} else {
+		encoded = r.QueryParams.Encode()
+	}
+
+	if r.queryCache == nil {
+		return encoded
+	}
 
+	// copy the params, since their values can be modified in place
+	c := &queryCache{rawQuery: rawQuery, counts: make([]int, 0, len(r.QueryParams)), encoded: encoded}
+	n := len(r.QueryParams)
+	for _, v := range r.QueryParams {
+		n += len(v)
 	}
+	c.params = make([]string, 0, n)
+	for k, v := range r.QueryParams {
+		c.params = append(append(c.params, k), v...)
+		c.counts = append(c.counts, len(v))
+	}
+	r.queryCache.store(c)
+	return encoded
+}
 
-	return req.WithContext(ctx), nil
+// matches returns true if the cache was encoded from params.
+func (c *queryCache) matches(params url.Values) bool {
+	if len(params) != len(c.counts) {
+		return false
+	}
+	i := 0
+	for _, n := range c.counts {
+		v, ok := params[c.params[i]]
+		if !ok || len(v) != n {
+			return false
+		}
+		for j := range v {
+			if v[j] != c.params[i+1+j] {
+				return false
+			}
+		}
+		i += 1 + n
+	}
+	return true
 }
 
 // getRequestBody returns the io.Reader which should be used as the body
@@ -279,6 +441,12 @@ func (r *Requester) getRequestBody() (body io.Reader, contentType string, _ erro
 	switch v := r.Body.(type) {
 	case nil:
 		return nil, "", nil
+	case func() (io.ReadCloser, error):
+		b, err := v()
+		if err != nil {
+			return nil, "", merry.Prepend(err, "opening body")
+		}
+		return b, "", nil
 	case io.Reader:
 		return v, "", nil
 	case string:
@@ -290,7 +458,7 @@ func (r *Requester) getRequestBody() (body io.Reader, contentType string, _ erro
 		if marshaler == nil {
 			marshaler = DefaultMarshaler
 		}
-		b, ct, err := marshaler.Marshal(r.Body)
+		b, ct, err := marshalRequest(marshaler, r.Header, r.Body)
 		if err != nil {
 			return nil, "", merry.Prepend(err, "marshaling body")
 		}
@@ -298,6 +466,21 @@ func (r *Requester) getRequestBody() (body io.Reader, contentType string, _ erro
 	}
 }
 
+// setCharset replaces the charset parameter of a media type.  If
+// charset is empty, the parameter is removed.
+func setCharset(contentType, charset string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", merry.Prependf(err, "failed to parse content type: %s", contentType)
+	}
+	if charset == "" {
+		delete(params, "charset")
+	} else {
+		params["charset"] = charset
+	}
+	return mime.FormatMediaType(mediaType, params), nil
+}
+
 // Send executes a request with the Doer.  The response body is not closed:
 // it is the caller's responsibility to close the response body.
 // If the caller prefers the body as a byte slice, or prefers the body
@@ -311,7 +494,13 @@ func (r *Requester) Send(opts ...Option) (*http.Response, error) {
 // withOpts is like With(), but skips the clone if there are no options to apply.
 func (r *Requester) withOpts(opts ...Option) (*Requester, error) {
 	if len(opts) > 0 {
-		return r.With(opts...)
+		reqs, err := r.With(opts...)
+		if err != nil {
+			return nil, err
+		}
+		// the clone is only used for one request, so caching its query would be wasted work
+		reqs.queryCache = nil
+		return reqs, nil
 	}
 	return r, nil
 }
@@ -341,6 +530,9 @@ func (r *Requester) Do(req *http.Request) (*http.Response, error) {
 	if doer == nil {
 		doer = http.DefaultClient
 	}
+	if r.noDefaultClient && doer == Doer(http.DefaultClient) {
+		return nil, ErrDefaultClient.Here()
+	}
 
 	resp, err := Wrap(doer, r.Middleware...).Do(req)
 	return resp, merry.Wrap(err)
@@ -378,11 +570,22 @@ func (r *Requester) ReceiveContext(ctx context.Context, into interface{}, opts .
 	}
 
 	resp, err = r.SendContext(ctx)
+	return r.receive(resp, err, into)
+}
+
+// receive reads the response body, and unmarshals it into into, if into isn't nil.
+// err is the error from sending the request.
+func (r *Requester) receive(resp *http.Response, err error, into interface{}) (*http.Response, []byte, error) {
+	if err == nil && into != nil && r.StreamResponse {
+		if su, ok := r.unmarshaler().(StreamUnmarshaler); ok {
+			return resp, nil, streamBody(su, resp, into, r.MaxResponseBody)
+		}
+	}
 
 	// Due to middleware, there are cases where both a response *and* and error
 	// are returned.  We need to make sure we handle the body, if present, even when
 	// an error was returned.
-	body, bodyReadError := readBody(resp)
+	body, bodyReadError := readBody(resp, r.MaxResponseBody)
 
 	if err != nil {
 		return resp, body, err
@@ -393,17 +596,50 @@ func (r *Requester) ReceiveContext(ctx context.Context, into interface{}, opts .
 	}
 
 	if into != nil {
-		unmarshaler := r.Unmarshaler
-		if unmarshaler == nil {
-			unmarshaler = DefaultUnmarshaler
-		}
-
-		err = unmarshaler.Unmarshal(body, resp.Header.Get("Content-Type"), into)
+		err = unmarshalResponse(r.unmarshaler(), resp, body, into)
 	}
 	return resp, body, err
 }
 
-func readBody(resp *http.Response) ([]byte, error) {
+func (r *Requester) unmarshaler() Unmarshaler {
+	if r.Unmarshaler == nil {
+		return DefaultUnmarshaler
+	}
+	return r.Unmarshaler
+}
+
+// streamBody unmarshals the response body directly from the stream.  The rest of
+// the body is drained, so the connection can be reused.  If max is greater than zero,
+// bodies longer than max return ErrBodyTooLarge.
+func streamBody(su StreamUnmarshaler, resp *http.Response, into interface{}, max int64) error {
+	body := resp.Body
+	if body == nil {
+		body = http.NoBody
+	}
+
+	defer drain(body)
+
+	var rd io.Reader = body
+	if max > 0 {
+		rd = &maxSizeReader{r: body, n: max}
+	}
+	err := su.UnmarshalReader(rd, resp.Header.Get(HeaderContentType), into)
+	if merry.Is(err, ErrBodyTooLarge) {
+		return ErrBodyTooLarge.Here()
+	}
+	return err
+}
+
+// MaxBodyPreallocation caps the size of the buffer preallocated to read a response
+// body, based on its Content-Length.  Longer bodies are still read: the buffer grows
+// as they are.  The cap keeps servers from making clients allocate large buffers by
+// sending large Content-Length headers.
+// nolint:gochecknoglobals
+var MaxBodyPreallocation int64 = 1 << 20
+
+// readBody reads and closes the response body.  If max is greater than zero, bodies
+// longer than max return ErrBodyTooLarge.
+func readBody(resp *http.Response, max int64) ([]byte, error) {
 
 	if resp == nil || resp.Body == nil || resp.Body == http.NoBody {
 		return nil, nil
@@ -411,20 +647,45 @@ func readBody(resp *http.Response) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	// Retry's ReadResponse has already read the body into memory, so
+	// use it rather than copying it again
+	if b, ok := resp.Body.(*bufferedBody); ok && b.Len() == len(b.b) {
+		if max > 0 && int64(len(b.b)) > max {
+			return nil, ErrBodyTooLarge.Here()
+		}
+		return b.b, nil
+	}
+
 	// check if we have a content length hint.  Pre-sizing
-	// the buffer saves time
+	// the buffer saves time, but don't trust it too far: it's
+	// supplied by the server
 	cls := resp.Header.Get("Content-Length")
 	var cl int64
 
 	if cls != "" {
 		cl, _ = strconv.ParseInt(cls, 10, 0)
 	}
+	if cl > MaxBodyPreallocation {
+		cl = MaxBodyPreallocation
+	}
+	if max > 0 && cl > max {
+		cl = max
+	}
 
-	buf := bytes.Buffer{}
+	var buf bytes.Buffer
 	if cl > 0 {
-		buf.Grow(int(cl))
+		// ReadFrom needs MinRead bytes free to detect EOF without growing the buffer
+		buf.Grow(int(cl) + bytes.MinRead)
 	}
-	if _, err := buf.ReadFrom(resp.Body); err != nil {
+
+	var body io.Reader = resp.Body
+	if max > 0 {
+		body = &maxSizeReader{r: body, n: max}
+	}
+	if _, err := buf.ReadFrom(body); err != nil {
+		if merry.Is(err, ErrBodyTooLarge) {
+			return nil, err
+		}
 		return nil, merry.Prepend(err, "reading response body")
 	}
 	return buf.Bytes(), nil
@@ -17,6 +17,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -172,6 +173,68 @@ func TestRequester_Request_QueryParams(t *testing.T) {
 			require.Equal(t, c.expectedURL, req.URL.String())
 		})
 	}
+
+	t.Run("changes after encoding", func(t *testing.T) {
+		reqs := MustNew(URL("http://a.io/?color=red"), QueryParam("limit", "30"))
+		urls := func() string {
+			req, err := reqs.Request()
+			require.NoError(t, err)
+			return req.URL.String()
+		}
+
+		assert.Equal(t, "http://a.io/?color=red&limit=30", urls())
+		assert.Equal(t, "http://a.io/?color=red&limit=30", urls())
+
+		reqs.QueryParams["limit"][0] = "40"
+		assert.Equal(t, "http://a.io/?color=red&limit=40", urls())
+
+		reqs.QueryParams.Add("limit", "50")
+		assert.Equal(t, "http://a.io/?color=red&limit=40&limit=50", urls())
+
+		reqs.URL.RawQuery = "color=blue"
+		assert.Equal(t, "http://a.io/?color=blue&limit=40&limit=50", urls())
+
+		// clones see their own changes
+		clone := reqs.Clone()
+		clone.QueryParams.Set("limit", "60")
+		req, err := clone.Request()
+		require.NoError(t, err)
+		assert.Equal(t, "http://a.io/?color=blue&limit=60", req.URL.String())
+		assert.Equal(t, "http://a.io/?color=blue&limit=40&limit=50", urls())
+
+		// neither clones nor per-request options replace the Requester's cached query
+		cached := reqs.queryCache.load()
+		require.NotNil(t, cached)
+		assert.NotSame(t, reqs.queryCache, clone.queryCache)
+		req, err = reqs.Request(QueryParam("page", "2"))
+		require.NoError(t, err)
+		assert.Equal(t, "http://a.io/?color=blue&limit=40&limit=50&page=2", req.URL.String())
+		assert.Same(t, cached, reqs.queryCache.load())
+	})
+
+	t.Run("concurrent clones", func(t *testing.T) {
+		// run with -race
+		reqs := MustNew(URL("http://a.io/"), QueryParam("limit", "30"))
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				_, err := reqs.Request()
+				assert.NoError(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				clone := reqs.Clone()
+				clone.QueryParams.Set("limit", "40")
+				req, err := clone.Request()
+				if assert.NoError(t, err) {
+					assert.Equal(t, "http://a.io/?limit=40", req.URL.String())
+				}
+			}()
+		}
+		wg.Wait()
+	})
 }
 
 func TestRequester_Request_Body(t *testing.T) {
@@ -245,6 +308,40 @@ func TestRequester_Request_Marshaler(t *testing.T) {
 	})
 }
 
+func TestRequester_Request_Charset(t *testing.T) {
+	cases := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"default", nil, "application/json; charset=UTF-8"},
+		{"none", []Option{Charset("")}, "application/json"},
+		{"other", []Option{Charset("ISO-8859-1")}, "application/json; charset=ISO-8859-1"},
+		{"no marshaler charset", []Option{MsgPack(), DeleteHeader(HeaderContentType), Charset("UTF-8")}, "application/msgpack; charset=UTF-8"},
+		{"explicit header", []Option{ContentType("application/json; charset=ascii"), Charset("")}, "application/json; charset=ascii"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := append([]Option{Body(map[string]string{"color": "red"})}, c.opts...)
+			req, err := Request(opts...)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, req.Header.Get(HeaderContentType))
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Request(
+			Body(map[string]string{"color": "red"}),
+			MarshalFunc(func(_ interface{}) ([]byte, string, error) {
+				return nil, "application/json; charset", nil
+			}),
+			Charset(""),
+		)
+		require.Error(t, err)
+	})
+}
+
 func TestRequester_Request_ContentLength(t *testing.T) {
 	reqs, err := New(Body("1234"))
 	require.NoError(t, err)
@@ -435,6 +532,51 @@ func TestRequester_Receive_withopts(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestRequester_Receive_maxResponseBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("0123456789"))
+	}))
+	defer ts.Close()
+
+	_, body, err := Receive(Get(ts.URL), MaxResponseBody(10))
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(body))
+
+	_, _, err = Receive(Get(ts.URL), MaxResponseBody(9))
+	require.Error(t, err)
+	assert.True(t, merry.Is(err, ErrBodyTooLarge))
+
+	t.Run("buffered by retry", func(t *testing.T) {
+		var buffered []byte
+		capture := Middleware(func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				resp, err := next.Do(req)
+				buffered = resp.Body.(*bufferedBody).b
+				return resp, err
+			})
+		})
+		retry := Retry(&RetryConfig{ReadResponse: true})
+
+		_, body, err := Receive(Get(ts.URL), capture, retry)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(body))
+		// not copied again
+		assert.Equal(t, &buffered[0], &body[0])
+
+		_, _, err = Receive(Get(ts.URL), capture, retry, MaxResponseBody(9))
+		assert.True(t, merry.Is(err, ErrBodyTooLarge))
+	})
+
+	t.Run("preallocation", func(t *testing.T) {
+		defer func(v int64) { MaxBodyPreallocation = v }(MaxBodyPreallocation)
+		MaxBodyPreallocation = 4
+		_, body, err := Receive(Get(ts.URL))
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789", string(body))
+	})
+}
+
 func TestRequester_ReceiveContext(t *testing.T) {
 
 	mux := http.NewServeMux()
@@ -509,6 +651,42 @@ func TestRequester_ReceiveContext(t *testing.T) {
 		assert.Equal(t, "green", m.Color)
 	})
 
+	t.Run("stream", func(t *testing.T) {
+		var m testModel
+		resp, body, err := Receive(&m, Get(ts.URL, "/model.json"), StreamResponse())
+		require.NoError(t, err)
+		assert.Equal(t, 206, resp.StatusCode)
+		assert.Nil(t, body)
+		assert.Equal(t, testModel{"green", 25}, m)
+
+		// if the unmarshaler can't stream, the body is read as usual
+		m = testModel{}
+		resp, body, err = Receive(&m, Get(ts.URL, "/model.json"), StreamResponse(),
+			UnmarshalFunc(func(data []byte, _ string, v interface{}) error {
+				return json.Unmarshal(data, v)
+			}),
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 206, resp.StatusCode)
+		assert.Equal(t, `{"color":"green","count":25}`, string(body))
+		assert.Equal(t, testModel{"green", 25}, m)
+
+		// without an into argument, the body is read as usual
+		_, body, err = Receive(Get(ts.URL, "/model.json"), StreamResponse())
+		require.NoError(t, err)
+		assert.Equal(t, `{"color":"green","count":25}`, string(body))
+
+		// MaxResponseBody applies to streamed bodies too
+		m = testModel{}
+		_, _, err = Receive(&m, Get(ts.URL, "/model.json"), StreamResponse(), MaxResponseBody(28))
+		require.NoError(t, err)
+		assert.Equal(t, testModel{"green", 25}, m)
+
+		_, _, err = Receive(&m, Get(ts.URL, "/model.json"), StreamResponse(), MaxResponseBody(10))
+		require.Error(t, err)
+		assert.True(t, merry.Is(err, ErrBodyTooLarge), err)
+	})
+
 	t.Run("acceptoptionsforintoargs", func(t *testing.T) {
 
 		mux.HandleFunc("/blue", func(writer http.ResponseWriter, request *http.Request) {
@@ -557,6 +735,12 @@ type TestStruct struct {
 	Important bool
 }
 
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func BenchmarkRequester_Receive(b *testing.B) {
 
 	inputJSON := `{"color":"blue","count":10,"flavor":"vanilla","important":true}`
@@ -585,6 +769,15 @@ func BenchmarkRequester_Receive(b *testing.B) {
 			}
 		})
 
+		b.Run("requester_configured", func(b *testing.B) {
+			// an already configured requester, with no per-call options
+			r := MustNew(mockServer, Get("/test"))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Receive(&TestStruct{})
+			}
+		})
+
 		b.Run("base", func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
 				req, _ := http.NewRequest("GET", "/test", nil)
@@ -594,6 +787,58 @@ func BenchmarkRequester_Receive(b *testing.B) {
 				json.Unmarshal(body, &TestStruct{})
 			}
 		})
+
+		// compare a configured requester to hand-written net/http code, both sending
+		// through an http.Client
+		client := &http.Client{Transport: roundTripperFunc(mockServer)}
+
+		b.Run("requester_client", func(b *testing.B) {
+			r := MustNew(WithDoer(client), Get("http://example.com/test"))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Receive(&TestStruct{})
+			}
+		})
+
+		b.Run("base_client", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req, _ := http.NewRequest("GET", "http://example.com/test", nil)
+				resp, _ := client.Do(req)
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				json.Unmarshal(body, &TestStruct{})
+			}
+		})
+	})
+
+	b.Run("query", func(b *testing.B) {
+		// encoding the QueryParams is cached per Requester
+		r := MustNew(mockServer, Get("/test?a=b"), QueryParam("color", "blue"), QueryParam("q", "user=sam"), QueryParam("limit", "30"))
+
+		b.Run("configured", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Receive(&TestStruct{})
+			}
+		})
+
+		b.Run("per_call", func(b *testing.B) {
+			// each call clones the Requester, so the cache isn't used
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Receive(&TestStruct{}, QueryParam("page", "2"))
+			}
+		})
+
+		b.Run("configured_parallel", func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					r.Receive(&TestStruct{})
+				}
+			})
+		})
 	})
 
 	b.Run("complex", func(b *testing.B) {
@@ -630,6 +875,25 @@ func BenchmarkRequester_Receive(b *testing.B) {
 			}
 		})
 
+		b.Run("template", func(b *testing.B) {
+			tmpl, err := MustNew(
+				mockServer,
+				Get("/test/blue/green"),
+				JSON(false),
+				Header("X-Under", "Over"),
+				Header("X-Over", "Under"),
+				QueryParam("color", "blue"),
+				QueryParam("q", "user=sam"),
+				Body(&ts),
+			).Compile()
+			require.NoError(b, err)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				tmpl.Receive(&ts)
+			}
+		})
+
 		b.Run("base", func(b *testing.B) {
 			for i := 0; i < b.N; i++ {
 				reqbody, _ := json.Marshal(&ts)
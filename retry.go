@@ -2,6 +2,7 @@ package requester
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"github.com/ansel1/merry"
 	"io"
@@ -10,6 +11,9 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -74,11 +78,51 @@ type RetryConfig struct {
 	// Backoff returns how long to wait between retries.  Defaults to
 	// an exponential backoff with some jitter.
 	Backoff Backoffer
+	// ResponseBackoff, if set, is used instead of Backoff, for strategies which need the
+	// response, e.g. to read rate limit reset times from headers.  Retry-After headers
+	// still take precedence, unless MaxRetryAfter is negative.
+	ResponseBackoff ResponseBackoffer
 	// ReadResponse will ensure the entire response is read before
 	// consider the request a success
 	ReadResponse bool
+	// MaxRetryAfter bounds the wait before retrying a 429 or 503 response with a
+	// Retry-After header.  The header's value, in seconds or as an HTTP date, is waited
+	// instead of the Backoff, but no longer than MaxRetryAfter.  Defaults to
+	// DefaultMaxRetryAfter.  If negative, Retry-After headers are ignored.
+	MaxRetryAfter time.Duration
+	// Budget, if set, caps retries to a fraction of requests.  A budget can be shared
+	// by many Retry middlewares, to cap retries across a whole process.
+	Budget *RetryBudget
+	// OnRetry, if set, is called before each retry, with the attempt which just
+	// completed, its request, response, and error, and how long Retry will wait before
+	// the next attempt.  Useful for logging or metrics.  The response body will be
+	// drained and closed after OnRetry returns.
+	OnRetry func(attempt int, req *http.Request, resp *http.Response, err error, delay time.Duration)
+	// AttemptHeader, if set, is the name of a header, like "X-Attempt", which is set to the
+	// number of each attempt, starting at 1, so servers can distinguish retries.  Whether or
+	// not it's set, the attempt number is available to downstream middleware from the
+	// request's context, with AttemptFromContext.
+	AttemptHeader string
+	// MaxElapsedTime, if set, stops retrying once the time since the first attempt began,
+	// plus the wait before the next attempt, would exceed it, even if MaxAttempts hasn't been
+	// reached.  It doesn't interrupt attempts in progress: use a context deadline for that.
+	MaxElapsedTime time.Duration
+	// BufferRequestBody, if true, makes requests with bodies which can't be rewound (i.e. without
+	// GetBody set, like arbitrary io.Readers) retryable, by reading the body into memory before
+	// the first attempt.  Bodies larger than MaxBufferedRequestBody, and bodies set with
+	// StreamBody, are sent without retries.
+	BufferRequestBody bool
+	// MaxBufferedRequestBody is the largest body BufferRequestBody will buffer, in bytes.
+	// Defaults to DefaultMaxBufferedRequestBody.
+	MaxBufferedRequestBody int64
 }
 
+// DefaultMaxBufferedRequestBody is the default value of RetryConfig.MaxBufferedRequestBody.
+const DefaultMaxBufferedRequestBody = 10 << 20
+
+// DefaultMaxRetryAfter is the default value of RetryConfig.MaxRetryAfter.
+const DefaultMaxRetryAfter = 120 * time.Second
+
 func (c *RetryConfig) normalize() {
 	if c.Backoff == nil {
 		c.Backoff = &DefaultBackoff
@@ -91,6 +135,14 @@ func (c *RetryConfig) normalize() {
 	if c.MaxAttempts < 1 {
 		c.MaxAttempts = 3
 	}
+
+	if c.MaxRetryAfter == 0 {
+		c.MaxRetryAfter = DefaultMaxRetryAfter
+	}
+
+	if c.MaxBufferedRequestBody <= 0 {
+		c.MaxBufferedRequestBody = DefaultMaxBufferedRequestBody
+	}
 }
 
 // ShouldRetryer evaluates whether an HTTP request should be retried.  resp may be nil.  Attempt is the number of
@@ -120,6 +172,171 @@ func AllRetryers(s ...ShouldRetryer) ShouldRetryer {
 	})
 }
 
+// AnyRetryers returns a ShouldRetryer which returns true if any of the supplied retryers return true.
+// Combined with AllRetryers, policies can be declared without writing custom functions:
+//
+//	c.ShouldRetry = AllRetryers(
+//		AnyRetryers(ShouldRetryerFunc(DefaultShouldRetry), RetryOnStatus(409)),
+//		ShouldRetryerFunc(OnlyIdempotentShouldRetry),
+//	)
+func AnyRetryers(s ...ShouldRetryer) ShouldRetryer {
+	return ShouldRetryerFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		for _, shouldRetryer := range s {
+			if shouldRetryer.ShouldRetry(attempt, req, resp, err) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RetryOnStatus returns a ShouldRetryer which returns true if the response's status code
+// is one of codes.  It returns false if there is no response.
+func RetryOnStatus(codes ...int) ShouldRetryer {
+	return ShouldRetryerFunc(func(_ int, _ *http.Request, resp *http.Response, _ error) bool {
+		if resp == nil {
+			return false
+		}
+		for _, code := range codes {
+			if resp.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// HeaderShouldRetry returns a ShouldRetryer which lets the server decide whether a request
+// should be retried, using a response header.  If the header's value is a boolean (as
+// parsed by strconv.ParseBool), it overrides next.  Otherwise, including when there is no
+// response, next is consulted.  If next is nil, DefaultShouldRetry is used.
+//
+// Several APIs use the X-Should-Retry header for this:
+//
+//	c.ShouldRetry = AllRetryers(HeaderShouldRetry("X-Should-Retry", nil), ShouldRetryerFunc(OnlyIdempotentShouldRetry))
+func HeaderShouldRetry(header string, next ShouldRetryer) ShouldRetryer {
+	if next == nil {
+		next = ShouldRetryerFunc(DefaultShouldRetry)
+	}
+
+	return ShouldRetryerFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		if resp != nil {
+			if b, perr := strconv.ParseBool(resp.Header.Get(header)); perr == nil {
+				return b
+			}
+		}
+		return next.ShouldRetry(attempt, req, resp, err)
+	})
+}
+
+// RetryBudget limits retries to a ratio of requests, to prevent retry storms when a server
+// is failing: without a budget, every failing request is multiplied into MaxAttempts requests,
+// adding load to a server which is already struggling.
+//
+// The budget is a token bucket.  Each request deposits Ratio tokens, and each retry
+// withdraws one token.  Retries are skipped when the bucket has less than one token.  The
+// bucket starts full, holding MaxTokens tokens, so occasional failures can be retried
+// even when there has been little traffic.
+//
+// A RetryBudget is safe for concurrent use, and may be shared by several RetryConfigs.
+type RetryBudget struct {
+	ratio     float64
+	maxTokens float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// NewRetryBudget returns a RetryBudget which allows retries for ratio of requests, e.g. 0.2
+// for 20%, and can accumulate up to maxTokens retries.  maxTokens < 1 is treated as 1.
+func NewRetryBudget(ratio float64, maxTokens int) *RetryBudget {
+	if maxTokens < 1 {
+		maxTokens = 1
+	}
+	return &RetryBudget{
+		ratio:     ratio,
+		maxTokens: float64(maxTokens),
+		tokens:    float64(maxTokens),
+	}
+}
+
+// Tokens returns the number of tokens in the budget, which is the number of
+// retries currently allowed.
+func (b *RetryBudget) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens
+}
+
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.tokens+b.ratio, b.maxTokens)
+}
+
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// BodyShouldRetry returns a ShouldRetryer which inspects the response body.  The request is
+// retried if shouldRetry returns true for the body, or if next says so.  If next is nil,
+// DefaultShouldRetry is used.  This handles APIs which report transient errors in the body,
+// sometimes even with a 200 status code:
+//
+//	c.ShouldRetry = BodyShouldRetry(func(resp *http.Response, body []byte) bool {
+//		return bytes.Contains(body, []byte(`"error":"throttled"`))
+//	}, nil)
+//
+// The entire body is read into memory, then restored, so it can still be read by the next
+// ShouldRetryer, or by the caller.  If the body was already buffered, e.g. by
+// RetryConfig.ReadResponse, the buffer is re-read.  If reading the body fails, the request is
+// retried, and if it isn't retried, the restored body returns the error after the bytes which
+// were read.
+func BodyShouldRetry(shouldRetry func(resp *http.Response, body []byte) bool, next ShouldRetryer) ShouldRetryer {
+	if next == nil {
+		next = ShouldRetryerFunc(DefaultShouldRetry)
+	}
+
+	return ShouldRetryerFunc(func(attempt int, req *http.Request, resp *http.Response, err error) bool {
+		if resp != nil && resp.Body != nil && resp.Body != http.NoBody {
+			body, rerr := ioutil.ReadAll(resp.Body)
+			if rerr != nil {
+				resp.Body = &errCloser{
+					Reader: io.MultiReader(bytes.NewReader(body), &errReader{err: rerr}),
+					err:    resp.Body.Close(),
+				}
+				return true
+			}
+			resp.Body = &errCloser{
+				Reader: bytes.NewReader(body),
+				err:    resp.Body.Close(),
+			}
+			if shouldRetry(resp, body) {
+				return true
+			}
+		}
+		return next.ShouldRetry(attempt, req, resp, err)
+	})
+}
+
+// errReader always returns err.
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
 // Backoffer calculates how long to wait between attempts.  The attempt argument is the attempt which
 // just completed, and starts at 1.  So attempt=1 should return the time to wait between attempt 1 and 2.
 type Backoffer interface {
@@ -134,6 +351,21 @@ func (b BackofferFunc) Backoff(attempt int) time.Duration {
 	return b(attempt)
 }
 
+// ResponseBackoffer calculates how long to wait between attempts, like Backoffer, but with access
+// to the response and error of the attempt which just completed.  resp may be nil.  The response
+// body shouldn't be read: it will be drained and closed before the next attempt.
+type ResponseBackoffer interface {
+	Backoff(attempt int, resp *http.Response, err error) time.Duration
+}
+
+// ResponseBackofferFunc adapts a function to the ResponseBackoffer interface.
+type ResponseBackofferFunc func(attempt int, resp *http.Response, err error) time.Duration
+
+// Backoff implements ResponseBackoffer
+func (b ResponseBackofferFunc) Backoff(attempt int, resp *http.Response, err error) time.Duration {
+	return b(attempt, resp, err)
+}
+
 // ExponentialBackoff defines the configuration options for an exponential backoff strategy.
 // The implementation is based on the one from grpc.
 //
@@ -223,6 +455,65 @@ func ConstantBackoffWithJitter(delay time.Duration) *ExponentialBackoff {
 	return &ExponentialBackoff{BaseDelay: delay, Jitter: 0.2}
 }
 
+// FullJitterBackoff is the "full jitter" backoff strategy, described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.  Each delay is
+// a random duration between zero and an exponentially growing ceiling.  Compared to
+// ExponentialBackoff, which jitters around the exponential delay, this spreads out retries
+// from many concurrent clients much more, at the cost of some shorter delays.
+type FullJitterBackoff struct {
+	// BaseDelay is the ceiling of the delay after the first failure.
+	BaseDelay time.Duration
+	// Multiplier is the factor with which to multiply the ceiling after each failed
+	// retry.  0 means the default, 2.
+	Multiplier float64
+	// MaxDelay is the upper bound of the ceiling.  0 means no max.
+	MaxDelay time.Duration
+}
+
+// Backoff implements Backoffer.
+func (b *FullJitterBackoff) Backoff(attempt int) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	ceiling := float64(b.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if b.MaxDelay > 0 {
+		ceiling = math.Min(ceiling, float64(b.MaxDelay))
+	}
+
+	// nolint:gosec
+	return time.Duration(rand.Float64() * math.Max(0, ceiling))
+}
+
+// DecorrelatedJitterBackoff is the "decorrelated jitter" backoff strategy, described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/.  Each delay is
+// a random duration between BaseDelay and three times the previous delay, capped at MaxDelay.
+//
+// Backoffers aren't told the previous delay, so each call replays the random sequence from the
+// first attempt.  That produces the same distribution of delays, without any state, so it's
+// safe to share between requests.
+type DecorrelatedJitterBackoff struct {
+	// BaseDelay is the minimum delay.
+	BaseDelay time.Duration
+	// MaxDelay is the upper bound of the delay.  0 means no max.
+	MaxDelay time.Duration
+}
+
+// Backoff implements Backoffer.
+func (b *DecorrelatedJitterBackoff) Backoff(attempt int) time.Duration {
+	base := float64(b.BaseDelay)
+	sleep := base
+	for i := 0; i < attempt; i++ {
+		// nolint:gosec
+		sleep = base + rand.Float64()*(sleep*3-base)
+		if b.MaxDelay > 0 && sleep > float64(b.MaxDelay) {
+			sleep = float64(b.MaxDelay)
+		}
+	}
+	return time.Duration(sleep)
+}
+
 // Retry retries the http request under certain conditions.  The number of retries,
 // retry conditions, and the time to sleep between retries can be configured.  If
 // config is nil, the DefaultRetryConfig will be used.
@@ -230,7 +521,8 @@ func ConstantBackoffWithJitter(delay time.Duration) *ExponentialBackoff {
 // Requests with bodies can only be retried if the request's GetBody function is
 // set.  It will be used to rewind the request body for the next attempt.  This
 // is set automatically for most body types, like strings, byte slices, string readers,
-// or byte readers.
+// or byte readers.  Other bodies can be made retryable with RetryConfig.BufferRequestBody,
+// except those set with StreamBody.
 func Retry(config *RetryConfig) Middleware {
 	var c RetryConfig
 	if config == nil {
@@ -242,51 +534,211 @@ func Retry(config *RetryConfig) Middleware {
 	c.normalize()
 
 	return func(next Doer) Doer {
-		return DoerFunc(func(req *http.Request) (*http.Response, error) {
-			// if GetBody is not set, we can't retry anyway
-			if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
-				return next.Do(req)
-			}
+		return &retryDoer{next: next, config: c}
+	}
+}
 
-			var resp *http.Response
-			var err error
-			var attempt int
-			for {
-				resp, err = next.Do(req)
-				attempt++
-
-				// if ReadResponse, then also read the entire response into a buffer, to ensure no
-				// error occurs
-				if err == nil && c.ReadResponse {
-					resp.Body, err = bufRespBody(resp.Body)
-				}
+// retryMiddlewarePC identifies middleware created by Retry.
+// nolint:gochecknoglobals
+var retryMiddlewarePC = reflect.ValueOf(Retry(nil)).Pointer()
 
-				if attempt >= c.MaxAttempts || !c.ShouldRetry.ShouldRetry(attempt, req, resp, err) {
-					break
-				}
+// retryConfigOf returns the config of middleware created by Retry.  Only Retry's own
+// middleware is invoked to find it: other middleware may have side effects.
+func retryConfigOf(m Middleware) (RetryConfig, bool) {
+	if m == nil || reflect.ValueOf(m).Pointer() != retryMiddlewarePC {
+		return RetryConfig{}, false
+	}
+	rd, ok := m(nil).(*retryDoer)
+	if !ok {
+		return RetryConfig{}, false
+	}
+	return rd.config, true
+}
 
-				// if we're going to retry, we need to fulfill some responsibilities of an http.Request consumer
-				// in particular, we need to drain and close the request body.  We drain it so keepAlive connections
-				// can be reused.
-				if resp != nil {
-					drain(resp.Body)
-				}
+// retryDoer is the Doer installed by the Retry middleware.
+type retryDoer struct {
+	next   Doer
+	config RetryConfig
+}
 
-				req, err = resetRequest(req)
-				if err != nil {
-					return resp, err
-				}
+func (d *retryDoer) Do(req *http.Request) (*http.Response, error) {
+	c := d.config
 
-				// sleep for backoff
-				select {
-				case <-req.Context().Done():
-					return nil, req.Context().Err()
-				case <-time.After(c.Backoff.Backoff(attempt)):
-				}
-			}
+	ctx := req.Context()
+
+	if c.BufferRequestBody {
+		var err error
+		if req, err = bufferRequestBody(req, c.MaxBufferedRequestBody); err != nil {
+			return nil, err
+		}
+	}
+
+	// if GetBody is not set, we can't retry anyway
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return d.next.Do(c.withAttempt(ctx, req, 1))
+	}
+
+	if c.Budget != nil {
+		c.Budget.deposit()
+	}
+
+	start := time.Now()
+
+	var resp *http.Response
+	var err error
+	var attempt int
+	for {
+		req = c.withAttempt(ctx, req, attempt+1)
+		resp, err = d.next.Do(req)
+		attempt++
+
+		// if ReadResponse, then also read the entire response into a buffer, to ensure no
+		// error occurs
+		if err == nil && c.ReadResponse {
+			resp.Body, err = bufRespBody(resp.Body)
+		}
+
+		if attempt >= c.MaxAttempts || !c.ShouldRetry.ShouldRetry(attempt, req, resp, err) {
+			break
+		}
+
+		backoff := c.backoff(attempt, resp, err)
+		if c.MaxElapsedTime > 0 && time.Since(start)+backoff > c.MaxElapsedTime {
+			break
+		}
+
+		if c.Budget != nil && !c.Budget.withdraw() {
+			break
+		}
+
+		if c.OnRetry != nil {
+			c.OnRetry(attempt, req, resp, err, backoff)
+		}
+
+		// if we're going to retry, we need to fulfill some responsibilities of an http.Request consumer
+		// in particular, we need to drain and close the request body.  We drain it so keepAlive connections
+		// can be reused.
+		if resp != nil {
+			drain(resp.Body)
+		}
+
+		req, err = resetRequest(req)
+		if err != nil {
 			return resp, err
-		})
+		}
+
+		// sleep for backoff
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+	}
+	return resp, err
+}
+
+type attemptCtxKey struct{}
+
+// AttemptFromContext returns the number of the attempt, starting at 1, being made by the Retry
+// middleware.  Middleware installed after Retry, and Doers, can use it to distinguish retries from
+// the first attempt.  Returns 0 if ctx isn't the context of a request sent by Retry.
+func AttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(attemptCtxKey{}).(int)
+	return attempt
+}
+
+// withAttempt returns a copy of req annotated with the attempt number.
+func (c *RetryConfig) withAttempt(ctx context.Context, req *http.Request, attempt int) *http.Request {
+	req = req.WithContext(context.WithValue(ctx, attemptCtxKey{}, attempt))
+	if c.AttemptHeader != "" {
+		// WithContext shares the header, so copy it, rather than modifying the caller's
+		h := req.Header.Clone()
+		if h == nil {
+			h = http.Header{}
+		}
+		h.Set(c.AttemptHeader, strconv.Itoa(attempt))
+		req.Header = h
+	}
+	return req
+}
+
+// backoff returns how long to wait before the next attempt.  If the server sent a Retry-After
+// header with a 429 or 503 response, that is honored, up to MaxRetryAfter.  Otherwise,
+// ResponseBackoff is used, if set, or Backoff.
+func (c *RetryConfig) backoff(attempt int, resp *http.Response, err error) time.Duration {
+	if c.MaxRetryAfter > 0 && resp != nil &&
+		(resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			if d > c.MaxRetryAfter {
+				d = c.MaxRetryAfter
+			}
+			return d
+		}
+	}
+	if c.ResponseBackoff != nil {
+		return c.ResponseBackoff.Backoff(attempt, resp, err)
+	}
+	return c.Backoff.Backoff(attempt)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number
+// of seconds, or an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
 	}
+	return d, true
+}
+
+// bufferRequestBody returns a copy of req with GetBody set, by reading the body into memory,
+// if req's body can't be rewound.  If the body is larger than max, req is returned with a
+// body which replays the bytes read, followed by the rest of the original body.
+func bufferRequestBody(req *http.Request, max int64) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+	if _, ok := req.Body.(*streamReader); ok {
+		// StreamBody asked not to buffer
+		return req, nil
+	}
+
+	// read one byte past the limit, to detect whether there's more
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		_ = req.Body.Close()
+		return nil, merry.Prepend(err, "buffering request body")
+	}
+
+	r := *req
+	if int64(len(buf)) > max {
+		r.Body = &struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return &r, nil
+	}
+
+	_ = req.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.ContentLength = int64(len(buf))
+	return &r, nil
 }
 
 type errCloser struct {
@@ -310,13 +762,19 @@ func bufRespBody(b io.ReadCloser) (r io.ReadCloser, err error) {
 	if _, err = buf.ReadFrom(b); err != nil {
 		return nil, err
 	}
-	if err := b.Close(); err != nil {
-		return &errCloser{
-			Reader: &buf,
-			err:    err,
-		}, nil
-	}
-	return io.NopCloser(&buf), nil
+	return &bufferedBody{Reader: bytes.NewReader(buf.Bytes()), b: buf.Bytes(), closeErr: b.Close()}, nil
+}
+
+// bufferedBody is a response body which has already been read into memory.
+// readBody returns its bytes, rather than copying them.
+type bufferedBody struct {
+	*bytes.Reader
+	b        []byte
+	closeErr error
+}
+
+func (b *bufferedBody) Close() error {
+	return b.closeErr
 }
 
 func resetRequest(req *http.Request) (*http.Request, error) {
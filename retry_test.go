@@ -2,15 +2,20 @@ package requester_test
 
 import (
 	"context"
+	"fmt"
 	. "github.com/gemalto/requester"
 	"github.com/gemalto/requester/httptestutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io"
+	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -172,6 +177,39 @@ func (m *netError) Temporary() bool {
 	return false
 }
 
+func TestFullJitterBackoff_Backoff(t *testing.T) {
+	b := FullJitterBackoff{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	ceilings := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		for attempt, ceiling := range ceilings {
+			d := b.Backoff(attempt + 1)
+			assert.True(t, d >= 0 && d <= ceiling, "attempt %d: %v should be between 0 and %v", attempt+1, d, ceiling)
+		}
+	}
+
+	b = FullJitterBackoff{BaseDelay: time.Second, Multiplier: 3}
+	for i := 0; i < 100; i++ {
+		assert.True(t, b.Backoff(3) <= 9*time.Second)
+	}
+}
+
+func TestDecorrelatedJitterBackoff_Backoff(t *testing.T) {
+	b := DecorrelatedJitterBackoff{BaseDelay: time.Second, MaxDelay: 20 * time.Second}
+
+	for i := 0; i < 100; i++ {
+		for attempt := 1; attempt < 6; attempt++ {
+			d := b.Backoff(attempt)
+			// each delay is at most 3x the previous, starting from the base
+			max := time.Second * time.Duration(math.Pow(3, float64(attempt)))
+			if max > 20*time.Second {
+				max = 20 * time.Second
+			}
+			assert.True(t, d >= time.Second && d <= max, "attempt %d: %v should be between 1s and %v", attempt, d, max)
+		}
+	}
+}
+
 func TestDefaultShouldRetry(t *testing.T) {
 	assert.True(t, DefaultShouldRetry(1, nil, nil, &net.OpError{
 		Op:  "accept",
@@ -220,6 +258,32 @@ func TestOnlyIdempotentShouldRetry(t *testing.T) {
 	}
 }
 
+func TestHeaderShouldRetry(t *testing.T) {
+	r := HeaderShouldRetry("X-Should-Retry", nil)
+
+	// header overrides the status code
+	assert.True(t, r.ShouldRetry(1, nil, MockResponse(400, Header("X-Should-Retry", "true")), nil))
+	assert.False(t, r.ShouldRetry(1, nil, MockResponse(500, Header("X-Should-Retry", "false")), nil))
+
+	// missing or invalid header falls back on the default
+	assert.True(t, r.ShouldRetry(1, nil, MockResponse(500), nil))
+	assert.False(t, r.ShouldRetry(1, nil, MockResponse(400, Header("X-Should-Retry", "maybe")), nil))
+	assert.True(t, r.ShouldRetry(1, nil, nil, &netError{timeout: true}))
+
+	// custom next
+	r = HeaderShouldRetry("X-Should-Retry", ShouldRetryerFunc(func(int, *http.Request, *http.Response, error) bool {
+		return true
+	}))
+	assert.True(t, r.ShouldRetry(1, nil, MockResponse(400), nil))
+	assert.False(t, r.ShouldRetry(1, nil, MockResponse(400, Header("X-Should-Retry", "0")), nil))
+
+	// composes with other retryers
+	req, err := http.NewRequest(http.MethodPost, "http://test.com", nil)
+	require.NoError(t, err)
+	r = AllRetryers(HeaderShouldRetry("X-Should-Retry", nil), ShouldRetryerFunc(OnlyIdempotentShouldRetry))
+	assert.False(t, r.ShouldRetry(1, req, MockResponse(400, Header("X-Should-Retry", "true")), nil))
+}
+
 func TestAllRetryers(t *testing.T) {
 	r := AllRetryers(ShouldRetryerFunc(DefaultShouldRetry), ShouldRetryerFunc(OnlyIdempotentShouldRetry))
 
@@ -241,6 +305,35 @@ func TestAllRetryers(t *testing.T) {
 
 }
 
+func TestAnyRetryers(t *testing.T) {
+	r := AnyRetryers(RetryOnStatus(400), ShouldRetryerFunc(OnlyIdempotentShouldRetry))
+
+	// false + false = false
+	req, err := http.NewRequest(http.MethodPost, "http://test.com", nil)
+	require.NoError(t, err)
+	assert.False(t, r.ShouldRetry(1, req, MockResponse(500), nil))
+
+	// true + false = true
+	assert.True(t, r.ShouldRetry(1, req, MockResponse(400), nil))
+
+	// false + true = true
+	req, err = http.NewRequest(http.MethodGet, "http://test.com", nil)
+	require.NoError(t, err)
+	assert.True(t, r.ShouldRetry(1, req, MockResponse(500), nil))
+
+	// none = false
+	assert.False(t, AnyRetryers().ShouldRetry(1, req, MockResponse(500), nil))
+}
+
+func TestRetryOnStatus(t *testing.T) {
+	r := RetryOnStatus(409, 425)
+
+	assert.True(t, r.ShouldRetry(1, nil, MockResponse(409), nil))
+	assert.True(t, r.ShouldRetry(1, nil, MockResponse(425), nil))
+	assert.False(t, r.ShouldRetry(1, nil, MockResponse(500), nil))
+	assert.False(t, r.ShouldRetry(1, nil, nil, io.EOF))
+}
+
 func TestRetry(t *testing.T) {
 	// this test asserts that requests are retried the right number of times, and with the
 	// correct time interval between retries.
@@ -602,3 +695,307 @@ func TestRetry_readResponse(t *testing.T) {
 	assert.Equal(t, 3, count)
 
 }
+
+func TestRetry_retryAfter(t *testing.T) {
+	var retryAfter atomic.Value
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1)%2 == 1 {
+			w.Header().Set("Retry-After", retryAfter.Load().(string))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		name          string
+		retryAfter    string
+		maxRetryAfter time.Duration
+		backoff       time.Duration
+		expected      time.Duration
+	}{
+		{"seconds", "0", 0, 10 * time.Second, 0},
+		{"bounded", "10", 100 * time.Millisecond, 10 * time.Second, 100 * time.Millisecond},
+		{"date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 10 * time.Second, 0},
+		{"invalid", "soon", 0, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"ignored", "0", -1, 50 * time.Millisecond, 50 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			retryAfter.Store(tc.retryAfter)
+			atomic.StoreInt32(&count, 0)
+
+			start := time.Now()
+			resp, err := Send(Get(s.URL), Retry(&RetryConfig{
+				Backoff:       ConstantBackoff(tc.backoff),
+				MaxRetryAfter: tc.maxRetryAfter,
+			}))
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusCode)
+			assert.EqualValues(t, 2, atomic.LoadInt32(&count))
+			assert.InDelta(t, tc.expected, time.Since(start), float64(40*time.Millisecond))
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	budget := NewRetryBudget(0.5, 2)
+	assert.Equal(t, 2.0, budget.Tokens())
+
+	// the budget is shared by both requesters
+	r1 := MustNew(Get(s.URL), Retry(&RetryConfig{MaxAttempts: 3, Backoff: NoBackoff(), Budget: budget}))
+	r2 := MustNew(Get(s.URL), Retry(&RetryConfig{MaxAttempts: 3, Backoff: NoBackoff(), Budget: budget}))
+
+	// the first request deposits 0.5 tokens, leaving 2 (the max), then spends them on 2 retries
+	_, err := r1.Send()
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&count))
+	assert.Equal(t, 0.0, budget.Tokens())
+
+	// the next request deposits 0.5 tokens, which isn't enough for a retry
+	atomic.StoreInt32(&count, 0)
+	_, err = r2.Send()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&count))
+	assert.Equal(t, 0.5, budget.Tokens())
+
+	// the next request tops it up to 1, enough for one retry
+	atomic.StoreInt32(&count, 0)
+	_, err = r1.Send()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&count))
+	assert.Equal(t, 0.0, budget.Tokens())
+}
+
+func TestRetry_onRetry(t *testing.T) {
+	s := httptest.NewServer(MockHandler(503, Header("Retry-After", "0")))
+	defer s.Close()
+
+	type event struct {
+		attempt int
+		code    int
+		delay   time.Duration
+	}
+	var events []event
+
+	_, err := Send(Get(s.URL), Retry(&RetryConfig{
+		MaxAttempts: 3,
+		OnRetry: func(attempt int, req *http.Request, resp *http.Response, err error, delay time.Duration) {
+			assert.NoError(t, err)
+			assert.Equal(t, s.URL, req.URL.String())
+			events = append(events, event{attempt, resp.StatusCode, delay})
+		},
+	}))
+	require.NoError(t, err)
+
+	// called for each retry, not for the final attempt
+	assert.Equal(t, []event{{1, 503, 0}, {2, 503, 0}}, events)
+}
+
+func TestBodyShouldRetry(t *testing.T) {
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) < 3 {
+			w.Write([]byte(`{"error":"throttled"}`))
+			return
+		}
+		w.Write([]byte(`{"color":"red"}`))
+	}))
+	defer s.Close()
+
+	throttled := func(_ *http.Response, body []byte) bool {
+		return strings.Contains(string(body), `"error":"throttled"`)
+	}
+
+	for _, readResponse := range []bool{false, true} {
+		t.Run(fmt.Sprintf("readResponse=%v", readResponse), func(t *testing.T) {
+			atomic.StoreInt32(&count, 0)
+			_, body, err := Receive(Get(s.URL), Retry(&RetryConfig{
+				MaxAttempts:  5,
+				Backoff:      NoBackoff(),
+				ReadResponse: readResponse,
+				ShouldRetry:  BodyShouldRetry(throttled, nil),
+			}))
+			require.NoError(t, err)
+			assert.Equal(t, `{"color":"red"}`, string(body))
+			assert.EqualValues(t, 3, atomic.LoadInt32(&count))
+		})
+	}
+
+	t.Run("body restored", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		_, body, err := Receive(Get(s.URL), Retry(&RetryConfig{
+			MaxAttempts: 2,
+			Backoff:     NoBackoff(),
+			ShouldRetry: BodyShouldRetry(throttled, nil),
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, `{"error":"throttled"}`, string(body))
+	})
+
+	t.Run("next", func(t *testing.T) {
+		s := httptest.NewServer(MockHandler(500))
+		defer s.Close()
+
+		resp, err := Send(Get(s.URL), Retry(&RetryConfig{
+			MaxAttempts: 2,
+			Backoff:     NoBackoff(),
+			ShouldRetry: BodyShouldRetry(throttled, nil),
+			OnRetry: func(attempt int, _ *http.Request, _ *http.Response, _ error, _ time.Duration) {
+				assert.Equal(t, 1, attempt)
+			},
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, 500, resp.StatusCode)
+	})
+}
+
+func TestRetry_responseBackoff(t *testing.T) {
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("X-Rate-Limit-Reset-Ms", "50")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	var delays []time.Duration
+	start := time.Now()
+	resp, err := Send(Get(s.URL), Retry(&RetryConfig{
+		Backoff: ConstantBackoff(10 * time.Second),
+		ResponseBackoff: ResponseBackofferFunc(func(attempt int, resp *http.Response, err error) time.Duration {
+			require.NoError(t, err)
+			ms, _ := strconv.Atoi(resp.Header.Get("X-Rate-Limit-Reset-Ms"))
+			return time.Duration(ms) * time.Millisecond
+		}),
+		OnRetry: func(_ int, _ *http.Request, _ *http.Response, _ error, delay time.Duration) {
+			delays = append(delays, delay)
+		},
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, []time.Duration{50 * time.Millisecond}, delays)
+	assert.InDelta(t, 50*time.Millisecond, time.Since(start), float64(40*time.Millisecond))
+}
+
+func TestRetry_attempt(t *testing.T) {
+	var headers []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("X-Attempt"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	var attempts []int
+	recordAttempt := Middleware(func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			attempts = append(attempts, AttemptFromContext(req.Context()))
+			return next.Do(req)
+		})
+	})
+
+	_, err := Send(Get(s.URL), Retry(&RetryConfig{Backoff: NoBackoff(), AttemptHeader: "X-Attempt"}), recordAttempt)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+	assert.Equal(t, []string{"1", "2", "3"}, headers)
+
+	// no header by default
+	headers, attempts = nil, nil
+	_, err = Send(Get(s.URL), Retry(&RetryConfig{MaxAttempts: 2, Backoff: NoBackoff()}), recordAttempt)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, attempts)
+	assert.Equal(t, []string{"", ""}, headers)
+
+	assert.Equal(t, 0, AttemptFromContext(context.Background()))
+
+	// the caller's request isn't modified, and may have no header
+	headers = nil
+	d := Retry(&RetryConfig{MaxAttempts: 2, Backoff: NoBackoff(), AttemptHeader: "X-Attempt"})(http.DefaultClient)
+	req, err := http.NewRequest("GET", s.URL, nil)
+	require.NoError(t, err)
+	req.Header = nil
+	resp, err := d.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"1", "2"}, headers)
+	assert.Nil(t, req.Header)
+}
+
+func TestRetry_bufferRequestBody(t *testing.T) {
+	s := httptest.NewServer(MockHandler(500))
+	defer s.Close()
+
+	i := httptestutil.Inspect(s)
+
+	bodies := func() []string {
+		var bodies []string
+		for e := i.NextExchange(); e != nil; e = i.NextExchange() {
+			bodies = append(bodies, e.RequestBody.String())
+		}
+		return bodies
+	}
+
+	config := RetryConfig{MaxAttempts: 3, Backoff: NoBackoff(), BufferRequestBody: true}
+
+	resp, err := Send(Post(s.URL), Body(&dummyReader{next: strings.NewReader("fudge")}), Retry(&config))
+	require.NoError(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+	assert.Equal(t, []string{"fudge", "fudge", "fudge"}, bodies())
+
+	// bodies over the limit are sent once, intact
+	config.MaxBufferedRequestBody = 3
+	_, err = Send(Post(s.URL), Body(&dummyReader{next: strings.NewReader("fudge")}), Retry(&config))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fudge"}, bodies())
+
+	// streamed bodies are never buffered
+	config.MaxBufferedRequestBody = 0
+	_, err = Send(Post(s.URL), StreamBody(strings.NewReader("fudge"), 5), Retry(&config))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fudge"}, bodies())
+
+	// but they can be reopened
+	opens := 0
+	_, err = Send(Post(s.URL), StreamBodyFunc(func() (io.ReadCloser, error) {
+		opens++
+		return ioutil.NopCloser(strings.NewReader("fudge")), nil
+	}, 5), Retry(&config))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fudge", "fudge", "fudge"}, bodies())
+	assert.Equal(t, 3, opens)
+}
+
+func TestRetry_maxElapsedTime(t *testing.T) {
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.Close()
+
+	start := time.Now()
+	resp, err := Send(Get(s.URL), Retry(&RetryConfig{
+		MaxAttempts:    100,
+		Backoff:        ConstantBackoff(40 * time.Millisecond),
+		MaxElapsedTime: 100 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 500, resp.StatusCode)
+
+	// attempts at ~0, 40, and 80ms.  Another would start after 100ms.
+	assert.EqualValues(t, 3, atomic.LoadInt32(&count))
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+}
@@ -0,0 +1,70 @@
+package requester
+
+import (
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
+
+// AsRoundTripper returns an http.RoundTripper which sends requests through the Requester's
+// Doer and Middleware, after adding the Requester's headers and query params, and signing
+// them with its Signer.  This plugs Requester-configured behavior into third-party libraries
+// which only accept an *http.Client:
+//
+//	client := &http.Client{Transport: r.AsRoundTripper()}
+//
+// Headers and query params already set on the request take precedence over the
+// Requester's.  The rest of the Requester's attributes, like its URL, Method, and Body, are
+// ignored.  The RoundTripper uses a clone of the Requester, so later changes to r
+// don't affect it.
+//
+// The Requester's Doer mustn't be an http.Client which uses the returned RoundTripper,
+// or requests will loop forever.
+func (r *Requester) AsRoundTripper() http.RoundTripper {
+	return &roundTripper{r: r.Clone()}
+}
+
+// Transport uses the default Requester and the options to create an http.RoundTripper.
+//
+// See Requester.AsRoundTripper() for more details.
+func Transport(opts ...Option) (http.RoundTripper, error) {
+	r, err := defaultRequester().withOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return r.AsRoundTripper(), nil
+}
+
+type roundTripper struct {
+	r *Requester
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't modify the request
+	req = req.Clone(req.Context())
+
+	for k, v := range t.r.Header {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = append([]string(nil), v...)
+		}
+	}
+
+	if len(t.r.QueryParams) > 0 {
+		q := req.URL.Query()
+		for k, v := range t.r.QueryParams {
+			if _, ok := q[k]; !ok {
+				q[k] = append([]string(nil), v...)
+			}
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	if t.r.Signer != nil {
+		if err := t.r.Signer.Sign(req); err != nil {
+			return nil, merry.Prepend(err, "signing request")
+		}
+	}
+
+	return t.r.Do(req)
+}
@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester_AsRoundTripper(t *testing.T) {
+	var received *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer ts.Close()
+
+	var middlewareCalled bool
+	r := MustNew(
+		Header("X-Color", "red"),
+		Header("X-Size", "large"),
+		QueryParam("color", "red"),
+		QueryParam("size", "large"),
+		Middleware(func(next Doer) Doer {
+			return DoerFunc(func(req *http.Request) (*http.Response, error) {
+				middlewareCalled = true
+				return next.Do(req)
+			})
+		}),
+	)
+
+	client := &http.Client{Transport: r.AsRoundTripper()}
+
+	// changes to the requester afterwards don't affect the round tripper
+	r.Header.Set("X-Color", "blue")
+
+	req, err := http.NewRequest("GET", ts.URL+"/path?size=small", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Size", "small")
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.True(t, middlewareCalled)
+	assert.Equal(t, "red", received.Header.Get("X-Color"))
+	assert.Equal(t, "small", received.Header.Get("X-Size"))
+	assert.Equal(t, "red", received.URL.Query().Get("color"))
+	assert.Equal(t, "small", received.URL.Query().Get("size"))
+
+	// the original request isn't modified
+	assert.Empty(t, req.Header.Get("X-Color"))
+	assert.Equal(t, "size=small", req.URL.RawQuery)
+}
+
+func TestTransport(t *testing.T) {
+	var received *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer ts.Close()
+
+	rt, err := Transport(
+		BasicAuth("user", "pass"),
+		SignerFunc(func(req *http.Request) error {
+			req.Header.Set("X-Signature", req.Header.Get(HeaderAuthorization))
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: rt}).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	user, pass, ok := received.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+	assert.Equal(t, received.Header.Get(HeaderAuthorization), received.Header.Get("X-Signature"))
+}
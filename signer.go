@@ -0,0 +1,29 @@
+package requester
+
+import "net/http"
+
+// Signer signs outgoing requests.  Requester invokes the Signer after the request
+// is fully constructed (method, URL, query params, headers, and marshaled body),
+// but before it is sent.  This makes it a suitable hook for computing HMAC or
+// AWS-style signatures over the final, canonical request.
+//
+// Signers which need to read the request body should read it from req.GetBody(),
+// rather than consuming req.Body.
+type Signer interface {
+	Sign(req *http.Request) error
+}
+
+// SignerFunc adapts a function to the Signer interface.
+type SignerFunc func(req *http.Request) error
+
+// Apply implements Option.  SignerFuncs can be used as requester options.  They
+// install themselves as the requester's Signer.
+func (f SignerFunc) Apply(r *Requester) error {
+	r.Signer = f
+	return nil
+}
+
+// Sign implements Signer.
+func (f SignerFunc) Sign(req *http.Request) error {
+	return f(req)
+}
@@ -0,0 +1,84 @@
+package requester
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester_Request_Signer(t *testing.T) {
+	var signed *http.Request
+
+	reqs := MustNew(
+		Post("http://test.com/red"),
+		QueryParam("color", "blue"),
+		Header("X-Color", "green"),
+		Body(map[string]string{"size": "big"}),
+		SignerFunc(func(req *http.Request) error {
+			signed = req
+			// the request should be fully built by the time it's signed
+			assert.Equal(t, "color=blue", req.URL.RawQuery)
+			assert.Equal(t, "green", req.Header.Get("X-Color"))
+			assert.Equal(t, contentTypeJSON, req.Header.Get(HeaderContentType))
+
+			rdr, err := req.GetBody()
+			require.NoError(t, err)
+			b, err := ioutil.ReadAll(rdr)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"size":"big"}`, string(b))
+
+			req.Header.Set("X-Signature", "sig")
+			return nil
+		}),
+	)
+
+	req, err := reqs.RequestContext(context.Background())
+	require.NoError(t, err)
+	assert.Same(t, signed, req)
+	assert.Equal(t, "sig", req.Header.Get("X-Signature"))
+
+	// body should still be intact
+	b, err := ioutil.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"size":"big"}`, string(b))
+
+	t.Run("error", func(t *testing.T) {
+		_, err := reqs.Request(SignerFunc(func(req *http.Request) error {
+			return errors.New("boom")
+		}))
+		require.EqualError(t, err, "signing request: boom")
+	})
+}
+
+func TestWithSigner(t *testing.T) {
+	s := SignerFunc(func(*http.Request) error { return nil })
+	reqs := MustNew(WithSigner(s))
+	assert.NotNil(t, reqs.Signer)
+
+	reqs.MustApply(WithSigner(nil))
+	assert.Nil(t, reqs.Signer)
+}
+
+func ExampleSigner() {
+	key := []byte("secret")
+
+	signer := SignerFunc(func(req *http.Request) error {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(req.Method + "\n" + req.URL.String()))
+		req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+		return nil
+	})
+
+	req, _ := Request(Get("http://api.com/resources/1"), signer)
+
+	fmt.Println(req.Header.Get("X-Signature"))
+}
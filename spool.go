@@ -0,0 +1,98 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/ansel1/merry"
+)
+
+// SpooledBody is a response body read by ReceiveSpooled.  Small bodies are held in memory,
+// and larger ones are spilled to a temp file.  Close it to remove the temp file.
+type SpooledBody struct {
+	io.ReadSeeker
+	file *os.File
+	size int64
+}
+
+// Size returns the length of the body.
+func (b *SpooledBody) Size() int64 {
+	return b.size
+}
+
+// InMemory returns true if the body is held in memory, rather than in a temp file.
+func (b *SpooledBody) InMemory() bool {
+	return b.file == nil
+}
+
+// Close removes the temp file, if the body was spilled to one.
+func (b *SpooledBody) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	err := b.file.Close()
+	if rmErr := os.Remove(b.file.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// ReceiveSpooled is like Receive, but caps the memory used to hold the response body.
+// Bodies up to maxMemory bytes are held in memory, and larger bodies are spilled to a temp
+// file, in the default directory for temp files.  Either way, the body is returned as a
+// SpooledBody, which must be closed to remove the temp file.  This suits services which
+// proxy payloads of unpredictable size.
+//
+// The body isn't unmarshaled.  The response body is closed.
+func (r *Requester) ReceiveSpooled(maxMemory int64, opts ...Option) (*http.Response, *SpooledBody, error) {
+	return r.ReceiveSpooledContext(context.Background(), maxMemory, opts...)
+}
+
+// ReceiveSpooledContext does the same as ReceiveSpooled, but requires a context.
+func (r *Requester) ReceiveSpooledContext(ctx context.Context, maxMemory int64, opts ...Option) (*http.Response, *SpooledBody, error) {
+	resp, err := r.SendContext(ctx, opts...)
+
+	// like Receive, read the body even if middleware returned an error with the response
+	body, bodyReadError := spoolBody(resp, maxMemory)
+	if err != nil {
+		return resp, body, err
+	}
+	return resp, body, bodyReadError
+}
+
+func spoolBody(resp *http.Response, maxMemory int64) (*SpooledBody, error) {
+	if resp == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return &SpooledBody{ReadSeeker: bytes.NewReader(nil)}, nil
+	}
+	defer resp.Body.Close()
+
+	// read one byte past the limit, to detect whether there's more
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMemory+1))
+	if err != nil {
+		return nil, merry.Prepend(err, "reading response body")
+	}
+	if int64(len(buf)) <= maxMemory {
+		return &SpooledBody{ReadSeeker: bytes.NewReader(buf), size: int64(len(buf))}, nil
+	}
+
+	f, err := ioutil.TempFile("", "requester-body-*")
+	if err != nil {
+		return nil, merry.Prepend(err, "creating temp file")
+	}
+	body := &SpooledBody{ReadSeeker: f, file: f}
+
+	n, err := io.Copy(f, io.MultiReader(bytes.NewReader(buf), resp.Body))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = body.Close()
+		return nil, merry.Prepend(err, "spilling response body to temp file")
+	}
+	body.size = n
+	return body, nil
+}
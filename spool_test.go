@@ -0,0 +1,70 @@
+package requester
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceiveSpooled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(204)
+			return
+		}
+		_, _ = w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer s.Close()
+
+	t.Run("in memory", func(t *testing.T) {
+		resp, body, err := ReceiveSpooled(1000, Get(s.URL))
+		require.NoError(t, err)
+		defer body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.True(t, body.InMemory())
+		assert.EqualValues(t, 1000, body.Size())
+
+		b, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 1000), string(b))
+	})
+
+	t.Run("spilled", func(t *testing.T) {
+		_, body, err := ReceiveSpooled(999, Get(s.URL))
+		require.NoError(t, err)
+		assert.False(t, body.InMemory())
+		assert.EqualValues(t, 1000, body.Size())
+
+		b, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 1000), string(b))
+
+		// seekable
+		_, err = body.Seek(990, io.SeekStart)
+		require.NoError(t, err)
+		b, err = ioutil.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 10), string(b))
+
+		name := body.file.Name()
+		assert.FileExists(t, name)
+		require.NoError(t, body.Close())
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, body, err := ReceiveSpooled(0, Get(s.URL, "empty"))
+		require.NoError(t, err)
+		assert.True(t, body.InMemory())
+		assert.EqualValues(t, 0, body.Size())
+		require.NoError(t, body.Close())
+	})
+}
@@ -0,0 +1,133 @@
+package requester
+
+import (
+	"github.com/ansel1/merry"
+)
+
+// ErrMiddlewareNotFound is returned by the methods which adjust named middleware,
+// if no middleware with the given name is installed.
+// nolint:gochecknoglobals
+var ErrMiddlewareNotFound = merry.New("middleware not found")
+
+// UseNamed appends middleware to Requester.Middleware, under a name.  The name can be used
+// to adjust the middleware stack later, with ReplaceMiddleware, RemoveMiddleware,
+// InsertBefore, and InsertAfter.  This lets a client built on Requester, like an SDK, expose
+// its middleware, so users can adjust it without rebuilding it:
+//
+//	base := requester.MustNew(requester.UseNamed("retry", requester.Retry(nil)))
+//	...
+//	custom := base.Clone()
+//	err := custom.ReplaceMiddleware("retry", requester.Retry(&requester.RetryConfig{MaxAttempts: 5}))
+//
+// It's an error if middleware with the same name is already installed.  If name is empty,
+// UseNamed is equivalent to Use.
+func UseNamed(name string, m Middleware) Option {
+	return OptionFunc(func(r *Requester) error {
+		r.syncMiddlewareNames()
+		if r.middlewareIndex(name) > -1 {
+			return merry.Errorf("middleware %q is already installed", name)
+		}
+		r.Middleware = append(r.Middleware, m)
+		r.middlewareNames = append(r.middlewareNames, name)
+		return nil
+	})
+}
+
+// MiddlewareNames returns the names of the middleware in Requester.Middleware, in the same
+// order.  Middleware installed without a name has an empty name.
+//
+// Names are tracked by position.  Middleware appended to Requester.Middleware directly, or
+// by Use, is unnamed, but if middleware is removed or reordered without using these
+// methods, the names will no longer match up.
+func (r *Requester) MiddlewareNames() []string {
+	names := make([]string, len(r.Middleware))
+	copy(names, r.middlewareNames)
+	return names
+}
+
+// ReplaceMiddleware replaces the named middleware, keeping its position in the stack.
+func (r *Requester) ReplaceMiddleware(name string, m Middleware) error {
+	i, err := r.namedMiddleware(name)
+	if err != nil {
+		return err
+	}
+	r.Middleware[i] = m
+	return nil
+}
+
+// RemoveMiddleware removes the named middleware.
+func (r *Requester) RemoveMiddleware(name string) error {
+	i, err := r.namedMiddleware(name)
+	if err != nil {
+		return err
+	}
+	r.Middleware = append(r.Middleware[:i:i], r.Middleware[i+1:]...)
+	r.middlewareNames = append(r.middlewareNames[:i:i], r.middlewareNames[i+1:]...)
+	return nil
+}
+
+// InsertBefore inserts middleware immediately before (outside of) the named middleware.  newName
+// is the name of the inserted middleware, and may be empty.
+func (r *Requester) InsertBefore(name, newName string, m Middleware) error {
+	i, err := r.namedMiddleware(name)
+	if err != nil {
+		return err
+	}
+	return r.insertMiddleware(i, newName, m)
+}
+
+// InsertAfter inserts middleware immediately after (inside of) the named middleware.  newName
+// is the name of the inserted middleware, and may be empty.
+func (r *Requester) InsertAfter(name, newName string, m Middleware) error {
+	i, err := r.namedMiddleware(name)
+	if err != nil {
+		return err
+	}
+	return r.insertMiddleware(i+1, newName, m)
+}
+
+func (r *Requester) insertMiddleware(i int, name string, m Middleware) error {
+	if r.middlewareIndex(name) > -1 {
+		return merry.Errorf("middleware %q is already installed", name)
+	}
+
+	// build new slices, so clones sharing the old arrays aren't affected
+	mw := make([]Middleware, 0, len(r.Middleware)+1)
+	mw = append(append(append(mw, r.Middleware[:i]...), m), r.Middleware[i:]...)
+	names := make([]string, 0, len(r.middlewareNames)+1)
+	names = append(append(append(names, r.middlewareNames[:i]...), name), r.middlewareNames[i:]...)
+
+	r.Middleware, r.middlewareNames = mw, names
+	return nil
+}
+
+// namedMiddleware returns the index of the named middleware.
+func (r *Requester) namedMiddleware(name string) (int, error) {
+	r.syncMiddlewareNames()
+	i := r.middlewareIndex(name)
+	if i < 0 {
+		return -1, ErrMiddlewareNotFound.Here().Appendf("name: %q", name)
+	}
+	return i, nil
+}
+
+func (r *Requester) middlewareIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, n := range r.middlewareNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// syncMiddlewareNames aligns the names with Requester.Middleware.  Middleware
+// appended directly to Requester.Middleware is unnamed.
+func (r *Requester) syncMiddlewareNames() {
+	for len(r.middlewareNames) < len(r.Middleware) {
+		r.middlewareNames = append(r.middlewareNames, "")
+	}
+	r.middlewareNames = r.middlewareNames[:len(r.Middleware)]
+}
@@ -0,0 +1,126 @@
+package requester
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ansel1/merry"
+)
+
+// Template is a precompiled request, created by Requester.Compile.  The URL, query string,
+// headers, and body are resolved, encoded, and marshaled once, so sending the same request
+// many times, e.g. in a hot loop, skips repeating that work.  Templates are safe for
+// concurrent use.
+type Template struct {
+	r     *Requester
+	proto *http.Request
+	body  []byte
+}
+
+// Compile resolves the Requester's URL, query params, headers, and body into a Template.  The
+// Template uses a clone of the Requester, so later changes to r don't affect it.  The Signer,
+// Doer, Middleware, and Unmarshaler are applied each time a request is sent.
+//
+// The body must be replayable: a string, a []byte, a value to marshal, or a reader whose
+// type http.NewRequest can replay, like *bytes.Reader.  Other readers return an error.
+func (r *Requester) Compile() (*Template, error) {
+	c := r.Clone()
+
+	// signatures can depend on the time, so sign each request separately
+	signer := c.Signer
+	c.Signer = nil
+	proto, err := c.RequestContext(context.Background())
+	c.Signer = signer
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if proto.Body != nil && proto.Body != http.NoBody {
+		if proto.GetBody == nil {
+			return nil, merry.New("can't compile a request whose body can't be replayed: use a string, []byte, or value to marshal")
+		}
+		rc, err := proto.GetBody()
+		if err != nil {
+			return nil, merry.Prepend(err, "calling req.GetBody")
+		}
+		body, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, merry.Prepend(err, "reading request body")
+		}
+	}
+
+	return &Template{r: c, proto: proto, body: body}, nil
+}
+
+// Request returns a new request built from the template.
+func (t *Template) Request() (*http.Request, error) {
+	return t.RequestContext(context.Background())
+}
+
+// RequestContext does the same as Request, but attaches a context to the request.
+func (t *Template) RequestContext(ctx context.Context) (*http.Request, error) {
+	p := t.proto
+	req := (&http.Request{
+		Method:           p.Method,
+		URL:              cloneURL(p.URL),
+		Proto:            p.Proto,
+		ProtoMajor:       p.ProtoMajor,
+		ProtoMinor:       p.ProtoMinor,
+		Header:           p.Header.Clone(),
+		Host:             p.Host,
+		ContentLength:    p.ContentLength,
+		TransferEncoding: p.TransferEncoding,
+		Close:            p.Close,
+		Trailer:          p.Trailer.Clone(),
+	}).WithContext(ctx)
+
+	if t.body != nil {
+		req.GetBody = t.getBody
+		req.Body, _ = t.getBody()
+	}
+
+	if t.r.Signer != nil {
+		if err := t.r.Signer.Sign(req); err != nil {
+			return nil, merry.Prepend(err, "signing request")
+		}
+	}
+	return req, nil
+}
+
+func (t *Template) getBody() (io.ReadCloser, error) {
+	if len(t.body) == 0 {
+		return http.NoBody, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(t.body)), nil
+}
+
+// Send sends a request built from the template, with the Requester's Doer and Middleware.
+func (t *Template) Send() (*http.Response, error) {
+	return t.SendContext(context.Background())
+}
+
+// SendContext does the same as Send, but attaches a context to the request.
+func (t *Template) SendContext(ctx context.Context) (*http.Response, error) {
+	req, err := t.RequestContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return t.r.Do(req)
+}
+
+// Receive sends a request built from the template, and reads the response, like
+// Requester.Receive.  into may be nil, or a value to unmarshal the response body into.
+func (t *Template) Receive(into interface{}) (*http.Response, []byte, error) {
+	return t.ReceiveContext(context.Background(), into)
+}
+
+// ReceiveContext does the same as Receive, but attaches a context to the request.
+func (t *Template) ReceiveContext(ctx context.Context, into interface{}) (*http.Response, []byte, error) {
+	resp, err := t.SendContext(ctx)
+	return t.r.receive(resp, err, into)
+}
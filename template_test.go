@@ -0,0 +1,82 @@
+package requester
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester_Compile(t *testing.T) {
+	type sent struct {
+		method, url, body, contentType, color, signature string
+	}
+	var requests []sent
+	doer := DoerFunc(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		requests = append(requests, sent{req.Method, req.URL.String(), string(b), req.Header.Get(HeaderContentType), req.Header.Get("X-Color"), req.Header.Get("X-Signature")})
+		// middleware and signers may modify the request, without affecting the template
+		req.Header.Set("X-Color", "blue")
+		return MockResponse(200, JSON(false), Body(map[string]string{"color": "red"})), nil
+	})
+
+	var signatures int
+	r := MustNew(
+		doer,
+		Post("http://example.test/colors"),
+		QueryParam("size", "large"),
+		Header("X-Color", "red"),
+		JSON(false),
+		Body(map[string]string{"color": "red"}),
+		SignerFunc(func(req *http.Request) error {
+			signatures++
+			req.Header.Set("X-Signature", strings.Repeat("s", signatures))
+			return nil
+		}),
+	)
+
+	tmpl, err := r.Compile()
+	require.NoError(t, err)
+
+	// changes to the requester don't affect the template
+	r.Header.Set("X-Color", "green")
+
+	for i := 0; i < 2; i++ {
+		var into map[string]string
+		resp, _, err := tmpl.Receive(&into)
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "red", into["color"])
+	}
+
+	expected := sent{"POST", "http://example.test/colors?size=large", `{"color":"red"}`, "application/json", "red", "s"}
+	assert.Equal(t, expected, requests[0])
+	expected.signature = "ss"
+	assert.Equal(t, expected, requests[1])
+
+	req, err := tmpl.Request()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(`{"color":"red"}`)), req.ContentLength)
+	require.NotNil(t, req.GetBody)
+	b, err := req.GetBody()
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(b)
+	assert.Equal(t, `{"color":"red"}`, string(body))
+
+	t.Run("no body", func(t *testing.T) {
+		tmpl, err := MustNew(doer, Get("http://example.test/")).Compile()
+		require.NoError(t, err)
+		req, err := tmpl.Request()
+		require.NoError(t, err)
+		assert.Nil(t, req.Body)
+	})
+
+	t.Run("streaming body", func(t *testing.T) {
+		_, err := MustNew(Body(ioutil.NopCloser(strings.NewReader("red")))).Compile()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,180 @@
+package requester
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings records how long the phases of a request took.  Phases which didn't
+// happen, like DNS and Connect when a connection is reused, are zero.
+type Timings struct {
+	// DNS is how long it took to resolve the host name.
+	DNS time.Duration
+	// Connect is how long it took to establish the TCP connection.
+	Connect time.Duration
+	// TLSHandshake is how long the TLS handshake took.
+	TLSHandshake time.Duration
+	// TTFB (time to first byte) is the time from sending the request until the
+	// first byte of the response was received.  It includes the other phases.
+	TTFB time.Duration
+	// Total is the time from sending the request until the response body was read
+	// to the end, or closed.
+	Total time.Duration
+	// ConnReused is true if the request was sent on a previously used connection.
+	ConnReused bool
+}
+
+type timingsCtxKey struct{}
+
+// TimingsFromContext returns the timings recorded by the Trace middleware so far.  Pass it the
+// context of the response's request:
+//
+//	resp, body, err := r.Receive(nil, requester.Trace(nil))
+//	timings, _ := requester.TimingsFromContext(resp.Request.Context())
+//	fmt.Println(timings.TTFB, timings.Total)
+//
+// Total is only set once the response body has been read or closed, which Receive does.
+// Returns false if the request wasn't traced.
+func TimingsFromContext(ctx context.Context) (Timings, bool) {
+	tr, _ := ctx.Value(timingsCtxKey{}).(*tracer)
+	if tr == nil {
+		return Timings{}, false
+	}
+	return tr.timings(), true
+}
+
+// Trace is middleware which records how long each phase of the request took, using
+// httptrace: DNS lookup, connecting, the TLS handshake, time to the first response byte, and
+// the total time, including reading the response body.  It's for diagnosing latency.
+//
+// If callback isn't nil, it's called with the timings once the response body has been read
+// to the end or closed, or as soon as the request fails.  The timings can also be retrieved
+// from the request's context with TimingsFromContext.
+//
+// Connection-level timings are only recorded if the Doer is an http.Client, or
+// another Doer which supports httptrace.  Any ClientTrace already in the request's context
+// is still called.
+func Trace(callback func(*http.Request, Timings)) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			tr := &tracer{start: time.Now()}
+			ctx := context.WithValue(req.Context(), timingsCtxKey{}, tr)
+			ctx = httptrace.WithClientTrace(ctx, tr.clientTrace())
+			req = req.WithContext(ctx)
+
+			finish := func() {
+				tr.finish()
+				if callback != nil {
+					callback(req, tr.timings())
+				}
+			}
+
+			resp, err := next.Do(req)
+			if err != nil || resp == nil || resp.Body == nil {
+				finish()
+				return resp, err
+			}
+
+			resp.Body = &timedBody{ReadCloser: resp.Body, finish: finish}
+			return resp, nil
+		})
+	}
+}
+
+// tracer collects the timings of a single request.  httptrace hooks may be
+// called from other goroutines.
+type tracer struct {
+	start time.Time
+
+	mu                               sync.Mutex
+	dnsStart, connectStart, tlsStart time.Time
+	t                                Timings
+}
+
+func (tr *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.t.DNS = time.Since(tr.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			// several addresses may be dialed in parallel: time from the first
+			if tr.connectStart.IsZero() {
+				tr.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			if err == nil {
+				tr.t.Connect = time.Since(tr.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.t.TLSHandshake = time.Since(tr.tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.t.ConnReused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			tr.mu.Lock()
+			defer tr.mu.Unlock()
+			tr.t.TTFB = time.Since(tr.start)
+		},
+	}
+}
+
+func (tr *tracer) finish() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.t.Total = time.Since(tr.start)
+}
+
+func (tr *tracer) timings() Timings {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.t
+}
+
+// timedBody calls finish once, when the body is read to the end or closed.
+type timedBody struct {
+	io.ReadCloser
+	finish func()
+	once   sync.Once
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.finish)
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.finish)
+	return err
+}
@@ -0,0 +1,75 @@
+package requester
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	var called []Timings
+	r := MustNew(
+		URL(ts.URL),
+		WithDoer(ts.Client()),
+		Trace(func(req *http.Request, timings Timings) {
+			called = append(called, timings)
+		}),
+	)
+
+	resp, body, err := r.Receive(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(body))
+
+	require.Len(t, called, 1)
+	timings := called[0]
+	assert.False(t, timings.ConnReused)
+	assert.NotZero(t, timings.Connect)
+	assert.NotZero(t, timings.TLSHandshake)
+	assert.GreaterOrEqual(t, int64(timings.TTFB), int64(10*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(timings.Total), int64(timings.TTFB))
+
+	fromCtx, ok := TimingsFromContext(resp.Request.Context())
+	require.True(t, ok)
+	assert.Equal(t, timings, fromCtx)
+
+	// second request reuses the connection
+	_, _, err = r.Receive(nil)
+	require.NoError(t, err)
+	require.Len(t, called, 2)
+	assert.True(t, called[1].ConnReused)
+	assert.Zero(t, called[1].Connect)
+	assert.Zero(t, called[1].TLSHandshake)
+
+	t.Run("error", func(t *testing.T) {
+		var timings *Timings
+		_, err := Send(
+			WithDoer(DoerFunc(func(req *http.Request) (*http.Response, error) {
+				return nil, errors.New("boom")
+			})),
+			Trace(func(req *http.Request, t Timings) {
+				timings = &t
+			}),
+		)
+		require.Error(t, err)
+		require.NotNil(t, timings)
+		assert.NotZero(t, timings.Total)
+		assert.Zero(t, timings.TTFB)
+	})
+
+	t.Run("untraced", func(t *testing.T) {
+		_, ok := TimingsFromContext(context.Background())
+		assert.False(t, ok)
+	})
+}
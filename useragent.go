@@ -0,0 +1,69 @@
+package requester
+
+import (
+	"path"
+	"runtime/debug"
+	"strings"
+)
+
+// DefaultUserAgent, if not empty, is sent as the User-Agent header of requests which
+// don't set one.  It's also the base the UserAgent option appends to, if the Requester
+// doesn't already have a User-Agent header.  For example, to identify all traffic
+// by the name and version of the program:
+//
+//	requester.DefaultUserAgent = requester.BuildInfoUserAgent()
+//
+// nolint:gochecknoglobals
+var DefaultUserAgent = ""
+
+// BuildInfoUserAgent returns a User-Agent built from the build info embedded in the
+// running binary, naming the main module and this module, with their versions, e.g.
+// "myapp/v1.2.0 requester/v1.0.0".  Returns an empty string if the binary has no
+// build info.
+func BuildInfoUserAgent() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	ua := appendProduct("", path.Base(info.Main.Path), moduleVersion(info.Main))
+	for _, dep := range info.Deps {
+		if dep.Path == "github.com/gemalto/requester" || dep.Path == "github.com/ThalesGroup/requester" {
+			ua = appendProduct(ua, "requester", moduleVersion(*dep))
+		}
+	}
+	return ua
+}
+
+func moduleVersion(m debug.Module) string {
+	if m.Replace != nil {
+		m = *m.Replace
+	}
+	if m.Version == "(devel)" {
+		return ""
+	}
+	return m.Version
+}
+
+// appendProduct adds a "product/version" token to a User-Agent.  If the product
+// is already in the User-Agent, its version is replaced.
+func appendProduct(ua, product, version string) string {
+	product = strings.ReplaceAll(strings.TrimSpace(product), " ", "-")
+	if product == "" || product == "." {
+		return ua
+	}
+
+	token := product
+	if version != "" {
+		token += "/" + strings.ReplaceAll(strings.TrimSpace(version), " ", "-")
+	}
+
+	tokens := strings.Fields(ua)
+	for i, t := range tokens {
+		if t == product || strings.HasPrefix(t, product+"/") {
+			tokens[i] = token
+			return strings.Join(tokens, " ")
+		}
+	}
+	return strings.Join(append(tokens, token), " ")
+}
@@ -0,0 +1,62 @@
+package requester
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAgent(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  []Option
+		expected string
+	}{
+		{"product and version", []Option{UserAgent("myapp", "1.2.0")}, "myapp/1.2.0"},
+		{"no version", []Option{UserAgent("myapp", "")}, "myapp"},
+		{"appends", []Option{UserAgent("myapp", "1.2.0"), UserAgent("mylib", "0.1")}, "myapp/1.2.0 mylib/0.1"},
+		{"replaces version", []Option{UserAgent("myapp", "1.2.0"), UserAgent("myapp", "1.3.0")}, "myapp/1.3.0"},
+		{"existing header", []Option{Header(HeaderUserAgent, "curl/7.0 (linux)"), UserAgent("myapp", "1")}, "curl/7.0 (linux) myapp/1"},
+		{"sanitized", []Option{UserAgent("my app", "1 beta")}, "my-app/1-beta"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := Request(c.options...)
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, req.Header.Get(HeaderUserAgent))
+		})
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	defer func(ua string) {
+		DefaultUserAgent = ua
+	}(DefaultUserAgent)
+
+	DefaultUserAgent = "base/1.0"
+
+	req, err := Request()
+	require.NoError(t, err)
+	assert.Equal(t, "base/1.0", req.Header.Get(HeaderUserAgent))
+
+	req, err = Request(Header(HeaderUserAgent, "other"))
+	require.NoError(t, err)
+	assert.Equal(t, "other", req.Header.Get(HeaderUserAgent))
+
+	req, err = Request(UserAgent("myapp", "1.2.0"))
+	require.NoError(t, err)
+	assert.Equal(t, "base/1.0 myapp/1.2.0", req.Header.Get(HeaderUserAgent))
+}
+
+func TestBuildInfoUserAgent(t *testing.T) {
+	// in tests, the main module is this module, but before Go 1.24, test binaries
+	// don't record it
+	if info, ok := debug.ReadBuildInfo(); !ok || info.Main.Path == "" {
+		t.Skip("no main module in build info")
+	}
+	ua := BuildInfoUserAgent()
+	assert.Regexp(t, `^requester(/\S+)?$`, ua)
+}
@@ -0,0 +1,97 @@
+package requester
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1" // nolint:gosec
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ansel1/merry"
+)
+
+// websocketGUID is appended to the handshake key to compute the accept key.  See RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket sends a WebSocket opening handshake, using the Requester's URL, headers, TLS
+// configuration, and the rest, and returns the connection once the server has accepted it.
+// ws:// and wss:// URLs are converted to http:// and https://.  Set subprotocols with the
+// Sec-WebSocket-Protocol header.
+//
+// The connection is raw: pass it to a WebSocket library to frame messages.  Close it when
+// done.  If the server doesn't switch protocols, an error is returned, with the response.
+//
+// The Doer must return the connection as the response body, as http.Client and
+// http.Transport do for 101 Switching Protocols responses.  Middleware which replaces the
+// response body, like Decompress, breaks the handshake.
+func (r *Requester) WebSocket(opts ...Option) (*http.Response, io.ReadWriteCloser, error) {
+	return r.WebSocketContext(context.Background(), opts...)
+}
+
+// WebSocketContext does the same as WebSocket, but requires a context.  The context
+// only applies to the handshake.
+func (r *Requester) WebSocketContext(ctx context.Context, opts ...Option) (*http.Response, io.ReadWriteCloser, error) {
+	reqs, err := r.With(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nonce [16]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, nil, merry.Prepend(err, "generating handshake key")
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	if reqs.URL != nil {
+		switch reqs.URL.Scheme {
+		case "ws":
+			reqs.URL.Scheme = "http"
+		case "wss":
+			reqs.URL.Scheme = "https"
+		}
+	}
+	reqs.Method = http.MethodGet
+	h := reqs.Headers()
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	h.Set("Sec-WebSocket-Version", "13")
+	h.Set("Sec-WebSocket-Key", key)
+
+	resp, err := reqs.SendContext(ctx)
+	if err != nil {
+		if resp != nil {
+			drain(resp.Body)
+		}
+		return resp, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		drain(resp.Body)
+		return resp, nil, merry.
+			Errorf("server refused the WebSocket handshake with status code: %d", resp.StatusCode).
+			WithHTTPCode(resp.StatusCode)
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		drain(resp.Body)
+		return resp, nil, merry.New("response body isn't a connection: middleware may have replaced it")
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != WebSocketAccept(key) {
+		conn.Close()
+		return resp, nil, merry.New("server returned an invalid WebSocket handshake")
+	}
+	return resp, conn, nil
+}
+
+// WebSocketAccept returns the Sec-WebSocket-Accept value a server must return for the
+// Sec-WebSocket-Key of a handshake.
+func WebSocketAccept(key string) string {
+	h := sha1.New() // nolint:gosec
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package requester
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawUpgradeHandler completes the handshake with the accept key returned by accept,
+// then echoes raw bytes.
+func rawUpgradeHandler(accept func(key string) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + accept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(conn, rw)
+	})
+}
+
+func TestRequester_WebSocket(t *testing.T) {
+	var req *http.Request
+	ts := httptest.NewServer(rawUpgradeHandler(WebSocketAccept))
+	defer ts.Close()
+	ts.Config.Handler = func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req = r
+			next.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+
+	resp, conn, err := MustNew(URL(ts.URL)).WebSocket(
+		Post("/ws"),
+		Header("Sec-WebSocket-Protocol", "chat"),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, 101, resp.StatusCode)
+
+	require.NotNil(t, req)
+	assert.Equal(t, "GET", req.Method)
+	assert.Equal(t, "/ws", req.URL.Path)
+	assert.Equal(t, "13", req.Header.Get("Sec-WebSocket-Version"))
+	assert.Equal(t, "chat", req.Header.Get("Sec-WebSocket-Protocol"))
+	assert.NotEmpty(t, req.Header.Get("Sec-WebSocket-Key"))
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestRequester_WebSocket_badAccept(t *testing.T) {
+	ts := httptest.NewServer(rawUpgradeHandler(func(string) string { return "bogus" }))
+	defer ts.Close()
+
+	_, conn, err := MustNew(URL(ts.URL)).WebSocket()
+	require.Error(t, err)
+	assert.Nil(t, conn)
+	assert.Contains(t, err.Error(), "invalid WebSocket handshake")
+}
+
+func TestRequester_WebSocket_refused(t *testing.T) {
+	ts := httptest.NewServer(MockHandler(403))
+	defer ts.Close()
+
+	resp, conn, err := MustNew(URL(ts.URL)).WebSocket()
+	require.Error(t, err)
+	assert.Nil(t, conn)
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Contains(t, err.Error(), "status code: 403")
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// example from RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", WebSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}